@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Priority aging keeps low-priority jobs from waiting forever under a steady
+// stream of higher-priority ones: a pending job's effective priority goes up
+// by one for every aging interval it has waited since it was added. Queues
+// without an interval of their own use the one set for allQueues, if any; an
+// interval of 0 turns aging off for a queue.
+
+type PriorityAging struct {
+	Queue    string
+	Interval time.Duration
+}
+
+// agedPriorityExpr is the SQL expression for the effective priority of a
+// job, given the current time in milliseconds as its argument. Dividing by
+// an interval of 0 gives NULL, so those jobs don't age.
+const agedPriorityExpr = `(jobs.priority + coalesce((? - jobs.created_at) / coalesce(
+	(SELECT interval_ms FROM priority_aging WHERE queue_name = jobs.queue),
+	(SELECT interval_ms FROM priority_aging WHERE queue_name = '` + allQueues + `')
+), 0))`
+
+// SetPriorityAging sets the aging interval of the named queue, or of all
+// queues without one of their own if queue is allQueues.
+func (db *DB) SetPriorityAging(queue string, interval time.Duration) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO priority_aging (queue_name, interval_ms) VALUES (?,?)
+	ON CONFLICT (queue_name) DO UPDATE SET interval_ms = excluded.interval_ms`,
+		queue, interval.Milliseconds())
+	return err
+}
+
+// AgingInterval returns the aging interval that applies to the named queue,
+// or 0 if its jobs don't age.
+func (db *DB) AgingInterval(queue string) (time.Duration, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var ms int64
+	err := db.QueryRow(`
+	SELECT interval_ms FROM priority_aging WHERE queue_name IN (?, ?)
+	ORDER BY queue_name = ? LIMIT 1`, queue, allQueues, allQueues).Scan(&ms)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (db *DB) ListPriorityAging() ([]PriorityAging, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT queue_name, interval_ms FROM priority_aging ORDER BY queue_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agings []PriorityAging
+	for rows.Next() {
+		var a PriorityAging
+		var ms int64
+		if err := rows.Scan(&a.Queue, &ms); err != nil {
+			return agings, err
+		}
+		a.Interval = time.Duration(ms) * time.Millisecond
+		agings = append(agings, a)
+	}
+	return agings, rows.Err()
+}
+
+// Deletes the aging interval of the named queue. Returns true if it had one.
+func (db *DB) DeletePriorityAging(queue string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM priority_aging WHERE queue_name = ?`, queue)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// agedPriority returns the effective priority of a pending job at now, the
+// same way ClaimJob works it out.
+func agedPriority(job *Job, interval time.Duration, now time.Time) int {
+	if job.Status != statusPending || interval <= 0 {
+		return job.Priority
+	}
+	waited := now.Sub(job.CreatedAtTime())
+	if waited < 0 {
+		return job.Priority
+	}
+	return job.Priority + int(waited/interval)
+}
+
+type agingSet struct {
+	globalArgs
+	queue    string
+	interval time.Duration
+}
+
+type agingList struct {
+	globalArgs
+}
+
+type agingRemove struct {
+	globalArgs
+	queue string
+}
+
+func parseAgingQueue(name string, args []string) (string, []string, error) {
+	var queue string
+	fs := flag.NewFlagSet(agingCommandName+" "+name, flag.ContinueOnError)
+	fs.StringVar(&queue, "queue", "", "only this queue (default: queues without their own interval)")
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	if queue == "" {
+		return allQueues, fs.Args(), nil
+	}
+	if err := validateName("queue", queue); err != nil {
+		return "", nil, err
+	}
+	return queue, fs.Args(), nil
+}
+
+func parseAgingSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("aging command required: set, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "set":
+		queue, args, err := parseAgingQueue(cmd, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: interval after which waiting jobs gain a priority point, or 0 for none")
+		}
+		interval, err := time.ParseDuration(args[0])
+		if err != nil || interval < 0 || (interval > 0 && interval < time.Second) {
+			return nil, fmt.Errorf("invalid aging interval: '%s'", args[0])
+		}
+		return agingSet{globalArgs: globals, queue: queue, interval: interval}, nil
+	case "list":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args)
+		}
+		return agingList{globalArgs: globals}, nil
+	case "remove":
+		queue, args, err := parseAgingQueue(cmd, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args)
+		}
+		return agingRemove{globalArgs: globals, queue: queue}, nil
+	}
+	return nil, fmt.Errorf("unknown aging command: '%s'", cmd)
+}
+
+func (cmd agingSet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.SetPriorityAging(cmd.queue, cmd.interval); err != nil {
+		return err
+	}
+	slog.Info("set priority aging", "queue", cmd.queue, "interval", cmd.interval)
+	return nil
+}
+
+func (cmd agingList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	agings, err := db.ListPriorityAging()
+	if err != nil {
+		return fmt.Errorf("failed to list priority aging: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(agings) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("QUEUE", "INTERVAL")
+	for _, a := range agings {
+		queue, interval := a.Queue, a.Interval.String()
+		if queue == allQueues {
+			queue = "(default)"
+		}
+		if a.Interval == 0 {
+			interval = "off"
+		}
+		t.Row(queue, interval)
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd agingRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeletePriorityAging(cmd.queue)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		if cmd.queue == allQueues {
+			return fmt.Errorf("no default aging interval is set")
+		}
+		return fmt.Errorf("queue '%s' has no aging interval", cmd.queue)
+	}
+	return nil
+}
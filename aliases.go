@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// An alias names a command that's added often, so it doesn't have to be
+// retyped and quoted each time: after `chime alias set deploy 'make deploy'`,
+// `chime add @deploy` or `chime add --alias deploy` adds a job running
+// `make deploy`. Any arguments after the alias are quoted for the shell and
+// appended to its command. Unlike templates, aliases have no parameters, and
+// the job is added as if its command had been typed out.
+
+// CommandAlias is a named command.
+type CommandAlias struct {
+	Name      string
+	Command   string
+	CreatedAt int64
+}
+
+// aliasPrefix marks a command given to chime add as an alias.
+const aliasPrefix = "@"
+
+// expand returns the alias's command with args appended.
+func (a CommandAlias) expand(args []string) string {
+	parts := []string{a.Command}
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// SetAlias creates the named alias, replacing any existing one.
+func (db *DB) SetAlias(name, command string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO command_aliases (name, command, created_at) VALUES (?,?,?)
+	ON CONFLICT (name) DO UPDATE SET command = excluded.command`,
+		name, command, time.Now().UnixMilli())
+	return err
+}
+
+// GetAlias returns the named alias, or nil if it doesn't exist.
+func (db *DB) GetAlias(name string) (*CommandAlias, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var a CommandAlias
+	if err := db.QueryRow(`SELECT name, command, created_at FROM command_aliases WHERE name = ?`, name).
+		Scan(&a.Name, &a.Command, &a.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (db *DB) ListAliases() ([]CommandAlias, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT name, command, created_at FROM command_aliases ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []CommandAlias
+	for rows.Next() {
+		var a CommandAlias
+		if err := rows.Scan(&a.Name, &a.Command, &a.CreatedAt); err != nil {
+			return aliases, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// Deletes the named alias. Returns true if the alias existed.
+func (db *DB) DeleteAlias(name string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM command_aliases WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+type aliasSet struct {
+	globalArgs
+	name    string
+	command string
+}
+
+type aliasList struct {
+	globalArgs
+}
+
+type aliasRemove struct {
+	globalArgs
+	name string
+}
+
+func parseAliasSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("alias command required: set, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "set":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("params required: alias name and command")
+		}
+		if err := validateName("alias", args[0]); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(args[1]) == "" {
+			return nil, fmt.Errorf("an alias's command can't be empty")
+		}
+		return aliasSet{globalArgs: globals, name: args[0], command: args[1]}, nil
+	case "list":
+		return aliasList{globalArgs: globals}, nil
+	case "remove":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: alias name to remove")
+		}
+		return aliasRemove{globalArgs: globals, name: args[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown alias command: '%s'", cmd)
+}
+
+func (cmd aliasSet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	return db.SetAlias(cmd.name, cmd.command)
+}
+
+func (cmd aliasList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	aliases, err := db.ListAliases()
+	if err != nil {
+		return fmt.Errorf("failed to list aliases: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(aliases) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("NAME", "COMMAND")
+	for _, a := range aliases {
+		t.Row(a.Name, a.Command)
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd aliasRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeleteAlias(cmd.name)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("no alias named '%s'", cmd.name)
+	}
+	return nil
+}
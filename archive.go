@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Finished jobs can be moved out of the jobs table into archived_jobs, which
+// has the same columns plus the time each job was archived. Job IDs may be
+// reused once jobs are deleted, so archived jobs have their own ID.
+
+// syncArchiveColumns adds any columns of the jobs table that the archive
+// table doesn't have yet.
+func syncArchiveColumns(db querier) error {
+	columns, err := tableColumns(db, "jobs")
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if err := ensureColumn(db, "archived_jobs", c.Name, c.definition()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveJobs moves every job matching the filter into the archive. Returns
+// the number of jobs archived.
+func (db *DB) ArchiveJobs(filter JobFilter) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	columns, err := tableColumns(db.DB, "jobs")
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	cols := strings.Join(names, ", ")
+
+	where, args := filter.where()
+	result, err := tx.Exec(`
+	INSERT INTO archived_jobs (`+cols+`, archived_at)
+	SELECT `+cols+`, ? FROM jobs WHERE `+where,
+		append([]any{time.Now().UnixMilli()}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := recordEvents(tx, db.actor, eventArchived, "", where, args...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE `+where, args...); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (db *DB) ListArchivedJobs() ([]Job, error) {
+	return db.listJobsFrom("archived_jobs")
+}
+
+type archive struct {
+	globalArgs
+	olderThan time.Duration
+	statuses  []int
+}
+
+func parseArchiveSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	var olderThan string
+	var statuses statusList
+	fs := flag.NewFlagSet(archiveCommandName, flag.ContinueOnError)
+	fs.StringVar(&olderThan, "older-than", "", "only archive jobs that finished longer ago than this, e.g. 30d")
+	fs.Var(&statuses, "status", "only archive jobs with this status (default: done); may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cmd := archive{globalArgs: globals, statuses: statuses}
+	if len(cmd.statuses) == 0 {
+		cmd.statuses = terminalStatuses
+	}
+	for _, s := range cmd.statuses {
+		if !isTerminal(s) {
+			return nil, fmt.Errorf("archive only moves finished jobs, not %s ones", statusNames[s])
+		}
+	}
+	if olderThan != "" {
+		age, err := parseAge(olderThan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --older-than: %w", err)
+		}
+		cmd.olderThan = age
+	}
+	return cmd, nil
+}
+
+func (cmd archive) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	filter := JobFilter{Statuses: cmd.statuses}
+	if cmd.olderThan > 0 {
+		filter.FinishedBefore = time.Now().Add(-cmd.olderThan).UnixMilli()
+	}
+	n, err := db.ArchiveJobs(filter)
+	if err != nil {
+		return fmt.Errorf("failed to archive jobs: %w", err)
+	}
+	slog.Info("archived jobs", "count", n)
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+// A runner started with --artifacts-dir gives every execution of a local job
+// a new, empty directory to leave build outputs and reports in, named by
+// $CHIME_ARTIFACTS_DIR, and records it with the job. Each job gets its own
+// directory there, with one numbered directory per execution, so a rerun
+// doesn't overwrite what the last run left; `chime artifacts` lists them.
+// The directories are removed with their jobs when those are deleted or
+// purged. Jobs run on hosts or by Kubernetes don't get one, since it would
+// be on the wrong machine; containers get it mounted at the same path.
+
+const chimeArtifactsDirEnvKey = "CHIME_ARTIFACTS_DIR"
+
+// SetJobArtifactsDir records the artifacts directory of a job's latest
+// execution.
+func (db *DB) SetJobArtifactsDir(jobID int64, dir string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`UPDATE jobs SET artifacts_dir = ? WHERE id = ?`, dir, jobID)
+	return err
+}
+
+// artifactsJobDir returns a job's directory in the artifacts directory,
+// which holds a directory per execution. Job IDs may be reused once jobs are
+// deleted, so it has the job's UUID too.
+func artifactsJobDir(root string, id int, uuid string) string {
+	return filepath.Join(root, fmt.Sprintf("%d-%s", id, uuid))
+}
+
+// createArtifactsDir creates the directory for a new execution of the job,
+// numbered one after the job's latest.
+func createArtifactsDir(root string, job *Job) (string, error) {
+	jobDir, err := filepath.Abs(artifactsJobDir(root, job.ID, job.UUID))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		return "", err
+	}
+	runs, err := artifactRuns(jobDir)
+	if err != nil {
+		return "", err
+	}
+	n := 1
+	if len(runs) > 0 {
+		n = runs[len(runs)-1] + 1
+	}
+	for {
+		// Another runner may take the same number first.
+		dir := filepath.Join(jobDir, strconv.Itoa(n))
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			return dir, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		n++
+	}
+}
+
+// artifactRuns returns the numbers of the executions with directories in a
+// job's artifacts directory, in order.
+func artifactRuns(jobDir string) ([]int, error) {
+	entries, err := os.ReadDir(jobDir)
+	if err != nil {
+		return nil, err
+	}
+	var runs []int
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			runs = append(runs, n)
+		}
+	}
+	slices.Sort(runs)
+	return runs, nil
+}
+
+// jobArtifactDirs returns the directories in the artifacts directory of the
+// jobs matching where that had any.
+func jobArtifactDirs(tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.Query(`SELECT id, uuid, artifacts_dir FROM jobs WHERE artifacts_dir != '' AND (`+where+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dirs []string
+	for rows.Next() {
+		var id int
+		var uuid, dir string
+		if err := rows.Scan(&id, &uuid, &dir); err != nil {
+			return nil, err
+		}
+		// Only remove what chime created.
+		jobDir := filepath.Dir(dir)
+		if filepath.Base(jobDir) == filepath.Base(artifactsJobDir("", id, uuid)) {
+			dirs = append(dirs, jobDir)
+		}
+	}
+	return dirs, rows.Err()
+}
+
+// removeArtifactDirs removes the artifacts of deleted jobs. Failures are
+// only logged, since the jobs are already gone.
+func removeArtifactDirs(dirs []string) {
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("failed to remove job artifacts", "dir", dir, "err", err)
+		}
+	}
+}
+
+// execJobWithArtifacts runs a job like execJobReportingProgress, giving it a
+// new artifacts directory if the runner is configured to and the job runs
+// locally.
+func execJobWithArtifacts(db *DB, cfg execConfig, job *Job) (*jobResult, error) {
+	if cfg.Output.ArtifactsDir == "" || !cfg.runsLocally(job) {
+		return execJobReportingProgress(db, cfg, job)
+	}
+	dir, err := createArtifactsDir(cfg.Output.ArtifactsDir, job)
+	if err != nil {
+		slog.Warn("failed to create job artifacts directory; the job runs without one", "id", job.ID, "err", err)
+		return execJobReportingProgress(db, cfg, job)
+	}
+	if err := db.SetJobArtifactsDir(int64(job.ID), dir); err != nil {
+		slog.Warn("failed to record job artifacts directory", "id", job.ID, "err", err)
+	}
+	cfg.artifacts = dir
+	return execJobReportingProgress(db, cfg, job)
+}
+
+type artifacts struct {
+	globalArgs
+	ref  string
+	all  bool
+	open bool
+}
+
+func parseArtifactsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := artifacts{globalArgs: globals}
+	fs := flag.NewFlagSet(artifactsCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.all, "all", false, "list the artifacts of every execution of the job, not just the latest")
+	fs.BoolVar(&cmd.open, "open", false, "open the latest execution's directory in the file manager instead of listing it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 && canPickJob() {
+		return cmd, nil
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: job ID, name or UUID to list the artifacts of")
+	}
+	cmd.ref = fs.Arg(0)
+	return cmd, nil
+}
+
+func (cmd artifacts) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.ref == "" {
+		if cmd.ref, err = pickJob(db, "list the artifacts of"); err != nil {
+			return err
+		}
+	}
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	if job.ArtifactsDir == "" {
+		return fmt.Errorf("job %d has no artifacts directory; run it with chime run --artifacts-dir", job.ID)
+	}
+	if _, err := os.Stat(job.ArtifactsDir); err != nil {
+		return fmt.Errorf("job %d's artifacts directory %s is gone", job.ID, job.ArtifactsDir)
+	}
+	if cmd.open {
+		return openInFileManager(job.ArtifactsDir)
+	}
+
+	dirs := []string{job.ArtifactsDir}
+	if cmd.all {
+		jobDir := filepath.Dir(job.ArtifactsDir)
+		runs, err := artifactRuns(jobDir)
+		if err != nil {
+			return err
+		}
+		dirs = dirs[:0]
+		for _, n := range runs {
+			dirs = append(dirs, filepath.Join(jobDir, strconv.Itoa(n)))
+		}
+	}
+	for i, dir := range dirs {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(dir)
+		if err := listArtifacts(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listArtifacts prints the files under dir with their sizes.
+func listArtifacts(dir string) error {
+	empty := true
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		empty = false
+		fmt.Printf("  %10s  %s\n", formatBytes(info.Size()), rel)
+		return nil
+	})
+	if err == nil && empty {
+		fmt.Println("  (empty)")
+	}
+	return err
+}
+
+// openInFileManager opens a directory with the desktop's file manager.
+func openInFileManager(dir string) error {
+	var opener *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		opener = exec.Command("open", dir)
+	case "windows":
+		opener = exec.Command("explorer", dir)
+	default:
+		opener = exec.Command("xdg-open", dir)
+	}
+	if err := opener.Start(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	return opener.Process.Release()
+}
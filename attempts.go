@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// A job that's retried or requeued runs more than once, and each run resets
+// the job's timing, exit code and worker. So when an attempt ends, however
+// it ends, it's recorded in the job_attempts table first: when it started
+// and finished, how it ended, its exit code, the worker that ran it and the
+// file its output was saved to, which is its own for every attempt after
+// the first. Like events, attempts are keyed by the job's UUID and kept
+// after the job is removed, and `chime history` shows them.
+
+// Outcomes of attempts that ended without the job finishing.
+const (
+	attemptLeaseExpired  = "lease expired"
+	attemptRunnerStopped = "runner stopped"
+)
+
+type JobAttempt struct {
+	Attempt    int
+	StartedAt  int64
+	FinishedAt int64
+	Outcome    string
+	ExitCode   int
+	Worker     string
+	OutputPath string
+	Failure    string
+}
+
+// recordAttempts records the attempt in progress of every job matching the
+// WHERE clause as having ended with outcome. Jobs that weren't started are
+// skipped, and one that hasn't finished, such as one whose lease expired,
+// ends now, without an exit code or a failure of its own.
+func recordAttempts(q querier, outcome, where string, args ...any) error {
+	_, err := q.Exec(`
+	INSERT INTO job_attempts (job_id, job_uuid, attempt, started_at, finished_at, outcome, exit_code, worker, output_path, failure)
+	SELECT id, uuid, attempts, started_at, CASE WHEN finished_at > 0 THEN finished_at ELSE ? END, ?,
+		CASE WHEN finished_at > 0 THEN exit_code ELSE -1 END, worker, output_path,
+		CASE WHEN finished_at > 0 THEN failure ELSE '' END
+	FROM jobs WHERE started_at > 0 AND (`+where+`)`,
+		append([]any{time.Now().UnixMilli(), outcome}, args...)...)
+	return err
+}
+
+// JobAttempts returns the recorded attempts of the job with the given UUID,
+// oldest first.
+func (db *DB) JobAttempts(uuid string) ([]JobAttempt, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT attempt, started_at, finished_at, outcome, exit_code, worker, output_path, failure FROM job_attempts
+	WHERE job_uuid = ?
+	ORDER BY id`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []JobAttempt
+	for rows.Next() {
+		var a JobAttempt
+		if err := rows.Scan(&a.Attempt, &a.StartedAt, &a.FinishedAt, &a.Outcome, &a.ExitCode, &a.Worker, &a.OutputPath, &a.Failure); err != nil {
+			return attempts, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// attemptOutputPaths returns the output files of earlier attempts of the
+// jobs matching where.
+func attemptOutputPaths(q querier, where string, args ...any) ([]string, error) {
+	rows, err := q.Query(`
+	SELECT DISTINCT output_path FROM job_attempts
+	WHERE output_path != '' AND job_uuid IN (SELECT uuid FROM jobs WHERE `+where+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// attemptRows returns the rows of history's attempts table: the job's
+// recorded attempts, then the one it's on if it's running.
+func attemptRows(job *Job, attempts []JobAttempt) [][]string {
+	var rows [][]string
+	row := func(a JobAttempt) {
+		finished, duration, exit := "", "", ""
+		if a.FinishedAt > 0 {
+			finished = time.UnixMilli(a.FinishedAt).Format("2006-01-02 15:04:05")
+			duration = time.UnixMilli(a.FinishedAt).Sub(time.UnixMilli(a.StartedAt)).Round(time.Millisecond).String()
+		}
+		if a.ExitCode >= 0 {
+			exit = fmt.Sprintf("%d", a.ExitCode)
+		}
+		outcome := a.Outcome
+		if a.Failure != "" && a.ExitCode < 0 {
+			outcome += ": " + a.Failure
+		}
+		output := a.OutputPath
+		if output != "" {
+			if _, err := os.Stat(output); err != nil {
+				output += " (removed)"
+			}
+		}
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", a.Attempt),
+			time.UnixMilli(a.StartedAt).Format("2006-01-02 15:04:05"),
+			finished, duration, outcome, exit, a.Worker, output,
+		})
+	}
+	for _, a := range attempts {
+		row(a)
+	}
+	if job.Status == statusInProgress || job.Status == statusSuspended {
+		row(JobAttempt{
+			Attempt:    job.Attempts,
+			StartedAt:  job.StartedAt,
+			Outcome:    statusNames[job.Status],
+			ExitCode:   -1,
+			Worker:     job.Worker,
+			OutputPath: job.OutputPath,
+		})
+	}
+	return rows
+}
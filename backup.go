@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Backup writes a consistent snapshot of the DB to path, which must not
+// already exist.
+func (db *DB) Backup(path string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// IntegrityCheck runs SQLite's integrity check, returning the problems it
+// finds, if any.
+func (db *DB) IntegrityCheck() ([]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// checkSchemaVersion returns an error if the DB's schema is newer than this
+// version of chime supports.
+func checkSchemaVersion(db *DB, what string) (int, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version of %s: %w", what, err)
+	}
+	if version > latestSchemaVersion {
+		return 0, fmt.Errorf("%s has schema version %d, but this version of chime only supports up to %d", what, version, latestSchemaVersion)
+	}
+	return version, nil
+}
+
+type backup struct {
+	globalArgs
+	path string
+}
+
+type restore struct {
+	globalArgs
+	path string
+}
+
+func parseBackupSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: path to write the backup to")
+	}
+	return backup{globalArgs: globals, path: args[0]}, nil
+}
+
+func parseRestoreSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: path of the backup to restore")
+	}
+	return restore{globalArgs: globals, path: args[0]}, nil
+}
+
+func (cmd backup) Run() error {
+	if _, err := os.Stat(cmd.path); err == nil {
+		return fmt.Errorf("%s already exists", cmd.path)
+	}
+
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.Backup(cmd.path); err != nil {
+		return fmt.Errorf("failed to back up db: %w", err)
+	}
+	slog.Info("backed up db", "db", cmd.globalArgs.dbPath, "backup", cmd.path)
+	return nil
+}
+
+func (cmd restore) Run() error {
+	// Replacing the DB under a running `chime run` would lose its updates.
+	if runIsActive(cmd.globalArgs.dbPath) {
+		return fmt.Errorf("can't restore while `chime run` is using %s", cmd.globalArgs.dbPath)
+	}
+
+	if _, err := os.Stat(cmd.path); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	src, err := openDB("file:" + cmd.path + "?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	problems, err := src.IntegrityCheck()
+	if err != nil {
+		return fmt.Errorf("failed to check backup: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup is corrupt: %s", problems[0])
+	}
+	version, err := checkSchemaVersion(src, "backup")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(cmd.globalArgs.dbPath); err == nil {
+		dst, err := openDB(cmd.globalArgs.dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open db: %w", err)
+		}
+		_, err = checkSchemaVersion(dst, cmd.globalArgs.dbPath)
+		dst.Close()
+		if err != nil {
+			return fmt.Errorf("refusing to restore: %w", err)
+		}
+	}
+
+	// Write a copy next to the DB and move it into place, so the DB is never
+	// left half-restored.
+	tmp := filepath.Join(filepath.Dir(cmd.globalArgs.dbPath), fmt.Sprintf(".%s.restore", filepath.Base(cmd.globalArgs.dbPath)))
+	os.Remove(tmp)
+	if err := src.Backup(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	// The old DB's journal files must not be applied to the restored one.
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(cmd.globalArgs.dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to remove old %s file: %w", suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, cmd.globalArgs.dbPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace db: %w", err)
+	}
+
+	// Bring an older backup up to date.
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open restored db: %w", err)
+	}
+	defer db.Close()
+
+	slog.Info("restored db", "db", cmd.globalArgs.dbPath, "backup", cmd.path, "schema_version", version)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "chime.db")
+	backupPath := filepath.Join(dir, "backup.db")
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	kept := insertTestJob(t, db, JobSpec{Command: "echo kept"})
+	if err := db.Backup(backupPath); err != nil {
+		t.Fatalf("failed to back up db: %v", err)
+	}
+	lost := insertTestJob(t, db, JobSpec{Command: "echo lost"})
+	db.Close()
+
+	cmd := restore{globalArgs: globalArgs{dbPath: dbPath}, path: backupPath}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+
+	db, err = Open(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open restored db: %v", err)
+	}
+	defer db.Close()
+	if job := getTestJob(t, db, kept); job.Command != "echo kept" {
+		t.Errorf("restored job #%d has command %q; want %q", kept, job.Command, "echo kept")
+	}
+	if job, err := db.GetJob(lost); err != nil || job != nil {
+		t.Errorf("job #%d added after the backup is in the restored db (err %v)", lost, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Batch groups jobs that make up a logical unit of work.
+type Batch struct {
+	ID        int64
+	Name      string
+	CreatedAt int64
+}
+
+// inNewBatch has AddJobs add its jobs to a new batch, with the given name,
+// and set id to the batch's ID.
+func inNewBatch(name string, id *int64) addJobsOption {
+	return func(cfg *addJobsConfig) {
+		cfg.begin = func(tx *sql.Tx, cfg *addJobsConfig) error {
+			result, err := tx.Exec(`INSERT INTO batches (name, created_at) VALUES (?,?)`, name, time.Now().UnixMilli())
+			if err != nil {
+				return err
+			}
+			if *id, err = result.LastInsertId(); err != nil {
+				return err
+			}
+			cfg.spec.BatchID = *id
+			cfg.detail = fmt.Sprintf("batch #%d", *id)
+			return nil
+		}
+	}
+}
+
+// GetBatch returns the batch with the given ID, or nil if it doesn't exist.
+func (db *DB) GetBatch(id int64) (*Batch, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var b Batch
+	if err := db.QueryRow(`SELECT id, name, created_at FROM batches WHERE id = ?`, id).
+		Scan(&b.ID, &b.Name, &b.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// batchState summarizes the statuses of a batch's jobs.
+type batchState struct {
+	Batch
+	Counts map[int]int
+}
+
+func (s batchState) Total() int {
+	var total int
+	for _, n := range s.Counts {
+		total += n
+	}
+	return total
+}
+
+// Done reports whether every job in the batch has finished.
+func (s batchState) Done() bool {
+	return s.Counts[statusPending] == 0 && s.Counts[statusInProgress] == 0 &&
+		s.Counts[statusSuspended] == 0 && s.Counts[statusHeld] == 0
+}
+
+// failed returns the number of the batch's jobs that failed.
+func (s batchState) failed() int {
+	return s.Counts[statusDoneFailed] + s.Counts[statusTimedOut] + s.Counts[statusDeadLetter]
+}
+
+func (s batchState) String() string {
+	var state string
+	switch {
+	case !s.Done() && s.Counts[statusInProgress] == 0 && s.Counts[statusPending]+s.Counts[statusHeld] == s.Total():
+		state = "pending"
+	case !s.Done():
+		state = "running"
+	case s.failed() > 0:
+		state = "failed"
+	default:
+		state = "succeeded"
+	}
+
+	var parts []string
+	for _, status := range allStatuses {
+		if s.Counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", s.Counts[status], statusNames[status]))
+		}
+	}
+
+	name := ""
+	if s.Name != "" {
+		name = fmt.Sprintf(" (%s)", s.Name)
+	}
+	return fmt.Sprintf("batch #%d%s: %s, %d jobs [%s]", s.ID, name, state, s.Total(), strings.Join(parts, ", "))
+}
+
+func readBatchState(db *DB, id int64) (*batchState, error) {
+	batch, err := db.GetBatch(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch: %w", err)
+	}
+	if batch == nil {
+		return nil, fmt.Errorf("no batch with ID %d", id)
+	}
+	counts, err := db.CountJobsByStatus(JobFilter{BatchID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count batch jobs: %w", err)
+	}
+	return &batchState{Batch: *batch, Counts: counts}, nil
+}
+
+type batchAdd struct {
+	globalArgs
+	name     string
+	commands []string
+}
+
+type batchStatus struct {
+	globalArgs
+	id int64
+}
+
+type batchWait struct {
+	globalArgs
+	id       int64
+	interval time.Duration
+}
+
+func parseBatchSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("batch command required: add, status or wait")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "add":
+		c := batchAdd{globalArgs: globals}
+		fs := flag.NewFlagSet(batchCommandName+" add", flag.ContinueOnError)
+		fs.StringVar(&c.name, "name", "", "name of the batch")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		c.commands = fs.Args()
+		return c, nil
+	case "status":
+		id, err := parseBatchID(args)
+		if err != nil {
+			return nil, err
+		}
+		return batchStatus{globalArgs: globals, id: id}, nil
+	case "wait":
+		c := batchWait{globalArgs: globals}
+		fs := flag.NewFlagSet(batchCommandName+" wait", flag.ContinueOnError)
+		fs.DurationVar(&c.interval, "interval", 2*time.Second, "how often to check the batch")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		id, err := parseBatchID(fs.Args())
+		if err != nil {
+			return nil, err
+		}
+		c.id = id
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown batch command: '%s'", cmd)
+}
+
+func parseBatchID(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("param required: batch ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid batch ID: '%s'", args[0])
+	}
+	return id, nil
+}
+
+func (cmd batchAdd) Run() error {
+	commands := cmd.commands
+	if len(commands) == 0 {
+		// Read one command per line from stdin.
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				commands = append(commands, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read commands: %w", err)
+		}
+	}
+	if len(commands) == 0 {
+		return fmt.Errorf("no commands given")
+	}
+
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	var batchID int64
+	ids, err := db.AddJobs(commands, inNewBatch(cmd.name, &batchID))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("added batch", "batch", batchID, "jobs", len(ids), "first_id", ids[0], "last_id", ids[len(ids)-1])
+	return nil
+}
+
+func (cmd batchStatus) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	state, err := readBatchState(db, cmd.id)
+	if err != nil {
+		return err
+	}
+	fmt.Println(state)
+	return nil
+}
+
+func (cmd batchWait) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	for {
+		state, err := readBatchState(db, cmd.id)
+		if err != nil {
+			return err
+		}
+		if state.Done() {
+			fmt.Println(state)
+			if n := state.failed(); n > 0 {
+				return fmt.Errorf("%d jobs in batch #%d failed", n, cmd.id)
+			}
+			return nil
+		}
+		time.Sleep(cmd.interval)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"syscall"
+	"time"
+)
+
+// `chime cancel` stops a job that hasn't finished from running: a pending or
+// held job is never started, and a running job's process is stopped with
+// SIGTERM, if it's running on this machine. Either way the job finishes as
+// cancelled, which isn't a failure: it isn't retried and has no follow-up,
+// and the runner that was running it leaves it cancelled once it exits.
+
+type cancelJob struct {
+	globalArgs
+	ref string
+}
+
+// CancelJob cancels a job that hasn't finished. Returns the job as it was
+// before, or nil if it doesn't exist or has already finished. A running
+// job's process is left for the caller to stop.
+func (db *DB) CancelJob(id int64) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := scanJob(tx.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if isTerminal(job.Status) {
+		return nil, nil
+	}
+	if _, err := tx.Exec(`UPDATE jobs SET status = ?, finished_at = ? WHERE id = ?`,
+		statusCancelled, time.Now().UnixMilli(), id); err != nil {
+		return nil, err
+	}
+	detail := ""
+	if job.Status == statusInProgress || job.Status == statusSuspended {
+		detail = "while running"
+	}
+	if err := recordEvents(tx, db.actor, eventCancelled, detail, "id = ?", id); err != nil {
+		return nil, err
+	}
+	return &job, tx.Commit()
+}
+
+func parseCancelSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 && canPickJob() {
+		return cancelJob{globalArgs: globals}, nil
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to cancel")
+	}
+	return cancelJob{globalArgs: globals, ref: args[0]}, nil
+}
+
+func (cmd cancelJob) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.ref == "" {
+		if cmd.ref, err = pickJob(db, "cancel", statusPending, statusHeld, statusInProgress, statusSuspended); err != nil {
+			return err
+		}
+	}
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	was, err := db.CancelJob(int64(job.ID))
+	if err != nil {
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
+	if was == nil {
+		return fmt.Errorf("job #%d has already finished", job.ID)
+	}
+	if was.Status != statusInProgress && was.Status != statusSuspended {
+		slog.Info("cancelled job", "id", was.ID)
+		return nil
+	}
+
+	if was.PID <= 0 || was.Host != "" || !processExists(was.PID) {
+		slog.Warn("cancelled job, but it isn't running as a process on this machine, so it carries on until it exits", "id", was.ID)
+		return nil
+	}
+	if err := signalJob(was.PID, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("cancelled job #%d, but failed to stop it: %w", was.ID, err)
+	}
+	if was.Status == statusSuspended {
+		// So it gets the signal.
+		signalJob(was.PID, sigCont)
+	}
+	slog.Info("cancelled job and stopped its process", "id", was.ID)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Environment variable naming the cgroup v2 directory that jobs with
+// resource limits get their cgroups created in. It must be delegated to the
+// user chime runs as, with no processes of its own. Defaults to the
+// runner's own cgroup.
+const chimeCgroupRootEnvKey = "CHIME_CGROUP_ROOT"
+
+// hasLimits reports whether the job has any resource limits.
+func (job Job) hasLimits() bool {
+	return job.MemLimit > 0 || job.CPULimit > 0
+}
+
+var sizeSuffixes = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// parseSize parses a size in bytes with an optional binary suffix, e.g.
+// "512M", "1.5G" or "2GiB".
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	suffix := ""
+	if n := len(num); n > 0 && num[n-1] >= 'A' && num[n-1] <= 'Z' {
+		num, suffix = num[:n-1], num[n-1:]
+	}
+	mult, ok := sizeSuffixes[suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid size '%s': unknown unit", s)
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// oomFailure is the failure reason recorded for a job killed for exceeding
+// its memory limit.
+func oomFailure(limit int64) string {
+	return fmt.Sprintf("killed for exceeding its memory limit of %s", formatBytes(limit))
+}
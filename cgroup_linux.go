@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const cgroupMount = "/sys/fs/cgroup"
+
+// Period of the CPU bandwidth limit, in microseconds; see cpu.max.
+const cgroupCPUPeriod = 100000
+
+// jobCgroup is a cgroup created for a single execution of a job, to apply
+// its resource limits.
+type jobCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// cgroupRoot returns the directory job cgroups are created in.
+func cgroupRoot() (string, error) {
+	root := os.Getenv(chimeCgroupRootEnvKey)
+	if root == "" {
+		// The runner's own cgroup is on the line for the v2 hierarchy,
+		// which has ID 0 and no controllers: "0::/path".
+		data, err := os.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if path, ok := strings.CutPrefix(line, "0::"); ok {
+				root = filepath.Join(cgroupMount, path)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 isn't available at '%s'; set $%s to a delegated cgroup", root, chimeCgroupRootEnvKey)
+	}
+	return root, nil
+}
+
+// enableControllers makes the controllers available to root's children.
+func enableControllers(root string, controllers ...string) error {
+	data, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(data))
+	if data, err = os.ReadFile(filepath.Join(root, "cgroup.subtree_control")); err != nil {
+		return err
+	}
+	enabled := strings.Fields(string(data))
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			return fmt.Errorf("the %s controller isn't available in '%s'", c, root)
+		}
+		if slices.Contains(enabled, c) {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+c), 0); err != nil {
+			return fmt.Errorf("failed to enable the %s controller in '%s' (it must have no processes of its own; set $%s to a delegated cgroup): %w",
+				c, root, chimeCgroupRootEnvKey, err)
+		}
+	}
+	return nil
+}
+
+// startInCgroup creates a cgroup with the job's resource limits and sets up
+// cmd to be started in it. The cgroup must be removed once the command has
+// exited.
+func startInCgroup(cmd *exec.Cmd, job *Job) (*jobCgroup, error) {
+	root, err := cgroupRoot()
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	if job.MemLimit > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if job.CPULimit > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if err := enableControllers(root, controllers...); err != nil {
+		return nil, err
+	}
+
+	path, err := os.MkdirTemp(root, fmt.Sprintf("chime-job-%d-", job.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &jobCgroup{path: path}
+	if err := cg.setLimits(job); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	if cg.dir, err = os.Open(path); err != nil {
+		cg.remove()
+		return nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// The child is placed in the cgroup as it's created, before it can start
+	// any processes of its own.
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+	return cg, nil
+}
+
+func (cg *jobCgroup) setLimits(job *Job) error {
+	if job.MemLimit > 0 {
+		if err := cg.write("memory.max", strconv.FormatInt(job.MemLimit, 10)); err != nil {
+			return err
+		}
+		// Don't let the job get around the limit by swapping; not every
+		// kernel has swap accounting.
+		if err := cg.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if job.CPULimit > 0 {
+		quota := int64(job.CPULimit * cgroupCPUPeriod)
+		if err := cg.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cg *jobCgroup) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to set %s: %w", file, err)
+	}
+	return nil
+}
+
+// oomKilled reports whether any of the job's processes were killed for
+// exceeding its memory limit.
+func (cg *jobCgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if n, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			count, _ := strconv.Atoi(n)
+			return count > 0
+		}
+	}
+	return false
+}
+
+// remove deletes the cgroup. It fails if any of the job's processes are
+// still running, e.g. ones it left in the background.
+func (cg *jobCgroup) remove() {
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	if err := os.Remove(cg.path); err != nil {
+		slog.Warn("failed to remove cgroup", "path", cg.path, "err", err)
+	}
+}
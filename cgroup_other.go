@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type jobCgroup struct{}
+
+func startInCgroup(cmd *exec.Cmd, job *Job) (*jobCgroup, error) {
+	return nil, fmt.Errorf("resource limits are only supported on Linux")
+}
+
+func (cg *jobCgroup) oomKilled() bool { return false }
+
+func (cg *jobCgroup) remove() {}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// `chime clone 42` adds a new pending job like job 42, which may have
+// finished, so "run that again, but tweaked" is one command: flags change
+// the clone's command, priority, queue, name or note, or add tags and
+// environment variables to it. The clone keeps the job's script, if it had
+// one, and its settings such as limits, retries and follow-ups, but not its
+// array, batch or parent, and it's added by the user cloning it. Jobs run in
+// their runner's working directory, so there's none to copy.
+
+type clone struct {
+	globalArgs
+	ref string
+	// Edits to the clone; unset fields are copied from the job.
+	command  string
+	priority *int
+	queue    string
+	name     string
+	note     *string
+	tags     stringList
+	env      stringList
+}
+
+func parseCloneSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := clone{globalArgs: globals}
+	fs := flag.NewFlagSet(cloneCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.command, "command", "", "command for the clone to run instead of the job's, or its script's")
+	fs.Func("priority", "priority of the clone instead of the job's", func(s string) error {
+		p, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid priority: '%s'", s)
+		}
+		cmd.priority = &p
+		return nil
+	})
+	fs.StringVar(&cmd.queue, "queue", "", "queue to add the clone to instead of the job's")
+	fs.StringVar(&cmd.name, "name", "", "name to refer to the clone by; the job's name isn't copied")
+	fs.Func("note", "note about the clone instead of the job's; empty for none", func(s string) error {
+		cmd.note = &s
+		return nil
+	})
+	fs.Var(&cmd.tags, "tag", "tag to add to the clone; may be repeated")
+	fs.Var(&cmd.env, "env", "KEY=VALUE variable to set for the clone, overriding the job's; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to clone")
+	}
+	cmd.ref = fs.Arg(0)
+	for _, kv := range cmd.env {
+		if err := validateEnvVar(kv); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.name != "" {
+		if err := validateJobName(cmd.name); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+func (cmd clone) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	spec := job.JSON().spec()
+	spec.UUID, spec.SubmittedBy, spec.Name, spec.FollowUp = "", "", cmd.name, false
+	if strings.TrimSpace(cmd.command) != "" {
+		spec.Command, spec.Script, spec.Template = cmd.command, "", ""
+	}
+	if cmd.priority != nil {
+		spec.Priority = *cmd.priority
+	}
+	if cmd.queue != "" {
+		spec.Queue = cmd.queue
+	}
+	if cmd.note != nil {
+		spec.Note = *cmd.note
+	}
+	for _, tag := range cmd.tags {
+		if !spec.Tags.Has(tag) {
+			spec.Tags = append(spec.Tags, tag)
+		}
+	}
+	for _, kv := range cmd.env {
+		key, _, _ := strings.Cut(kv, "=")
+		spec.Env = slices.DeleteFunc(spec.Env, func(e string) bool { return strings.HasPrefix(e, key+"=") })
+		spec.Env = append(spec.Env, kv)
+	}
+
+	id, err := db.insertJob(spec, fmt.Sprintf("clone of #%d", job.ID))
+	if err != nil {
+		return err
+	}
+	slog.Info("added job", "id", id, "clone_of", job.ID)
+	return nil
+}
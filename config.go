@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Settings that belong to a user rather than to a DB, such as how tables are
+// colored, are read from config.json in a chime directory in the user's
+// config directory, e.g. ~/.config/chime/config.json on Linux, or from the
+// file named by $CHIME_CONFIG. The file is optional.
+
+const chimeConfigEnvKey = "CHIME_CONFIG"
+
+// userConfig is the contents of the config file.
+type userConfig struct {
+	Theme themeConfig `json:"theme"`
+}
+
+// userConfigPath returns the path of the config file.
+func userConfigPath() (string, error) {
+	if path := os.Getenv(chimeConfigEnvKey); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chime", "config.json"), nil
+}
+
+// loadUserConfig reads the config file, returning an empty config if there
+// isn't one.
+func loadUserConfig() (userConfig, error) {
+	var cfg userConfig
+	path, err := userConfigPath()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
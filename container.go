@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Environment variable naming the container runtime that runs jobs with an
+// image, e.g. docker or podman. Defaults to whichever of those is on the PATH.
+const chimeContainerRuntimeEnvKey = "CHIME_CONTAINER_RUNTIME"
+
+// Variables describing the runner's host that aren't passed into containers,
+// since they'd override the image's own.
+var hostOnlyEnvVars = []string{"PATH", "HOME", "HOSTNAME", "TMPDIR", "PWD", "OLDPWD", "SHELL", "SHLVL", "_"}
+
+// containerRuntime returns the command used to run containers.
+func containerRuntime() (string, error) {
+	if runtime := os.Getenv(chimeContainerRuntimeEnvKey); runtime != "" {
+		return runtime, nil
+	}
+	for _, runtime := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(runtime); err == nil {
+			return runtime, nil
+		}
+	}
+	return "", fmt.Errorf("no container runtime found: install docker or podman, or set $%s", chimeContainerRuntimeEnvKey)
+}
+
+// validateImage checks an image given to --image. Images can't start with a
+// dash, which the container runtime would take as an option.
+func validateImage(image string) error {
+	if err := validateName("image", image); err != nil {
+		return err
+	}
+	if strings.HasPrefix(image, "-") {
+		return fmt.Errorf("invalid image '%s': must not start with '-'", image)
+	}
+	return nil
+}
+
+// containerCommand wraps a job's command, as built by jobCommand, so it runs
+// in a container of the job's image. The container gets the job's
+// environment, and the runner's working directory, the job's script file (if
+// any) and mounts, the job's artifacts directory and progress file, are
+// mounted at the same paths as on the host. The job's resource limits are
+// applied by the container runtime.
+func containerCommand(cmd *exec.Cmd, job *Job, env []string, mounts []string) (*exec.Cmd, error) {
+	// Jobs imported from older versions weren't validated.
+	if err := validateImage(job.Image); err != nil {
+		return nil, err
+	}
+	runtime, err := containerRuntime()
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"run", "--rm", "--init",
+		"--label", "chime.job.id=" + strconv.Itoa(job.ID),
+		"--label", "chime.job.uuid=" + job.UUID,
+		"--volume", cwd + ":" + cwd,
+		"--workdir", cwd,
+	}
+	if job.Script != "" {
+		// The script file is always the last argument.
+		script := cmd.Args[len(cmd.Args)-1]
+		args = append(args, "--volume", script+":"+script+":ro")
+	}
+	for _, path := range mounts {
+		args = append(args, "--volume", path+":"+path)
+	}
+	// Values are passed through the runtime's environment, which is the
+	// job's, so they don't show up in the process list.
+	seen := map[string]bool{}
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if seen[key] || slices.Contains(hostOnlyEnvVars, key) {
+			continue
+		}
+		seen[key] = true
+		args = append(args, "--env", key)
+	}
+	if job.MemLimit > 0 {
+		args = append(args, "--memory", strconv.FormatInt(job.MemLimit, 10), "--memory-swap", strconv.FormatInt(job.MemLimit, 10))
+	}
+	if job.CPULimit > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(job.CPULimit, 'f', -1, 64))
+	}
+	args = append(args, job.Image)
+	args = append(args, cmd.Args...)
+
+	wrapped := exec.Command(runtime, args...)
+	wrapped.Env = env
+	return wrapped, nil
+}
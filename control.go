@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A running `chime run` accepts control requests on a Unix socket next to
+// the DB. Each connection carries a single JSON request line, answered by a
+// single JSON response line.
+
+type controlRequest struct {
+	Command string `json:"command"`
+	Workers int    `json:"workers,omitempty"`
+	// Duration of a burst, in time.ParseDuration format.
+	For string `json:"for,omitempty"`
+}
+
+type controlResponse struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	controlScale   = "scale"
+	controlBurst   = "burst"
+	controlWorkers = "workers"
+	controlPause   = "pause"
+	controlResume  = "resume"
+	controlStatus  = "status"
+	controlStop    = "stop"
+)
+
+// runControl lets a run be paused, so it takes no new jobs until resumed,
+// and stopped, so it takes no new jobs at all and exits once its running
+// jobs have finished. Unlike `chime pause`, pausing only affects this run.
+type runControl struct {
+	lock     sync.Mutex
+	paused   bool
+	resumed  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newRunControl() *runControl {
+	return &runControl{stop: make(chan struct{})}
+}
+
+// stopped is closed once the run has been told to stop.
+func (c *runControl) stopped() <-chan struct{} {
+	return c.stop
+}
+
+// stopTaking stops the run taking new jobs, reporting whether it was still
+// taking them.
+func (c *runControl) stopTaking() bool {
+	stopped := false
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		stopped = true
+	})
+	return stopped
+}
+
+// drain stops the run taking new jobs and lets the running ones finish,
+// logging that it's doing so the first time. Reports whether the run was
+// still taking jobs.
+func (c *runControl) drain() bool {
+	if !c.stopTaking() {
+		return false
+	}
+	slog.Info("stopping once running jobs finish")
+	return true
+}
+
+func (c *runControl) setPaused(paused bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.paused == paused {
+		return false
+	}
+	c.paused = paused
+	if paused {
+		c.resumed = make(chan struct{})
+	} else {
+		close(c.resumed)
+	}
+	return true
+}
+
+func (c *runControl) isPaused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.paused
+}
+
+// wait waits until the run isn't paused. Returns false if ctx is cancelled
+// first.
+func (c *runControl) wait(ctx context.Context) bool {
+	c.lock.Lock()
+	paused, resumed := c.paused, c.resumed
+	c.lock.Unlock()
+	if !paused {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
+
+func controlSocketPath(dbPath string) string {
+	return dbPath + ".sock"
+}
+
+// runIsActive reports whether a `chime run` is serving control requests for
+// the DB at dbPath.
+func runIsActive(dbPath string) bool {
+	conn, err := net.Dial("unix", controlSocketPath(dbPath))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// controlServer serves control requests for a running worker pool.
+type controlServer struct {
+	listener net.Listener
+	path     string
+	pool     *workerPool
+	run      *runControl
+}
+
+// listenControl starts serving control requests on the socket at path. It
+// fails if another run is already listening there.
+func listenControl(path string, pool *workerPool, run *runControl) (*controlServer, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another run is already listening on %s", path)
+	}
+	// Remove a stale socket left behind by a run that didn't exit cleanly.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &controlServer{listener: listener, path: path, pool: pool, run: run}
+	go s.serve()
+	return s, nil
+}
+
+func (s *controlServer) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("control socket failed to accept", "err", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var resp controlResponse
+	var req controlRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %s", err)
+	} else if resp.Message, err = s.dispatch(req); err != nil {
+		resp.Error = err.Error()
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		slog.Error("control socket failed to send response", "err", err)
+	}
+}
+
+func (s *controlServer) dispatch(req controlRequest) (string, error) {
+	switch req.Command {
+	case controlScale:
+		if err := s.pool.Scale(req.Workers); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("scaled to %d workers", req.Workers), nil
+	case controlBurst:
+		d, err := time.ParseDuration(req.For)
+		if err != nil {
+			return "", fmt.Errorf("invalid burst duration: %w", err)
+		}
+		if err := s.pool.Burst(req.Workers, d); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("bursting to %d workers until %s", req.Workers, time.Now().Add(d).Format(time.DateTime)), nil
+	case controlWorkers:
+		n, burstUntil := s.pool.Size()
+		if burstUntil.IsZero() {
+			return fmt.Sprintf("%d workers", n), nil
+		}
+		return fmt.Sprintf("%d workers, bursting until %s", n, burstUntil.Format(time.DateTime)), nil
+	case controlPause:
+		if !s.run.setPaused(true) {
+			return "", fmt.Errorf("the run is already paused")
+		}
+		slog.Info("paused; not taking new jobs until resumed")
+		return "paused the run; running jobs carry on", nil
+	case controlResume:
+		if !s.run.setPaused(false) {
+			return "", fmt.Errorf("the run isn't paused")
+		}
+		slog.Info("resumed")
+		return "resumed the run", nil
+	case controlStatus:
+		return s.status(), nil
+	case controlStop:
+		s.run.drain()
+		return "stopping once running jobs finish", nil
+	}
+	return "", fmt.Errorf("unknown control command: '%s'", req.Command)
+}
+
+// status describes the state of the run, e.g. "running, 4 workers (2 busy),
+// 17 jobs started in 1h5m".
+func (s *controlServer) status() string {
+	state := "running"
+	select {
+	case <-s.run.stopped():
+		state = "stopping"
+	default:
+		if s.run.isPaused() {
+			state = "paused"
+		}
+	}
+	workers, burstUntil := s.pool.Size()
+	burst := ""
+	if !burstUntil.IsZero() {
+		burst = fmt.Sprintf(", bursting until %s", burstUntil.Format(time.DateTime))
+	}
+
+	r := s.pool.recorder
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	uptime := time.Since(time.UnixMilli(r.summary.StartedAt)).Round(time.Second)
+	return fmt.Sprintf("%s, %d workers (%d busy)%s, %d jobs started in %s: %d succeeded, %d failed",
+		state, workers, r.busy, burst, r.started, uptime, r.summary.NumSucceeded, r.summary.NumFailed)
+}
+
+// sendControl sends a request to the run listening on the socket at path.
+func sendControl(path string, req controlRequest) (string, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to a running `chime run` (is one running?): %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Message, nil
+}
+
+// controlCommand sends a single control request to the running `chime run`.
+type controlCommand struct {
+	globalArgs
+	req controlRequest
+}
+
+// parseWorkerCount parses a number of workers, or "auto" for one per CPU.
+func parseWorkerCount(s string) (int, error) {
+	if s == "auto" {
+		return runtime.NumCPU(), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number of workers: '%s'", s)
+	}
+	return n, nil
+}
+
+func parseScaleSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	var burst int
+	var burstFor time.Duration
+	fs := flag.NewFlagSet(scaleCommandName, flag.ContinueOnError)
+	fs.IntVar(&burst, "burst", 0, "temporarily raise the number of workers to this")
+	fs.DurationVar(&burstFor, "for", time.Hour, "how long a burst lasts")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if burst > 0 {
+		if fs.NArg() != 0 {
+			return nil, fmt.Errorf("number of workers can't be combined with --burst")
+		}
+		return controlCommand{
+			globalArgs: globals,
+			req:        controlRequest{Command: controlBurst, Workers: burst, For: burstFor.String()},
+		}, nil
+	}
+
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: number of workers, or --burst")
+	}
+	n, err := parseWorkerCount(fs.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	return controlCommand{
+		globalArgs: globals,
+		req:        controlRequest{Command: controlScale, Workers: n},
+	}, nil
+}
+
+// parseWorkersSubcommand parses `workers`, which shows the number of workers
+// of the running `chime run`, or `workers set <n>`, which is `scale <n>`.
+func parseWorkersSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return controlCommand{globalArgs: globals, req: controlRequest{Command: controlWorkers}}, nil
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "set" {
+		return nil, fmt.Errorf("unknown workers command: '%s'", cmd)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: number of workers, or auto")
+	}
+	n, err := parseWorkerCount(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return controlCommand{
+		globalArgs: globals,
+		req:        controlRequest{Command: controlScale, Workers: n},
+	}, nil
+}
+
+func (cmd controlCommand) Run() error {
+	msg, err := sendControl(controlSocketPath(cmd.globalArgs.dbPath), cmd.req)
+	if err != nil {
+		return err
+	}
+	slog.Info(msg)
+	return nil
+}
+
+// parseCtlSubcommand parses `ctl <command>`, which sends a control request to
+// the running `chime run`.
+func parseCtlSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ctl command required: pause, resume, status, stop, scale or burst")
+	}
+	cmd, args := args[0], args[1:]
+	req := controlRequest{Command: cmd}
+	switch cmd {
+	case controlPause, controlResume, controlStatus, controlStop:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args)
+		}
+	case controlScale:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: number of workers, or auto")
+		}
+		n, err := parseWorkerCount(args[0])
+		if err != nil {
+			return nil, err
+		}
+		req.Workers = n
+	case controlBurst:
+		if len(args) != 2 {
+			return nil, fmt.Errorf("params required: number of workers and how long the burst lasts")
+		}
+		n, err := parseWorkerCount(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := time.ParseDuration(args[1]); err != nil {
+			return nil, fmt.Errorf("invalid burst duration: %w", err)
+		}
+		req.Workers, req.For = n, args[1]
+	default:
+		return nil, fmt.Errorf("unknown ctl command: '%s'", cmd)
+	}
+	return controlCommand{globalArgs: globals, req: req}, nil
+}
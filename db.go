@@ -1,19 +1,42 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrDuplicateName is returned when adding a job whose name is already used
+// by a pending or running job.
+var ErrDuplicateName = errors.New("a pending or running job already has that name")
+
+// insertErr translates errors from inserting jobs.
+func insertErr(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrDuplicateName
+	}
+	if isPendingLimitErr(err) {
+		return ErrPendingLimit
+	}
+	return err
+}
+
 type DB struct {
 	lock *sync.Mutex
 	*sql.DB
+	// Recorded as the actor of the job events caused through this DB.
+	actor string
 }
 
 const (
@@ -21,16 +44,455 @@ const (
 	statusInProgress  int = 1
 	statusDoneSuccess int = 2
 	statusDoneFailed  int = 3
+	// Running, but stopped with SIGSTOP until it's resumed.
+	statusSuspended int = 4
+	// Pending, but not to be run until it's released.
+	statusHeld int = 5
+	// Failed, and out of retries; see dlq.go.
+	statusDeadLetter int = 6
+	// Cancelled before it finished; see cancel.go.
+	statusCancelled int = 7
+	// Killed for running longer than its timeout.
+	statusTimedOut int = 8
 )
 
+// Names used for statuses in filters and machine-readable output.
+var statusNames = map[int]string{
+	statusPending:     "pending",
+	statusInProgress:  "running",
+	statusDoneSuccess: "succeeded",
+	statusDoneFailed:  "failed",
+	statusSuspended:   "suspended",
+	statusHeld:        "held",
+	statusDeadLetter:  "dead",
+	statusCancelled:   "cancelled",
+	statusTimedOut:    "timed-out",
+}
+
+// Statuses of jobs that have finished.
+var terminalStatuses = []int{statusDoneSuccess, statusDoneFailed, statusTimedOut, statusCancelled, statusDeadLetter}
+
+func isTerminal(status int) bool {
+	for _, s := range terminalStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Order in which statuses are reported.
+var allStatuses = []int{statusPending, statusHeld, statusInProgress, statusSuspended, statusDoneSuccess, statusDoneFailed, statusTimedOut, statusCancelled, statusDeadLetter}
+
+// isFailure reports whether a finished job's status means it failed, rather
+// than succeeding or being cancelled.
+func isFailure(status int) bool {
+	return status == statusDoneFailed || status == statusTimedOut || status == statusDeadLetter
+}
+
+func parseStatus(name string) (int, error) {
+	for status, n := range statusNames {
+		if n == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown status '%s'", name)
+}
+
+// JobFilter selects jobs matching all of its non-empty fields.
+type JobFilter struct {
+	Statuses []int
+	// Jobs must have every one of these tags.
+	Tags    []string
+	BatchID int64
+	Queue   string
+	// Jobs must have one of these IDs, or an ID in one of these ranges.
+	IDs      []int64
+	IDRanges []IDRange
+	// If non-zero, jobs must have finished before this time (unix millis).
+	FinishedBefore int64
+}
+
+// IDRange is an inclusive range of job IDs.
+type IDRange struct {
+	First, Last int64
+}
+
+func (f JobFilter) IsEmpty() bool {
+	return len(f.Statuses) == 0 && len(f.Tags) == 0 && f.BatchID == 0 && f.Queue == "" &&
+		len(f.IDs) == 0 && len(f.IDRanges) == 0 && f.FinishedBefore == 0
+}
+
+// where returns a SQL condition matching the filter, and its arguments.
+func (f JobFilter) where() (string, []any) {
+	conds := []string{"1=1"}
+	var args []any
+	if len(f.Statuses) > 0 {
+		conds = append(conds, "status IN ("+placeholders(len(f.Statuses))+")")
+		for _, s := range f.Statuses {
+			args = append(args, s)
+		}
+	}
+	for _, tag := range f.Tags {
+		conds = append(conds, "instr(',' || tags || ',', ?) > 0")
+		args = append(args, ","+tag+",")
+	}
+	if f.BatchID != 0 {
+		conds = append(conds, "batch_id = ?")
+		args = append(args, f.BatchID)
+	}
+	if f.Queue != "" {
+		conds = append(conds, "queue = ?")
+		args = append(args, f.Queue)
+	}
+	if len(f.IDs) > 0 || len(f.IDRanges) > 0 {
+		var idConds []string
+		if len(f.IDs) > 0 {
+			idConds = append(idConds, "id IN ("+placeholders(len(f.IDs))+")")
+			for _, id := range f.IDs {
+				args = append(args, id)
+			}
+		}
+		for _, r := range f.IDRanges {
+			idConds = append(idConds, "id BETWEEN ? AND ?")
+			args = append(args, r.First, r.Last)
+		}
+		conds = append(conds, "("+strings.Join(idConds, " OR ")+")")
+	}
+	if f.FinishedBefore != 0 {
+		conds = append(conds, "finished_at != 0 AND finished_at < ?")
+		args = append(args, f.FinishedBefore)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
 type Job struct {
-	ID         int    `db:"id"`
-	Command    string `db:"command"`
-	PID        int    `db:"pid"`
-	Status     int    `db:"status"`
-	CreatedAt  int64  `db:"created_at"`
-	StartedAt  int64  `db:"started_at"`
-	FinishedAt int64  `db:"finished_at"`
+	ID         int       `db:"id"`
+	Command    string    `db:"command"`
+	PID        int       `db:"pid"`
+	Status     int       `db:"status"`
+	CreatedAt  int64     `db:"created_at"`
+	StartedAt  int64     `db:"started_at"`
+	FinishedAt int64     `db:"finished_at"`
+	Script     string    `db:"script"`
+	Template   string    `db:"template"`
+	ArrayID    int       `db:"array_id"`
+	ArrayIndex int       `db:"array_index"`
+	Priority   int       `db:"priority"`
+	Tags       CommaList `db:"tags"`
+	BatchID    int       `db:"batch_id"`
+	EnvMode    string    `db:"env_mode"`
+	EnvAllow   CommaList `db:"env_allow"`
+	Env        EnvVars   `db:"env"`
+	Queue      string    `db:"queue"`
+	Name       string    `db:"name"`
+	UUID       string    `db:"uuid"`
+	// Exit code of the job's process, or -1 if it hasn't exited or was killed.
+	ExitCode int `db:"exit_code"`
+	// CPU time used by the job's process and the children it waited for, in
+	// milliseconds, and their peak resident set size in KiB, or -1 if unknown.
+	UserCPU int64 `db:"user_cpu_ms"`
+	SysCPU  int64 `db:"sys_cpu_ms"`
+	MaxRSS  int64 `db:"max_rss_kb"`
+	// Niceness and I/O scheduling class the job runs with; zero values leave
+	// the runner's.
+	Nice   int    `db:"nice"`
+	IONice string `db:"ionice"`
+	// Memory limit in bytes and CPU limit in cores, or 0 for none.
+	MemLimit int64   `db:"mem_limit"`
+	CPULimit float64 `db:"cpu_limit"`
+	// Why the job failed, e.g. its exit status or a limit it exceeded.
+	Failure string `db:"failure"`
+	// Container image the job runs in, or empty to run it on the host.
+	Image string `db:"image"`
+	// Remote host or host pool the job runs on over ssh, or empty to run it
+	// on the runner's host.
+	Host string `db:"host"`
+	// Remote worker a running job is leased to, and when the lease expires
+	// (Unix ms), or 0 if the job was taken by a local run.
+	LeaseOwner     string `db:"lease_owner"`
+	LeaseExpiresAt int64  `db:"lease_expires_at"`
+	// Worker that started the job, e.g. "alice@build1[4242] worker 3".
+	Worker string `db:"worker"`
+	// Labels a worker must have to take the job.
+	Requires CommaList `db:"requires"`
+	// Time of day the job may start in, e.g. 22:00-06:00, or empty for any
+	// time; see window.go.
+	TimeWindow string `db:"time_window"`
+	// Commands run as child jobs once the job has succeeded or failed; see
+	// followup.go. ParentID is the job that added this one, either as its
+	// follow-up, or from within it with add --parent; see lineage.go.
+	OnSuccess string `db:"on_success"`
+	OnFailure string `db:"on_failure"`
+	ParentID  int64  `db:"parent_id"`
+	FollowUp  bool   `db:"follow_up"`
+	// How many times the job is retried if it fails, and how many times it
+	// has been so far.
+	MaxRetries int `db:"max_retries"`
+	Retries    int `db:"retries"`
+	// Name of a lock the job holds while it runs, so no two jobs with the
+	// same lock run at once, or empty for none.
+	Lock string `db:"lock_name"`
+	// Number of a run's worker slots the job occupies while it runs.
+	Slots int `db:"slots"`
+	// Devices the job needs, as name=count pairs like gpus=1, and once a
+	// run has handed it to a worker, the indices of the devices it got; see
+	// resources.go.
+	Resources CommaList `db:"resources"`
+	Devices   map[string][]int
+	// Free-text note about the job; see notes.go.
+	Note string `db:"note"`
+	// File the job's output was saved to, or empty if it wasn't; see
+	// output.go.
+	OutputPath string `db:"output_path"`
+	// OS user who added the job; see users.go.
+	SubmittedBy string `db:"submitted_by"`
+	// Artifacts directory of the job's latest execution, or empty if it had
+	// none; see artifacts.go.
+	ArtifactsDir string `db:"artifacts_dir"`
+	// Latest progress the job reported, from 0 to 100, or -1 if it hasn't
+	// reported any, and its message; see progress.go.
+	Progress        int    `db:"progress"`
+	ProgressMessage string `db:"progress_message"`
+	// How long the job may run before it's killed, in milliseconds, or 0
+	// for no limit.
+	Timeout int64 `db:"timeout_ms"`
+	// Number of times the job has been claimed, counting the attempt it's
+	// on if it's running; see attempts.go.
+	Attempts int `db:"attempts"`
+}
+
+// JobSpec describes a job to be enqueued.
+type JobSpec struct {
+	Command string
+	// Script, if set, is the content of a script snapshotted at add time;
+	// it is executed instead of Command, which then just describes it.
+	Script string
+	// Template is the name of the template the command was rendered from.
+	Template string
+	// ArrayID and ArrayIndex are set for jobs expanded from an array; the
+	// array ID is the ID of the array's first job.
+	ArrayID    int
+	ArrayIndex int
+	// Jobs with higher priority are taken first.
+	Priority int
+	Tags     CommaList
+	BatchID  int64
+	// EnvMode and EnvAllow override the runner's environment mode and
+	// allowlist; Env is applied on top of the resulting environment.
+	EnvMode  string
+	EnvAllow CommaList
+	Env      EnvVars
+	// Queue defaults to defaultQueue if empty.
+	Queue string
+	// Name, if set, must be unique among pending and running jobs.
+	Name string
+	// Nice and IONice set the CPU and I/O scheduling priority of the job's
+	// process; see applySchedPriority.
+	Nice   int
+	IONice string
+	// MemLimit (bytes) and CPULimit (cores) are enforced with a cgroup.
+	MemLimit int64
+	CPULimit float64
+	// Image, if set, is the container image the job runs in.
+	Image string
+	// Host, if set, is the remote host or host pool the job runs on.
+	Host string
+	// Requires lists the labels a worker must have to take the job.
+	Requires CommaList
+	// TimeWindow, if set, is the time of day the job may start in.
+	TimeWindow string
+	// OnSuccess and OnFailure are follow-up commands. ParentID is set for
+	// child jobs, and FollowUp for those that are a follow-up.
+	OnSuccess string
+	OnFailure string
+	ParentID  int64
+	FollowUp  bool
+	// MaxRetries is how many times the job is requeued if it fails, before
+	// it's dead-lettered.
+	MaxRetries int
+	// Lock, if set, keeps the job from running alongside others with it.
+	Lock string
+	// Slots is the number of worker slots the job occupies; 0 means 1.
+	Slots int
+	// Resources are the devices the job needs, as name=count pairs.
+	Resources CommaList
+	Note      string
+	// SubmittedBy is the user adding the job; the current OS user if empty.
+	SubmittedBy string
+	// UUID is kept for jobs imported from another DB; a new one is made if
+	// empty.
+	UUID string
+	// Timeout, if set, is how long the job may run before it's killed.
+	Timeout time.Duration
+}
+
+const defaultQueue = "default"
+
+// CommaList is a list of strings stored as a comma-separated string, such as
+// a job's tags.
+type CommaList []string
+
+func (t CommaList) String() string {
+	return strings.Join(t, ",")
+}
+
+func (t *CommaList) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("unsupported type for list: %T", src)
+	}
+	*t = nil
+	if s != "" {
+		*t = strings.Split(s, ",")
+	}
+	return nil
+}
+
+func (t CommaList) Has(tag string) bool {
+	for _, have := range t {
+		if have == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// insertJobColumns are the columns set when a job is added; see insertArgs.
+const insertJobColumns = `command, status, created_at, started_at, finished_at, script, template, array_id, array_index, priority, tags, batch_id, env_mode, env_allow, env, queue, name, uuid, nice, ionice, mem_limit, cpu_limit, image, host, requires, time_window, on_success, on_failure, parent_id, max_retries, lock_name, slots, resources, note, submitted_by, timeout_ms, follow_up`
+
+var insertJobSQL = `INSERT INTO jobs (` + insertJobColumns + `) values (` + placeholders(strings.Count(insertJobColumns, ",")+1) + `)`
+
+func (spec JobSpec) insertArgs(createdAt int64) []any {
+	return []any{
+		spec.Command,
+		statusPending,
+		createdAt,
+		0,
+		0,
+		spec.Script,
+		spec.Template,
+		spec.ArrayID,
+		spec.ArrayIndex,
+		spec.Priority,
+		spec.Tags.String(),
+		spec.BatchID,
+		spec.EnvMode,
+		spec.EnvAllow.String(),
+		spec.Env,
+		spec.queue(),
+		spec.Name,
+		cmp.Or(spec.UUID, newUUID()),
+		spec.Nice,
+		spec.IONice,
+		spec.MemLimit,
+		spec.CPULimit,
+		spec.Image,
+		spec.Host,
+		spec.Requires.String(),
+		spec.TimeWindow,
+		spec.OnSuccess,
+		spec.OnFailure,
+		spec.ParentID,
+		spec.MaxRetries,
+		spec.Lock,
+		max(spec.Slots, 1),
+		spec.Resources.String(),
+		spec.Note,
+		spec.submitter(),
+		spec.Timeout.Milliseconds(),
+		spec.FollowUp,
+	}
+}
+
+func (spec JobSpec) submitter() string {
+	if spec.SubmittedBy == "" {
+		return osUserName()
+	}
+	return spec.SubmittedBy
+}
+
+func (spec JobSpec) queue() string {
+	if spec.Queue == "" {
+		return defaultQueue
+	}
+	return spec.Queue
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const jobColumns = `id, command, pid, status, created_at, started_at, finished_at, script, template, array_id, array_index, priority, tags, batch_id, env_mode, env_allow, env, queue, name, uuid, exit_code, user_cpu_ms, sys_cpu_ms, max_rss_kb, nice, ionice, mem_limit, cpu_limit, failure, image, host, lease_owner, lease_expires_at, worker, requires, time_window, on_success, on_failure, parent_id, max_retries, retries, lock_name, slots, resources, note, output_path, submitted_by, artifacts_dir, progress, progress_message, timeout_ms, follow_up, attempts`
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.Command,
+		&job.PID,
+		&job.Status,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.Script,
+		&job.Template,
+		&job.ArrayID,
+		&job.ArrayIndex,
+		&job.Priority,
+		&job.Tags,
+		&job.BatchID,
+		&job.EnvMode,
+		&job.EnvAllow,
+		&job.Env,
+		&job.Queue,
+		&job.Name,
+		&job.UUID,
+		&job.ExitCode,
+		&job.UserCPU,
+		&job.SysCPU,
+		&job.MaxRSS,
+		&job.Nice,
+		&job.IONice,
+		&job.MemLimit,
+		&job.CPULimit,
+		&job.Failure,
+		&job.Image,
+		&job.Host,
+		&job.LeaseOwner,
+		&job.LeaseExpiresAt,
+		&job.Worker,
+		&job.Requires,
+		&job.TimeWindow,
+		&job.OnSuccess,
+		&job.OnFailure,
+		&job.ParentID,
+		&job.MaxRetries,
+		&job.Retries,
+		&job.Lock,
+		&job.Slots,
+		&job.Resources,
+		&job.Note,
+		&job.OutputPath,
+		&job.SubmittedBy,
+		&job.ArtifactsDir,
+		&job.Progress,
+		&job.ProgressMessage,
+		&job.Timeout,
+		&job.FollowUp,
+		&job.Attempts,
+	)
+	return job, err
 }
 
 func (job Job) CreatedAtTime() time.Time {
@@ -45,18 +507,16 @@ func (job Job) StartedAtTime() time.Time {
 	return time.UnixMilli(job.StartedAt)
 }
 
+// timeout returns how long the job may run, or 0 for no limit.
+func (job Job) timeout() time.Duration {
+	return time.Duration(job.Timeout) * time.Millisecond
+}
+
 func (job Job) String() string {
 	sb := strings.Builder{}
 	sb.WriteString(fmt.Sprintf("%d: ", job.ID))
-	switch job.Status {
-	case statusPending:
-		sb.WriteString("[ ] ")
-	case statusInProgress:
-		sb.WriteString("[-] ")
-	case statusDoneSuccess:
-		sb.WriteString("[x] ")
-	case statusDoneFailed:
-		sb.WriteString("[!] ")
+	if glyph := currentTheme().glyph(job.Status); glyph != "" {
+		sb.WriteString(glyph + " ")
 	}
 	sb.WriteString(job.Command)
 	if job.PID > 0 {
@@ -76,45 +536,297 @@ func (job Job) String() string {
 }
 
 func (db *DB) SetJobPID(jobID int64, pid int64) error {
+	return db.setJobPID(jobID, pid, fmt.Sprintf("pid %d", pid))
+}
+
+// SetRemoteJobPID records the pid of the ssh client running a job on host.
+func (db *DB) SetRemoteJobPID(jobID int64, pid int64, host string) error {
+	return db.setJobPID(jobID, pid, fmt.Sprintf("ssh %s, pid %d", host, pid))
+}
+
+func (db *DB) setJobPID(jobID int64, pid int64, detail string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	_, err := db.Exec("UPDATE jobs SET pid=? WHERE id=?", pid, jobID)
-	return err
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE jobs SET pid=?, worker=? WHERE id=?", pid, db.actor, jobID); err != nil {
+		return err
+	}
+	if err := recordEvents(tx, db.actor, eventStarted, detail, "id = ?", jobID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordJobStarted records that a job started somewhere other than a local
+// process, such as on a cluster, described by where.
+func (db *DB) RecordJobStarted(jobID int64, where string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE jobs SET worker=? WHERE id=?", db.actor, jobID); err != nil {
+		return err
+	}
+	if err := recordEvents(tx, db.actor, eventStarted, where, "id = ?", jobID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (db *DB) SetJobStatus(jobID int64, status int64) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	_, err := db.Exec("UPDATE jobs SET status=?, finished_at=? WHERE id=?", status, time.Now().UnixMilli(), jobID)
-	return err
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE jobs SET status=?, finished_at=? WHERE id=?", status, time.Now().UnixMilli(), jobID); err != nil {
+		return err
+	}
+	if err := recordEvents(tx, db.actor, eventFinished, statusNames[int(status)], "id = ?", jobID); err != nil {
+		return err
+	}
+	if err := recordAttempts(tx, statusNames[int(status)], "id = ?", jobID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (db *DB) TakeNextJob() (*Job, error) {
+// FinishJob marks a job as finished with the given status, recording the
+// exit code and resource usage of its process, and why it failed if it did.
+// A job cancelled while it ran stays cancelled.
+func (db *DB) FinishJob(jobID int64, status int64, exitCode int, usage jobUsage, failure string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	var job Job
-	if err := db.QueryRow(`
-	WITH selected_job AS (
-		SELECT * FROM jobs
-		WHERE status = 0
-		ORDER BY id ASC
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := finishJob(tx, db.actor, jobID, status, exitCode, usage, failure, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// finishJob finishes a job for FinishJob in a transaction of the caller's.
+// The job's attempt is recorded as having ended with outcome, or with the
+// job's status if outcome is empty.
+func finishJob(tx *sql.Tx, actor string, jobID int64, status int64, exitCode int, usage jobUsage, failure, outcome string) error {
+	var current, retries, maxRetries int
+	if err := tx.QueryRow(`SELECT status, retries, max_retries FROM jobs WHERE id = ?`, jobID).Scan(&current, &retries, &maxRetries); err != nil {
+		return err
+	}
+	if current == statusCancelled {
+		if _, err := tx.Exec("UPDATE jobs SET exit_code=?, user_cpu_ms=?, sys_cpu_ms=?, max_rss_kb=? WHERE id=?",
+			exitCode, usage.UserCPU, usage.SysCPU, usage.MaxRSS, jobID); err != nil {
+			return err
+		}
+		return recordAttempts(tx, statusNames[statusCancelled], "id = ?", jobID)
+	}
+
+	// A failed or timed-out job with retries left is requeued, and one
+	// without any left is dead-lettered.
+	retry := false
+	if status == int64(statusDoneFailed) || status == int64(statusTimedOut) {
+		if retries < maxRetries {
+			retry = true
+		} else if maxRetries > 0 {
+			status = int64(statusDeadLetter)
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE jobs SET status=?, finished_at=?, exit_code=?, user_cpu_ms=?, sys_cpu_ms=?, max_rss_kb=?, failure=? WHERE id=?",
+		status, time.Now().UnixMilli(), exitCode, usage.UserCPU, usage.SysCPU, usage.MaxRSS, failure, jobID); err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("%s (exit %d)", statusNames[int(status)], exitCode)
+	if exitCode < 0 && failure != "" {
+		detail = fmt.Sprintf("%s: %s", statusNames[int(status)], failure)
+	}
+	if err := recordEvents(tx, actor, eventFinished, detail, "id = ?", jobID); err != nil {
+		return err
+	}
+	if outcome == "" {
+		outcome = statusNames[int(status)]
+	}
+	if err := recordAttempts(tx, outcome, "id = ?", jobID); err != nil {
+		return err
+	}
+	if retry {
+		// Keep the failure, to show why the job is being retried.
+		if _, err := tx.Exec(`
+		UPDATE jobs SET status = ?, pid = 0, started_at = 0, finished_at = 0, exit_code = -1,
+			user_cpu_ms = -1, sys_cpu_ms = -1, max_rss_kb = -1, worker = '',
+			lease_owner = '', lease_expires_at = 0, retries = retries + 1
+		WHERE id = ?`, statusPending, jobID); err != nil {
+			return err
+		}
+		detail := fmt.Sprintf("retry %d of %d", retries+1, maxRetries)
+		return recordEvents(tx, actor, eventRequeued, detail, "id = ?", jobID)
+	}
+	return nil
+}
+
+// How long a job claimed by a local runner is leased to it. Runners renew
+// their leases with heartbeats; see heartbeat.
+const defaultLease = time.Minute
+
+// claimFilter describes the jobs a worker may claim.
+type claimFilter struct {
+	// Capabilities of the worker; jobs' requirements must all be among them.
+	Labels []string
+	// Queue jobs must be in, or empty for any.
+	Queue string
+	// Jobs must have every one of these tags.
+	Tags []string
+	// Regular expression jobs' commands must match, or empty for any.
+	Match string
+}
+
+// where returns a SQL condition matching the filter's queue, tags and
+// pattern, and its arguments.
+func (f claimFilter) where() (string, []any) {
+	conds := []string{"1=1"}
+	var args []any
+	if f.Queue != "" {
+		conds = append(conds, "queue = ?")
+		args = append(args, f.Queue)
+	}
+	for _, tag := range f.Tags {
+		conds = append(conds, "instr(',' || tags || ',', ?) > 0")
+		args = append(args, ","+tag+",")
+	}
+	if f.Match != "" {
+		conds = append(conds, "command REGEXP ?")
+		args = append(args, f.Match)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// TakeNextJob takes the next pending job matching filter, and leases it to
+// the DB's actor; see ClaimJob.
+func (db *DB) TakeNextJob(filter claimFilter) (*Job, error) {
+	return db.ClaimJob(filter, db.actor, defaultLease)
+}
+
+// TakeJobAbove is like TakeNextJob, but only takes a job whose priority is
+// higher than the given one.
+func (db *DB) TakeJobAbove(filter claimFilter, priority int) (*Job, error) {
+	return db.claimJob(filter, db.actor, defaultLease, priority+1)
+}
+
+// ClaimJob takes the next pending job matching filter, whose requirements
+// are all among the filter's labels, whose time window, if any, includes
+// the current time, whose lock, if any, isn't held by a running job, and
+// that no concurrency limit holds back, and leases it to
+// owner for the given duration. Jobs are taken by their priority, raised by
+// any aging (see aging.go), and among jobs of the same priority from the
+// queue with the fewest recent starts for its weight (see weights.go), in
+// order of their position in it; see move.go. The
+// job is put back in the queue if the lease isn't renewed in time, e.g.
+// because its worker died; jobs with expired leases are put back before
+// claiming, and by ExpireLeases.
+func (db *DB) ClaimJob(filter claimFilter, owner string, lease time.Duration) (*Job, error) {
+	return db.claimJob(filter, owner, lease, math.MinInt)
+}
+
+// claimJob is ClaimJob, only taking jobs with at least minPriority.
+func (db *DB) claimJob(filter claimFilter, owner string, lease time.Duration, minPriority int) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := expireLeases(tx, db.actor); err != nil {
+		return nil, err
+	}
+	job, err := claimNextJob(tx, filter, owner, lease, minPriority)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	if err := recordEvents(tx, db.actor, eventClaimed, "", "id = ?", job.ID); err != nil {
+		return nil, err
+	}
+	return job, tx.Commit()
+}
+
+// claimNextJob marks the job claimJob takes as running in tx, returning it,
+// or nil if there's none.
+func claimNextJob(tx *sql.Tx, filter claimFilter, owner string, lease time.Duration, minPriority int) (*Job, error) {
+	// Duplicate labels would be counted twice.
+	labelsJSON, err := json.Marshal(slices.Compact(slices.Sorted(slices.Values(filter.Labels))))
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs := filter.where()
+	clock := clockTime(time.Now())
+	args := []any{time.Now().Add(-fairShareWindow).UnixMilli()}
+	args = append(args, whereArgs...)
+	args = append(args,
+		string(labelsJSON),
+		settingPausedQueues, allQueues,
+		clock, clock, clock, clock,
+		statusInProgress, statusSuspended,
+		statusInProgress, statusSuspended,
+		minPriority,
+		time.Now().UnixMilli(),
+		time.Now().UnixMilli(),
+		owner, time.Now().Add(lease).UnixMilli(),
+	)
+	job, err := scanJob(tx.QueryRow(`
+	WITH `+fairShareCTE+`,
+	selected_job AS (
+		SELECT jobs.* FROM jobs
+		LEFT JOIN fair_shares ON share_queue = jobs.queue
+		WHERE status = 0 AND `+where+`
+		-- Every requirement must be one of the labels; lists have no
+		-- duplicates, so it's enough to count the labels required.
+		AND (requires = '' OR (
+			SELECT count(*) FROM json_each(?)
+			WHERE instr(',' || requires || ',', ',' || value || ',') > 0
+		) = length(requires) - length(replace(requires, ',', '')) + 1)
+		AND NOT EXISTS (
+			SELECT 1 FROM settings WHERE key = ?
+			AND (value = ? OR instr(',' || value || ',', ',' || queue || ',') > 0)
+		)
+		AND `+timeWindowCond+`
+		-- Running and suspended jobs hold their locks.
+		AND (lock_name = '' OR NOT EXISTS (
+			SELECT 1 FROM jobs AS holder
+			WHERE holder.lock_name = jobs.lock_name AND holder.status IN (?, ?)
+		))
+		AND `+limitsCond+`
+		AND jobs.priority >= ?
+		ORDER BY `+agedPriorityExpr+` DESC, share ASC, `+jobPositionExpr+` ASC, id ASC
 		LIMIT 1
 	)
-	UPDATE jobs SET status = 1, started_at=?
+	UPDATE jobs SET status = 1, started_at=?, lease_owner=?, lease_expires_at=?,
+		attempts = attempts + 1, output_path = ''
 	WHERE id = (SELECT id FROM selected_job)
-	RETURNING id, command, pid, status, created_at, started_at, finished_at;
-	`,
-		time.Now().UnixMilli(),
-	).
-		Scan(
-			&job.ID,
-			&job.Command,
-			&job.PID,
-			&job.Status,
-			&job.CreatedAt,
-			&job.StartedAt,
-			&job.FinishedAt,
-		); err != nil {
+	RETURNING `+jobColumns+`;
+	`, args...))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -123,15 +835,45 @@ func (db *DB) TakeNextJob() (*Job, error) {
 	return &job, nil
 }
 
-// Deletes job with given ID. Returns true if the job existed.
-func (db *DB) DeleteJob(id int64) (bool, error) {
+// OwnsRunningJobs reports whether any running or suspended jobs are leased
+// to owner.
+func (db *DB) OwnsRunningJobs(owner string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var owns bool
+	err := db.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM jobs WHERE lease_owner = ? AND status IN (?, ?))`,
+		owner, statusInProgress, statusSuspended).Scan(&owns)
+	return owns, err
+}
+
+// RenewLeases extends owner's leases on all of its running jobs. Returns the
+// number of jobs.
+func (db *DB) RenewLeases(owner string, lease time.Duration) (int64, error) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	result, err := db.Exec(`DELETE FROM jobs WHERE id = ?`, id)
+	result, err := db.Exec(`
+	UPDATE jobs SET lease_expires_at = ?
+	WHERE status IN (?, ?) AND lease_owner = ?`,
+		time.Now().Add(lease).UnixMilli(), statusInProgress, statusSuspended, owner)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
+// RenewLease extends owner's lease on a running job. Returns false if the
+// job is no longer leased to owner, e.g. because the lease expired.
+func (db *DB) RenewLease(jobID int64, owner string, lease time.Duration) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`
+	UPDATE jobs SET lease_expires_at = ?
+	WHERE id = ? AND status = ? AND lease_owner = ?`,
+		time.Now().Add(lease).UnixMilli(), jobID, statusInProgress, owner)
+	if err != nil {
+		return false, err
+	}
 	rows, err := result.RowsAffected()
 	if err != nil {
 		return false, err
@@ -139,10 +881,317 @@ func (db *DB) DeleteJob(id int64) (bool, error) {
 	return rows > 0, nil
 }
 
+// ExpireLeases puts running jobs whose leases have expired back in the
+// queue, so another worker can take them. Returns the number of jobs.
+func (db *DB) ExpireLeases() (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := expireLeases(tx, db.actor)
+	if err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+func expireLeases(q querier, actor string) (int64, error) {
+	// Jobs claimed before leases were added have none, and never expire.
+	const expired = "status = ? AND lease_expires_at > 0 AND lease_expires_at < ?"
+	now := time.Now().UnixMilli()
+	if err := recordEvents(q, actor, eventRequeued, "lease expired", expired, statusInProgress, now); err != nil {
+		return 0, err
+	}
+	if err := recordAttempts(q, attemptLeaseExpired, expired, statusInProgress, now); err != nil {
+		return 0, err
+	}
+	result, err := q.Exec(`
+	UPDATE jobs SET status = ?, pid = 0, started_at = 0, lease_owner = '', lease_expires_at = 0, worker = ''
+	WHERE `+expired, statusPending, statusInProgress, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetJobSuspended marks a running job as suspended, or a suspended job as
+// running again. Returns false if the job wasn't running or suspended.
+func (db *DB) SetJobSuspended(jobID int64, suspended bool) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	from, to, event := statusInProgress, statusSuspended, eventSuspended
+	if !suspended {
+		from, to, event = statusSuspended, statusInProgress, eventResumed
+	}
+	result, err := tx.Exec(`UPDATE jobs SET status = ? WHERE id = ? AND status = ?`, to, jobID, from)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := recordEvents(tx, db.actor, event, "", "id = ?", jobID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SetJobHeld marks a pending job as held, or a held job as pending again.
+// Returns false if the job wasn't pending or held.
+func (db *DB) SetJobHeld(jobID int64, held bool) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	from, to, event := statusPending, statusHeld, eventHeld
+	if !held {
+		from, to, event = statusHeld, statusPending, eventReleased
+	}
+	result, err := tx.Exec(`UPDATE jobs SET status = ? WHERE id = ? AND status = ?`, to, jobID, from)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := recordEvents(tx, db.actor, event, "", "id = ?", jobID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetJob returns the job with the given ID, or nil if it doesn't exist.
+func (db *DB) GetJob(id int64) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	job, err := scanJob(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindJobByName returns the unfinished job with the given name, or
+// failing that the most recent job with that name. Returns nil if no job has
+// the name.
+func (db *DB) FindJobByName(name string) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	job, err := scanJob(db.QueryRow(`
+	SELECT `+jobColumns+` FROM jobs
+	WHERE name = ?
+	ORDER BY status IN (0, 1, 4, 5) DESC, id DESC
+	LIMIT 1`, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindJobsByUUIDPrefix returns the jobs whose UUIDs start with prefix.
+func (db *DB) FindJobsByUUIDPrefix(prefix string) ([]Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT `+jobColumns+` FROM jobs WHERE substr(uuid, 1, ?) = ? LIMIT 2`,
+		len(prefix), strings.ToLower(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RequeueJob makes a finished job pending again, with all of its retries.
+// Returns false if the job doesn't exist or hasn't finished.
+func (db *DB) RequeueJob(id int64) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE jobs SET status = ?, pid = 0, started_at = 0, finished_at = 0, exit_code = -1,
+		user_cpu_ms = -1, sys_cpu_ms = -1, max_rss_kb = -1, failure = '', worker = '', retries = 0
+	WHERE id = ? AND status IN (?, ?, ?, ?, ?)`,
+		statusPending, id, statusDoneSuccess, statusDoneFailed, statusTimedOut, statusCancelled, statusDeadLetter)
+	if err != nil {
+		return false, insertErr(err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := recordEvents(tx, db.actor, eventRequeued, "", "id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Deletes job with given ID. Returns true if the job existed.
+func (db *DB) DeleteJob(id int64) (bool, error) {
+	n, err := db.DeleteJobs(JobFilter{IDs: []int64{id}})
+	return n > 0, err
+}
+
+// DeleteJobs deletes every job matching the filter, and their saved output
+// and artifacts. Returns the number of jobs deleted.
+func (db *DB) DeleteJobs(filter JobFilter) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	outputs, err := jobOutputPaths(tx, where, args...)
+	if err != nil {
+		return 0, err
+	}
+	artifacts, err := jobArtifactDirs(tx, where, args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordEvents(tx, db.actor, eventRemoved, "", where, args...); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`DELETE FROM jobs WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	removeOutputFiles(outputs)
+	removeArtifactDirs(artifacts)
+	return n, nil
+}
+
+// PurgeJobs deletes every job matching the filter, and their saved output
+// and artifacts, and returns the number of jobs deleted in each status.
+func (db *DB) PurgeJobs(filter JobFilter) (map[int]int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	rows, err := tx.Query(`SELECT status, COUNT(*) FROM jobs WHERE `+where+` GROUP BY status`, args...)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[int]int{}
+	for rows.Next() {
+		var status, count int
+		if err := rows.Scan(&status, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		counts[status] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	outputs, err := jobOutputPaths(tx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := jobArtifactDirs(tx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordEvents(tx, db.actor, eventRemoved, "purged", where, args...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE `+where, args...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	removeOutputFiles(outputs)
+	removeArtifactDirs(artifacts)
+	return counts, nil
+}
+
 func (db *DB) ListJobs() ([]Job, error) {
+	return db.listJobsFrom("jobs")
+}
+
+// FilterJobs returns the jobs matching the filter, in ID order.
+func (db *DB) FilterJobs(filter JobFilter) ([]Job, error) {
+	where, args := filter.where()
+	return db.queryJobs("jobs", where, args...)
+}
+
+func (db *DB) listJobsFrom(table string) ([]Job, error) {
+	return db.queryJobs(table, "1")
+}
+
+// queryJobs returns the jobs in the table matching the WHERE clause, in ID order.
+func (db *DB) queryJobs(table, where string, args ...any) ([]Job, error) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	rows, err := db.Query(`SELECT id, command, pid, status, created_at, started_at, finished_at FROM JOBS`)
+	rows, err := db.Query(`SELECT `+jobColumns+` FROM `+table+` WHERE `+where+` ORDER BY id`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -151,16 +1200,8 @@ func (db *DB) ListJobs() ([]Job, error) {
 	var jobs []Job
 
 	for rows.Next() {
-		var job Job
-		if err := rows.Scan(
-			&job.ID,
-			&job.Command,
-			&job.PID,
-			&job.Status,
-			&job.CreatedAt,
-			&job.StartedAt,
-			&job.FinishedAt,
-		); err != nil {
+		job, err := scanJob(rows)
+		if err != nil {
 			return jobs, err
 		}
 		jobs = append(jobs, job)
@@ -168,48 +1209,378 @@ func (db *DB) ListJobs() ([]Job, error) {
 	return jobs, nil
 }
 
+// SetPriority sets the priority of every job matching the filter. Returns the
+// number of jobs updated.
+func (db *DB) SetPriority(filter JobFilter, priority int) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	result, err := tx.Exec(`UPDATE jobs SET priority = ? WHERE `+where, append([]any{priority}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := recordEvents(tx, db.actor, eventPriority, fmt.Sprintf("priority %d", priority), where, args...); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// CountJobsByStatus returns the number of jobs matching the filter in each status.
+func (db *DB) CountJobsByStatus(filter JobFilter) (map[int]int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	where, args := filter.where()
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM jobs WHERE `+where+` GROUP BY status`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var status, count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// CountJobsByQueue returns the number of jobs in each status, by queue.
+func (db *DB) CountJobsByQueue() (map[string]map[int]int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT queue, status, COUNT(*) FROM jobs GROUP BY queue, status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]map[int]int{}
+	for rows.Next() {
+		var queue string
+		var status, count int
+		if err := rows.Scan(&queue, &status, &count); err != nil {
+			return counts, err
+		}
+		if counts[queue] == nil {
+			counts[queue] = map[int]int{}
+		}
+		counts[queue][status] = count
+	}
+	return counts, rows.Err()
+}
+
+// OldestPendingJob returns the creation time of the oldest pending job, or
+// the zero time if there are none.
+func (db *DB) OldestPendingJob() (time.Time, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var createdAt sql.NullInt64
+	if err := db.QueryRow(`SELECT MIN(created_at) FROM jobs WHERE status = ?`, statusPending).Scan(&createdAt); err != nil {
+		return time.Time{}, err
+	}
+	if !createdAt.Valid {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(createdAt.Int64), nil
+}
+
 func (db *DB) AddJob(command string) (int64, error) {
+	return db.InsertJob(JobSpec{Command: command})
+}
+
+func (db *DB) InsertJob(spec JobSpec) (int64, error) {
+	return db.insertJob(spec, "")
+}
+
+// insertJob adds a job, with detail as its queued event's.
+func (db *DB) insertJob(spec JobSpec, detail string) (int64, error) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	result, err := db.Exec(`
-	BEGIN TRANSACTION;
-	INSERT INTO jobs (command, status, created_at, started_at, finished_at)  values (?,?,?,?,?);
-	COMMIT TRANSACTION;
-	`, command, statusPending, time.Now().UnixMilli(), 0, 0)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(insertJobSQL, spec.insertArgs(time.Now().UnixMilli())...)
+	if err != nil {
+		return 0, insertErr(err)
+	}
+	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
+	if err := recordEvents(tx, db.actor, eventQueued, detail, "id = ?", id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// InsertUniqueJob adds a job unless an identical command is already pending
+// or running in the same queue. Returns the ID of the added or existing job,
+// and whether it already existed.
+func (db *DB) InsertUniqueJob(spec JobSpec) (int64, bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+	SELECT id FROM jobs
+	WHERE command = ? AND script = ? AND queue = ? AND status IN (?, ?, ?, ?)
+	ORDER BY id ASC
+	LIMIT 1`,
+		spec.Command, spec.Script, spec.queue(), statusPending, statusInProgress, statusSuspended, statusHeld,
+	).Scan(&id)
+	if err == nil {
+		return id, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	result, err := tx.Exec(insertJobSQL, spec.insertArgs(time.Now().UnixMilli())...)
+	if err != nil {
+		return 0, false, insertErr(err)
+	}
+	if id, err = result.LastInsertId(); err != nil {
+		return 0, false, err
+	}
+	if err := recordEvents(tx, db.actor, eventQueued, "", "id = ?", id); err != nil {
+		return 0, false, err
+	}
+	return id, false, tx.Commit()
+}
+
+// InsertArrayJobs adds one job per index in [first, last], all sharing an
+// array ID. Returns the IDs of the added jobs.
+func (db *DB) InsertArrayJobs(spec JobSpec, first, last int) ([]int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ins, err := newJobInserter(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer ins.Close()
+
+	var ids []int64
+	var arrayID int64
+	for i := first; i <= last; i++ {
+		spec.ArrayID, spec.ArrayIndex = int(arrayID), i
+		id, err := ins.insert(spec)
+		if err != nil {
+			return nil, err
+		}
+		if arrayID == 0 {
+			arrayID = id
+			if _, err := tx.Exec(`UPDATE jobs SET array_id = ? WHERE id = ?`, arrayID, id); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, id)
+	}
+	if err := recordEvents(tx, db.actor, eventQueued, fmt.Sprintf("array #%d", arrayID), "array_id = ?", arrayID); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// jobInserter adds jobs in a transaction with a single prepared insert, for
+// AddJobs and arrays.
+type jobInserter struct {
+	stmt *sql.Stmt
+	now  int64
+}
+
+func newJobInserter(tx *sql.Tx) (*jobInserter, error) {
+	stmt, err := tx.Prepare(insertJobSQL)
+	if err != nil {
+		return nil, err
+	}
+	return &jobInserter{stmt: stmt, now: time.Now().UnixMilli()}, nil
+}
+
+// insert adds a job, returning its ID.
+func (ins *jobInserter) insert(spec JobSpec) (int64, error) {
+	result, err := ins.stmt.Exec(spec.insertArgs(ins.now)...)
+	if err != nil {
+		return 0, insertErr(err)
+	}
 	return result.LastInsertId()
 }
 
-func Open(filename string) (*DB, error) {
-	db, err := sql.Open("sqlite3", filename)
+func (ins *jobInserter) Close() error {
+	return ins.stmt.Close()
+}
+
+// addJobsConfig is how AddJobs adds jobs, as set by its options.
+type addJobsConfig struct {
+	// spec has the settings of every job but its command.
+	spec JobSpec
+	// begin runs in the transaction before any jobs are added, and may
+	// change the config.
+	begin func(tx *sql.Tx, cfg *addJobsConfig) error
+	// prepare adjusts the spec of the ith job, or reports false to skip
+	// it, and added is passed its ID once it's added.
+	prepare func(tx *sql.Tx, i int, spec *JobSpec) (bool, error)
+	added   func(tx *sql.Tx, i int, id int64) error
+	// detail is the detail of the jobs' queued events.
+	detail string
+}
+
+type addJobsOption func(*addJobsConfig)
+
+// withSettings gives every job spec's settings, with its own command.
+func withSettings(spec JobSpec) addJobsOption {
+	return func(cfg *addJobsConfig) { cfg.spec = spec }
+}
+
+// forEachJob passes each job's spec to prepare before it's added, and its
+// ID to added after.
+func forEachJob(prepare func(tx *sql.Tx, i int, spec *JobSpec) (bool, error), added func(tx *sql.Tx, i int, id int64) error) addJobsOption {
+	return func(cfg *addJobsConfig) { cfg.prepare, cfg.added = prepare, added }
+}
+
+// withEventDetail sets the detail of the jobs' queued events.
+func withEventDetail(detail string) addJobsOption {
+	return func(cfg *addJobsConfig) { cfg.detail = detail }
+}
+
+// AddJobs adds a job per command in one transaction, so either all of them
+// are added or none are, with a single prepared insert and one statement
+// recording their queued events, which makes adding thousands of jobs
+// quick. The jobs have default settings unless options say otherwise.
+// Returns the IDs of the added jobs.
+func (db *DB) AddJobs(commands []string, opts ...addJobsOption) ([]int64, error) {
+	var cfg addJobsConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	sqlStmt := `
-	BEGIN TRANSACTION;
-	create table if not exists jobs 
-	(
-		id integer not null primary key,
-		command text not null,
-		pid integer default 0,
-		status integer default 0,
-		created_at int default 0,
-		started_at int default 0,
-		finished_at int default 0
-	);
-	COMMIT TRANSACTION;
-	`
-	_, err = db.Exec(sqlStmt)
+	if cfg.begin != nil {
+		if err := cfg.begin(tx, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	ins, err := newJobInserter(tx)
 	if err != nil {
 		return nil, err
 	}
+	defer ins.Close()
+
+	var ids []int64
+	for i, command := range commands {
+		spec := cfg.spec
+		spec.Command = command
+		if cfg.prepare != nil {
+			ok, err := cfg.prepare(tx, i, &spec)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		id, err := ins.insert(spec)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.added != nil {
+			if err := cfg.added(tx, i, id); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, tx.Commit()
+	}
+	// Nothing else can add jobs during the transaction, so the jobs between
+	// the first and last are all the ones just added.
+	if err := recordEvents(tx, db.actor, eventQueued, cfg.detail, "id BETWEEN ? AND ?", ids[0], ids[len(ids)-1]); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
 
+func Open(filename string) (*DB, error) {
+	if readOnly {
+		// Opening it would create it.
+		if _, err := os.Stat(filename); err != nil {
+			return nil, fmt.Errorf("no DB at %s", filename)
+		}
+	}
+	db, err := openDB(filename)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		if err := checkReadOnlySchema(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+	if _, err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if err := db.SyncSearchIndex(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to update search index: %w", err)
+	}
+	return db, nil
+}
+
+// openDB opens the DB without applying migrations.
+func openDB(filename string) (*DB, error) {
+	key, err := dbKey()
+	if err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(filename, key)
+	if err != nil {
+		return nil, err
+	}
 	return &DB{
-		lock: &sync.Mutex{},
-		DB:   db,
+		lock:  &sync.Mutex{},
+		DB:    db,
+		actor: defaultActor(),
 	}, nil
 }
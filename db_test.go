@@ -0,0 +1,239 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a new, migrated DB that's removed after the test.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "chime.db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func insertTestJob(t *testing.T, db *DB, spec JobSpec) int64 {
+	t.Helper()
+	id, err := db.InsertJob(spec)
+	if err != nil {
+		t.Fatalf("failed to add job: %v", err)
+	}
+	return id
+}
+
+// claimTestJob claims the next job matching filter, failing the test unless
+// it's the one with ID want, or none if want is 0.
+func claimTestJob(t *testing.T, db *DB, filter claimFilter, want int64) *Job {
+	t.Helper()
+	job, err := db.TakeNextJob(filter)
+	if err != nil {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+	switch {
+	case want == 0 && job != nil:
+		t.Fatalf("claimed job #%d; want none", job.ID)
+	case want != 0 && job == nil:
+		t.Fatalf("claimed no job; want #%d", want)
+	case want != 0 && int64(job.ID) != want:
+		t.Fatalf("claimed job #%d; want #%d", job.ID, want)
+	}
+	return job
+}
+
+func finishTestJob(t *testing.T, db *DB, id int64, status int) {
+	t.Helper()
+	exitCode := 0
+	if status != statusDoneSuccess {
+		exitCode = 1
+	}
+	if err := db.FinishJob(id, int64(status), exitCode, unknownUsage, ""); err != nil {
+		t.Fatalf("failed to finish job #%d: %v", id, err)
+	}
+}
+
+func getTestJob(t *testing.T, db *DB, id int64) *Job {
+	t.Helper()
+	job, err := db.GetJob(id)
+	if err != nil || job == nil {
+		t.Fatalf("failed to get job #%d: %v", id, err)
+	}
+	return job
+}
+
+func TestClaimRequiresLabels(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestJob(t, db, JobSpec{Command: "train", Requires: CommaList{"gpu", "linux"}})
+
+	claimTestJob(t, db, claimFilter{}, 0)
+	claimTestJob(t, db, claimFilter{Labels: []string{"gpu"}}, 0)
+	// Duplicate labels don't make up for a missing one.
+	claimTestJob(t, db, claimFilter{Labels: []string{"gpu", "gpu"}}, 0)
+	claimTestJob(t, db, claimFilter{Labels: []string{"linux", "gpu", "big"}}, id)
+}
+
+func TestClaimTimeWindow(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	window := func(from, to time.Duration) string {
+		return clockTime(now.Add(from)) + "-" + clockTime(now.Add(to))
+	}
+	later := insertTestJob(t, db, JobSpec{Command: "later", TimeWindow: window(time.Hour, 2*time.Hour)})
+	open := insertTestJob(t, db, JobSpec{Command: "now", TimeWindow: window(-time.Hour, time.Hour)})
+
+	claimTestJob(t, db, claimFilter{}, open)
+	claimTestJob(t, db, claimFilter{}, 0)
+	if job := getTestJob(t, db, later); job.Status != statusPending {
+		t.Errorf("job outside its window is %s; want pending", statusNames[job.Status])
+	}
+}
+
+func TestClaimLock(t *testing.T) {
+	db := openTestDB(t)
+	first := insertTestJob(t, db, JobSpec{Command: "migrate a", Lock: "schema"})
+	second := insertTestJob(t, db, JobSpec{Command: "migrate b", Lock: "schema"})
+	other := insertTestJob(t, db, JobSpec{Command: "unlocked"})
+
+	claimTestJob(t, db, claimFilter{}, first)
+	// The locked job is skipped while its lock is held, not waited for.
+	claimTestJob(t, db, claimFilter{}, other)
+	claimTestJob(t, db, claimFilter{}, 0)
+
+	finishTestJob(t, db, first, statusDoneSuccess)
+	claimTestJob(t, db, claimFilter{}, second)
+}
+
+func TestClaimConcurrencyLimit(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.SetConcurrencyLimit(limitTag, "db", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetConcurrencyLimit(limitQueue, "slow", 1); err != nil {
+		t.Fatal(err)
+	}
+	tagged := insertTestJob(t, db, JobSpec{Command: "a", Tags: CommaList{"db", "nightly"}})
+	taggedToo := insertTestJob(t, db, JobSpec{Command: "b", Tags: CommaList{"db"}})
+	slow := insertTestJob(t, db, JobSpec{Command: "c", Queue: "slow"})
+	slowToo := insertTestJob(t, db, JobSpec{Command: "d", Queue: "slow"})
+
+	claimTestJob(t, db, claimFilter{}, tagged)
+	claimTestJob(t, db, claimFilter{}, slow)
+	claimTestJob(t, db, claimFilter{}, 0)
+
+	// Suspended jobs still count against their limits.
+	if ok, err := db.SetJobSuspended(tagged, true); err != nil || !ok {
+		t.Fatalf("failed to suspend job: %v", err)
+	}
+	claimTestJob(t, db, claimFilter{}, 0)
+
+	finishTestJob(t, db, slow, statusDoneFailed)
+	claimTestJob(t, db, claimFilter{}, slowToo)
+	if ok, err := db.SetJobSuspended(tagged, false); err != nil || !ok {
+		t.Fatalf("failed to resume job: %v", err)
+	}
+	finishTestJob(t, db, tagged, statusDoneSuccess)
+	claimTestJob(t, db, claimFilter{}, taggedToo)
+}
+
+func TestFinishJobRetriesThenDeadLetters(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestJob(t, db, JobSpec{Command: "flaky", MaxRetries: 2})
+
+	for retry := 1; retry <= 2; retry++ {
+		claimTestJob(t, db, claimFilter{}, id)
+		finishTestJob(t, db, id, statusDoneFailed)
+		job := getTestJob(t, db, id)
+		if job.Status != statusPending || job.Retries != retry {
+			t.Fatalf("after failure %d, job is %s with %d retries; want pending with %d", retry, statusNames[job.Status], job.Retries, retry)
+		}
+		if job.StartedAt != 0 || job.FinishedAt != 0 || job.ExitCode != -1 || job.LeaseOwner != "" {
+			t.Errorf("retried job wasn't reset: %+v", job)
+		}
+	}
+
+	claimTestJob(t, db, claimFilter{}, id)
+	finishTestJob(t, db, id, statusDoneFailed)
+	job := getTestJob(t, db, id)
+	if job.Status != statusDeadLetter || job.Retries != 2 || job.ExitCode != 1 {
+		t.Errorf("out of retries, job is %s with %d retries and exit %d; want dead with 2 and exit 1", statusNames[job.Status], job.Retries, job.ExitCode)
+	}
+}
+
+func TestFinishJobWithoutRetries(t *testing.T) {
+	db := openTestDB(t)
+	failed := insertTestJob(t, db, JobSpec{Command: "false"})
+	succeeded := insertTestJob(t, db, JobSpec{Command: "true", MaxRetries: 3})
+
+	claimTestJob(t, db, claimFilter{}, failed)
+	finishTestJob(t, db, failed, statusDoneFailed)
+	// Jobs without retries fail rather than being dead-lettered.
+	if job := getTestJob(t, db, failed); job.Status != statusDoneFailed {
+		t.Errorf("job without retries is %s; want failed", statusNames[job.Status])
+	}
+
+	claimTestJob(t, db, claimFilter{}, succeeded)
+	finishTestJob(t, db, succeeded, statusDoneSuccess)
+	if job := getTestJob(t, db, succeeded); job.Status != statusDoneSuccess || job.Retries != 0 {
+		t.Errorf("succeeded job is %s with %d retries; want succeeded with 0", statusNames[job.Status], job.Retries)
+	}
+}
+
+func TestAddJobs(t *testing.T) {
+	db := openTestDB(t)
+	ids, err := db.AddJobs([]string{"echo a", "echo b"}, withSettings(JobSpec{Queue: "bulk", Priority: 3}))
+	if err != nil {
+		t.Fatalf("failed to add jobs: %v", err)
+	}
+	if len(ids) != 2 || ids[1] != ids[0]+1 {
+		t.Fatalf("added jobs %v; want two in a row", ids)
+	}
+	for i, command := range []string{"echo a", "echo b"} {
+		if job := getTestJob(t, db, ids[i]); job.Command != command || job.Queue != "bulk" || job.Priority != 3 {
+			t.Errorf("job #%d is %q in queue %q with priority %d; want %q in bulk with 3", ids[i], job.Command, job.Queue, job.Priority, command)
+		}
+	}
+
+	var batchID int64
+	ids, err = db.AddJobs([]string{"echo c"}, inNewBatch("nightly", &batchID))
+	if err != nil {
+		t.Fatalf("failed to add batch: %v", err)
+	}
+	if job := getTestJob(t, db, ids[0]); batchID == 0 || int64(job.BatchID) != batchID {
+		t.Errorf("job #%d is in batch %d; want %d", ids[0], job.BatchID, batchID)
+	}
+}
+
+func TestImportJobsSkipsDuplicates(t *testing.T) {
+	src := openTestDB(t)
+	var batchID int64
+	if _, err := src.AddJobs([]string{"echo a", "echo b"}, inNewBatch("nightly", &batchID)); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := src.ExportJobs(JobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := openTestDB(t)
+	insertTestJob(t, dst, JobSpec{Command: "echo existing"})
+	for _, want := range []importResult{{Imported: 2}, {Duplicates: 2}} {
+		result, err := dst.ImportJobs(doc)
+		if err != nil {
+			t.Fatalf("failed to import jobs: %v", err)
+		}
+		if result != want {
+			t.Errorf("import got %+v; want %+v", result, want)
+		}
+	}
+	jobs, err := dst.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 || jobs[1].Command != "echo a" || jobs[1].BatchID == 0 || jobs[2].BatchID != jobs[1].BatchID {
+		t.Errorf("jobs after importing: %+v", jobs)
+	}
+}
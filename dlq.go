@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Jobs added with retries that fail their last retry are dead-lettered
+// rather than just failed, so persistent failures can be triaged apart
+// from transient ones. `chime dlq` lists and requeues them.
+
+type dlqList struct {
+	globalArgs
+	queue string
+}
+
+type dlqRequeue struct {
+	globalArgs
+	// Jobs to requeue, or empty for every dead-lettered job.
+	refs []string
+}
+
+func parseDLQSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("dlq command required: list or requeue")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		list := dlqList{globalArgs: globals}
+		fs := flag.NewFlagSet(dlqCommandName+" list", flag.ContinueOnError)
+		fs.StringVar(&list.queue, "queue", "", "only list jobs in this queue")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+		}
+		return list, nil
+	case "requeue":
+		return dlqRequeue{globalArgs: globals, refs: args}, nil
+	}
+	return nil, fmt.Errorf("unknown dlq command: '%s'", cmd)
+}
+
+func (cmd dlqList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	jobs, err := db.FilterJobs(JobFilter{Statuses: []int{statusDeadLetter}, Queue: cmd.queue})
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(jobs) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("ID", "QUEUE", "RETRIES", "FINISHED", "FAILURE", "COMMAND")
+	for _, job := range jobs {
+		t.Row(
+			fmt.Sprintf("%d", job.ID),
+			job.Queue,
+			fmt.Sprintf("%d", job.Retries),
+			job.FinishedAtTime().Format(time.DateTime),
+			job.Failure,
+			job.Command,
+		)
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd dlqRequeue) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	var jobs []Job
+	if len(cmd.refs) == 0 {
+		if jobs, err = db.FilterJobs(JobFilter{Statuses: []int{statusDeadLetter}}); err != nil {
+			return fmt.Errorf("failed to list jobs: %w", err)
+		}
+	}
+	for _, ref := range cmd.refs {
+		job, err := resolveJob(db, ref)
+		if err != nil {
+			return err
+		}
+		if job.Status != statusDeadLetter {
+			return fmt.Errorf("job #%d isn't in the dead-letter queue", job.ID)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	for _, job := range jobs {
+		ok, err := db.RequeueJob(int64(job.ID))
+		if err != nil {
+			return fmt.Errorf("failed to requeue job #%d: %w", job.ID, err)
+		}
+		if ok {
+			slog.Info("requeued job", "id", job.ID)
+		}
+	}
+	return nil
+}
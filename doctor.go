@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+)
+
+// A problem found by `chime doctor`, with a suggested fix.
+type diagnosis struct {
+	problem string
+	fix     string
+}
+
+// deadRunningJobs returns jobs marked as running whose process has exited,
+// e.g. because the runner was killed.
+func deadRunningJobs(db *DB) ([]Job, error) {
+	jobs, err := db.queryJobs("jobs", "status IN (?, ?) AND pid > 0", statusInProgress, statusSuspended)
+	if err != nil {
+		return nil, err
+	}
+	var dead []Job
+	for _, j := range jobs {
+		if !processExists(j.PID) {
+			dead = append(dead, j)
+		}
+	}
+	return dead, nil
+}
+
+func diagnose(db *DB) ([]diagnosis, error) {
+	var found []diagnosis
+
+	problems, err := db.IntegrityCheck()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+	for _, p := range problems {
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("integrity check: %s", p),
+			fix:     "restore from a backup with `chime restore`, or try `chime backup` and restore the copy",
+		})
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	switch {
+	case version > latestSchemaVersion:
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("schema version %d is newer than this version of chime supports (%d)", version, latestSchemaVersion),
+			fix:     "upgrade chime",
+		})
+	case version < latestSchemaVersion:
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("schema version %d is behind the latest (%d)", version, latestSchemaVersion),
+			fix:     "run `chime migrate`",
+		})
+	}
+	if version > latestSchemaVersion {
+		// The checks below may not make sense for a newer schema.
+		return found, nil
+	}
+
+	dead, err := deadRunningJobs(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check running jobs: %w", err)
+	}
+	for _, j := range dead {
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("job #%d is marked running, but its process (PID %d) has exited", j.ID, j.PID),
+			fix:     fmt.Sprintf("run `chime doctor --fix` to mark it failed, then `chime requeue %d` to run it again", j.ID),
+		})
+	}
+
+	backwards, err := db.queryJobs("jobs", "started_at > 0 AND finished_at > 0 AND finished_at < started_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check job times: %w", err)
+	}
+	for _, j := range backwards {
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("job #%d finished before it started", j.ID),
+			fix:     fmt.Sprintf("`chime requeue %d` to run it again, or `chime remove %d`", j.ID, j.ID),
+		})
+	}
+
+	unknown, err := db.queryJobs("jobs", fmt.Sprintf("status NOT IN (%s)", placeholders(len(allStatuses))), statusArgs(allStatuses)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check job statuses: %w", err)
+	}
+	for _, j := range unknown {
+		found = append(found, diagnosis{
+			problem: fmt.Sprintf("job #%d has unknown status %d", j.ID, j.Status),
+			fix:     fmt.Sprintf("`chime remove %d`", j.ID),
+		})
+	}
+	return found, nil
+}
+
+func statusArgs(statuses []int) []any {
+	args := make([]any, len(statuses))
+	for i, s := range statuses {
+		args[i] = s
+	}
+	return args
+}
+
+type doctor struct {
+	globalArgs
+	fix bool
+}
+
+func parseDoctorSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := doctor{globalArgs: globals}
+	fs := flag.NewFlagSet(doctorCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.fix, "fix", false, "mark running jobs whose process has exited as failed")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return cmd, nil
+}
+
+func (cmd doctor) Run() error {
+	// Don't migrate, so an out of date schema can be reported.
+	db, err := openDB(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.fix {
+		// A running `chime run` may not have recorded a job that just exited.
+		if runIsActive(cmd.globalArgs.dbPath) {
+			return fmt.Errorf("can't fix jobs while `chime run` is using %s", cmd.globalArgs.dbPath)
+		}
+		dead, err := deadRunningJobs(db)
+		if err != nil {
+			return fmt.Errorf("failed to check running jobs: %w", err)
+		}
+		for _, j := range dead {
+			if err := db.SetJobStatus(int64(j.ID), int64(statusDoneFailed)); err != nil {
+				return fmt.Errorf("failed to update job #%d: %w", j.ID, err)
+			}
+			slog.Info("marked job as failed", "id", j.ID)
+		}
+	}
+
+	found, err := diagnose(db)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+	for _, d := range found {
+		fmt.Printf("%s\n  fix: %s\n", d.problem, d.fix)
+	}
+	return fmt.Errorf("found %d problems", len(found))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// `chime run --dry-run` and `chime take --dry-run` print the jobs they'd
+// claim, in order, without running anything or changing the DB. The claims
+// are made the way a run makes them, in a transaction that's rolled back, so
+// they respect priorities, aging, fair shares, requirements, time windows,
+// locks and limits; each job claimed counts as running for the ones after
+// it, so a job that would wait on one of them isn't listed.
+
+// PlanClaims returns the pending jobs a runner with the given filter would
+// claim, in the order it would claim them, up to limit jobs if it's positive.
+func (db *DB) PlanClaims(filter claimFilter, limit int) ([]Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is committed.
+	defer tx.Rollback()
+
+	if _, err := expireLeases(tx, db.actor); err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for limit <= 0 || len(jobs) < limit {
+		job, err := claimNextJob(tx, filter, db.actor, defaultLease, math.MinInt)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			break
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// printClaimPlan prints the jobs PlanClaims returned.
+func printClaimPlan(jobs []Job) {
+	if len(jobs) == 0 {
+		fmt.Println("no jobs would be claimed")
+		return
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(jobs) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("ORDER", "ID", "QUEUE", "PRIORITY", "COMMAND")
+	for i, job := range jobs {
+		t.Row(strconv.Itoa(i+1), strconv.Itoa(job.ID), job.Queue, strconv.Itoa(job.Priority), job.Command)
+	}
+	fmt.Println(t)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Editor used by `chime edit` when neither $VISUAL nor $EDITOR is set.
+const defaultEditor = "vi"
+
+// EditJob replaces the command of a pending or held job, or its script if
+// it has one. Returns false if the job isn't pending or held.
+func (db *DB) EditJob(id int64, command, script string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE jobs SET command = ?, script = ? WHERE id = ? AND status IN (?, ?)`,
+		command, script, id, statusPending, statusHeld)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	detail := "script"
+	if script == "" {
+		detail = command
+	}
+	if err := recordEvents(tx, db.actor, eventEdited, detail, "id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+type edit struct {
+	globalArgs
+	ref     string
+	command string
+	set     bool
+}
+
+func parseEditSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := edit{globalArgs: globals}
+	fs := flag.NewFlagSet(editCommandName, flag.ContinueOnError)
+	fs.Func("set", "new command for the job, instead of editing it in $EDITOR", func(s string) error {
+		cmd.command, cmd.set = s, true
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to edit")
+	}
+	cmd.ref = fs.Arg(0)
+	if cmd.set && strings.TrimSpace(cmd.command) == "" {
+		return nil, fmt.Errorf("--set needs a command")
+	}
+	return cmd, nil
+}
+
+func (cmd edit) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	if job.Status != statusPending && job.Status != statusHeld {
+		return fmt.Errorf("job #%d isn't pending (it's %s); only pending jobs can be edited", job.ID, statusNames[job.Status])
+	}
+
+	command, script := job.Command, job.Script
+	switch {
+	case cmd.set && script != "":
+		return fmt.Errorf("job #%d runs a script; edit it without --set", job.ID)
+	case cmd.set:
+		command = cmd.command
+	case script != "":
+		if script, err = editText(fmt.Sprintf("chime-job-%d-*", job.ID), script); err != nil {
+			return err
+		}
+	default:
+		if command, err = editText(fmt.Sprintf("chime-job-%d-*.sh", job.ID), command+"\n"); err != nil {
+			return err
+		}
+		command = strings.TrimSpace(command)
+	}
+	if command == "" {
+		return fmt.Errorf("the command can't be empty")
+	}
+	if command == job.Command && script == job.Script {
+		slog.Info("job unchanged", "id", job.ID)
+		return nil
+	}
+
+	ok, err := db.EditJob(int64(job.ID), command, script)
+	if err != nil {
+		return fmt.Errorf("failed to edit job: %w", err)
+	}
+	if !ok {
+		// It was taken while being edited.
+		return fmt.Errorf("job #%d is no longer pending", job.ID)
+	}
+	slog.Info("edited job", "id", job.ID)
+	return nil
+}
+
+// editText opens text in the user's editor, in a temporary file named after
+// pattern, and returns what it was saved as.
+func editText(pattern, text string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+	c := editorCommand(editor, f.Name())
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("editor exited with status %d; job unchanged", exitErr.ExitCode())
+		}
+		return "", fmt.Errorf("failed to run editor: %w", err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
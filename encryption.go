@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The key for an encrypted DB is read from $CHIME_DB_KEY, or from the file
+// named by $CHIME_DB_KEY_FILE. Encrypted DBs need chime to be built with the
+// sqlcipher build tag, see sqlcipher.go.
+const (
+	chimeDBKeyEnvKey     = "CHIME_DB_KEY"
+	chimeDBKeyFileEnvKey = "CHIME_DB_KEY_FILE"
+)
+
+// dbKey returns the key for the DB, or "" if it isn't encrypted.
+func dbKey() (string, error) {
+	if key, ok := os.LookupEnv(chimeDBKeyEnvKey); ok {
+		if key == "" {
+			return "", fmt.Errorf("$%s is empty", chimeDBKeyEnvKey)
+		}
+		return key, nil
+	}
+	path, ok := os.LookupEnv(chimeDBKeyFileEnvKey)
+	if !ok {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := strings.TrimRight(string(b), "\r\n")
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", path)
+	}
+	return key, nil
+}
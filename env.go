@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment modes control which of the runner's environment variables a
+// job's process inherits.
+const (
+	// The job inherits the runner's whole environment.
+	envModeInherit = "inherit"
+	// The job only gets a small set of basic variables, see minimalEnvVars.
+	envModeMinimal = "minimal"
+	// The job only gets the variables named in its allowlist.
+	envModeAllowlist = "allowlist"
+)
+
+// Variables passed through to jobs in the minimal environment mode.
+var minimalEnvVars = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TERM", "TZ", "LANG", "LC_ALL",
+}
+
+// Used as the PATH in the minimal environment mode if the runner has none.
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+func validateEnvMode(mode string) error {
+	switch mode {
+	case "", envModeInherit, envModeMinimal, envModeAllowlist:
+		return nil
+	}
+	return fmt.Errorf("invalid environment mode '%s': must be one of %s, %s or %s",
+		mode, envModeInherit, envModeMinimal, envModeAllowlist)
+}
+
+// EnvVars is a list of KEY=VALUE environment variables, stored as a JSON array.
+type EnvVars []string
+
+func (e EnvVars) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(e))
+	return string(b), err
+}
+
+func (e *EnvVars) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+		*e = nil
+		return nil
+	default:
+		return fmt.Errorf("unsupported type for env: %T", src)
+	}
+	return json.Unmarshal(b, (*[]string)(e))
+}
+
+func validateEnvVar(kv string) error {
+	if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+		return fmt.Errorf("invalid environment variable '%s': expected KEY=VALUE", kv)
+	}
+	return nil
+}
+
+// localeEnv returns the variables that make a job run under the given locale.
+func localeEnv(locale string) EnvVars {
+	return EnvVars{"LANG=" + locale, "LC_ALL=" + locale}
+}
+
+// jobEnv returns the environment for the job's process. The job's own
+// environment mode and allowlist take precedence over the runner's.
+func jobEnv(job *Job, cfg execConfig) []string {
+	mode, allow := cfg.EnvMode, cfg.EnvAllow
+	if job.EnvMode != "" {
+		mode = job.EnvMode
+	}
+	if len(job.EnvAllow) > 0 {
+		allow = job.EnvAllow
+	}
+
+	var env []string
+	switch mode {
+	case envModeMinimal:
+		env = filterEnv(os.Environ(), minimalEnvVars)
+		if _, ok := os.LookupEnv("PATH"); !ok {
+			env = append(env, "PATH="+defaultPath)
+		}
+	case envModeAllowlist:
+		env = filterEnv(os.Environ(), allow)
+	default:
+		env = os.Environ()
+	}
+
+	// Later entries win, so the job's overrides and chime's variables are
+	// applied last.
+	env = append(env, job.Env...)
+	env = append(env, chimeJobVars(job)...)
+	if cfg.artifacts != "" {
+		env = append(env, chimeArtifactsDirEnvKey+"="+cfg.artifacts)
+	}
+	if cfg.progressFile != "" {
+		env = append(env, chimeProgressFileEnvKey+"="+cfg.progressFile)
+	}
+	if cfg.dbPath != "" {
+		// The job may not run in the runner's directory.
+		path, err := filepath.Abs(cfg.dbPath)
+		if err != nil {
+			path = cfg.dbPath
+		}
+		env = append(env, chimeDBPathEnvKey+"="+path)
+	}
+	return env
+}
+
+// chimeJobVars returns the variables chime sets to tell a job about itself.
+func chimeJobVars(job *Job) []string {
+	vars := []string{fmt.Sprintf("CHIME_JOB_ID=%d", job.ID)}
+	if job.ArrayID != 0 {
+		vars = append(vars,
+			fmt.Sprintf("CHIME_ARRAY_ID=%d", job.ArrayID),
+			fmt.Sprintf("CHIME_ARRAY_INDEX=%d", job.ArrayIndex),
+		)
+	}
+	return append(vars, deviceVars(job)...)
+}
+
+// filterEnv returns the variables of env whose names are in names.
+func filterEnv(env []string, names []string) []string {
+	var out []string
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		for _, name := range names {
+			if key == name {
+				out = append(out, kv)
+				break
+			}
+		}
+	}
+	return out
+}
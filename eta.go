@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Estimates are based on at most this many of the most recent successful jobs.
+const etaHistoryLimit = 5000
+
+// jobDuration is how long a successful job took.
+type jobDuration struct {
+	Command  string
+	Template string
+	Duration time.Duration
+}
+
+// RecentDurations returns the durations of the most recent successful jobs,
+// including archived ones.
+func (db *DB) RecentDurations(limit int) ([]jobDuration, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT command, template, finished_at - started_at FROM (
+		SELECT command, template, started_at, finished_at FROM jobs
+		WHERE status = ? AND started_at > 0 AND finished_at >= started_at
+		UNION ALL
+		SELECT command, template, started_at, finished_at FROM archived_jobs
+		WHERE status = ? AND started_at > 0 AND finished_at >= started_at
+	)
+	ORDER BY finished_at DESC
+	LIMIT ?`, statusDoneSuccess, statusDoneSuccess, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var durations []jobDuration
+	for rows.Next() {
+		var d jobDuration
+		var ms int64
+		if err := rows.Scan(&d.Command, &d.Template, &ms); err != nil {
+			return durations, err
+		}
+		d.Duration = time.Duration(ms) * time.Millisecond
+		durations = append(durations, d)
+	}
+	return durations, rows.Err()
+}
+
+// durationEstimator estimates how long jobs will take from the median
+// duration of previous runs of the same command, or failing that of jobs
+// rendered from the same template.
+type durationEstimator struct {
+	byCommand  map[string]time.Duration
+	byTemplate map[string]time.Duration
+	// Median of all the jobs, for estimates of the queue as a whole.
+	overall time.Duration
+}
+
+func loadDurationEstimator(db *DB) (*durationEstimator, error) {
+	durations, err := db.RecentDurations(etaHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	byCommand := map[string][]time.Duration{}
+	byTemplate := map[string][]time.Duration{}
+	var all []time.Duration
+	for _, d := range durations {
+		byCommand[d.Command] = append(byCommand[d.Command], d.Duration)
+		if d.Template != "" {
+			byTemplate[d.Template] = append(byTemplate[d.Template], d.Duration)
+		}
+		all = append(all, d.Duration)
+	}
+
+	e := &durationEstimator{
+		byCommand:  map[string]time.Duration{},
+		byTemplate: map[string]time.Duration{},
+		overall:    median(all),
+	}
+	for c, ds := range byCommand {
+		e.byCommand[c] = median(ds)
+	}
+	for t, ds := range byTemplate {
+		e.byTemplate[t] = median(ds)
+	}
+	return e, nil
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	return percentile(sorted, 0.5)
+}
+
+// estimate returns how long the job is expected to take in total. Returns
+// false if there's no history for the job's command or template.
+func (e *durationEstimator) estimate(job Job) (time.Duration, bool) {
+	if e == nil {
+		return 0, false
+	}
+	if d, ok := e.byCommand[job.Command]; ok {
+		return d, true
+	}
+	if job.Template != "" {
+		if d, ok := e.byTemplate[job.Template]; ok {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// remaining returns how much longer a running job is expected to take, which
+// is negative if it's taking longer than expected.
+func (e *durationEstimator) remaining(job Job) (time.Duration, bool) {
+	if job.Status != statusInProgress || job.StartedAt == 0 {
+		return 0, false
+	}
+	d, ok := e.estimate(job)
+	if !ok {
+		return 0, false
+	}
+	return d - time.Since(job.StartedAtTime()), true
+}
+
+// drainTime estimates how long the pending jobs will take to run with the
+// given number of workers. Jobs without history of their own are assumed to
+// take the overall median. Also returns how many jobs had no history.
+func (e *durationEstimator) drainTime(pending []Job, workers int) (time.Duration, int) {
+	var total time.Duration
+	var unknown int
+	for _, job := range pending {
+		d, ok := e.estimate(job)
+		if !ok {
+			d = e.overall
+			unknown++
+		}
+		total += d
+	}
+	return total / time.Duration(max(workers, 1)), unknown
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Every change to a job is recorded in the job_events table, in the same
+// transaction as the change itself. Events are keyed by the job's UUID as
+// well as its ID, since IDs can be reused once jobs are deleted, and they're
+// kept after the job is removed.
+
+const (
+	eventQueued    = "queued"
+	eventClaimed   = "claimed"
+	eventStarted   = "started"
+	eventFinished  = "finished"
+	eventRequeued  = "requeued"
+	eventPriority  = "priority"
+	eventRemoved   = "removed"
+	eventArchived  = "archived"
+	eventSuspended = "suspended"
+	eventResumed   = "resumed"
+	eventHeld      = "held"
+	eventReleased  = "released"
+	eventMoved     = "moved"
+	eventEdited    = "edited"
+	eventAnnotated = "annotated"
+	eventCancelled = "cancelled"
+)
+
+type JobEvent struct {
+	ID      int64
+	JobID   int64
+	JobUUID string
+	Event   string
+	At      int64
+	Actor   string
+	Detail  string
+}
+
+func (e JobEvent) AtTime() time.Time {
+	return time.UnixMilli(e.At)
+}
+
+// defaultActor identifies this process in job events, e.g. "alice@build1[4242]".
+func defaultActor() string {
+	name := osUserName()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s[%d]", name, host, os.Getpid())
+}
+
+// WithActor returns a DB that shares this one's connection, but records
+// actor as the actor of the events it causes.
+func (db *DB) WithActor(actor string) *DB {
+	return &DB{lock: db.lock, DB: db.DB, actor: actor}
+}
+
+// recordEvents records an event for every job matching the WHERE clause.
+func recordEvents(q querier, actor, event, detail, where string, args ...any) error {
+	_, err := q.Exec(`
+	INSERT INTO job_events (job_id, job_uuid, event, at, actor, detail)
+	SELECT id, uuid, ?, ?, ?, ? FROM jobs WHERE `+where,
+		append([]any{event, time.Now().UnixMilli(), actor, detail}, args...)...)
+	return err
+}
+
+// JobHistory returns the events of the job with the given UUID, oldest first.
+func (db *DB) JobHistory(uuid string) ([]JobEvent, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT id, job_id, job_uuid, event, at, actor, detail FROM job_events
+	WHERE job_uuid = ?
+	ORDER BY id`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []JobEvent
+	for rows.Next() {
+		var e JobEvent
+		if err := rows.Scan(&e.ID, &e.JobID, &e.JobUUID, &e.Event, &e.At, &e.Actor, &e.Detail); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// EventsAfter returns up to limit events with IDs greater than afterID that
+// happened at or after since (in Unix milliseconds), oldest first.
+func (db *DB) EventsAfter(afterID, since int64, limit int) ([]JobEvent, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT id, job_id, job_uuid, event, at, actor, detail FROM job_events
+	WHERE id > ? AND at >= ?
+	ORDER BY id
+	LIMIT ?`, afterID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []JobEvent
+	for rows.Next() {
+		var e JobEvent
+		if err := rows.Scan(&e.ID, &e.JobID, &e.JobUUID, &e.Event, &e.At, &e.Actor, &e.Detail); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// LastEventID returns the ID of the most recent event, or 0 if there are none.
+func (db *DB) LastEventID() (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var id int64
+	err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM job_events`).Scan(&id)
+	return id, err
+}
+
+type JobEventJSON struct {
+	ID      int64     `json:"id"`
+	JobID   int64     `json:"job_id"`
+	JobUUID string    `json:"job_uuid"`
+	Event   string    `json:"event"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+func (e JobEvent) JSON() JobEventJSON {
+	return JobEventJSON{
+		ID:      e.ID,
+		JobID:   e.JobID,
+		JobUUID: e.JobUUID,
+		Event:   e.Event,
+		At:      e.AtTime().UTC(),
+		Actor:   e.Actor,
+		Detail:  e.Detail,
+	}
+}
+
+type events struct {
+	globalArgs
+	follow   bool
+	since    time.Duration
+	afterID  int64
+	interval time.Duration
+}
+
+func parseEventsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := events{globalArgs: globals}
+	var since string
+	fs := flag.NewFlagSet(eventsCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.follow, "follow", false, "keep running, printing events as they happen")
+	fs.StringVar(&since, "since", "", "only print events from this long ago onwards, e.g. 1h or 7d")
+	fs.Int64Var(&cmd.afterID, "after", 0, "only print events after the one with this ID, e.g. to resume a stream")
+	fs.DurationVar(&cmd.interval, "interval", time.Second, "how often to check for new events with --follow")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if since != "" {
+		age, err := parseAge(since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --since: %w", err)
+		}
+		cmd.since = age
+	}
+	if cmd.interval <= 0 {
+		return nil, fmt.Errorf("--interval must be positive")
+	}
+	return cmd, nil
+}
+
+// Events are read in pages of this size.
+const eventsPageSize = 1000
+
+// Run prints events as NDJSON, one event per line.
+func (cmd events) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	afterID := cmd.afterID
+	var since int64
+	if cmd.since > 0 {
+		since = time.Now().Add(-cmd.since).UnixMilli()
+	} else if cmd.follow && afterID == 0 {
+		// Following without a starting point starts from now.
+		if afterID, err = db.LastEventID(); err != nil {
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		page, err := db.EventsAfter(afterID, since, eventsPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+		for _, e := range page {
+			if err := enc.Encode(e.JSON()); err != nil {
+				return err
+			}
+			afterID = e.ID
+		}
+		if len(page) == eventsPageSize {
+			continue
+		}
+		if !cmd.follow {
+			return nil
+		}
+		time.Sleep(cmd.interval)
+	}
+}
+
+type history struct {
+	globalArgs
+	ref string
+}
+
+func parseHistorySubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID, name or UUID prefix")
+	}
+	return history{globalArgs: globals, ref: args[0]}, nil
+}
+
+func (cmd history) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	events, err := db.JobHistory(job.UUID)
+	if err != nil {
+		return fmt.Errorf("failed to read job history: %w", err)
+	}
+	if len(events) == 0 {
+		fmt.Printf("no history recorded for job #%d\n", job.ID)
+		return nil
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("TIME", "ATTEMPT", "EVENT", "DETAIL", "ACTOR")
+
+	// Each claim starts a new attempt at running the job.
+	var attempt int
+	for _, e := range events {
+		if e.Event == eventClaimed {
+			attempt++
+		}
+		attemptStr := ""
+		if attempt > 0 {
+			attemptStr = fmt.Sprintf("%d", attempt)
+		}
+		t.Row(e.AtTime().Format("2006-01-02 15:04:05.000"), attemptStr, e.Event, e.Detail, e.Actor)
+	}
+
+	fmt.Printf("job #%d (%s): %s\n", job.ID, job.UUID, job.Command)
+	fmt.Println(t)
+
+	attempts, err := db.JobAttempts(job.UUID)
+	if err != nil {
+		return fmt.Errorf("failed to read job attempts: %w", err)
+	}
+	if rows := attemptRows(job, attempts); len(rows) > 0 {
+		at := table.New().
+			Border(lipgloss.NormalBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				if row < 0 {
+					return headerStyle
+				}
+				return cellStyle
+			}).
+			Headers("ATTEMPT", "STARTED", "FINISHED", "DURATION", "OUTCOME", "EXIT", "WORKER", "OUTPUT").
+			Rows(rows...)
+		fmt.Println("attempts:")
+		fmt.Println(at)
+	}
+	return nil
+}
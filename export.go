@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// `chime export` writes jobs as a JSON document that `chime import` adds to
+// another DB, to move a queue between machines or merge queues. The document
+// is an object with:
+//
+//   - "format": always "chime-jobs"
+//   - "version": the version of the format, currently 1
+//   - "exported_at": when the jobs were exported, in RFC 3339
+//   - "batches": the batches the jobs belong to, as objects with "id" and
+//     "name"
+//   - "jobs": the jobs, in ID order, as printed by `chime show --json`
+//
+// Jobs get new IDs when they're imported, and the IDs jobs refer to, of
+// their array, batch and parent, are mapped to the new ones. Jobs keep their
+// UUIDs, so importing a job that's already in the DB, or was archived from
+// it, is detected and skips it. Imported jobs are pending, or held if they
+// were held, however far they'd got; their results, output and history stay
+// in the DB they came from.
+
+const (
+	jobExportFormat  = "chime-jobs"
+	jobExportVersion = 1
+)
+
+// jobExport is the document chime export writes.
+type jobExport struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exported_at"`
+	Batches    []batchJSON `json:"batches,omitempty"`
+	Jobs       []JobJSON   `json:"jobs"`
+}
+
+type batchJSON struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// importResult counts the jobs an import added and skipped.
+type importResult struct {
+	Imported   int
+	Duplicates int
+}
+
+// ExportJobs returns the jobs matching the filter, and their batches, as an
+// export document.
+func (db *DB) ExportJobs(filter JobFilter) (*jobExport, error) {
+	jobs, err := db.FilterJobs(filter)
+	if err != nil {
+		return nil, err
+	}
+	doc := &jobExport{Format: jobExportFormat, Version: jobExportVersion, ExportedAt: time.Now(), Jobs: []JobJSON{}}
+	seen := map[int]bool{}
+	for _, job := range jobs {
+		doc.Jobs = append(doc.Jobs, job.JSON())
+		if job.BatchID == 0 || seen[job.BatchID] {
+			continue
+		}
+		seen[job.BatchID] = true
+		batch, err := db.GetBatch(int64(job.BatchID))
+		if err != nil {
+			return nil, err
+		}
+		if batch != nil {
+			doc.Batches = append(doc.Batches, batchJSON{batch.ID, batch.Name})
+		}
+	}
+	return doc, nil
+}
+
+// ImportJobs adds the jobs of an export document, skipping any already in
+// the DB. Either every job is imported or none are.
+func (db *DB) ImportJobs(doc *jobExport) (importResult, error) {
+	var result importResult
+	if doc.Format != jobExportFormat {
+		return result, fmt.Errorf("not a chime job export: format is '%s', expected '%s'", doc.Format, jobExportFormat)
+	}
+	if doc.Version < 1 || doc.Version > jobExportVersion {
+		return result, fmt.Errorf("unsupported job export version %d; this chime reads up to version %d", doc.Version, jobExportVersion)
+	}
+	jobs := slices.Clone(doc.Jobs)
+	// Arrays and parents come before the jobs that refer to them.
+	slices.SortFunc(jobs, func(a, b JobJSON) int { return a.ID - b.ID })
+	for i, job := range jobs {
+		if job.Command == "" && job.Script == "" {
+			return result, fmt.Errorf("job %d in the export has no command", job.ID)
+		}
+		if job.UUID == "" {
+			return result, fmt.Errorf("job %d in the export has no UUID", job.ID)
+		}
+		if i > 0 && jobs[i-1].ID == job.ID {
+			return result, fmt.Errorf("job %d is in the export twice", job.ID)
+		}
+		if job.Timeout != "" {
+			if _, err := time.ParseDuration(job.Timeout); err != nil {
+				return result, fmt.Errorf("job %d in the export has an invalid timeout: %w", job.ID, err)
+			}
+		}
+	}
+
+	batchNames := map[int64]string{}
+	for _, b := range doc.Batches {
+		batchNames[b.ID] = b.Name
+	}
+	batchIDs := map[int]int64{}
+	jobIDs := map[int]int64{}
+	// The job being imported, to say which one failed.
+	var current int
+	prepare := func(tx *sql.Tx, i int, spec *JobSpec) (bool, error) {
+		job := jobs[i]
+		current = job.ID
+		existing, err := jobIDByUUID(tx, job.UUID)
+		if err != nil {
+			return false, err
+		}
+		if existing != 0 {
+			jobIDs[job.ID] = existing
+			result.Duplicates++
+			return false, nil
+		}
+
+		*spec = job.spec()
+		if job.BatchID != 0 {
+			if spec.BatchID = batchIDs[job.BatchID]; spec.BatchID == 0 {
+				res, err := tx.Exec(`INSERT INTO batches (name, created_at) VALUES (?,?)`, batchNames[int64(job.BatchID)], time.Now().UnixMilli())
+				if err != nil {
+					return false, err
+				}
+				if spec.BatchID, err = res.LastInsertId(); err != nil {
+					return false, err
+				}
+				batchIDs[job.BatchID] = spec.BatchID
+			}
+		}
+		// A parent that wasn't exported leaves the job without one.
+		spec.ParentID = jobIDs[int(job.ParentID)]
+		return true, nil
+	}
+	added := func(tx *sql.Tx, i int, id int64) error {
+		job := jobs[i]
+		jobIDs[job.ID] = id
+
+		// An array is named after its first job, so the first of an array's
+		// jobs to be imported names it.
+		arrayID := int64(0)
+		if job.ArrayID != 0 {
+			if arrayID = jobIDs[job.ArrayID]; arrayID == 0 {
+				arrayID = id
+				jobIDs[job.ArrayID] = id
+			}
+		}
+		status := statusPending
+		if job.Status == statusNames[statusHeld] {
+			status = statusHeld
+		}
+		if _, err := tx.Exec(`UPDATE jobs SET array_id = ?, status = ? WHERE id = ?`, arrayID, status, id); err != nil {
+			return err
+		}
+		result.Imported++
+		return nil
+	}
+
+	commands := make([]string, len(jobs))
+	for i, job := range jobs {
+		commands[i] = job.Command
+	}
+	if _, err := db.AddJobs(commands, forEachJob(prepare, added), withEventDetail("imported")); err != nil {
+		return importResult{}, fmt.Errorf("failed to import job %d: %w", current, err)
+	}
+	return result, nil
+}
+
+// jobIDByUUID returns the ID of the job with the UUID, or of the archived
+// job if it's been archived, or 0 if there's neither.
+func jobIDByUUID(tx *sql.Tx, uuid string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(`
+	SELECT id FROM jobs WHERE uuid = ?
+	UNION ALL SELECT id FROM archived_jobs WHERE uuid = ?
+	LIMIT 1`, uuid, uuid).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// spec returns the spec of a job to add like an exported one. Its array,
+// batch and parent are left to the importer to map.
+func (job JobJSON) spec() JobSpec {
+	// ImportJobs has checked the timeout.
+	timeout, _ := time.ParseDuration(job.Timeout)
+	return JobSpec{
+		Command:     job.Command,
+		Script:      job.Script,
+		Template:    job.Template,
+		ArrayIndex:  job.ArrayIndex,
+		Priority:    job.Priority,
+		Tags:        job.Tags,
+		EnvMode:     job.EnvMode,
+		EnvAllow:    job.EnvAllow,
+		Env:         job.Env,
+		Queue:       job.Queue,
+		Name:        job.Name,
+		Nice:        job.Nice,
+		IONice:      job.IONice,
+		MemLimit:    job.MemLimit,
+		CPULimit:    job.CPULimit,
+		Image:       job.Image,
+		Host:        job.Host,
+		Requires:    job.Requires,
+		TimeWindow:  job.TimeWindow,
+		OnSuccess:   job.OnSuccess,
+		OnFailure:   job.OnFailure,
+		FollowUp:    job.FollowUp,
+		MaxRetries:  job.MaxRetries,
+		Lock:        job.Lock,
+		Slots:       job.Slots,
+		Resources:   job.Resources,
+		Note:        job.Note,
+		SubmittedBy: job.User,
+		UUID:        job.UUID,
+		Timeout:     timeout,
+	}
+}
+
+type export struct {
+	globalArgs
+	filter JobFilter
+}
+
+func parseExportSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := export{globalArgs: globals}
+	var statuses statusList
+	fs := flag.NewFlagSet(exportCommandName, flag.ContinueOnError)
+	fs.Var(&statuses, "status", "export jobs with this status; may be repeated (default: every job)")
+	fs.StringVar(&cmd.filter.Queue, "queue", "", "export jobs in this queue")
+	fs.Var((*stringList)(&cmd.filter.Tags), "tag", "export jobs with this tag; may be repeated to require several")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument: '%s'", fs.Arg(0))
+	}
+	cmd.filter.Statuses = statuses
+	return cmd, nil
+}
+
+func (cmd export) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	doc, err := db.ExportJobs(cmd.filter)
+	if err != nil {
+		return fmt.Errorf("failed to export jobs: %w", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	slog.Info("exported jobs", "count", len(doc.Jobs))
+	return nil
+}
+
+type importJobs struct {
+	globalArgs
+	path string
+}
+
+func parseImportSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := importJobs{globalArgs: globals}
+	fs := flag.NewFlagSet(importCommandName, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: file written by chime export, or - for stdin")
+	}
+	cmd.path = fs.Arg(0)
+	return cmd, nil
+}
+
+func (cmd importJobs) Run() error {
+	var in io.Reader = os.Stdin
+	if cmd.path != "-" {
+		f, err := os.Open(cmd.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var doc jobExport
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to read job export: %w", err)
+	}
+
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	result, err := db.ImportJobs(&doc)
+	if err != nil {
+		return err
+	}
+	slog.Info("imported jobs", "count", result.Imported, "duplicates", result.Duplicates)
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A job can have follow-up commands, one to run if it succeeds and one if it
+// fails, e.g. to send a notification. Once the job has finished, the worker
+// that ran it adds the follow-up as a child job, linked to the job by its
+// parent ID and marked as its follow-up, and runs it straight away.
+
+// StartFollowUp adds the follow-up of a finished job as a child job, and
+// leases it to the worker that ran the job; see claimFollowUp.
+func (db *DB) StartFollowUp(parentID int64) (*Job, error) {
+	return db.claimFollowUp(parentID, defaultLease)
+}
+
+// claimFollowUp adds the follow-up of a finished job for its outcome as a
+// child job in the same queue and environment, already leased to the job's
+// lease owner for the given duration. Returns nil if the job has no
+// follow-up for its outcome, or its follow-up was already added.
+func (db *DB) claimFollowUp(parentID int64, lease time.Duration) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	parent, err := scanJob(tx.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, parentID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("no job #%d", parentID)
+		}
+		return nil, err
+	}
+	command := parent.OnSuccess
+	if parent.Status != statusDoneSuccess {
+		command = parent.OnFailure
+	}
+	// A cancelled job has no outcome to follow up.
+	if !isTerminal(parent.Status) || parent.Status == statusCancelled || command == "" {
+		return nil, nil
+	}
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM jobs WHERE parent_id = ? AND follow_up)`, parentID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, nil
+	}
+
+	spec := JobSpec{
+		Command:  command,
+		Queue:    parent.Queue,
+		EnvMode:  parent.EnvMode,
+		EnvAllow: parent.EnvAllow,
+		Env:      parent.Env,
+		ParentID: parentID,
+		FollowUp: true,
+	}
+	now := time.Now()
+	result, err := tx.Exec(insertJobSQL, spec.insertArgs(now.UnixMilli())...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := recordEvents(tx, db.actor, eventQueued, fmt.Sprintf("follow-up of #%d", parentID), "id = ?", id); err != nil {
+		return nil, err
+	}
+	job, err := scanJob(tx.QueryRow(`
+	UPDATE jobs SET status = ?, started_at = ?, lease_owner = ?, lease_expires_at = ?, attempts = 1
+	WHERE id = ?
+	RETURNING `+jobColumns,
+		statusInProgress, now.UnixMilli(), parent.LeaseOwner, now.Add(lease).UnixMilli(), id))
+	if err != nil {
+		return nil, err
+	}
+	if err := recordEvents(tx, db.actor, eventClaimed, "", "id = ?", id); err != nil {
+		return nil, err
+	}
+	return &job, tx.Commit()
+}
+
+// execJobAndFollowUp runs a job, then its follow-up for its outcome if it
+// has one. A follow-up that fails is only logged; the job's result is
+// returned.
+func execJobAndFollowUp(db *DB, cfg execConfig, job *Job) (*jobResult, error) {
+	result, err := execJobSavingOutput(db, cfg, job)
+	if err != nil || result.Preempted || !job.hasFollowUps() {
+		return result, err
+	}
+	child, err := db.StartFollowUp(int64(job.ID))
+	if err != nil {
+		slog.Error("failed to add follow-up job", "id", job.ID, "err", err)
+		return result, nil
+	}
+	if child != nil {
+		slog.Debug("running follow-up job", "id", child.ID, "parent", job.ID)
+		if _, err := execJobSavingOutput(db, cfg, child); err != nil {
+			slog.Error("follow-up job failed", "id", child.ID, "err", err)
+		}
+	}
+	return result, nil
+}
+
+func (job Job) hasFollowUps() bool {
+	return job.OnSuccess != "" || job.OnFailure != ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Held jobs are pending jobs that runners skip until they're released, so
+// work can be staged in the queue before it's ready to run.
+
+type hold struct {
+	globalArgs
+	ref string
+}
+
+type release struct {
+	globalArgs
+	ref string
+}
+
+func parseHoldSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to hold")
+	}
+	return hold{globalArgs: globals, ref: args[0]}, nil
+}
+
+func parseReleaseSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to release")
+	}
+	return release{globalArgs: globals, ref: args[0]}, nil
+}
+
+func (cmd hold) Run() error {
+	return setHeld(cmd.globalArgs, cmd.ref, true)
+}
+
+func (cmd release) Run() error {
+	return setHeld(cmd.globalArgs, cmd.ref, false)
+}
+
+func setHeld(globals globalArgs, ref string, held bool) error {
+	db, err := Open(globals.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, ref)
+	if err != nil {
+		return err
+	}
+	ok, err := db.SetJobHeld(int64(job.ID), held)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	switch {
+	case !ok && held:
+		return fmt.Errorf("job #%d isn't pending", job.ID)
+	case !ok:
+		return fmt.Errorf("job #%d isn't held", job.ID)
+	case held:
+		slog.Info("held job", "id", job.ID)
+	default:
+		slog.Info("released job", "id", job.ID)
+	}
+	return nil
+}
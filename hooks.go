@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Hooks are shell commands a runner runs before and after each job, e.g. to
+// check a VPN is up or push metrics, with the job's details in their
+// environment. A job whose pre-hook fails fails without running; a failed
+// post-hook is only logged.
+
+// hookEnv returns the environment of a job's hooks: the runner's own, and
+// the job's details. Post-hooks also get the job's result.
+func hookEnv(job *Job, result *jobResult) []string {
+	env := append(os.Environ(), chimeJobVars(job)...)
+	env = append(env,
+		"CHIME_JOB_UUID="+job.UUID,
+		"CHIME_JOB_NAME="+job.Name,
+		"CHIME_JOB_QUEUE="+job.Queue,
+		"CHIME_JOB_COMMAND="+job.Command,
+	)
+	if result != nil {
+		env = append(env,
+			"CHIME_JOB_STATUS="+statusNames[result.status()],
+			"CHIME_JOB_EXIT_CODE="+strconv.Itoa(result.ExitCode),
+			"CHIME_JOB_DURATION_MS="+strconv.FormatInt(result.Duration().Milliseconds(), 10),
+		)
+	}
+	return env
+}
+
+// runHook runs hook for job, with its output going where the job's does.
+func runHook(cfg execConfig, hook string, job *Job, result *jobResult) error {
+	cmd := shellCommand(hook)
+	cmd.Env = hookEnv(job, result)
+	cmd.Stdout = cfg.stdout()
+	cmd.Stderr = cfg.stderr()
+	return cmd.Run()
+}
+
+// execJob runs a job between the runner's hooks, if it has any.
+func execJob(db jobStore, cfg execConfig, job *Job) (*jobResult, error) {
+	if cfg.PreHook != "" {
+		if err := runHook(cfg, cfg.PreHook, job, nil); err != nil {
+			now := time.Now()
+			failure := fmt.Sprintf("pre-hook failed: %s", err)
+			result := &jobResult{Job: job, Err: errors.New(failure), ExitCode: -1, StartedAt: now, FinishedAt: now}
+			if err := db.FinishJob(int64(job.ID), int64(statusDoneFailed), -1, unknownUsage, failure); err != nil {
+				return result, fmt.Errorf("failed to set status of job #%d: %w", job.ID, err)
+			}
+			return result, nil
+		}
+	}
+
+	result, err := execJobCommand(db, cfg, job)
+
+	if cfg.PostHook != "" {
+		hookResult := result
+		if hookResult == nil {
+			hookResult = &jobResult{Job: job, Err: err, ExitCode: -1}
+		}
+		if err := runHook(cfg, cfg.PostHook, job, hookResult); err != nil {
+			slog.Warn("post-hook failed", "id", job.ID, "err", err)
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,134 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+const (
+	// How often copying input to a job's terminal checks whether the job
+	// has exited.
+	inputPollInterval = 100 * time.Millisecond
+	// How long to wait for the rest of a job's output once it has exited;
+	// processes it started can keep its terminal open indefinitely.
+	outputDrainTimeout = 2 * time.Second
+)
+
+// startInteractive starts a job's command on a new pseudo-terminal attached
+// to the runner's, for `chime take --interactive`, so the job can prompt for
+// input and run TUIs. The runner's terminal is in raw mode until the
+// returned function, to be called once the command has exited, restores it.
+// The job leads a session of its own, which is also its process group.
+func startInteractive(cmd *exec.Cmd) (func(), error) {
+	attrs := &syscall.SysProcAttr{}
+	if cmd.SysProcAttr != nil {
+		*attrs = *cmd.SysProcAttr
+	}
+	// A session leader can't change its process group.
+	attrs.Setpgid = false
+	attrs.Setsid = true
+	attrs.Setctty = true
+	// The terminal carries both of the job's outputs to where its stdout
+	// was going.
+	stdout := cmd.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+
+	ptmx, err := pty.StartWithAttrs(cmd, nil, attrs)
+	if err != nil {
+		return nil, err
+	}
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	go func() {
+		for range resize {
+			if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
+				slog.Debug("failed to resize job's terminal", "err", err)
+			}
+		}
+	}()
+	resize <- syscall.SIGWINCH
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		slog.Warn("failed to put terminal in raw mode", "err", err)
+	}
+	done := make(chan struct{})
+	input := make(chan struct{})
+	go func() {
+		copyInput(ptmx, os.Stdin, done)
+		close(input)
+	}()
+	output := make(chan struct{})
+	go func() {
+		// Reading fails once the job and everything it started have closed
+		// the terminal.
+		io.Copy(stdout, ptmx)
+		close(output)
+	}()
+
+	return func() {
+		select {
+		case <-output:
+		case <-time.After(outputDrainTimeout):
+			slog.Warn("job's terminal is still open, not waiting for the rest of its output", "after", outputDrainTimeout)
+		}
+		close(done)
+		<-input
+		signal.Stop(resize)
+		close(resize)
+		if state != nil {
+			term.Restore(int(os.Stdin.Fd()), state)
+		}
+		ptmx.Close()
+	}, nil
+}
+
+// copyInput copies input from src to the job's terminal until done is
+// closed. It polls src rather than blocking in a read, which would go on
+// after the job exited and swallow the first input meant for whatever reads
+// the terminal next, such as the next job of `take --loop --interactive`.
+func copyInput(dst io.Writer, src *os.File, done <-chan struct{}) {
+	fd := int(src.Fd())
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		ready, err := unix.Poll(fds, int(inputPollInterval.Milliseconds()))
+		if errors.Is(err, unix.EINTR) || ready == 0 {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		n, err := unix.Read(fd, buf)
+		if errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) {
+			continue
+		}
+		if err != nil || n == 0 {
+			// The input was closed.
+			return
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
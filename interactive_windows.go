@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func startInteractive(cmd *exec.Cmd) (func(), error) {
+	return nil, errors.New("interactive jobs aren't supported on Windows")
+}
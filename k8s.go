@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variable naming the kubectl binary the Kubernetes executor
+// uses. Its cluster and credentials come from the usual kubeconfig.
+const chimeKubectlEnvKey = "CHIME_KUBECTL"
+
+// How often the Kubernetes executor checks whether a job has finished.
+const k8sPollInterval = 2 * time.Second
+
+func kubectl() string {
+	if k := os.Getenv(chimeKubectlEnvKey); k != "" {
+		return k
+	}
+	return "kubectl"
+}
+
+// runKubectl runs kubectl in the executor's namespace and returns its stdout.
+func runKubectl(cfg execConfig, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(kubectl(), append([]string{"--namespace", cfg.Namespace}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// k8sJobName returns the name of the Kubernetes Job for a chime job. The
+// UUID keeps names from different chime DBs sharing a namespace apart.
+func k8sJobName(job *Job) string {
+	return fmt.Sprintf("chime-%d-%s", job.ID, strings.SplitN(job.UUID, "-", 2)[0])
+}
+
+// k8sJobManifest returns a Kubernetes Job that runs the chime job once, in
+// its image or the executor's default one. Scripts are passed in the
+// environment and written to a file in the container before running.
+func k8sJobManifest(job *Job, cfg execConfig) ([]byte, error) {
+	image := job.Image
+	if image == "" {
+		image = cfg.K8sImage
+	}
+	if image == "" {
+		return nil, fmt.Errorf("job has no image, and no --k8s-image was given")
+	}
+
+	type envVar struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	var env []envVar
+	for _, kv := range append(append([]string(nil), job.Env...), chimeJobVars(job)...) {
+		k, v, _ := strings.Cut(kv, "=")
+		env = append(env, envVar{k, v})
+	}
+	command := []string{"sh", "-c", job.Command}
+	if job.Script != "" {
+		env = append(env, envVar{"CHIME_SCRIPT", job.Script})
+		run := "sh /tmp/chime-script"
+		if strings.HasPrefix(job.Script, "#!") {
+			run = "chmod +x /tmp/chime-script && exec /tmp/chime-script"
+		}
+		command = []string{"sh", "-c", `printf '%s' "$CHIME_SCRIPT" > /tmp/chime-script && ` + run}
+	}
+
+	container := map[string]any{
+		"name":    "job",
+		"image":   image,
+		"command": command,
+		"env":     env,
+	}
+	limits := map[string]string{}
+	if job.MemLimit > 0 {
+		limits["memory"] = strconv.FormatInt(job.MemLimit, 10)
+	}
+	if job.CPULimit > 0 {
+		limits["cpu"] = strconv.FormatFloat(job.CPULimit, 'f', -1, 64)
+	}
+	if len(limits) > 0 {
+		container["resources"] = map[string]any{"limits": limits}
+	}
+
+	labels := map[string]string{"app.kubernetes.io/managed-by": "chime", "chime/job-id": strconv.Itoa(job.ID)}
+	return json.Marshal(map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":        k8sJobName(job),
+			"labels":      labels,
+			"annotations": map[string]string{"chime/job-uuid": job.UUID},
+		},
+		"spec": map[string]any{
+			// chime does its own retries, with requeue.
+			"backoffLimit": 0,
+			"template": map[string]any{
+				"metadata": map[string]any{"labels": labels},
+				"spec": map[string]any{
+					"restartPolicy": "Never",
+					"containers":    []any{container},
+				},
+			},
+		},
+	})
+}
+
+// k8sJobStatus is the part of a Job's and its pod's status the executor uses.
+type k8sJobStatus struct {
+	Status struct {
+		Conditions []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type k8sPodList struct {
+	Items []struct {
+		Status struct {
+			ContainerStatuses []struct {
+				State struct {
+					Terminated *struct {
+						ExitCode int    `json:"exitCode"`
+						Reason   string `json:"reason"`
+					} `json:"terminated"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// execK8sJob runs a job as a Kubernetes Job and waits for it to finish. The
+// pod's logs are copied to the runner's output, and its exit code and
+// failure reason are recorded. The Kubernetes Job is deleted afterwards.
+func execK8sJob(db jobStore, cfg execConfig, job *Job) (*jobResult, error) {
+	result := &jobResult{Job: job, ExitCode: -1, StartedAt: time.Now()}
+	name := k8sJobName(job)
+
+	finish := func(status int, failure string) (*jobResult, error) {
+		result.FinishedAt = time.Now()
+		if err := db.FinishJob(int64(job.ID), int64(status), result.ExitCode, unknownUsage, failure); err != nil {
+			return result, fmt.Errorf("failed to set status of job #%d: %w", job.ID, err)
+		}
+		return result, nil
+	}
+
+	manifest, err := k8sJobManifest(job, cfg)
+	if err == nil {
+		_, err = runKubectl(cfg, manifest, "create", "--filename", "-")
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create Kubernetes Job: %w", err)
+		return finish(statusDoneFailed, result.Err.Error())
+	}
+	defer func() {
+		if _, err := runKubectl(cfg, nil, "delete", "job", name, "--cascade=background", "--wait=false"); err != nil {
+			slog.Warn("failed to delete Kubernetes Job", "id", job.ID, "name", name, "err", err)
+		}
+	}()
+	if err := db.RecordJobStarted(int64(job.ID), fmt.Sprintf("k8s job %s/%s", cfg.Namespace, name)); err != nil {
+		slog.Error("failed to record job start", "id", job.ID, "err", err)
+	}
+
+	// Wait for the Job to complete or fail.
+	var jobFailure, podFailure string
+	for done := false; !done; {
+		time.Sleep(k8sPollInterval)
+		out, err := runKubectl(cfg, nil, "get", "job", name, "--output", "json")
+		if err != nil {
+			slog.Warn("failed to check Kubernetes Job", "id", job.ID, "name", name, "err", err)
+			continue
+		}
+		var status k8sJobStatus
+		if err := json.Unmarshal(out, &status); err != nil {
+			return result, fmt.Errorf("failed to parse status of Kubernetes Job %s: %w", name, err)
+		}
+		for _, c := range status.Status.Conditions {
+			if c.Status != "True" {
+				continue
+			}
+			switch c.Type {
+			case "Complete":
+				done = true
+			case "Failed":
+				done = true
+				jobFailure = strings.TrimSpace(c.Reason + ": " + c.Message)
+			}
+		}
+	}
+
+	if out, err := runKubectl(cfg, nil, "get", "pods", "--selector", "job-name="+name, "--output", "json"); err != nil {
+		slog.Warn("failed to read Kubernetes pod status", "id", job.ID, "err", err)
+	} else {
+		var pods k8sPodList
+		if err := json.Unmarshal(out, &pods); err == nil && len(pods.Items) > 0 {
+			for _, cs := range pods.Items[0].Status.ContainerStatuses {
+				if t := cs.State.Terminated; t != nil {
+					result.ExitCode = t.ExitCode
+					// Completed and Error just restate the exit code.
+					if t.Reason != "Completed" && t.Reason != "Error" {
+						podFailure = t.Reason
+					}
+				}
+			}
+		}
+	}
+
+	logs, err := runKubectl(cfg, nil, "logs", "job/"+name)
+	if err != nil {
+		slog.Warn("failed to read Kubernetes Job logs", "id", job.ID, "err", err)
+	}
+	cfg.stdout().Write(logs)
+
+	// Prefer the most specific reason, e.g. OOMKilled over the exit code over
+	// the Job's BackoffLimitExceeded.
+	var failure string
+	switch {
+	case podFailure != "":
+		failure = podFailure
+	case result.ExitCode > 0:
+		failure = fmt.Sprintf("exit status %d", result.ExitCode)
+	case jobFailure != "":
+		failure = jobFailure
+	}
+	if failure != "" {
+		result.Err = fmt.Errorf("%s", failure)
+		return finish(statusDoneFailed, failure)
+	}
+	return finish(statusDoneSuccess, "")
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Concurrency limits cap how many jobs with a tag, in a queue, or of a user
+// may run at once, however many workers there are. Runners enforce them when
+// claiming jobs; suspended jobs count as running. Users can also be limited
+// in how many jobs they have pending; see users.go.
+
+// Kinds of concurrency limits.
+const (
+	limitTag   = "tag"
+	limitQueue = "queue"
+	limitUser  = "user"
+)
+
+type ConcurrencyLimit struct {
+	Kind string
+	Name string
+	// Most jobs running at once, or 0 for no limit.
+	Max int
+	// For users, the most pending and held jobs, or 0 for no limit.
+	MaxPending int
+}
+
+// limitsCond is a SQL condition matching jobs that no concurrency limit
+// keeps from running, given the running and suspended statuses as
+// arguments.
+const limitsCond = `NOT EXISTS (
+	SELECT 1 FROM concurrency_limits AS l
+	WHERE l.max_running > 0
+	AND CASE l.kind
+		WHEN 'tag' THEN instr(',' || jobs.tags || ',', ',' || l.name || ',') > 0
+		WHEN 'user' THEN jobs.submitted_by = l.name
+		ELSE jobs.queue = l.name END
+	AND (
+		SELECT count(*) FROM jobs AS r
+		WHERE r.status IN (?, ?)
+		AND CASE l.kind
+			WHEN 'tag' THEN instr(',' || r.tags || ',', ',' || l.name || ',') > 0
+			WHEN 'user' THEN r.submitted_by = l.name
+			ELSE r.queue = l.name END
+	) >= l.max_running
+)`
+
+// SetConcurrencyLimit sets the limit on the named tag, queue or user,
+// replacing any existing one.
+func (db *DB) SetConcurrencyLimit(kind, name string, max int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO concurrency_limits (kind, name, max_running) VALUES (?,?,?)
+	ON CONFLICT (kind, name) DO UPDATE SET max_running = excluded.max_running`,
+		kind, name, max)
+	return err
+}
+
+// SetPendingLimit sets the most pending jobs the named user may have,
+// keeping any limit on their running jobs.
+func (db *DB) SetPendingLimit(name string, max int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO concurrency_limits (kind, name, max_running, max_pending) VALUES (?,?,0,?)
+	ON CONFLICT (kind, name) DO UPDATE SET max_pending = excluded.max_pending`,
+		limitUser, name, max)
+	return err
+}
+
+// GetConcurrencyLimit returns the limit on the named tag, queue or user, or
+// nil if it has none.
+func (db *DB) GetConcurrencyLimit(kind, name string) (*ConcurrencyLimit, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	l := ConcurrencyLimit{Kind: kind, Name: name}
+	err := db.QueryRow(`SELECT max_running, max_pending FROM concurrency_limits WHERE kind = ? AND name = ?`, kind, name).Scan(&l.Max, &l.MaxPending)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (db *DB) ListConcurrencyLimits() ([]ConcurrencyLimit, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT kind, name, max_running, max_pending FROM concurrency_limits ORDER BY kind, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []ConcurrencyLimit
+	for rows.Next() {
+		var l ConcurrencyLimit
+		if err := rows.Scan(&l.Kind, &l.Name, &l.Max, &l.MaxPending); err != nil {
+			return limits, err
+		}
+		limits = append(limits, l)
+	}
+	return limits, rows.Err()
+}
+
+// Deletes the limit on the named tag, queue or user. Returns true if it
+// existed.
+func (db *DB) DeleteConcurrencyLimit(kind, name string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM concurrency_limits WHERE kind = ? AND name = ?`, kind, name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// countWith returns how many jobs of the limit's tag, queue or user are in
+// one of the two statuses.
+func (db *DB) countWith(l ConcurrencyLimit, status1, status2 int) (int, error) {
+	where := "instr(',' || tags || ',', ?) > 0"
+	arg := "," + l.Name + ","
+	switch l.Kind {
+	case limitQueue:
+		where, arg = "queue = ?", l.Name
+	case limitUser:
+		where, arg = "submitted_by = ?", l.Name
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var n int
+	err := db.QueryRow(`SELECT count(*) FROM jobs WHERE status IN (?, ?) AND `+where,
+		status1, status2, arg).Scan(&n)
+	return n, err
+}
+
+type limitSet struct {
+	globalArgs
+	kind string
+	name string
+	max  int
+	// Set the user's pending limit rather than their running one.
+	pending bool
+}
+
+type limitList struct {
+	globalArgs
+}
+
+type limitRemove struct {
+	globalArgs
+	kind string
+	name string
+}
+
+// parseLimitTarget parses the --queue and --user flags, the --pending flag
+// of set, and the tag, queue or user name of a limit command.
+func parseLimitTarget(name string, args []string, pending *bool) (string, []string, error) {
+	var queue, user bool
+	fs := flag.NewFlagSet(limitCommandName+" "+name, flag.ContinueOnError)
+	fs.BoolVar(&queue, "queue", false, "limit a queue rather than a tag")
+	fs.BoolVar(&user, "user", false, "limit the jobs of a user rather than a tag")
+	if pending != nil {
+		fs.BoolVar(pending, "pending", false, "with --user, limit how many jobs the user may have pending rather than running")
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	kind := limitTag
+	switch {
+	case queue && user:
+		return "", nil, fmt.Errorf("--queue and --user can't be combined")
+	case queue:
+		kind = limitQueue
+	case user:
+		kind = limitUser
+	}
+	if pending != nil && *pending && !user {
+		return "", nil, fmt.Errorf("--pending only applies with --user")
+	}
+	if fs.NArg() > 0 {
+		if err := validateName(kind, fs.Arg(0)); err != nil {
+			return "", nil, err
+		}
+	}
+	return kind, fs.Args(), nil
+}
+
+func parseLimitSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("limit command required: set, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "set":
+		var pending bool
+		kind, args, err := parseLimitTarget(cmd, args, &pending)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 2 {
+			return nil, fmt.Errorf("params required: %s name and number of jobs", kind)
+		}
+		max, err := strconv.Atoi(args[1])
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("invalid number of jobs: '%s'", args[1])
+		}
+		return limitSet{globalArgs: globals, kind: kind, name: args[0], max: max, pending: pending}, nil
+	case "list":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args)
+		}
+		return limitList{globalArgs: globals}, nil
+	case "remove":
+		kind, args, err := parseLimitTarget(cmd, args, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: %s name to remove the limit of", kind)
+		}
+		return limitRemove{globalArgs: globals, kind: kind, name: args[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown limit command: '%s'", cmd)
+}
+
+func (cmd limitSet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.pending {
+		if err := db.SetPendingLimit(cmd.name, cmd.max); err != nil {
+			return err
+		}
+		slog.Info("set pending job limit", cmd.kind, cmd.name, "max", cmd.max)
+		return nil
+	}
+	if err := db.SetConcurrencyLimit(cmd.kind, cmd.name, cmd.max); err != nil {
+		return err
+	}
+	slog.Info("set concurrency limit", cmd.kind, cmd.name, "max", cmd.max)
+	return nil
+}
+
+func (cmd limitList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	limits, err := db.ListConcurrencyLimits()
+	if err != nil {
+		return fmt.Errorf("failed to list limits: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(limits) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("KIND", "NAME", "RUNNING", "MAX", "PENDING", "MAX PENDING")
+	limitText := func(max int) string {
+		if max == 0 {
+			return "-"
+		}
+		return strconv.Itoa(max)
+	}
+	for _, l := range limits {
+		running, err := db.countWith(l, statusInProgress, statusSuspended)
+		if err != nil {
+			return fmt.Errorf("failed to count running jobs: %w", err)
+		}
+		pending := "-"
+		if l.Kind == limitUser {
+			n, err := db.countWith(l, statusPending, statusHeld)
+			if err != nil {
+				return fmt.Errorf("failed to count pending jobs: %w", err)
+			}
+			pending = strconv.Itoa(n)
+		}
+		t.Row(l.Kind, l.Name, strconv.Itoa(running), limitText(l.Max), pending, limitText(l.MaxPending))
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd limitRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeleteConcurrencyLimit(cmd.kind, cmd.name)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("no limit on %s '%s'", cmd.kind, cmd.name)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss/tree"
+)
+
+// A job can add jobs of its own: chime runs jobs with $CHIME_JOB_ID and
+// $CHIME_DB_PATH set, so `chime add --parent $CHIME_JOB_ID ...` from within
+// one adds a child job to the same DB, linked to the job by its parent ID
+// like a follow-up is. Children can add children in turn, and `chime show`
+// draws the tree of jobs descended from a job, while `chime wait --recursive`
+// waits for all of them to finish.
+
+// JobDescendants returns the jobs descended from the job, including its
+// follow-ups, in the order they were added.
+func (db *DB) JobDescendants(id int64) ([]Job, error) {
+	return db.queryJobs("jobs", `id IN (
+		WITH RECURSIVE descendants (id) AS (
+			SELECT id FROM jobs WHERE parent_id = ?
+			UNION SELECT j.id FROM jobs AS j JOIN descendants AS d ON j.parent_id = d.id
+		)
+		SELECT id FROM descendants
+	)`, id)
+}
+
+// jobTree renders the job and its descendants as a tree, each job under the
+// one that added it.
+func jobTree(root Job, descendants []Job) *tree.Tree {
+	children := map[int64][]Job{}
+	for _, job := range descendants {
+		children[job.ParentID] = append(children[job.ParentID], job)
+	}
+	var build func(job Job) *tree.Tree
+	build = func(job Job) *tree.Tree {
+		t := tree.Root(jobTreeLabel(job))
+		for _, child := range children[int64(job.ID)] {
+			t.Child(build(child))
+		}
+		return t
+	}
+	return build(root)
+}
+
+func jobTreeLabel(job Job) string {
+	label := fmt.Sprintf("#%d %s: %s", job.ID, statusNames[job.Status], job.Command)
+	if job.FollowUp {
+		label += " (follow-up)"
+	}
+	return label
+}
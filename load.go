@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// How often a runner that's backing off checks the machine again.
+const loadCheckInterval = 5 * time.Second
+
+// loadGate holds a runner back from taking jobs while the machine is busy,
+// as given to `run --max-load` and `--min-free-disk`. The zero value never
+// holds it back.
+type loadGate struct {
+	// Highest 1 minute load average to take jobs at, or 0 for any.
+	maxLoad float64
+	// Least free space in bytes on the filesystem jobs run in, or 0 for any.
+	minFreeDisk int64
+	// Directory jobs run in.
+	dir string
+}
+
+func (g loadGate) isSet() bool {
+	return g.maxLoad > 0 || g.minFreeDisk > 0
+}
+
+// check returns why the runner shouldn't take a job now, or "" if it may.
+func (g loadGate) check() (string, error) {
+	if g.maxLoad > 0 {
+		load, err := loadAverage()
+		if err != nil {
+			return "", fmt.Errorf("failed to read load average: %w", err)
+		}
+		if load > g.maxLoad {
+			return fmt.Sprintf("load average %.2f is above %g", load, g.maxLoad), nil
+		}
+	}
+	if g.minFreeDisk > 0 {
+		free, err := freeDiskSpace(g.dir)
+		if err != nil {
+			return "", fmt.Errorf("failed to read free disk space: %w", err)
+		}
+		if free < g.minFreeDisk {
+			return fmt.Sprintf("%s free on disk is below %s", formatBytes(free), formatBytes(g.minFreeDisk)), nil
+		}
+	}
+	return "", nil
+}
+
+// wait blocks until the machine isn't too busy to take a job. Returns false
+// if stop was closed first.
+func (g loadGate) wait(stop <-chan struct{}) bool {
+	if !g.isSet() {
+		return true
+	}
+	backedOff := false
+	for {
+		reason, err := g.check()
+		if err != nil {
+			// Better to keep taking jobs than to stall on a broken check.
+			slog.Warn("failed to check system load", "err", err)
+			return true
+		}
+		if reason == "" {
+			if backedOff {
+				slog.Info("taking jobs again")
+			}
+			return true
+		}
+		if !backedOff {
+			slog.Warn("not taking jobs while the machine is busy", "reason", reason)
+			backedOff = true
+		}
+		select {
+		case <-stop:
+			return false
+		case <-time.After(loadCheckInterval):
+		}
+	}
+}
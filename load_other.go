@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func loadAverage() (float64, error) {
+	return 0, fmt.Errorf("load averages are only supported on Linux")
+}
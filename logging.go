@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging configures the default slog logger. Text logs keep the
+// standard log package's format; JSON logs have one object per line, for
+// shipping to a log aggregator.
+func setupLogging(verbose, quiet bool, format string) error {
+	level := slog.LevelInfo
+	switch {
+	case verbose && quiet:
+		return fmt.Errorf("--verbose and --quiet can't be used together")
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+
+	switch format {
+	case logFormatText:
+		slog.SetLogLoggerLevel(level)
+	case logFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("invalid --log-format: '%s' (must be %s or %s)", format, logFormatText, logFormatJSON)
+	}
+	return nil
+}
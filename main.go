@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
@@ -18,12 +29,64 @@ import (
 const chimeDBPathEnvKey = "CHIME_DB_PATH"
 
 const (
-	helpCommandName   = "help"
-	runCommandName    = "run"
-	takeCommandName   = "take"
-	listCommandName   = "list"
-	addCommandName    = "add"
-	removeCommandName = "remove"
+	helpCommandName      = "help"
+	runCommandName       = "run"
+	takeCommandName      = "take"
+	listCommandName      = "list"
+	addCommandName       = "add"
+	removeCommandName    = "remove"
+	runsCommandName      = "runs"
+	metricsCommandName   = "metrics"
+	templateCommandName  = "template"
+	priorityCommandName  = "priority"
+	batchCommandName     = "batch"
+	scaleCommandName     = "scale"
+	showCommandName      = "show"
+	requeueCommandName   = "requeue"
+	purgeCommandName     = "purge"
+	archiveCommandName   = "archive"
+	migrateCommandName   = "migrate"
+	backupCommandName    = "backup"
+	restoreCommandName   = "restore"
+	vacuumCommandName    = "vacuum"
+	doctorCommandName    = "doctor"
+	historyCommandName   = "history"
+	eventsCommandName    = "events"
+	statsCommandName     = "stats"
+	timelineCommandName  = "timeline"
+	reportCommandName    = "report"
+	topCommandName       = "top"
+	hostsCommandName     = "hosts"
+	serveCommandName     = "serve"
+	workerCommandName    = "worker"
+	pauseCommandName     = "pause"
+	resumeCommandName    = "resume"
+	suspendCommandName   = "suspend"
+	holdCommandName      = "hold"
+	releaseCommandName   = "release"
+	dlqCommandName       = "dlq"
+	limitCommandName     = "limit"
+	weightCommandName    = "weight"
+	agingCommandName     = "aging"
+	moveCommandName      = "move"
+	workersCommandName   = "workers"
+	ctlCommandName       = "ctl"
+	editCommandName      = "edit"
+	annotateCommandName  = "annotate"
+	watchCommandName     = "watch"
+	xargsCommandName     = "xargs"
+	searchCommandName    = "search"
+	artifactsCommandName = "artifacts"
+	exportCommandName    = "export"
+	importCommandName    = "import"
+	aliasCommandName     = "alias"
+	psCommandName        = "ps"
+	cancelCommandName    = "cancel"
+	waitCommandName      = "wait"
+	cloneCommandName     = "clone"
+
+	installServiceCommandName   = "install-service"
+	uninstallServiceCommandName = "uninstall-service"
 )
 
 type globalArgs struct {
@@ -32,42 +95,158 @@ type globalArgs struct {
 
 type run struct {
 	globalArgs
+	execConfig
 	numWorkers int
+	// Keep waiting for new jobs rather than exiting once the queue is empty,
+	// until there have been none for idleTimeout, if it's positive.
+	follow      bool
+	idleTimeout time.Duration
+	// Fired when a --follow run's queue drains; see notify.go.
+	notify      notifiers
+	metricsAddr string
+	// File the run appends its job events to; see runreport.go.
+	reportPath string
+	// Shared by all of the run's workers.
+	rate rateLimit
+	load loadGate
+	// How running jobs make way for more urgent ones when the workers are
+	// all busy: preemptSuspend, preemptRequeue, or empty for not at all.
+	preempt string
+	// Devices the run's jobs can be given; see resources.go.
+	resources resourceCounts
+	// What to do with jobs left running by runners that stopped, as the run
+	// starts: recoverRequeue, recoverFail or recoverIgnore; see recover.go.
+	recoverMode string
+	// Print the jobs the run would claim instead; see dryrun.go.
+	dryRun bool
 }
 
+// How often a --follow run checks for new jobs while the queue is empty.
+const followPollInterval = time.Second
+
 type take struct {
 	globalArgs
-	jobID int
+	execConfig
+	jobID  int
+	dryRun bool
+	// Keep taking jobs, one at a time, until there are none left.
+	loop bool
+	// Ring the terminal bell after each job.
+	bell bool
 }
 type list struct {
 	globalArgs
+	collapseArrays bool
+	asJSON         bool
+	archived       bool
+	columns        []listColumn
+	// Only jobs whose command, name or note matches.
+	grep *regexp.Regexp
+	// Only jobs added by this user, if set.
+	user string
+	// Only jobs with one of these statuses, if any.
+	statuses []int
 }
 type add struct {
 	globalArgs
-	commandToRun string
+	commandToRun   string
+	scriptPath     string
+	templateName   string
+	templateParams map[string]string
+	aliasName      string
+	aliasArgs      []string
+	arrayRange     string
+	priority       int
+	tags           stringList
+	batchID        int64
+	envMode        string
+	envAllow       stringList
+	env            stringList
+	locale         string
+	queue          string
+	unique         bool
+	name           string
+	nice           int
+	ionice         string
+	memLimit       int64
+	cpuLimit       float64
+	image          string
+	host           string
+	requires       stringList
+	timeWindow     string
+	onSuccess      string
+	onFailure      string
+	parent         string
+	maxRetries     int
+	timeout        time.Duration
+	lock           string
+	slots          int
+	resources      resourceCounts
+	gpus           int
+	note           string
 }
 type remove struct {
 	globalArgs
-	id int
+	// Job IDs, names, UUID prefixes, or ranges of IDs like 10-20.
+	refs     []string
+	statuses []int
+}
+
+// defaultDBPath returns the path of the DB used without --dbpath or
+// $CHIME_DB_PATH: ~/.chime.db, or on Windows chime.db in a chime directory
+// in %APPDATA%, which it creates.
+func defaultDBPath() (string, error) {
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(dir, "chime")
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "chime.db"), nil
+	}
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homedir, ".chime.db"), nil
 }
 
 func main() {
-	var dbPath string
+	var dbPath, logFormat string
+	var verbose, quiet, readOnlyFlag bool
 	flag.StringVar(&dbPath, "dbpath", "", "path to DB file")
+	flag.BoolVar(&readOnlyFlag, "read-only", false, "only allow commands that query jobs, and never change the DB")
+	flag.BoolVar(&verbose, "verbose", false, "log debug messages too")
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors")
+	flag.StringVar(&logFormat, "log-format", logFormatText, "format of log messages: text or json")
 	flag.Parse()
 
+	if err := setupLogging(verbose, quiet, logFormat); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
 	if len(dbPath) == 0 {
 		var ok bool
 		if dbPath, ok = os.LookupEnv(chimeDBPathEnvKey); !ok {
-			homedir, err := os.UserHomeDir()
-			if err != nil {
-				log.Fatalf("failed to find home dir: %s", err)
+			var err error
+			if dbPath, err = defaultDBPath(); err != nil {
+				slog.Error("failed to find home dir", "err", err)
+				os.Exit(1)
 			}
-
-			dbPath = filepath.Join(homedir, ".chime.db")
 		}
 	}
 
+	envReadOnly, err := readOnlyFromEnv()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	readOnly = readOnlyFlag || envReadOnly
+
 	cmd, err := parseSubcommand(
 		globalArgs{
 			dbPath: dbPath,
@@ -75,12 +254,16 @@ func main() {
 		flag.Args(),
 	)
 	if err != nil {
-		log.Printf("%s", err)
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	if readOnly && !isQueryCommand(cmd) {
+		slog.Error(fmt.Sprintf("'%s' isn't allowed in read-only mode, since it can change the DB", strings.Join(flag.Args(), " ")))
 		os.Exit(1)
 	}
 
 	if err := cmd.Run(); err != nil {
-		log.Printf("%s", err)
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
@@ -96,61 +279,204 @@ func (r run) Run() error {
 	}
 	defer db.Close()
 
-	jobs := make(chan *Job)
-	var numJobs int
-
-	// Channel to collect errors from async tasks;
-	// 1 per consumer plus one for producer.
-	errs := make(chan error, r.numWorkers+1)
+	db = db.WithActor(r.runnerName())
+	// Jobs needing devices require the resources' names; see resources.go.
+	filter := r.claimFilter()
+	filter.Labels = append(filter.Labels, r.resources.names()...)
+	if r.dryRun {
+		jobs, err := db.PlanClaims(filter, 0)
+		if err != nil {
+			return err
+		}
+		printClaimPlan(jobs)
+		return nil
+	}
+	if err := autoPurge(db); err != nil {
+		slog.Warn("auto-purge failed", "err", err)
+	}
+	if r.recoverMode != recoverIgnore {
+		dead, err := deadLocalRunners(db)
+		if err != nil {
+			return fmt.Errorf("failed to read workers: %w", err)
+		}
+		n, err := db.RecoverJobs(r.recoverMode, dead)
+		if err != nil {
+			return fmt.Errorf("failed to recover jobs: %w", err)
+		}
+		if n > 0 {
+			slog.Warn("recovered jobs left running by runners that stopped", "count", n, "action", r.recoverMode)
+		}
+	}
+	defer heartbeat(db)()
+	if paused, err := db.PausedQueues(); err != nil {
+		slog.Warn("failed to read paused queues", "err", err)
+	} else if len(paused) > 0 {
+		slog.Warn("not taking jobs from paused queues", "queues", CommaList(paused).String())
+	}
+	if r.load.isSet() {
+		if _, err := r.load.check(); err != nil {
+			return err
+		}
+	}
 
-	// Start a worker to pull jobs from DB and push into queue.
+	recorder := newRunRecorder(r.numWorkers)
+	tracer, err := newRunTracer(r.numWorkers)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	control := newRunControl()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		var err error
-		numJobs, err = runProducerWorker(db, jobs)
-		errs <- err
+		select {
+		case <-control.stopped():
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
+	claims := claimConfig{
+		filter:      filter,
+		follow:      r.follow,
+		idleTimeout: r.idleTimeout,
+		limiter:     newRateLimiter(r.rate),
+		load:        r.load,
+		control:     control,
+	}
+	report, err := openRunReport(r.reportPath)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	pool := newWorkerPool(ctx, db, r.execConfig, claims, recorder, tracer, report, r.resources)
+	if r.preempt != "" {
+		pool.preempt = &preempter{mode: r.preempt, db: db, pool: pool, filter: filter}
+		go pool.watchPreemption(ctx)
+	}
+	if len(r.notify) > 0 {
+		pool.drain = &drainNotifier{notifiers: r.notify, run: db.actor}
+	}
 
-	for i := 0; i < r.numWorkers; i++ {
-		go func() {
-			errs <- runConsumerWorker(i, db, jobs)
-		}()
+	ctl, err := listenControl(controlSocketPath(r.globalArgs.dbPath), pool, control)
+	if err != nil {
+		slog.Warn("control socket unavailable", "err", err)
+	} else {
+		defer ctl.Close()
 	}
 
-	numErrs := 0
-	for i := 0; i < r.numWorkers+1; i++ {
-		if err := <-errs; err != nil {
-			numErrs++
-			log.Printf("Error: %v", err)
+	if r.metricsAddr != "" {
+		srv, err := serveMetrics(r.metricsAddr, db, recorder, pool)
+		if err != nil {
+			return fmt.Errorf("failed to serve metrics: %w", err)
 		}
+		defer srv.Close()
 	}
 
-	log.Printf("finished after processing %d jobs (%d errors)", numJobs, numErrs)
+	// Stop taking new jobs once interrupted. When following, let the running
+	// ones finish unless interrupted again, or the run was already stopping;
+	// otherwise pass the signal on to them, since they're in their own
+	// process groups and don't get it from the terminal.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		if !r.follow {
+			control.stopTaking()
+		} else if control.drain() {
+			sig = <-signals
+		}
+		for {
+			slog.Info("stopping running jobs", "signal", sig.String())
+			runningJobs.signal(sig.(syscall.Signal))
+			sig = <-signals
+		}
+	}()
+
+	// Each worker claims its own jobs from the DB as it frees up.
+	if err := pool.Scale(r.numWorkers); err != nil {
+		return err
+	}
+	errs := pool.Wait()
+	cancel()
+	pool.drain.wait()
+	numJobs := pool.NumJobs()
+	numErrs := len(errs)
+	for _, err := range errs {
+		slog.Error("worker failed", "err", err)
+	}
+
+	slog.Info("finished run", "jobs", numJobs, "errors", numErrs)
+	tracer.finish(numJobs, numErrs)
+
+	if _, err := db.RecordRun(recorder.finish(), runRetention); err != nil {
+		slog.Error("failed to record run summary", "err", err)
+	}
 	return nil
 }
 
-func runProducerWorker(db *DB, jobs chan<- *Job) (int, error) {
-	defer close(jobs)
-	numJobs := 0
-	for {
-		nextJob, err := db.TakeNextJob()
-		if err != nil {
-			return numJobs, fmt.Errorf("failed to read next job from DB: %w", err)
-		}
-		if nextJob == nil {
-			return numJobs, nil
+// heartbeat renews the leases of the jobs claimed through db a few times per
+// lease period, and records its workers as seen, until the returned func is
+// called.
+func heartbeat(db *DB) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(defaultLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if _, err := db.RenewLeases(db.actor, defaultLease); err != nil {
+				slog.Warn("failed to renew job leases", "err", err)
+			}
+			if err := db.TouchWorkers(db.actor); err != nil {
+				slog.Warn("failed to record worker heartbeat", "err", err)
+			}
 		}
-		numJobs++
-		jobs <- nextJob
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
 	}
-	return numJobs, nil
 }
 
-func runConsumerWorker(workerId int, db *DB, jobs <-chan *Job) error {
-	for job := range jobs {
-		if err := execJob(db, job); err != nil {
+// runConsumerWorker claims jobs from the pool and runs them, one at a time,
+// until the pool has no more for it or ctx is cancelled.
+func runConsumerWorker(ctx context.Context, workerId int, pool *workerPool) error {
+	run := pool.db.actor
+	db := pool.db.WithActor(fmt.Sprintf("%s worker %d", run, workerId))
+	defer registerWorker(db, run)()
+	for {
+		job, err := pool.claim(ctx)
+		if err != nil || job == nil {
+			return err
+		}
+		err = runWorkerJob(db, pool.cfg, job, pool.recorder, pool.tracer, pool.report)
+		pool.releaseSlots(job)
+		if err != nil {
 			return err
 		}
 	}
+}
+
+// runWorkerJob executes a job for a worker of a run, recording its result.
+func runWorkerJob(db *DB, cfg execConfig, job *Job, recorder *runRecorder, tracer *runTracer, report *runReport) error {
+	recorder.jobStarted()
+	span := tracer.jobStarted(job, db.actor)
+	report.jobStarted(job, db.actor)
+	result, err := execJobAndFollowUp(db, cfg, job)
+	report.jobEnded(job, db.actor, result, err)
+	tracer.jobEnded(span, result, err)
+	recorder.jobEnded()
+	if err != nil {
+		return err
+	}
+	recorder.add(result)
 	return nil
 }
 
@@ -160,90 +486,181 @@ func (t take) Run() error {
 		return fmt.Errorf("failed to open db: %w", err)
 	}
 	defer db.Close()
+	db = db.WithActor(t.runnerName())
+	if t.dryRun {
+		jobs, err := db.PlanClaims(t.claimFilter(), 1)
+		if err != nil {
+			return err
+		}
+		printClaimPlan(jobs)
+		return nil
+	}
+	defer heartbeat(db)()
+	defer registerWorker(db, db.actor)()
 
-	nextJob, err := db.TakeNextJob()
-	if err != nil {
-		return err
+	// Signals go to the running job, and with --loop, stop taking more.
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		var once sync.Once
+		for sig := range signals {
+			once.Do(func() { close(stop) })
+			runningJobs.signal(sig.(syscall.Signal))
+		}
+	}()
+
+	numJobs := 0
+	for {
+		nextJob, err := db.TakeNextJob(t.claimFilter())
+		if err != nil {
+			return err
+		}
+		if nextJob == nil {
+			break
+		}
+		if _, err := execJobAndFollowUp(db, t.execConfig, nextJob); err != nil {
+			return err
+		}
+		numJobs++
+		if t.bell {
+			ringBell()
+		}
+		if !t.loop {
+			return nil
+		}
+		select {
+		case <-stop:
+			slog.Info("stopped taking jobs", "jobs", numJobs)
+			return nil
+		default:
+		}
 	}
-	if nextJob == nil {
-		return nil
+	if t.loop {
+		slog.Info("no more jobs to take", "jobs", numJobs)
 	}
+	return nil
+}
 
-	return execJob(db, nextJob)
+// ringBell rings the terminal's bell, if stderr is a terminal.
+func ringBell() {
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		fmt.Fprint(os.Stderr, "\a")
+	}
 }
 
 func (cmd list) Run() error {
-	log.Printf("opening db at path: %s", cmd.globalArgs.dbPath)
+	slog.Debug("opening db", "path", cmd.globalArgs.dbPath)
 	db, err := Open(cmd.globalArgs.dbPath)
 	if err != nil {
 		return fmt.Errorf("failed to open db: %w", err)
 	}
 	defer db.Close()
 
-	jobs, err := db.ListJobs()
+	var jobs []Job
+	if cmd.archived {
+		jobs, err = db.ListArchivedJobs()
+	} else {
+		jobs, err = db.ListJobs()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to list jobs: %w", err)
 	}
+	if cmd.grep != nil {
+		jobs = grepJobs(jobs, cmd.grep)
+	}
+	if cmd.user != "" {
+		jobs = jobsOf(jobs, cmd.user)
+	}
+	if len(cmd.statuses) > 0 {
+		jobs = slices.DeleteFunc(jobs, func(job Job) bool { return !slices.Contains(cmd.statuses, job.Status) })
+	}
+
+	if cmd.asJSON {
+		out := make([]JobJSON, len(jobs))
+		for i, job := range jobs {
+			out[i] = job.JSON()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
 
+	theme := currentTheme()
 	headerStyle := lipgloss.NewStyle().
 		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("#ffffff")).Bold(true)
+		PaddingRight(2).Foreground(theme.color("header")).Bold(true)
 	cellStyle := lipgloss.NewStyle().
 		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
-	pendingStyle := lipgloss.NewStyle().
-		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("#888888"))
-	progressStyle := lipgloss.NewStyle().
-		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("#ffff00"))
-	successStyle := lipgloss.NewStyle().
-		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("#00ff00"))
-	failedStyle := lipgloss.NewStyle().
-		PaddingLeft(2).
-		PaddingRight(2).Foreground(lipgloss.Color("196"))
+		PaddingRight(2).Foreground(theme.color("text"))
+	// The status column is colored by status.
+	statusStyle := func(status int) lipgloss.Style {
+		return cellStyle.Foreground(theme.statusColor(status))
+	}
 
 	termWidth, _, _ := term.GetSize(int(os.Stdout.Fd()))
 
+	headers := []string{"ID", "STATUS"}
+	for _, col := range cmd.columns {
+		headers = append(headers, col.header)
+	}
+	headers = append(headers, "COMMAND")
+	cmdCol := len(headers) - 1
+
 	// Pre-compute natural column widths so we can decide whether to cap.
-	idW := lipgloss.Width(headerStyle.Render("ID"))
-	statusW := lipgloss.Width(headerStyle.Render("STATUS"))
-	cmdW := lipgloss.Width(headerStyle.Render("COMMAND"))
-
-	rows := make([][]string, len(jobs))
-	for i, job := range jobs {
-		row := JobToRow(job)
-		rows[i] = row
-		idW = max(idW, lipgloss.Width(cellStyle.Render(row[0])))
-		var s lipgloss.Style
-		switch job.Status {
-		case statusPending:
-			s = pendingStyle
-		case statusInProgress:
-			s = progressStyle
-		case statusDoneSuccess:
-			s = successStyle
-		case statusDoneFailed:
-			s = failedStyle
-		default:
-			s = cellStyle
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = lipgloss.Width(headerStyle.Render(h))
+	}
+
+	var est *durationEstimator
+	if !cmd.archived {
+		if est, err = loadDurationEstimator(db); err != nil {
+			return fmt.Errorf("failed to read job durations: %w", err)
+		}
+	}
+
+	var rows [][]string
+	var statuses []int
+	if cmd.collapseArrays {
+		rows, statuses = collapsedRows(jobs, est, cmd.columns)
+	} else {
+		for _, job := range jobs {
+			rows = append(rows, withColumns(JobToRow(job, est), []Job{job}, cmd.columns))
+			statuses = append(statuses, job.Status)
+		}
+	}
+
+	for i, row := range rows {
+		s := statusStyle(statuses[i])
+		for c, cell := range row {
+			if c == 1 {
+				widths[c] = max(widths[c], lipgloss.Width(s.Render(cell)))
+			} else {
+				widths[c] = max(widths[c], lipgloss.Width(cellStyle.Render(cell)))
+			}
 		}
-		statusW = max(statusW, lipgloss.Width(s.Render(row[1])))
-		cmdW = max(cmdW, lipgloss.Width(cellStyle.Render(row[2])))
 	}
 
 	// If the table would overflow the terminal, pre-truncate command strings
 	// with an ellipsis rather than letting lipgloss word-wrap or shrink.
-	const borderOverhead = 4 // left + right borders + 2 column separators
-	const cellPadding = 4    // PaddingLeft(2) + PaddingRight(2)
-	if termWidth > 0 && idW+statusW+cmdW+borderOverhead > termWidth {
-		maxCmdContent := termWidth - idW - statusW - borderOverhead - cellPadding
+	borderOverhead := len(headers) + 1 // left + right borders + column separators
+	const cellPadding = 4              // PaddingLeft(2) + PaddingRight(2)
+	tableW, otherW := borderOverhead, 0
+	for c, w := range widths {
+		tableW += w
+		if c != cmdCol {
+			otherW += w
+		}
+	}
+	if termWidth > 0 && tableW > termWidth {
+		maxCmdContent := termWidth - otherW - borderOverhead - cellPadding
 		if maxCmdContent > 1 {
 			for i, row := range rows {
-				runes := []rune(row[2])
+				runes := []rune(row[cmdCol])
 				if len(runes) > maxCmdContent {
-					rows[i][2] = string(runes[:maxCmdContent-1]) + "…"
+					rows[i][cmdCol] = string(runes[:maxCmdContent-1]) + "…"
 				}
 			}
 		}
@@ -251,27 +668,17 @@ func (cmd list) Run() error {
 
 	t := table.New().
 		Border(lipgloss.NormalBorder()).
-		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		BorderStyle(lipgloss.NewStyle().Foreground(theme.color("border"))).
 		StyleFunc(func(row, col int) lipgloss.Style {
-			if row < 0 || row >= len(jobs) {
+			if row < 0 || row >= len(rows) {
 				return headerStyle
 			}
 			if col != 1 {
 				return cellStyle
 			}
-			switch jobs[row].Status {
-			case statusPending:
-				return pendingStyle
-			case statusInProgress:
-				return progressStyle
-			case statusDoneSuccess:
-				return successStyle
-			case statusDoneFailed:
-				return failedStyle
-			}
-			return cellStyle
+			return statusStyle(statuses[row])
 		}).
-		Headers("ID", "STATUS", "COMMAND")
+		Headers(headers...)
 
 	for _, row := range rows {
 		t.Row(row...)
@@ -282,22 +689,187 @@ func (cmd list) Run() error {
 	return err
 }
 
+// collapsedRows renders jobs as table rows, with all jobs of an array
+// summarized in a single row. Also returns the status used to style each row.
+func collapsedRows(jobs []Job, est *durationEstimator, cols []listColumn) ([][]string, []int) {
+	arrays := map[int][]Job{}
+	for _, job := range jobs {
+		if job.ArrayID != 0 {
+			arrays[job.ArrayID] = append(arrays[job.ArrayID], job)
+		}
+	}
+
+	var rows [][]string
+	var statuses []int
+	for _, job := range jobs {
+		if job.ArrayID == 0 {
+			rows = append(rows, withColumns(JobToRow(job, est), []Job{job}, cols))
+			statuses = append(statuses, job.Status)
+			continue
+		}
+		members, ok := arrays[job.ArrayID]
+		if !ok {
+			// Already rendered with an earlier member.
+			continue
+		}
+		delete(arrays, job.ArrayID)
+		row, status := ArrayToRow(members)
+		rows = append(rows, withColumns(row, members, cols))
+		statuses = append(statuses, status)
+	}
+	return rows, statuses
+}
+
+// ArrayToRow summarizes the jobs of an array as a single table row. The
+// returned status is the most noteworthy status among the jobs.
+func ArrayToRow(jobs []Job) ([]string, int) {
+	counts := map[int]int{}
+	for _, job := range jobs {
+		counts[job.Status]++
+	}
+
+	var status int
+	switch {
+	case counts[statusDoneFailed] > 0 || counts[statusTimedOut] > 0 || counts[statusDeadLetter] > 0:
+		status = statusDoneFailed
+	case counts[statusInProgress] > 0:
+		status = statusInProgress
+	case counts[statusSuspended] > 0:
+		status = statusSuspended
+	case counts[statusPending] > 0:
+		status = statusPending
+	case counts[statusHeld] > 0:
+		status = statusHeld
+	default:
+		status = statusDoneSuccess
+	}
+
+	var parts []string
+	for _, s := range allStatuses {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], statusNames[s]))
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("%d [%d]", jobs[0].ArrayID, len(jobs)),
+		strings.Join(parts, ", "),
+		jobs[0].Command,
+	}, status
+}
+
+// listColumn is an optional column of `chime list`. Its value is computed
+// from the jobs shown in a row: one job, or all the jobs of a collapsed array.
+type listColumn struct {
+	header string
+	value  func(jobs []Job) string
+}
+
+var listColumnNames = []string{"exit", "cpu", "rss", "worker", "note", "user"}
+
+var listColumns = map[string]listColumn{
+	"exit": {"EXIT", func(jobs []Job) string {
+		if len(jobs) != 1 || jobs[0].ExitCode < 0 {
+			return "-"
+		}
+		return strconv.Itoa(jobs[0].ExitCode)
+	}},
+	// Arrays show the total CPU time of their jobs.
+	"cpu": {"CPU", func(jobs []Job) string {
+		var total time.Duration
+		known := false
+		for _, job := range jobs {
+			if d, ok := job.CPUTime(); ok {
+				total += d
+				known = true
+			}
+		}
+		if !known {
+			return "-"
+		}
+		return total.String()
+	}},
+	// Arrays show the largest peak RSS of their jobs.
+	"rss": {"MAX RSS", func(jobs []Job) string {
+		peak := int64(-1)
+		for _, job := range jobs {
+			peak = max(peak, job.MaxRSS)
+		}
+		return formatRSS(peak)
+	}},
+	// Arrays show their workers if they all ran on the same one.
+	"worker": {"WORKER", func(jobs []Job) string {
+		worker := jobs[0].Worker
+		for _, job := range jobs[1:] {
+			if job.Worker != worker {
+				return "(various)"
+			}
+		}
+		if worker == "" {
+			return "-"
+		}
+		return worker
+	}},
+	// Arrays show the note of their first job.
+	"note": {"NOTE", func(jobs []Job) string {
+		if jobs[0].Note == "" {
+			return "-"
+		}
+		return jobs[0].Note
+	}},
+	"user": {"USER", func(jobs []Job) string {
+		if jobs[0].SubmittedBy == "" {
+			return "-"
+		}
+		return jobs[0].SubmittedBy
+	}},
+}
+
+// withColumns inserts the values of the extra columns into a row, before
+// the command.
+func withColumns(row []string, jobs []Job, cols []listColumn) []string {
+	if len(cols) == 0 {
+		return row
+	}
+	out := append([]string(nil), row[:len(row)-1]...)
+	for _, col := range cols {
+		out = append(out, col.value(jobs))
+	}
+	return append(out, row[len(row)-1])
+}
+
 func JobRowStyles() {
 
 }
 
-func JobToRow(job Job) []string {
+// JobToRow renders a job as a table row. Running jobs include an estimate
+// of their remaining time if est has history for them; est may be nil.
+func JobToRow(job Job, est *durationEstimator) []string {
 	out := []string{
 		fmt.Sprintf("%d", job.ID),
 	}
 	switch job.Status {
 	case statusPending:
-		out = append(out, "Pending")
+		if job.Retries > 0 {
+			out = append(out, fmt.Sprintf("Pending (retry %d of %d)", job.Retries, job.MaxRetries))
+		} else {
+			out = append(out, "Pending")
+		}
 	case statusInProgress:
-		out = append(
-			out,
-			fmt.Sprintf("Running (%s)", time.Now().Sub(job.StartedAtTime())),
-		)
+		elapsed := time.Now().Sub(job.StartedAtTime())
+		var status string
+		if left, ok := est.remaining(job); ok && left >= 0 {
+			status = fmt.Sprintf("Running (%s, ~%s left)", elapsed, left.Round(time.Second))
+		} else if ok {
+			status = fmt.Sprintf("Running (%s, overdue)", elapsed)
+		} else {
+			status = fmt.Sprintf("Running (%s)", elapsed)
+		}
+		// Jobs that report their progress show it too; see progress.go.
+		if progress := formatProgress(job); progress != "" {
+			status += " " + progress
+		}
+		out = append(out, status)
 	case statusDoneSuccess:
 		out = append(
 			out,
@@ -305,6 +877,16 @@ func JobToRow(job Job) []string {
 		)
 	case statusDoneFailed:
 		out = append(out, "Failed")
+	case statusSuspended:
+		out = append(out, fmt.Sprintf("Suspended (%s)", time.Now().Sub(job.StartedAtTime())))
+	case statusHeld:
+		out = append(out, "Held")
+	case statusDeadLetter:
+		out = append(out, fmt.Sprintf("Dead (%d retries)", job.Retries))
+	case statusCancelled:
+		out = append(out, "Cancelled")
+	case statusTimedOut:
+		out = append(out, fmt.Sprintf("Timed out (%s)", job.timeout()))
 	}
 	out = append(out, job.Command)
 	return out
@@ -317,10 +899,31 @@ func (cmd remove) Run() error {
 	}
 	defer db.Close()
 
-	_, err = db.DeleteJob(int64(cmd.id))
+	if len(cmd.refs) == 0 && len(cmd.statuses) == 0 {
+		ref, err := pickJob(db, "remove")
+		if err != nil {
+			return err
+		}
+		cmd.refs = []string{ref}
+	}
+	filter := JobFilter{Statuses: cmd.statuses}
+	for _, ref := range cmd.refs {
+		if first, last, err := parseRange(ref); err == nil {
+			filter.IDRanges = append(filter.IDRanges, IDRange{First: int64(first), Last: int64(last)})
+			continue
+		}
+		job, err := resolveJob(db, ref)
+		if err != nil {
+			return err
+		}
+		filter.IDs = append(filter.IDs, int64(job.ID))
+	}
+
+	n, err := db.DeleteJobs(filter)
 	if err != nil {
 		return err
 	}
+	slog.Info("removed jobs", "count", n)
 	return nil
 }
 
@@ -331,15 +934,356 @@ func (cmd add) Run() error {
 	}
 	defer db.Close()
 
-	jobID, err := db.AddJob(cmd.commandToRun)
+	spec := JobSpec{Command: cmd.commandToRun}
+	switch {
+	case cmd.scriptPath != "":
+		// Snapshot the script now so later edits don't affect the queued job.
+		script, err := os.ReadFile(cmd.scriptPath)
+		if err != nil {
+			return fmt.Errorf("failed to read script: %w", err)
+		}
+		spec = JobSpec{Command: cmd.scriptPath, Script: string(script)}
+	case cmd.templateName != "":
+		tmpl, err := db.GetTemplate(cmd.templateName)
+		if err != nil {
+			return fmt.Errorf("failed to read template: %w", err)
+		}
+		if tmpl == nil {
+			return fmt.Errorf("no template named '%s'", cmd.templateName)
+		}
+		command, err := tmpl.Render(cmd.templateParams)
+		if err != nil {
+			return fmt.Errorf("failed to render template: %w", err)
+		}
+		spec = JobSpec{Command: command, Template: tmpl.Name}
+	case cmd.aliasName != "":
+		alias, err := db.GetAlias(cmd.aliasName)
+		if err != nil {
+			return fmt.Errorf("failed to read alias: %w", err)
+		}
+		if alias == nil {
+			return fmt.Errorf("no alias named '%s'; see chime alias list", cmd.aliasName)
+		}
+		spec = JobSpec{Command: alias.expand(cmd.aliasArgs)}
+	}
+	spec.Priority = cmd.priority
+	spec.Tags = CommaList(cmd.tags)
+	spec.EnvMode = cmd.envMode
+	spec.EnvAllow = CommaList(cmd.envAllow)
+	if cmd.locale != "" {
+		spec.Env = localeEnv(cmd.locale)
+	}
+	spec.Env = append(spec.Env, cmd.env...)
+	spec.Queue = cmd.queue
+	spec.Name = cmd.name
+	spec.Nice = cmd.nice
+	spec.IONice = cmd.ionice
+	spec.MemLimit = cmd.memLimit
+	spec.CPULimit = cmd.cpuLimit
+	spec.Image = cmd.image
+	spec.Host = cmd.host
+	// Only runs with the resources the job needs may take it.
+	requires := append(cmd.requires, cmd.resources.names()...)
+	spec.Requires = CommaList(slices.Compact(slices.Sorted(slices.Values(requires))))
+	spec.TimeWindow = cmd.timeWindow
+	spec.OnSuccess = cmd.onSuccess
+	spec.OnFailure = cmd.onFailure
+	spec.MaxRetries = cmd.maxRetries
+	spec.Timeout = cmd.timeout
+	spec.Lock = cmd.lock
+	spec.Slots = cmd.slots
+	spec.Resources = cmd.resources.list()
+	spec.Note = cmd.note
+	if cmd.parent != "" {
+		parent, err := resolveJob(db, cmd.parent)
+		if err != nil {
+			return fmt.Errorf("invalid --parent: %w", err)
+		}
+		spec.ParentID = int64(parent.ID)
+	}
+	if cmd.batchID != 0 {
+		batch, err := db.GetBatch(cmd.batchID)
+		if err != nil {
+			return fmt.Errorf("failed to read batch: %w", err)
+		}
+		if batch == nil {
+			return fmt.Errorf("no batch with ID %d", cmd.batchID)
+		}
+		spec.BatchID = batch.ID
+	}
+
+	if cmd.arrayRange != "" {
+		first, last, err := parseRange(cmd.arrayRange)
+		if err != nil {
+			return fmt.Errorf("invalid array range: %w", err)
+		}
+		ids, err := db.InsertArrayJobs(spec, first, last)
+		if err != nil {
+			return err
+		}
+		slog.Info("added array", "array", ids[0], "first_id", ids[0], "last_id", ids[len(ids)-1])
+		return nil
+	}
+
+	if cmd.unique {
+		jobID, existed, err := db.InsertUniqueJob(spec)
+		if err != nil {
+			return err
+		}
+		if existed {
+			slog.Info("identical job is already queued", "id", jobID)
+		} else {
+			slog.Info("added job", "id", jobID)
+		}
+		return nil
+	}
+
+	jobID, err := db.InsertJob(spec)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("added job #%d", jobID)
+	slog.Info("added job", "id", jobID)
 	return nil
 }
 
+func parseAddSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := add{globalArgs: globals}
+	fs := flag.NewFlagSet(addCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.scriptPath, "script", "", "path to a script to snapshot and run")
+	fs.StringVar(&cmd.templateName, "template", "", "name of a template to render; args are key=value params")
+	fs.StringVar(&cmd.aliasName, "alias", "", "name of an alias whose command to run, like @name; args are appended to it")
+	fs.StringVar(&cmd.arrayRange, "array", "", "add one job per index in a range like 1-100; the index is in $CHIME_ARRAY_INDEX")
+	fs.IntVar(&cmd.priority, "priority", 0, "jobs with higher priority are taken first")
+	fs.Var(&cmd.tags, "tag", "tag to label the job with; may be repeated")
+	fs.Int64Var(&cmd.batchID, "batch", 0, "ID of an existing batch to add the job to")
+	fs.StringVar(&cmd.envMode, "env-mode", "", "environment the job gets: inherit, minimal or allowlist (default: the runner's)")
+	fs.Var(&cmd.envAllow, "env-allow", "variable passed to the job in allowlist mode; may be repeated")
+	fs.Var(&cmd.env, "env", "KEY=VALUE variable to set for the job; may be repeated")
+	fs.StringVar(&cmd.locale, "locale", "", "locale to run the job under, e.g. C.UTF-8; sets LANG and LC_ALL")
+	fs.StringVar(&cmd.queue, "queue", defaultQueue, "queue to add the job to")
+	fs.BoolVar(&cmd.unique, "unique", false, "don't add the job if an identical one is already pending or running in the queue")
+	fs.StringVar(&cmd.name, "name", "", "name to refer to the job by; must be unique among pending and running jobs")
+	fs.IntVar(&cmd.nice, "nice", 0, "niceness to run the job with, from -20 (highest priority) to 19")
+	fs.StringVar(&cmd.ionice, "ionice", "", "I/O scheduling class to run the job with on Linux: idle, best-effort[:0-7] or realtime[:0-7]")
+	var memLimit string
+	fs.StringVar(&memLimit, "mem-limit", "", "memory the job may use on Linux, e.g. 512M or 2G; it's killed if it uses more")
+	fs.Float64Var(&cmd.cpuLimit, "cpu-limit", 0, "CPU cores the job may use on Linux, e.g. 1.5; it's throttled if it uses more")
+	fs.StringVar(&cmd.image, "image", "", "container image to run the job in, with docker or podman")
+	fs.StringVar(&cmd.host, "host", "", "remote host or host pool to run the job on over ssh")
+	fs.Var(&cmd.requires, "requires", "label a worker must have to take the job, e.g. gpu; may be repeated")
+	fs.StringVar(&cmd.lock, "lock", "", "name of a lock the job holds while it runs; jobs with the same lock never run at once")
+	fs.StringVar(&cmd.note, "note", "", "free-text note about the job, shown by show and matched by list --grep")
+	fs.IntVar(&cmd.gpus, "gpus", 0, "number of the runner's GPUs the job needs; their indices are in $CUDA_VISIBLE_DEVICES")
+	fs.Var(&cmd.resources, "resource", "name=count of the runner's devices of a resource the job needs, e.g. fpgas=1; may be repeated")
+	fs.IntVar(&cmd.slots, "slots", 1, "number of a run's worker slots the job occupies, for jobs that need more than one worker's share of the machine")
+	fs.IntVar(&cmd.maxRetries, "retries", 0, "times to requeue the job if it fails, before moving it to the dead-letter queue")
+	fs.DurationVar(&cmd.timeout, "timeout", 0, "stop the job if it runs longer than this, e.g. 2h; it then finishes as timed-out")
+	fs.StringVar(&cmd.onSuccess, "on-success", "", "command to run as a follow-up job if the job succeeds")
+	fs.StringVar(&cmd.onFailure, "on-failure", "", "command to run as a follow-up job if the job fails")
+	fs.StringVar(&cmd.parent, "parent", "", "ID or name of the job adding this one, usually $CHIME_JOB_ID, to record it as that job's child")
+	fs.StringVar(&cmd.timeWindow, "window", "", "local time of day the job may start in, e.g. 22:00-06:00; it stays pending outside it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	args = fs.Args()
+	if cmd.timeWindow != "" {
+		window, err := parseTimeWindow(cmd.timeWindow)
+		if err != nil {
+			return nil, err
+		}
+		cmd.timeWindow = window
+	}
+	if memLimit != "" {
+		limit, err := parseSize(memLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --mem-limit: %w", err)
+		}
+		cmd.memLimit = limit
+	}
+	if cmd.maxRetries < 0 {
+		return nil, fmt.Errorf("--retries must be positive")
+	}
+	if cmd.timeout < 0 {
+		return nil, fmt.Errorf("--timeout must be positive")
+	}
+	if cmd.cpuLimit < 0 {
+		return nil, fmt.Errorf("--cpu-limit must be positive")
+	}
+	if cmd.image != "" {
+		if err := validateImage(cmd.image); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.host != "" {
+		if err := validateHost(cmd.host); err != nil {
+			return nil, err
+		}
+		if cmd.image != "" {
+			return nil, fmt.Errorf("--host can't be combined with --image")
+		}
+		if cmd.memLimit > 0 || cmd.cpuLimit > 0 || cmd.nice != 0 || cmd.ionice != "" {
+			return nil, fmt.Errorf("--host can't be combined with resource limits or scheduling priorities")
+		}
+	}
+
+	// A command like @name runs an alias.
+	if cmd.aliasName == "" && cmd.scriptPath == "" && cmd.templateName == "" &&
+		len(args) > 0 && strings.HasPrefix(args[0], aliasPrefix) && len(args[0]) > len(aliasPrefix) {
+		cmd.aliasName, args = strings.TrimPrefix(args[0], aliasPrefix), args[1:]
+	}
+	switch {
+	case cmd.aliasName != "" && (cmd.scriptPath != "" || cmd.templateName != ""):
+		return nil, fmt.Errorf("--alias can't be combined with --script or --template")
+	case cmd.scriptPath != "" && cmd.templateName != "":
+		return nil, fmt.Errorf("--script can't be combined with --template")
+	case cmd.scriptPath != "":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("command to run can't be combined with --script")
+		}
+	case cmd.templateName != "":
+		params, err := parseTemplateParams(args)
+		if err != nil {
+			return nil, err
+		}
+		cmd.templateParams = params
+	case cmd.aliasName != "":
+		cmd.aliasArgs = args
+	default:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: command to run")
+		}
+		cmd.commandToRun = args[0]
+	}
+	if cmd.arrayRange != "" {
+		if _, _, err := parseRange(cmd.arrayRange); err != nil {
+			return nil, fmt.Errorf("invalid array range: %w", err)
+		}
+	}
+	for _, tag := range cmd.tags {
+		if err := validateName("tag", tag); err != nil {
+			return nil, err
+		}
+	}
+	for _, label := range cmd.requires {
+		if err := validateName("label", label); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.lock != "" {
+		if err := validateName("lock", cmd.lock); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.slots < 1 {
+		return nil, fmt.Errorf("--slots must be at least 1")
+	}
+	if cmd.gpus < 0 {
+		return nil, fmt.Errorf("--gpus must be positive")
+	}
+	if cmd.gpus > 0 {
+		if cmd.resources == nil {
+			cmd.resources = resourceCounts{}
+		}
+		cmd.resources[resourceGPUs] = cmd.gpus
+	}
+	if err := validateName("queue", cmd.queue); err != nil {
+		return nil, err
+	}
+	if err := validateNice(cmd.nice); err != nil {
+		return nil, err
+	}
+	if cmd.ionice != "" {
+		if _, _, err := parseIONice(cmd.ionice); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.unique && cmd.arrayRange != "" {
+		return nil, fmt.Errorf("--unique can't be combined with --array")
+	}
+	if cmd.name != "" {
+		if err := validateJobName(cmd.name); err != nil {
+			return nil, err
+		}
+		if cmd.arrayRange != "" {
+			return nil, fmt.Errorf("--name can't be combined with --array")
+		}
+	}
+	if err := validateEnvMode(cmd.envMode); err != nil {
+		return nil, err
+	}
+	for _, kv := range cmd.env {
+		if err := validateEnvVar(kv); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// statusList is a flag naming job statuses; it may be given multiple times.
+type statusList []int
+
+func (l *statusList) String() string {
+	var names []string
+	for _, s := range *l {
+		names = append(names, statusNames[s])
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *statusList) Set(value string) error {
+	if value == "done" {
+		*l = append(*l, terminalStatuses...)
+		return nil
+	}
+	status, err := parseStatus(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, status)
+	return nil
+}
+
+// validateName checks a user-supplied name such as a tag or queue name.
+func validateName(kind, name string) error {
+	if name == "" || strings.ContainsAny(name, ", \t\n") {
+		return fmt.Errorf("invalid %s '%s': must be non-empty and contain no commas or whitespace", kind, name)
+	}
+	return nil
+}
+
+// parseRange parses an inclusive range of integers like "1-100".
+func parseRange(s string) (int, int, error) {
+	firstStr, lastStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range like 1-100, got '%s'", s)
+	}
+	first, err := strconv.Atoi(firstStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range start '%s'", firstStr)
+	}
+	last, err := strconv.Atoi(lastStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range end '%s'", lastStr)
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("range end %d is before start %d", last, first)
+	}
+	return first, last, nil
+}
+
 func parseSubcommand(globals globalArgs, args []string) (subcommand, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("no args specified")
@@ -348,22 +1292,151 @@ func parseSubcommand(globals globalArgs, args []string) (subcommand, error) {
 
 	switch cmd {
 	case runCommandName:
+		var cfg execConfig
+		var follow bool
+		var idleTimeout time.Duration
+		var notify notifiers
+		var metricsAddr string
+		var reportPath string
+		var rate rateLimit
+		var load loadGate
+		var minFreeDisk string
+		var preempt string
+		var recoverMode string
+		var workers string
+		var resources resourceCounts
+		var dryRun bool
+		fs := flag.NewFlagSet(runCommandName, flag.ContinueOnError)
+		addExecFlags(fs, &cfg)
+		addClaimFlags(fs, &cfg)
+		addOutputFlags(fs, &cfg.Output)
+		fs.BoolVar(&dryRun, "dry-run", false, "print the jobs that would be claimed, in order, without running them")
+		fs.BoolVar(&follow, "follow", false, "keep running and wait for new jobs when the queue is empty, until interrupted")
+		fs.DurationVar(&idleTimeout, "idle-timeout", 0, "with --follow, exit once there's been nothing to run for this long, e.g. 30m")
+		fs.Var(&notify, "notify", "with --follow, when the queue drains ring the bell, show a desktop notification or post to a webhook: bell, desktop or a URL; may be repeated")
+		fs.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics at /metrics on this address, e.g. :9090")
+		fs.StringVar(&reportPath, "report", "", "append a JSON line to this file as each job starts and finishes, e.g. for CI to read")
+		fs.Var(&rate, "rate", "start at most this many jobs per window, across all workers, e.g. 10/1m")
+		fs.Float64Var(&load.maxLoad, "max-load", 0, "don't take jobs while the 1 minute load average is above this, on Linux")
+		fs.StringVar(&minFreeDisk, "min-free-disk", "", "don't take jobs while less than this is free on the working directory's disk, e.g. 10G")
+		fs.Var(&resources, "resource", "name=count of devices the run's jobs can be given, e.g. gpus=2; may be repeated")
+		fs.StringVar(&workers, "workers", "", "number of workers, or auto for one per CPU (default: 1)")
+		fs.StringVar(&recoverMode, "recover", recoverIgnore, "what to do with jobs left running by runners that stopped, as the run starts: requeue, fail or ignore")
+		fs.StringVar(&preempt, "preempt", "", "when all workers are busy, make way for a higher-priority job by suspending or requeueing the lowest-priority running job: suspend or requeue")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		switch preempt {
+		case "", preemptSuspend, preemptRequeue:
+		default:
+			return nil, fmt.Errorf("invalid --preempt: '%s' (expected suspend or requeue)", preempt)
+		}
+		switch recoverMode {
+		case recoverRequeue, recoverFail, recoverIgnore:
+		default:
+			return nil, fmt.Errorf("invalid --recover: '%s' (expected requeue, fail or ignore)", recoverMode)
+		}
+		if preempt == preemptSuspend && runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("--preempt suspend isn't supported on Windows, since jobs can't be suspended there; use requeue")
+		}
+		if preempt != "" && cfg.Executor == executorK8s {
+			return nil, fmt.Errorf("--preempt can't be combined with the k8s executor")
+		}
+		if load.maxLoad < 0 {
+			return nil, fmt.Errorf("--max-load must be positive")
+		}
+		if idleTimeout != 0 && !follow {
+			return nil, fmt.Errorf("--idle-timeout only applies with --follow")
+		}
+		if idleTimeout < 0 {
+			return nil, fmt.Errorf("--idle-timeout must be positive")
+		}
+		if len(notify) > 0 && !follow {
+			return nil, fmt.Errorf("--notify only applies with --follow")
+		}
+		if minFreeDisk != "" {
+			size, err := parseSize(minFreeDisk)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --min-free-disk: %w", err)
+			}
+			load.minFreeDisk = size
+		}
+		load.dir = "."
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+
 		numWorkers := 1
 		var err error
 		if len(args) > 0 {
-			if numWorkers, err = strconv.Atoi(args[0]); err != nil {
+			if workers != "" {
+				return nil, fmt.Errorf("number of workers can't be given both as an argument and with --workers")
+			}
+			if args[0] == "auto" {
+				numWorkers = runtime.NumCPU()
+			} else if numWorkers, err = strconv.Atoi(args[0]); err != nil {
 				return nil, fmt.Errorf("invalid value for number of workers")
 			}
+		} else if workers != "" {
+			if numWorkers, err = parseWorkerCount(workers); err != nil {
+				return nil, err
+			}
 		}
 		if numWorkers < 1 {
 			numWorkers = 1
 		}
 
+		cfg.dbPath = globals.dbPath
 		return run{
-			globalArgs: globals,
-			numWorkers: numWorkers,
+			globalArgs:  globals,
+			execConfig:  cfg,
+			numWorkers:  numWorkers,
+			follow:      follow,
+			idleTimeout: idleTimeout,
+			notify:      notify,
+			metricsAddr: metricsAddr,
+			reportPath:  reportPath,
+			rate:        rate,
+			load:        load,
+			preempt:     preempt,
+			recoverMode: recoverMode,
+			resources:   resources,
+			dryRun:      dryRun,
 		}, nil
 	case takeCommandName:
+		var cfg execConfig
+		var dryRun bool
+		fs := flag.NewFlagSet(takeCommandName, flag.ContinueOnError)
+		addExecFlags(fs, &cfg)
+		addClaimFlags(fs, &cfg)
+		addOutputFlags(fs, &cfg.Output)
+		fs.BoolVar(&dryRun, "dry-run", false, "print the job that would be claimed without running it")
+		var loop, bell bool
+		fs.BoolVar(&loop, "loop", false, "keep taking and running jobs, one at a time, until there are none left")
+		fs.BoolVar(&bell, "bell", false, "ring the terminal bell after each job")
+		fs.BoolVar(&cfg.Interactive, "interactive", false, "attach the job to this terminal, so it can read input and run TUIs")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if cfg.Interactive {
+			switch {
+			case runtime.GOOS == "windows":
+				return nil, fmt.Errorf("--interactive isn't supported on Windows")
+			case !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())):
+				return nil, fmt.Errorf("--interactive needs a terminal")
+			case cfg.Executor == executorK8s:
+				return nil, fmt.Errorf("--interactive can't be combined with the k8s executor")
+			}
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if loop && dryRun {
+			return nil, fmt.Errorf("--loop can't be combined with --dry-run")
+		}
+		args = fs.Args()
+
 		var jobID int
 		var err error
 		if len(args) == 1 {
@@ -371,58 +1444,513 @@ func parseSubcommand(globals globalArgs, args []string) (subcommand, error) {
 				return nil, fmt.Errorf("param required: command to run")
 			}
 		}
-		return take{globalArgs: globals, jobID: jobID}, nil
+		cfg.dbPath = globals.dbPath
+		return take{globalArgs: globals, execConfig: cfg, jobID: jobID, dryRun: dryRun, loop: loop, bell: bell}, nil
 	case listCommandName:
-		return list{globalArgs: globals}, nil
-	case addCommandName:
-		if len(args) != 1 {
-			return nil, fmt.Errorf("param required: command to run")
+		cmd := list{globalArgs: globals}
+		fs := flag.NewFlagSet(listCommandName, flag.ContinueOnError)
+		fs.BoolVar(&cmd.collapseArrays, "collapse", false, "summarize each array job in a single row")
+		fs.BoolVar(&cmd.asJSON, "json", false, "print jobs as JSON")
+		fs.BoolVar(&cmd.archived, "archived", false, "list archived jobs instead")
+		var columns, grep string
+		var mine bool
+		var statuses statusList
+		fs.Var(&statuses, "status", "only list jobs with this status, or done for any finished one; may be repeated")
+		fs.StringVar(&grep, "grep", "", "only list jobs whose command, name or note matches this regular expression")
+		fs.BoolVar(&mine, "mine", false, "only list jobs you added")
+		fs.StringVar(&cmd.user, "user", "", "only list jobs added by this user")
+		fs.StringVar(&columns, "columns", "", "extra columns to show, comma separated: "+strings.Join(listColumnNames, ", "))
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if columns != "" {
+			for _, name := range strings.Split(columns, ",") {
+				col, ok := listColumns[strings.TrimSpace(name)]
+				if !ok {
+					return nil, fmt.Errorf("unknown column: '%s' (must be one of %s)", name, strings.Join(listColumnNames, ", "))
+				}
+				cmd.columns = append(cmd.columns, col)
+			}
+		}
+		if grep != "" {
+			re, err := regexp.Compile(grep)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --grep: %w", err)
+			}
+			cmd.grep = re
+		}
+		if mine {
+			if cmd.user != "" {
+				return nil, fmt.Errorf("--mine and --user can't be combined")
+			}
+			cmd.user = osUserName()
 		}
-		return add{globalArgs: globals, commandToRun: args[0]}, nil
+		cmd.statuses = statuses
+		return cmd, nil
+	case addCommandName:
+		return parseAddSubcommand(globals, args)
 	case removeCommandName:
-		if len(args) != 1 {
-			return nil, fmt.Errorf("param required: job ID to remove")
+		var statuses statusList
+		fs := flag.NewFlagSet(removeCommandName, flag.ContinueOnError)
+		fs.Var(&statuses, "status", "remove jobs with this status; may be repeated")
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
-		jobID, err := strconv.Atoi(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid job ID: '%s'", args[0])
+		if fs.NArg() == 0 && len(statuses) == 0 && !canPickJob() {
+			return nil, fmt.Errorf("param required: job IDs, names or ranges to remove, or --status")
 		}
 		return remove{
 			globalArgs: globals,
-			id:         jobID,
+			refs:       fs.Args(),
+			statuses:   statuses,
 		}, nil
+	case runsCommandName:
+		return parseRunsSubcommand(globals, args)
+	case metricsCommandName:
+		return parseMetricsSubcommand(globals, args)
+	case templateCommandName:
+		return parseTemplateSubcommand(globals, args)
+	case priorityCommandName:
+		return parsePrioritySubcommand(globals, args)
+	case batchCommandName:
+		return parseBatchSubcommand(globals, args)
+	case scaleCommandName:
+		return parseScaleSubcommand(globals, args)
+	case showCommandName:
+		return parseShowSubcommand(globals, args)
+	case requeueCommandName:
+		return parseRequeueSubcommand(globals, args)
+	case purgeCommandName:
+		return parsePurgeSubcommand(globals, args)
+	case archiveCommandName:
+		return parseArchiveSubcommand(globals, args)
+	case migrateCommandName:
+		return parseMigrateSubcommand(globals, args)
+	case backupCommandName:
+		return parseBackupSubcommand(globals, args)
+	case restoreCommandName:
+		return parseRestoreSubcommand(globals, args)
+	case vacuumCommandName:
+		return parseVacuumSubcommand(globals, args)
+	case doctorCommandName:
+		return parseDoctorSubcommand(globals, args)
+	case historyCommandName:
+		return parseHistorySubcommand(globals, args)
+	case eventsCommandName:
+		return parseEventsSubcommand(globals, args)
+	case statsCommandName:
+		return parseStatsSubcommand(globals, args)
+	case timelineCommandName:
+		return parseTimelineSubcommand(globals, args)
+	case reportCommandName:
+		return parseReportSubcommand(globals, args)
+	case topCommandName:
+		return parseTopSubcommand(globals, args)
+	case hostsCommandName:
+		return parseHostsSubcommand(globals, args)
+	case serveCommandName:
+		return parseServeSubcommand(globals, args)
+	case workerCommandName:
+		return parseWorkerSubcommand(globals, args)
+	case pauseCommandName:
+		return parsePauseSubcommand(globals, args)
+	case resumeCommandName:
+		return parseResumeSubcommand(globals, args)
+	case suspendCommandName:
+		return parseSuspendSubcommand(globals, args)
+	case holdCommandName:
+		return parseHoldSubcommand(globals, args)
+	case releaseCommandName:
+		return parseReleaseSubcommand(globals, args)
+	case dlqCommandName:
+		return parseDLQSubcommand(globals, args)
+	case limitCommandName:
+		return parseLimitSubcommand(globals, args)
+	case weightCommandName:
+		return parseWeightSubcommand(globals, args)
+	case agingCommandName:
+		return parseAgingSubcommand(globals, args)
+	case moveCommandName:
+		return parseMoveSubcommand(globals, args)
+	case workersCommandName:
+		return parseWorkersSubcommand(globals, args)
+	case ctlCommandName:
+		return parseCtlSubcommand(globals, args)
+	case editCommandName:
+		return parseEditSubcommand(globals, args)
+	case annotateCommandName:
+		return parseAnnotateSubcommand(globals, args)
+	case watchCommandName:
+		return parseWatchSubcommand(globals, args)
+	case xargsCommandName:
+		return parseXargsSubcommand(globals, args)
+	case installServiceCommandName:
+		return parseInstallServiceSubcommand(globals, args)
+	case uninstallServiceCommandName:
+		return parseUninstallServiceSubcommand(globals, args)
+	case searchCommandName:
+		return parseSearchSubcommand(globals, args)
+	case artifactsCommandName:
+		return parseArtifactsSubcommand(globals, args)
+	case exportCommandName:
+		return parseExportSubcommand(globals, args)
+	case importCommandName:
+		return parseImportSubcommand(globals, args)
+	case aliasCommandName:
+		return parseAliasSubcommand(globals, args)
+	case cancelCommandName:
+		return parseCancelSubcommand(globals, args)
+	case waitCommandName:
+		return parseWaitSubcommand(globals, args)
+	case cloneCommandName:
+		return parseCloneSubcommand(globals, args)
+	case psCommandName:
+		return parsePsSubcommand(globals, args)
 	}
 	return nil, fmt.Errorf("unknown command: '%s'", cmd)
 }
 
-func execJob(db *DB, nextJob *Job) error {
-	cmd := exec.Command("sh", "-c", nextJob.Command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// jobResult describes the outcome of a single execution of a job.
+type jobResult struct {
+	Job        *Job
+	Err        error // error returned by the command, nil if it succeeded
+	ExitCode   int   // -1 if the process didn't exit normally
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// The job was killed to make way for a more urgent one, and put back in
+	// the queue; see preempt.go.
+	Preempted bool
+	// The job was killed for running longer than its timeout.
+	TimedOut bool
+}
+
+func (r jobResult) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
 
-	runJobErr := func() error {
-		if err := cmd.Start(); err != nil {
+// status returns the status the job finished with.
+func (r jobResult) status() int {
+	switch {
+	case r.TimedOut:
+		return statusTimedOut
+	case r.Err != nil:
+		return statusDoneFailed
+	}
+	return statusDoneSuccess
+}
+
+// jobCommand builds the command that executes the job. The returned cleanup
+// func must be called once the command has finished.
+func jobCommand(job *Job) (*exec.Cmd, func(), error) {
+	if job.Script == "" {
+		return shellCommand(job.Command), func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", fmt.Sprintf("chime-job-%d-*", job.ID)+scriptFileSuffix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create script file: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = f.WriteString(job.Script)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o700)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	return scriptCommand(f.Name(), job.Script), cleanup, nil
+}
+
+// execConfig holds the runner's settings for executing jobs.
+type execConfig struct {
+	// Default environment mode and allowlist for jobs that don't set their own.
+	EnvMode  string
+	EnvAllow []string
+	// Where jobs run: executorLocal or executorK8s.
+	Executor string
+	// Kubernetes namespace jobs are created in, and the image of jobs that
+	// don't have their own.
+	Namespace string
+	K8sImage  string
+	// Spreads jobs over the hosts of their host pools.
+	hosts *hostPicker
+	// Name identifying the runner in jobs and events; see runnerName.
+	Name string
+	// Capabilities of the runner's workers, such as gpu; only jobs whose
+	// requirements are all among them are taken.
+	Labels []string
+	// Restrict the jobs local runners take to a queue, jobs with all of the
+	// tags, or jobs whose commands match the pattern; see claimFilter.
+	Queue string
+	Tags  []string
+	Match string
+	// Where jobs' output goes; the runner's own stdout and stderr if nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Shell commands run before and after each job; see hooks.go.
+	PreHook  string
+	PostHook string
+	// Attach local jobs to the runner's terminal; see startInteractive.
+	Interactive bool
+	// Where local runners save jobs' output; see output.go.
+	Output outputConfig
+	// Artifacts directory of the job being run, if it has one; see
+	// artifacts.go.
+	artifacts string
+	// File the job being run reports its progress in; see progress.go.
+	progressFile string
+	// DB local jobs are given, so they can add jobs of their own to it; see
+	// lineage.go.
+	dbPath string
+}
+
+// runnerName returns the runner's configured name, or by default its user,
+// host and pid, like "alice@build1[4242]". Its workers are named after it.
+func (cfg execConfig) runnerName() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return defaultActor()
+}
+
+// claimFilter returns the filter of the jobs the runner takes.
+func (cfg execConfig) claimFilter() claimFilter {
+	return claimFilter{Labels: slices.Clone(cfg.Labels), Queue: cfg.Queue, Tags: cfg.Tags, Match: cfg.Match}
+}
+
+// addClaimFlags registers the flags of commands that take jobs from the DB
+// that restrict which they take.
+func addClaimFlags(fs *flag.FlagSet, cfg *execConfig) {
+	fs.StringVar(&cfg.Queue, "queue", "", "only take jobs in this queue")
+	fs.Var((*stringList)(&cfg.Tags), "tag", "only take jobs with this tag; may be repeated to require several")
+	fs.StringVar(&cfg.Match, "match", "", "only take jobs whose commands match this regular expression")
+}
+
+// runsLocally reports whether the job runs on this machine, as a process or
+// in a container, rather than on a host or a cluster.
+func (cfg execConfig) runsLocally(job *Job) bool {
+	return job.Host == "" && cfg.Executor != executorK8s
+}
+
+// jobMounts returns the files and directories chime gives the job being run,
+// which containers need mounted.
+func (cfg execConfig) jobMounts() []string {
+	var mounts []string
+	for _, path := range []string{cfg.artifacts, cfg.progressFile} {
+		if path != "" {
+			mounts = append(mounts, path)
+		}
+	}
+	return mounts
+}
+
+func (cfg execConfig) stdout() io.Writer {
+	if cfg.Stdout == nil {
+		return os.Stdout
+	}
+	return cfg.Stdout
+}
+
+func (cfg execConfig) stderr() io.Writer {
+	if cfg.Stderr == nil {
+		return os.Stderr
+	}
+	return cfg.Stderr
+}
+
+// Executors run jobs.
+const (
+	// Jobs run as processes of the runner.
+	executorLocal = "local"
+	// Jobs run as Kubernetes Jobs, created with kubectl.
+	executorK8s = "k8s"
+)
+
+// addExecFlags registers the flags shared by commands that execute jobs.
+func addExecFlags(fs *flag.FlagSet, cfg *execConfig) {
+	fs.StringVar(&cfg.EnvMode, "env-mode", envModeInherit, "environment jobs get by default: inherit, minimal or allowlist")
+	fs.Var((*stringList)(&cfg.EnvAllow), "env-allow", "variable passed to jobs in allowlist mode; may be repeated")
+	fs.StringVar(&cfg.Executor, "executor", executorLocal, "where jobs run: local, or k8s to run each as a Kubernetes Job")
+	fs.StringVar(&cfg.Namespace, "namespace", "default", "Kubernetes namespace to run jobs in with --executor k8s")
+	fs.StringVar(&cfg.K8sImage, "k8s-image", "", "image of jobs without one of their own with --executor k8s")
+	fs.Var((*stringList)(&cfg.Labels), "label", "capability of this runner's workers, e.g. gpu, required by some jobs; may be repeated")
+	fs.StringVar(&cfg.PreHook, "pre-hook", "", "shell command to run before each job; the job fails without running if it fails")
+	fs.StringVar(&cfg.PostHook, "post-hook", "", "shell command to run after each job, with its result in CHIME_JOB_STATUS and CHIME_JOB_EXIT_CODE")
+	fs.StringVar(&cfg.Name, "worker-name", "", "name to record as the runner of jobs, followed by the worker's index (default: user@host[pid])")
+	cfg.hosts = newHostPicker()
+}
+
+func (cfg execConfig) validate() error {
+	if err := validateEnvMode(cfg.EnvMode); err != nil {
+		return err
+	}
+	if err := cfg.Output.validate(); err != nil {
+		return err
+	}
+	if cfg.Name != "" {
+		if err := validateName("worker name", cfg.Name); err != nil {
 			return err
 		}
-		if err := db.SetJobPID(int64(nextJob.ID), int64(cmd.Process.Pid)); err != nil {
-			log.Printf("failed to set job pid: %s", err)
+	}
+	for _, label := range cfg.Labels {
+		if err := validateName("label", label); err != nil {
+			return err
 		}
-
-		if err := cmd.Wait(); err != nil {
+	}
+	if cfg.Queue != "" {
+		if err := validateName("queue", cfg.Queue); err != nil {
+			return err
+		}
+	}
+	for _, tag := range cfg.Tags {
+		if err := validateName("tag", tag); err != nil {
 			return err
 		}
+	}
+	if cfg.Match != "" {
+		if _, err := regexp.Compile(cfg.Match); err != nil {
+			return fmt.Errorf("invalid --match: %w", err)
+		}
+	}
+	switch cfg.Executor {
+	case executorLocal, executorK8s:
 		return nil
-	}()
+	}
+	return fmt.Errorf("invalid executor '%s': must be %s or %s", cfg.Executor, executorLocal, executorK8s)
+}
 
-	if runJobErr != nil {
+// jobStore records the progress of the jobs execJob runs: the DB, or for a
+// remote worker, the server it claimed them from.
+type jobStore interface {
+	SetJobStatus(jobID int64, status int64) error
+	SetJobPID(jobID int64, pid int64) error
+	SetRemoteJobPID(jobID int64, pid int64, host string) error
+	RecordJobStarted(jobID int64, where string) error
+	FinishJob(jobID int64, status int64, exitCode int, usage jobUsage, failure string) error
+	GetHostPool(name string) (*HostPool, error)
+}
+
+// execJobCommand runs a job, on its host, where the runner's executor runs
+// it, or as a local process, and records its result; see execJob.
+func execJobCommand(db jobStore, cfg execConfig, nextJob *Job) (*jobResult, error) {
+	// A job's own host takes precedence over the runner's executor.
+	if nextJob.Host != "" {
+		return execRemoteJob(db, cfg, nextJob)
+	}
+	if cfg.Executor == executorK8s {
+		return execK8sJob(db, cfg, nextJob)
+	}
+
+	cmd, cleanup, err := jobCommand(nextJob)
+	if err != nil {
 		if err := db.SetJobStatus(int64(nextJob.ID), int64(statusDoneFailed)); err != nil {
-			return fmt.Errorf("failed to set job status to failed (%s) for job error: %s", err, runJobErr)
+			slog.Error("failed to set job status to failed", "id", nextJob.ID, "err", err)
+		}
+		return nil, err
+	}
+	defer cleanup()
+	cmd.Env = jobEnv(nextJob, cfg)
+	// Its own process group lets the whole job be signalled; see
+	// process_unix.go and process_windows.go.
+	setJobProcAttr(cmd)
+
+	// A job whose container or limits can't be set up fails rather than
+	// running without them.
+	var cgroup *jobCgroup
+	var setupErr error
+	if nextJob.Image != "" {
+		// The container runtime enforces the limits.
+		if cmd, err = containerCommand(cmd, nextJob, cmd.Env, cfg.jobMounts()); err != nil {
+			setupErr = fmt.Errorf("failed to set up container: %w", err)
+		}
+	} else if nextJob.hasLimits() {
+		if cgroup, err = startInCgroup(cmd, nextJob); err != nil {
+			setupErr = fmt.Errorf("failed to set up resource limits: %w", err)
+		} else {
+			defer cgroup.remove()
+		}
+	}
+	if setupErr != nil {
+		now := time.Now()
+		result := &jobResult{Job: nextJob, Err: setupErr, ExitCode: -1, StartedAt: now, FinishedAt: now}
+		if err := db.FinishJob(int64(nextJob.ID), int64(statusDoneFailed), -1, unknownUsage, setupErr.Error()); err != nil {
+			return result, fmt.Errorf("failed to set job status to failed (%s) for job error: %s", err, setupErr)
+		}
+		return result, nil
+	}
+	cmd.Stdout = cfg.stdout()
+	cmd.Stderr = cfg.stderr()
+
+	result := &jobResult{Job: nextJob, StartedAt: time.Now()}
+	timedOut := false
+	runJobErr := func() error {
+		if cfg.Interactive {
+			finish, err := startInteractive(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+		} else if err := cmd.Start(); err != nil {
+			return err
+		}
+		untrack, err := trackJobProcess(cmd.Process.Pid)
+		if err != nil {
+			slog.Warn("failed to track job's processes", "id", nextJob.ID, "err", err)
+		}
+		defer untrack()
+		runningJobs.add(cmd.Process.Pid)
+		defer runningJobs.remove(cmd.Process.Pid)
+		if err := applySchedPriority(cmd.Process.Pid, nextJob); err != nil {
+			slog.Warn("failed to set job scheduling priority", "id", nextJob.ID, "err", err)
+		}
+		if err := db.SetJobPID(int64(nextJob.ID), int64(cmd.Process.Pid)); err != nil {
+			slog.Error("failed to set job pid", "id", nextJob.ID, "err", err)
+		}
+
+		timer := startJobTimer(nextJob, cmd.Process.Pid)
+		err = cmd.Wait()
+		timedOut = timer.stop()
+		return err
+	}()
+	result.FinishedAt = time.Now()
+	result.Err = runJobErr
+	result.ExitCode = -1
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	usage := processUsage(cmd.ProcessState)
+
+	if preemptedJobs.take(nextJob.ID) {
+		// It's already back in the queue.
+		result.Preempted = true
+		return result, nil
+	}
+	if timedOut {
+		failure := fmt.Sprintf("timed out after %s", nextJob.timeout())
+		result.TimedOut = true
+		result.Err = errors.New(failure)
+		if err := db.FinishJob(int64(nextJob.ID), int64(statusTimedOut), result.ExitCode, usage, failure); err != nil {
+			return result, fmt.Errorf("failed to set job status to timed out: %w", err)
+		}
+	} else if runJobErr != nil {
+		failure := runJobErr.Error()
+		if cgroup != nil && cgroup.oomKilled() {
+			failure = oomFailure(nextJob.MemLimit)
+			result.Err = errors.New(failure)
+		}
+		if err := db.FinishJob(int64(nextJob.ID), int64(statusDoneFailed), result.ExitCode, usage, failure); err != nil {
+			return result, fmt.Errorf("failed to set job status to failed (%s) for job error: %s", err, runJobErr)
 		}
 	} else {
-		if err := db.SetJobStatus(int64(nextJob.ID), int64(statusDoneSuccess)); err != nil {
-			return fmt.Errorf("failed to set job status to success for job error: %s", runJobErr)
+		if err := db.FinishJob(int64(nextJob.ID), int64(statusDoneSuccess), result.ExitCode, usage, ""); err != nil {
+			return result, fmt.Errorf("failed to set job status to success: %w", err)
 		}
 	}
 
-	return nil
+	return result, nil
 }
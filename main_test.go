@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last int
+		wantErr     bool
+	}{
+		{in: "1-100", first: 1, last: 100},
+		{in: "5-5", first: 5, last: 5},
+		{in: "0-3", first: 0, last: 3},
+		{in: "10", wantErr: true},
+		{in: "a-3", wantErr: true},
+		{in: "1-b", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "9-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		first, last, err := parseRange(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRange(%q) = %d, %d; want an error", tt.in, first, last)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRange(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if first != tt.first || last != tt.last {
+			t.Errorf("parseRange(%q) = %d, %d; want %d, %d", tt.in, first, last, tt.first, tt.last)
+		}
+	}
+}
+
+func TestParseAddRejectsOptionImage(t *testing.T) {
+	if _, err := parseSubcommand(globalArgs{}, []string{"add", "--image", "--privileged", "true"}); err == nil {
+		t.Error("parsing an image starting with '-' succeeded; want an error")
+	}
+	if _, err := parseSubcommand(globalArgs{}, []string{"add", "--image", "alpine:3", "true"}); err != nil {
+		t.Errorf("parsing a plain image failed: %v", err)
+	}
+}
+
+func TestParseRejectsOptionHosts(t *testing.T) {
+	for _, args := range [][]string{
+		{"add", "--host", "-oProxyCommand=touch pwned", "true"},
+		{"hosts", "set", "gpus", "box1", "-oProxyCommand=touch pwned"},
+	} {
+		if _, err := parseSubcommand(globalArgs{}, args); err == nil {
+			t.Errorf("parsing %q succeeded; want an error", args)
+		}
+	}
+	if _, err := parseSubcommand(globalArgs{}, []string{"add", "--host", "user@box1", "true"}); err != nil {
+		t.Errorf("parsing a plain host failed: %v", err)
+	}
+
+	cmd := sshCommand(&Job{ID: 1, Command: "true"}, "box1")
+	if args := cmd.Args; len(args) < 3 || args[len(args)-3] != "--" || args[len(args)-2] != "box1" {
+		t.Errorf("ssh args %q; want -- before the host", args)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type metrics struct {
+	globalArgs
+	textfilePath string
+}
+
+func parseMetricsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := metrics{globalArgs: globals}
+	fs := flag.NewFlagSet(metricsCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.textfilePath, "textfile", "", "write metrics to this file for the node_exporter textfile collector")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return cmd, nil
+}
+
+func (cmd metrics) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, db); err != nil {
+		return err
+	}
+
+	if cmd.textfilePath == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return writeFileAtomic(cmd.textfilePath, buf.Bytes())
+}
+
+// writeMetrics writes the current queue gauges in the Prometheus text
+// exposition format.
+func writeMetrics(w io.Writer, db *DB) error {
+	counts, err := db.CountJobsByStatus(JobFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to count jobs: %w", err)
+	}
+	oldestPending, err := db.OldestPendingJob()
+	if err != nil {
+		return fmt.Errorf("failed to find oldest pending job: %w", err)
+	}
+	lastRuns, err := db.ListRuns(1)
+	if err != nil {
+		return fmt.Errorf("failed to read last run: %w", err)
+	}
+
+	queueCounts, err := db.CountJobsByQueue()
+	if err != nil {
+		return fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	fmt.Fprintln(w, "# HELP chime_jobs Number of jobs in the queue by status.")
+	fmt.Fprintln(w, "# TYPE chime_jobs gauge")
+	for _, status := range allStatuses {
+		fmt.Fprintf(w, "chime_jobs{status=%q} %d\n", statusNames[status], counts[status])
+	}
+
+	queues := make([]string, 0, len(queueCounts))
+	for q := range queueCounts {
+		queues = append(queues, q)
+	}
+	sort.Strings(queues)
+	fmt.Fprintln(w, "# HELP chime_queue_jobs Number of jobs in each queue by status.")
+	fmt.Fprintln(w, "# TYPE chime_queue_jobs gauge")
+	for _, q := range queues {
+		for _, status := range allStatuses {
+			fmt.Fprintf(w, "chime_queue_jobs{queue=%q,status=%q} %d\n", q, statusNames[status], queueCounts[q][status])
+		}
+	}
+
+	var pendingAge float64
+	if !oldestPending.IsZero() {
+		pendingAge = time.Since(oldestPending).Seconds()
+	}
+	fmt.Fprintln(w, "# HELP chime_oldest_pending_job_age_seconds Age of the oldest pending job.")
+	fmt.Fprintln(w, "# TYPE chime_oldest_pending_job_age_seconds gauge")
+	fmt.Fprintf(w, "chime_oldest_pending_job_age_seconds %g\n", pendingAge)
+
+	if len(lastRuns) > 0 {
+		last := lastRuns[0]
+		fmt.Fprintln(w, "# HELP chime_last_run_finished_timestamp_seconds Time the last run finished.")
+		fmt.Fprintln(w, "# TYPE chime_last_run_finished_timestamp_seconds gauge")
+		fmt.Fprintf(w, "chime_last_run_finished_timestamp_seconds %g\n", float64(last.FinishedAt)/1000)
+		fmt.Fprintln(w, "# HELP chime_last_run_duration_seconds Wall time of the last run.")
+		fmt.Fprintln(w, "# TYPE chime_last_run_duration_seconds gauge")
+		fmt.Fprintf(w, "chime_last_run_duration_seconds %g\n", last.WallTime().Seconds())
+		fmt.Fprintln(w, "# HELP chime_last_run_jobs Number of jobs processed by the last run by outcome.")
+		fmt.Fprintln(w, "# TYPE chime_last_run_jobs gauge")
+		fmt.Fprintf(w, "chime_last_run_jobs{outcome=\"succeeded\"} %d\n", last.NumSucceeded)
+		fmt.Fprintf(w, "chime_last_run_jobs{outcome=\"failed\"} %d\n", last.NumFailed)
+	}
+	return nil
+}
+
+// Upper bounds, in seconds, of the job duration histogram buckets.
+var jobDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// writeRunMetrics writes the counters of an ongoing run in the Prometheus
+// text exposition format.
+func writeRunMetrics(w io.Writer, r *runRecorder, pool *workerPool) {
+	workers, _ := pool.Size()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fmt.Fprintln(w, "# HELP chime_jobs_started_total Number of jobs started by this run.")
+	fmt.Fprintln(w, "# TYPE chime_jobs_started_total counter")
+	fmt.Fprintf(w, "chime_jobs_started_total %d\n", r.started)
+	fmt.Fprintln(w, "# HELP chime_jobs_completed_total Number of jobs completed by this run by outcome.")
+	fmt.Fprintln(w, "# TYPE chime_jobs_completed_total counter")
+	fmt.Fprintf(w, "chime_jobs_completed_total{outcome=\"succeeded\"} %d\n", r.summary.NumSucceeded)
+	fmt.Fprintf(w, "chime_jobs_completed_total{outcome=\"failed\"} %d\n", r.summary.NumFailed)
+
+	fmt.Fprintln(w, "# HELP chime_job_duration_seconds Duration of the jobs completed by this run.")
+	fmt.Fprintln(w, "# TYPE chime_job_duration_seconds histogram")
+	var cumulative int
+	for i, le := range jobDurationBuckets {
+		cumulative += r.durationCounts[i]
+		fmt.Fprintf(w, "chime_job_duration_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	cumulative += r.durationCounts[len(jobDurationBuckets)]
+	fmt.Fprintf(w, "chime_job_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "chime_job_duration_seconds_sum %g\n", r.durationSum.Seconds())
+	fmt.Fprintf(w, "chime_job_duration_seconds_count %d\n", cumulative)
+
+	var utilization float64
+	if workers > 0 {
+		utilization = float64(r.busy) / float64(workers)
+	}
+	fmt.Fprintln(w, "# HELP chime_workers Number of workers in this run.")
+	fmt.Fprintln(w, "# TYPE chime_workers gauge")
+	fmt.Fprintf(w, "chime_workers %d\n", workers)
+	fmt.Fprintln(w, "# HELP chime_workers_busy Number of workers currently running a job.")
+	fmt.Fprintln(w, "# TYPE chime_workers_busy gauge")
+	fmt.Fprintf(w, "chime_workers_busy %d\n", r.busy)
+	fmt.Fprintln(w, "# HELP chime_worker_utilization Fraction of workers currently running a job.")
+	fmt.Fprintln(w, "# TYPE chime_worker_utilization gauge")
+	fmt.Fprintf(w, "chime_worker_utilization %g\n", utilization)
+}
+
+// serveMetrics serves the queue and run metrics at /metrics on addr.
+func serveMetrics(addr string, db *DB, r *runRecorder, pool *workerPool) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		if err := writeMetrics(&buf, db); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeRunMetrics(&buf, r, pool)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// The schema is built up by an ordered list of migrations. The version of
+// the last migration applied to a DB is recorded in its schema_version table,
+// and any later migrations are applied when it's opened.
+//
+// DBs created before versioning already have some of the schema, so every
+// step must be safe to apply to a DB that already has its change.
+
+type migration struct {
+	version     int
+	description string
+	steps       []migrationStep
+}
+
+type migrationStep interface {
+	// describe returns the SQL, or a description, of the step.
+	describe() string
+	apply(q querier) error
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// execStep runs a SQL statement.
+type execStep string
+
+func (s execStep) describe() string      { return strings.TrimSpace(string(s)) }
+func (s execStep) apply(q querier) error { _, err := q.Exec(string(s)); return err }
+
+// addColumnStep adds a column to a table, unless it already has it.
+type addColumnStep struct {
+	table, column, definition string
+}
+
+func (s addColumnStep) describe() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", s.table, s.column, s.definition)
+}
+
+func (s addColumnStep) apply(q querier) error {
+	return ensureColumn(q, s.table, s.column, s.definition)
+}
+
+// funcStep runs a Go function, e.g. to backfill data.
+type funcStep struct {
+	description string
+	fn          func(q querier) error
+}
+
+func (s funcStep) describe() string      { return s.description }
+func (s funcStep) apply(q querier) error { return s.fn(q) }
+
+func addColumns(table string, columns ...string) []migrationStep {
+	var steps []migrationStep
+	for i := 0; i+1 < len(columns); i += 2 {
+		steps = append(steps, addColumnStep{table, columns[i], columns[i+1]})
+	}
+	return steps
+}
+
+var migrations = []migration{
+	{1, "create jobs table", []migrationStep{execStep(`
+	create table if not exists jobs
+	(
+		id integer not null primary key,
+		command text not null,
+		pid integer default 0,
+		status integer default 0,
+		created_at int default 0,
+		started_at int default 0,
+		finished_at int default 0
+	)`)}},
+	{2, "create runs table", []migrationStep{execStep(`
+	create table if not exists runs
+	(
+		id integer not null primary key,
+		started_at int default 0,
+		finished_at int default 0,
+		num_workers integer default 0,
+		num_jobs integer default 0,
+		num_succeeded integer default 0,
+		num_failed integer default 0,
+		total_job_time int default 0,
+		longest_job_id integer default 0,
+		longest_job_time int default 0,
+		failures text not null default '[]'
+	)`)}},
+	{3, "add script jobs", addColumns("jobs",
+		"script", "text not null default ''",
+	)},
+	{4, "add job templates", append([]migrationStep{execStep(`
+	create table if not exists templates
+	(
+		name text not null primary key,
+		command text not null,
+		created_at int default 0
+	)`)}, addColumns("jobs",
+		"template", "text not null default ''",
+	)...)},
+	{5, "add array jobs", addColumns("jobs",
+		"array_id", "integer default 0",
+		"array_index", "integer default 0",
+	)},
+	{6, "add job priorities and tags", addColumns("jobs",
+		"priority", "integer default 0",
+		"tags", "text not null default ''",
+	)},
+	{7, "add batches", append([]migrationStep{execStep(`
+	create table if not exists batches
+	(
+		id integer not null primary key,
+		name text not null default '',
+		created_at int default 0
+	)`)}, addColumns("jobs",
+		"batch_id", "integer default 0",
+	)...)},
+	{8, "add job environment settings", addColumns("jobs",
+		"env_mode", "text not null default ''",
+		"env_allow", "text not null default ''",
+		"env", "text not null default '[]'",
+	)},
+	{9, "add queues", addColumns("jobs",
+		"queue", "text not null default '"+defaultQueue+"'",
+	)},
+	{10, "add job names", append(addColumns("jobs",
+		"name", "text not null default ''",
+	),
+		// Names only need to be unique among jobs that haven't finished.
+		execStep(`
+	CREATE UNIQUE INDEX IF NOT EXISTS jobs_active_name ON jobs (name)
+	WHERE name != '' AND status IN (0, 1)`))},
+	{11, "add job UUIDs", append(addColumns("jobs",
+		"uuid", "text not null default ''",
+	), funcStep{"assign UUIDs to existing jobs", backfillUUIDs}, execStep(`
+	CREATE UNIQUE INDEX IF NOT EXISTS jobs_uuid ON jobs (uuid) WHERE uuid != ''`))},
+	{12, "create settings table", []migrationStep{execStep(`
+	create table if not exists settings
+	(
+		key text not null primary key,
+		value text not null
+	)`)}},
+	{13, "create archive table", []migrationStep{execStep(`
+	create table if not exists archived_jobs
+	(
+		archive_id integer not null primary key,
+		archived_at int default 0
+	)`)}},
+	{14, "create job events table", []migrationStep{execStep(`
+	create table if not exists job_events
+	(
+		id integer not null primary key,
+		job_id integer not null,
+		job_uuid text not null default '',
+		event text not null,
+		at int default 0,
+		actor text not null default '',
+		detail text not null default ''
+	)`), execStep(`
+	CREATE INDEX IF NOT EXISTS job_events_uuid ON job_events (job_uuid)`)}},
+	{15, "add job exit codes", addColumns("jobs",
+		"exit_code", "integer not null default -1",
+	)},
+	{16, "add job resource usage", addColumns("jobs",
+		"user_cpu_ms", "integer not null default -1",
+		"sys_cpu_ms", "integer not null default -1",
+		"max_rss_kb", "integer not null default -1",
+	)},
+	{17, "add job scheduling priorities", addColumns("jobs",
+		"nice", "integer not null default 0",
+		"ionice", "text not null default ''",
+	)},
+	{18, "add job resource limits and failure reasons", addColumns("jobs",
+		"mem_limit", "integer not null default 0",
+		"cpu_limit", "real not null default 0",
+		"failure", "text not null default ''",
+	)},
+	{19, "add job container images", addColumns("jobs",
+		"image", "text not null default ''",
+	)},
+	{20, "add remote hosts and host pools", append([]migrationStep{execStep(`
+	create table if not exists host_pools
+	(
+		name text not null primary key,
+		hosts text not null default ''
+	)`)}, addColumns("jobs",
+		"host", "text not null default ''",
+	)...)},
+	{21, "add job leases", addColumns("jobs",
+		"lease_owner", "text not null default ''",
+		"lease_expires_at", "integer not null default 0",
+	)},
+	{22, "add job workers", addColumns("jobs",
+		"worker", "text not null default ''",
+	)},
+	{23, "add job requirements", addColumns("jobs",
+		"requires", "text not null default ''",
+	)},
+	// Suspended jobs haven't finished, so keep their names reserved.
+	{24, "reserve names of suspended jobs", []migrationStep{
+		execStep(`DROP INDEX IF EXISTS jobs_active_name`),
+		execStep(`
+	CREATE UNIQUE INDEX IF NOT EXISTS jobs_active_name ON jobs (name)
+	WHERE name != '' AND status IN (0, 1, 4)`),
+	}},
+	{25, "reserve names of held jobs", []migrationStep{
+		execStep(`DROP INDEX IF EXISTS jobs_active_name`),
+		execStep(`
+	CREATE UNIQUE INDEX IF NOT EXISTS jobs_active_name ON jobs (name)
+	WHERE name != '' AND status IN (0, 1, 4, 5)`),
+	}},
+	{26, "add job time windows", addColumns("jobs",
+		"time_window", "text not null default ''",
+	)},
+	{27, "add job follow-ups", addColumns("jobs",
+		"on_success", "text not null default ''",
+		"on_failure", "text not null default ''",
+		"parent_id", "integer not null default 0",
+	)},
+	{28, "add job retries", addColumns("jobs",
+		"max_retries", "integer not null default 0",
+		"retries", "integer not null default 0",
+	)},
+	{29, "add job locks", append(addColumns("jobs",
+		"lock_name", "text not null default ''",
+	), execStep(`
+	CREATE INDEX IF NOT EXISTS jobs_lock_name ON jobs (lock_name, status) WHERE lock_name != ''`))},
+	{30, "create concurrency limits table", []migrationStep{execStep(`
+	create table if not exists concurrency_limits
+	(
+		kind text not null,
+		name text not null,
+		max_running integer not null,
+		primary key (kind, name)
+	)`)}},
+	{31, "create queue weights table", []migrationStep{execStep(`
+	create table if not exists queue_weights
+	(
+		queue_name text not null primary key,
+		weight real not null
+	)`), execStep(`
+	CREATE INDEX IF NOT EXISTS jobs_queue_started_at ON jobs (queue, started_at)`)}},
+	{32, "create priority aging table", []migrationStep{execStep(`
+	create table if not exists priority_aging
+	(
+		queue_name text not null primary key,
+		interval_ms int not null
+	)`)}},
+	// Positions are NULL until a job is moved; see move.go.
+	{33, "add job positions", addColumns("jobs",
+		"position", "real",
+	)},
+	{34, "add job slots", addColumns("jobs",
+		"slots", "integer not null default 1",
+	)},
+	{35, "add job resources", addColumns("jobs",
+		"resources", "text not null default ''",
+	)},
+	{36, "add job notes", addColumns("jobs",
+		"note", "text not null default ''",
+	)},
+	{37, "add job output paths", addColumns("jobs",
+		"output_path", "text not null default ''",
+	)},
+	// Only builds with FTS5 create the index; see search.go.
+	{38, "create job search index", []migrationStep{
+		funcStep{"create the job search index and index saved job output, if SQLite has FTS5", syncSearchIndex},
+	}},
+	{39, "add job users and pending limits", append(append(addColumns("jobs",
+		"submitted_by", "text not null default ''",
+	), addColumns("concurrency_limits",
+		"max_pending", "integer not null default 0",
+	)...), execStep(`
+	UPDATE jobs SET submitted_by = coalesce((
+		SELECT substr(actor, 1, instr(actor, '@') - 1) FROM job_events
+		WHERE job_uuid = jobs.uuid AND event = 'queued' AND instr(actor, '@') > 1
+		ORDER BY id LIMIT 1
+	), '')
+	WHERE submitted_by = ''`), execStep(`
+	CREATE INDEX IF NOT EXISTS jobs_submitted_by ON jobs (submitted_by, status)`), execStep(pendingLimitTrigger))},
+	{40, "add job artifacts directories", addColumns("jobs",
+		"artifacts_dir", "text not null default ''",
+	)},
+	{41, "add job progress", addColumns("jobs",
+		"progress", "integer not null default -1",
+		"progress_message", "text not null default ''",
+	)},
+	{42, "create command aliases table", []migrationStep{execStep(`
+	create table if not exists command_aliases
+	(
+		name text not null primary key,
+		command text not null,
+		created_at int not null
+	)`)}},
+	{43, "create workers table", []migrationStep{execStep(`
+	create table if not exists workers
+	(
+		name text not null primary key,
+		run text not null,
+		host text not null,
+		pid int not null,
+		started_at int not null,
+		last_seen int not null
+	)`)}},
+	{44, "add job timeouts", addColumns("jobs",
+		"timeout_ms", "integer not null default 0",
+	)},
+	// Jobs can add children of their own with add --parent, so follow-ups are
+	// marked, and only they are exempt from the pending limit.
+	{45, "add job follow-up flags", append(addColumns("jobs",
+		"follow_up", "integer not null default 0",
+	), execStep(`
+	UPDATE jobs SET follow_up = 1 WHERE parent_id != 0`), execStep(`
+	DROP TRIGGER IF EXISTS jobs_pending_limit`), execStep(pendingLimitTrigger))},
+	// Jobs count the claims they've had, so attempts before this one are
+	// counted by their claimed events.
+	{46, "create job attempts table", append(addColumns("jobs",
+		"attempts", "integer not null default 0",
+	), execStep(`
+	UPDATE jobs SET attempts = (
+		SELECT count(*) FROM job_events WHERE job_uuid = jobs.uuid AND event = 'claimed'
+	)`), execStep(`
+	create table if not exists job_attempts
+	(
+		id integer not null primary key,
+		job_id integer not null,
+		job_uuid text not null,
+		attempt integer not null,
+		started_at int not null,
+		finished_at int not null,
+		outcome text not null,
+		exit_code integer not null,
+		worker text not null default '',
+		output_path text not null default '',
+		failure text not null default ''
+	)`), execStep(`
+	CREATE INDEX IF NOT EXISTS job_attempts_uuid ON job_attempts (job_uuid)`))},
+}
+
+// latestSchemaVersion is the version of the schema this build of chime uses.
+var latestSchemaVersion = migrations[len(migrations)-1].version
+
+// SchemaVersion returns the version of the last migration applied to the DB.
+func (db *DB) SchemaVersion() (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return schemaVersion(db.DB)
+}
+
+// schemaVersion returns 0 for DBs from before schemas were versioned.
+func schemaVersion(q querier) (int, error) {
+	columns, err := tableColumns(q, "schema_version")
+	if err != nil || len(columns) == 0 {
+		return 0, err
+	}
+	rows, err := q.Query(`SELECT COALESCE(MAX(version), 0) FROM schema_version`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var version int
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+	}
+	return version, rows.Err()
+}
+
+// PendingMigrations returns the migrations not yet applied to the DB.
+func (db *DB) PendingMigrations() ([]migration, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > latestSchemaVersion {
+		return nil, fmt.Errorf("DB schema version %d is newer than this version of chime supports (%d)", version, latestSchemaVersion)
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies any pending migrations, all in one transaction. Returns
+// the migrations applied.
+func (db *DB) Migrate() ([]migration, error) {
+	pending, err := db.PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+	create table if not exists schema_version
+	(
+		version integer not null primary key,
+		description text not null,
+		applied_at int default 0
+	)`); err != nil {
+		return nil, err
+	}
+	// Another process may have migrated the DB since we checked.
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []migration
+	for _, m := range pending {
+		if m.version <= version {
+			continue
+		}
+		for _, step := range m.steps {
+			if err := step.apply(tx); err != nil {
+				return nil, fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?,?,?)`,
+			m.version, m.description, time.Now().UnixMilli()); err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+
+	// The archive table mirrors the jobs table, so keep it in step.
+	if err := syncArchiveColumns(tx); err != nil {
+		return nil, fmt.Errorf("failed to update archive table: %w", err)
+	}
+	return applied, tx.Commit()
+}
+
+// backfillUUIDs assigns UUIDs to jobs added before jobs had them.
+func backfillUUIDs(q querier) error {
+	rows, err := q.Query(`SELECT id FROM jobs WHERE uuid = ''`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := q.Exec(`UPDATE jobs SET uuid = ? WHERE id = ?`, newUUID(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate UUID: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// columnInfo describes a column of a table, as reported by PRAGMA table_info.
+type columnInfo struct {
+	Name         string
+	Type         string
+	NotNull      bool
+	DefaultValue sql.NullString
+}
+
+// definition returns the column's definition for use in ALTER TABLE.
+func (c columnInfo) definition() string {
+	def := c.Type
+	if c.NotNull {
+		def += " not null"
+	}
+	if c.DefaultValue.Valid {
+		def += " default " + c.DefaultValue.String
+	}
+	return def
+}
+
+func tableColumns(q querier, table string) ([]columnInfo, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []columnInfo
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			c                columnInfo
+		)
+		if err := rows.Scan(&cid, &c.Name, &c.Type, &notNull, &c.DefaultValue, &pk); err != nil {
+			return nil, err
+		}
+		c.NotNull = notNull != 0
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func ensureColumn(q querier, table, column, definition string) error {
+	columns, err := tableColumns(q, table)
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if c.Name == column {
+			return nil
+		}
+	}
+
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+type migrate struct {
+	globalArgs
+	dryRun bool
+}
+
+func parseMigrateSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := migrate{globalArgs: globals}
+	fs := flag.NewFlagSet(migrateCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.dryRun, "dry-run", false, "print the migrations that would be applied without applying them")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return cmd, nil
+}
+
+func (cmd migrate) Run() error {
+	db, err := openDB(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if !cmd.dryRun {
+		applied, err := db.Migrate()
+		if err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
+		for _, m := range applied {
+			slog.Info("applied migration", "version", m.version, "description", m.description)
+		}
+		slog.Info("schema is up to date", "version", latestSchemaVersion)
+		return nil
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %d, latest %d\n", version, latestSchemaVersion)
+	for _, m := range pending {
+		fmt.Printf("\n-- migration %d: %s\n", m.version, m.description)
+		for _, step := range m.steps {
+			fmt.Printf("%s;\n", step.describe())
+		}
+	}
+	return nil
+}
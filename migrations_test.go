@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// The schema of DBs made before migrations were added, by the first
+// version of chime.
+const baselineSchema = `
+create table if not exists jobs
+(
+	id integer not null primary key,
+	command text not null,
+	pid integer default 0,
+	status integer default 0,
+	created_at int default 0,
+	started_at int default 0,
+	finished_at int default 0
+)`
+
+func TestMigrateBaselineDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chime.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(`INSERT INTO jobs (command, status, created_at, started_at, finished_at) VALUES
+		('echo pending', 0, 1000, 0, 0),
+		('echo done', 2, 1000, 2000, 3000)`); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to migrate baseline DB: %v", err)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion {
+		t.Errorf("schema version %d after migrating; want %d", version, latestSchemaVersion)
+	}
+
+	jobs, err := db.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs after migrating; want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.UUID == "" {
+			t.Errorf("job #%d has no UUID after migrating", job.ID)
+		}
+		if job.Queue != defaultQueue {
+			t.Errorf("job #%d is in queue %q; want %q", job.ID, job.Queue, defaultQueue)
+		}
+	}
+	if jobs[1].Command != "echo done" || jobs[1].Status != statusDoneSuccess || jobs[1].FinishedAt != 3000 {
+		t.Errorf("finished job changed by migrating: %+v", jobs[1])
+	}
+
+	// The migrated DB takes new jobs, and reopening it applies nothing.
+	if _, err := db.AddJob("echo new"); err != nil {
+		t.Fatalf("failed to add a job to the migrated DB: %v", err)
+	}
+	db.Close()
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := db.PendingMigrations(); err != nil || len(pending) > 0 {
+		t.Errorf("got %d pending migrations after reopening (err %v); want none", len(pending), err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Pending jobs of the same priority and queue are taken in order of their
+// position, which is their ID unless they've been moved. Moving a job next
+// to another gives it the other's priority and a position between its
+// neighbours; moving it to the top gives it the highest priority in its
+// queue and a position before every other job there.
+
+// jobPositionExpr is the SQL expression for a job's position in its queue.
+const jobPositionExpr = `coalesce(jobs.position, jobs.id)`
+
+// Where to move a job: to the top of its queue, or before or after another
+// job in it.
+type moveTarget struct {
+	top    bool
+	before int64
+	after  int64
+}
+
+func (to moveTarget) String() string {
+	switch {
+	case to.top:
+		return "to the top"
+	case to.before != 0:
+		return fmt.Sprintf("before #%d", to.before)
+	default:
+		return fmt.Sprintf("after #%d", to.after)
+	}
+}
+
+// MoveJob moves a pending or held job within its queue.
+func (db *DB) MoveJob(id int64, to moveTarget) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, priority int
+	var queue string
+	if err := tx.QueryRow(`SELECT status, queue, priority FROM jobs WHERE id = ?`, id).
+		Scan(&status, &queue, &priority); err != nil {
+		return err
+	}
+	if status != statusPending && status != statusHeld {
+		return fmt.Errorf("job #%d isn't pending", id)
+	}
+
+	var position float64
+	if to.top {
+		if err := tx.QueryRow(`
+		SELECT max(coalesce(max(priority), ?), ?), coalesce(min(`+jobPositionExpr+`), 1) - 1 FROM jobs
+		WHERE status IN (?, ?) AND queue = ? AND id != ?`,
+			priority, priority, statusPending, statusHeld, queue, id).Scan(&priority, &position); err != nil {
+			return err
+		}
+	} else {
+		other := to.before
+		if other == 0 {
+			other = to.after
+		}
+		if other == id {
+			return fmt.Errorf("can't move job #%d next to itself", id)
+		}
+		var otherStatus int
+		var otherQueue string
+		var otherPosition float64
+		if err := tx.QueryRow(`SELECT status, queue, priority, `+jobPositionExpr+` FROM jobs WHERE id = ?`, other).
+			Scan(&otherStatus, &otherQueue, &priority, &otherPosition); err != nil {
+			return err
+		}
+		if otherStatus != statusPending && otherStatus != statusHeld {
+			return fmt.Errorf("job #%d isn't pending", other)
+		}
+		if otherQueue != queue {
+			return fmt.Errorf("job #%d is in queue '%s', not '%s'", other, otherQueue, queue)
+		}
+
+		// Halfway to the neighbour on the other side, or a whole step if
+		// there's none.
+		neighbour, step := `max(`+jobPositionExpr+`)`, -1.0
+		cmp := "<"
+		if to.after != 0 {
+			neighbour, step, cmp = `min(`+jobPositionExpr+`)`, 1.0, ">"
+		}
+		var next *float64
+		if err := tx.QueryRow(`
+		SELECT `+neighbour+` FROM jobs
+		WHERE status IN (?, ?) AND queue = ? AND priority = ? AND id != ? AND `+jobPositionExpr+` `+cmp+` ?`,
+			statusPending, statusHeld, queue, priority, id, otherPosition).Scan(&next); err != nil {
+			return err
+		}
+		position = otherPosition + step
+		if next != nil {
+			position = (otherPosition + *next) / 2
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE jobs SET priority = ?, position = ? WHERE id = ?`, priority, position, id); err != nil {
+		return err
+	}
+	if err := recordEvents(tx, db.actor, eventMoved, to.String(), "id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type move struct {
+	globalArgs
+	ref           string
+	top           bool
+	before, after string
+}
+
+func parseMoveSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := move{globalArgs: globals}
+	// The job may come before the flags, as in `chime move 12 --to-top`.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd.ref, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet(moveCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.top, "to-top", false, "move the job to the top of its queue")
+	fs.StringVar(&cmd.before, "before", "", "move the job just before this job in its queue")
+	fs.StringVar(&cmd.after, "after", "", "move the job just after this job in its queue")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	rest := fs.Args()
+	if cmd.ref == "" && len(rest) > 0 {
+		cmd.ref, rest = rest[0], rest[1:]
+	}
+	if cmd.ref == "" {
+		return nil, fmt.Errorf("param required: job ID or name to move")
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	targets := 0
+	for _, set := range []bool{cmd.top, cmd.before != "", cmd.after != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("exactly one of --to-top, --before or --after is required")
+	}
+	return cmd, nil
+}
+
+func (cmd move) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	to := moveTarget{top: cmd.top}
+	if ref := cmd.before + cmd.after; ref != "" {
+		other, err := resolveJob(db, ref)
+		if err != nil {
+			return err
+		}
+		if cmd.before != "" {
+			to.before = int64(other.ID)
+		} else {
+			to.after = int64(other.ID)
+		}
+	}
+
+	if err := db.MoveJob(int64(job.ID), to); err != nil {
+		return fmt.Errorf("failed to move job: %w", err)
+	}
+	slog.Info("moved job", "id", job.ID, "to", to.String())
+	return nil
+}
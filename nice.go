@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// I/O scheduling classes a job can run with, as accepted by `add --ionice`.
+// best-effort and realtime take an optional level from 0 (highest) to 7,
+// e.g. "best-effort:6".
+const (
+	ioniceIdle       = "idle"
+	ioniceBestEffort = "best-effort"
+	ioniceRealtime   = "realtime"
+)
+
+// Default level of the best-effort and realtime classes.
+const defaultIONiceLevel = 4
+
+func validateNice(n int) error {
+	if n < -20 || n > 19 {
+		return fmt.Errorf("invalid niceness %d: must be between -20 and 19", n)
+	}
+	return nil
+}
+
+// parseIONice parses an I/O scheduling class and level like "best-effort:6".
+func parseIONice(s string) (class string, level int, err error) {
+	class, levelStr, hasLevel := strings.Cut(s, ":")
+	level = defaultIONiceLevel
+	switch class {
+	case ioniceIdle:
+		if hasLevel {
+			return "", 0, fmt.Errorf("invalid I/O scheduling class '%s': %s has no levels", s, ioniceIdle)
+		}
+		return class, 0, nil
+	case ioniceBestEffort, ioniceRealtime:
+		if hasLevel {
+			if level, err = strconv.Atoi(levelStr); err != nil || level < 0 || level > 7 {
+				return "", 0, fmt.Errorf("invalid I/O scheduling level '%s': must be between 0 and 7", levelStr)
+			}
+		}
+		return class, level, nil
+	}
+	return "", 0, fmt.Errorf("invalid I/O scheduling class '%s': must be one of %s, %s or %s",
+		s, ioniceIdle, ioniceBestEffort, ioniceRealtime)
+}
+
+// applySchedPriority sets the CPU and I/O scheduling priority of a job's
+// process once it has started. Processes it starts from then on inherit
+// them. I/O priorities are only supported on Linux.
+func applySchedPriority(pid int, job *Job) error {
+	if job.Nice != 0 {
+		if err := setNiceness(pid, job.Nice); err != nil {
+			return fmt.Errorf("failed to set niceness: %w", err)
+		}
+	}
+	if job.IONice != "" {
+		class, level, err := parseIONice(job.IONice)
+		if err != nil {
+			return err
+		}
+		if err := setIOPriority(pid, class, level); err != nil {
+			return fmt.Errorf("failed to set I/O priority: %w", err)
+		}
+	}
+	return nil
+}
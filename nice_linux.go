@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// See ioprio_set(2).
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	ioniceRealtime:   1,
+	ioniceBestEffort: 2,
+	ioniceIdle:       3,
+}
+
+func setIOPriority(pid int, class string, level int) error {
+	prio := ioprioClasses[class]<<ioprioClassShift | level
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func setIOPriority(pid int, class string, level int) error {
+	return fmt.Errorf("I/O priorities are only supported on Linux")
+}
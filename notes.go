@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Jobs can carry a free-text note, like "re-run of #42 with the fix", set
+// when they're added or annotated later in any status. Notes are shown by
+// `chime show` and matched by `chime list --grep`.
+
+// SetJobNote sets the note of a job, or clears it if note is empty. Returns
+// false if there's no such job.
+func (db *DB) SetJobNote(id int64, note string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE jobs SET note = ? WHERE id = ?`, note, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := recordEvents(tx, db.actor, eventAnnotated, note, "id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// grepJobs returns the jobs whose command, name or note matches re.
+func grepJobs(jobs []Job, re *regexp.Regexp) []Job {
+	var matched []Job
+	for _, job := range jobs {
+		if re.MatchString(job.Command) || re.MatchString(job.Name) || re.MatchString(job.Note) {
+			matched = append(matched, job)
+		}
+	}
+	return matched
+}
+
+type annotate struct {
+	globalArgs
+	ref  string
+	note string
+}
+
+func parseAnnotateSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	var clear bool
+	fs := flag.NewFlagSet(annotateCommandName, flag.ContinueOnError)
+	fs.BoolVar(&clear, "clear", false, "remove the job's note")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("param required: job ID or name to annotate")
+	}
+	cmd := annotate{globalArgs: globals, ref: fs.Arg(0), note: strings.Join(fs.Args()[1:], " ")}
+	switch {
+	case clear && cmd.note != "":
+		return nil, fmt.Errorf("a note can't be combined with --clear")
+	case !clear && strings.TrimSpace(cmd.note) == "":
+		return nil, fmt.Errorf("param required: note, or --clear")
+	}
+	return cmd, nil
+}
+
+func (cmd annotate) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	ok, err := db.SetJobNote(int64(job.ID), cmd.note)
+	if err != nil {
+		return fmt.Errorf("failed to annotate job: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("job #%d no longer exists", job.ID)
+	}
+	if cmd.note == "" {
+		slog.Info("cleared note", "id", job.ID)
+	} else {
+		slog.Info("annotated job", "id", job.ID)
+	}
+	return nil
+}
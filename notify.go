@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `chime run --follow --notify desktop` lets you know when the queue has
+// drained: once the run goes from running jobs to having none to run or
+// running, each notifier is fired once with a summary of the jobs it ran
+// since it was last idle. A notifier is "bell", which rings the terminal's
+// bell, "desktop", which shows a desktop notification with notify-send, or
+// osascript on macOS, or a webhook URL, which is posted a JSON summary with
+// a "text" field that chat webhooks such as Slack's show.
+
+const (
+	notifyBell    = "bell"
+	notifyDesktop = "desktop"
+)
+
+// notifiers is the list of --notify flags.
+type notifiers []string
+
+func (n *notifiers) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *notifiers) Set(value string) error {
+	switch {
+	case value == notifyBell:
+	case value == notifyDesktop:
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("desktop notifications aren't supported on Windows")
+		}
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+	default:
+		return fmt.Errorf("expected bell, desktop or a webhook URL")
+	}
+	*n = append(*n, value)
+	return nil
+}
+
+// drainSummary describes the jobs a run ran before the queue drained.
+type drainSummary struct {
+	Event      string             `json:"event"`
+	Run        string             `json:"run"`
+	Text       string             `json:"text"`
+	NumJobs    int                `json:"jobs"`
+	Succeeded  int                `json:"succeeded"`
+	Failed     int                `json:"failed"`
+	StartedAt  time.Time          `json:"started_at"`
+	DrainedAt  time.Time          `json:"drained_at"`
+	DurationMs int64              `json:"duration_ms"`
+	Failures   []FailureSignature `json:"failures"`
+}
+
+// drainNotifier fires a run's notifiers each time the queue drains. Only
+// used on the claiming turn, which it doesn't hold up: notifications are
+// sent in the background. A nil drainNotifier does nothing.
+type drainNotifier struct {
+	notifiers notifiers
+	run       string
+	// The run's summary when the queue last drained, and when the first
+	// job after it was claimed.
+	last      RunSummary
+	busySince time.Time
+	sending   sync.WaitGroup
+}
+
+// claimed records that a job was claimed.
+func (d *drainNotifier) claimed() {
+	if d != nil && d.busySince.IsZero() {
+		d.busySince = time.Now()
+	}
+}
+
+// drained fires the notifiers once the run has nothing to claim and none of
+// its jobs are running, if it ran any since it was last idle. summary is the
+// run's summary so far.
+func (d *drainNotifier) drained(summary RunSummary) {
+	if d == nil || d.busySince.IsZero() {
+		return
+	}
+	s := drainSummary{
+		Event:     "queue_drained",
+		Run:       d.run,
+		NumJobs:   summary.NumJobs - d.last.NumJobs,
+		Succeeded: summary.NumSucceeded - d.last.NumSucceeded,
+		Failed:    summary.NumFailed - d.last.NumFailed,
+		StartedAt: d.busySince,
+		DrainedAt: time.Now(),
+		Failures:  summary.Failures[len(d.last.Failures):],
+	}
+	s.DurationMs = s.DrainedAt.Sub(s.StartedAt).Milliseconds()
+	s.Text = fmt.Sprintf("chime: queue drained, %d jobs in %s: %d succeeded, %d failed",
+		s.NumJobs, s.DrainedAt.Sub(s.StartedAt).Round(time.Second), s.Succeeded, s.Failed)
+	if s.Failures == nil {
+		s.Failures = []FailureSignature{}
+	}
+	d.last, d.busySince = summary, time.Time{}
+
+	slog.Info("queue drained", "jobs", s.NumJobs, "succeeded", s.Succeeded, "failed", s.Failed)
+	d.sending.Add(1)
+	go func() {
+		defer d.sending.Done()
+		for _, n := range d.notifiers {
+			if err := notify(n, s); err != nil {
+				slog.Warn("failed to send notification", "notifier", n, "err", err)
+			}
+		}
+	}()
+}
+
+// wait waits for notifications still being sent.
+func (d *drainNotifier) wait() {
+	if d != nil {
+		d.sending.Wait()
+	}
+}
+
+func notify(notifier string, s drainSummary) error {
+	switch notifier {
+	case notifyBell:
+		ringBell()
+		return nil
+	case notifyDesktop:
+		return showDesktopNotification("chime", strings.TrimPrefix(s.Text, "chime: "))
+	}
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(notifier, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// showDesktopNotification shows a desktop notification.
+func showDesktopNotification(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	default:
+		cmd = exec.Command("notify-send", title, message)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s failed: %w: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A runner started with --output-dir saves the output of each job it runs,
+// stdout and stderr interleaved, to a file there as well as printing it,
+// and records the file with the job. Each attempt at running a job gets its
+// own file, recorded with the attempt; see attempts.go.
+// --output-limit caps each file, keeping the head or the tail of the output,
+// and --output-quota caps the directory, removing the oldest files once a
+// job finishes. Files are removed with the jobs they belong to when those
+// are deleted or purged; archived jobs keep theirs.
+
+// Which part of a job's output beyond --output-limit is kept.
+const (
+	outputKeepHead = "head"
+	outputKeepTail = "tail"
+)
+
+// outputConfig is where a runner saves jobs' output.
+type outputConfig struct {
+	// Directory output is saved to, or empty to not save it.
+	Dir string
+	// Most bytes of output saved per job, or 0 for no limit, and which part
+	// of the output is kept beyond it.
+	Limit int64
+	Keep  string
+	// Most bytes the directory's files may add up to, or 0 for no limit.
+	Quota int64
+	// Directory jobs get their artifacts directories in, or empty for none;
+	// see artifacts.go.
+	ArtifactsDir string
+}
+
+// addOutputFlags registers the flags of commands that run jobs locally that
+// configure saving their output and artifacts.
+func addOutputFlags(fs *flag.FlagSet, cfg *outputConfig) {
+	fs.StringVar(&cfg.Dir, "output-dir", "", "save each job's output to a file in this directory")
+	fs.Func("output-limit", "most output to save per job with --output-dir, e.g. 10M (default: no limit)", func(s string) error {
+		n, err := parseSize(s)
+		cfg.Limit = n
+		return err
+	})
+	fs.StringVar(&cfg.Keep, "output-keep", outputKeepTail, "part of a job's output to save beyond --output-limit: head or tail")
+	fs.Func("output-quota", "most space the files in --output-dir may take up, e.g. 1G; the oldest are removed beyond it (default: no limit)", func(s string) error {
+		n, err := parseSize(s)
+		cfg.Quota = n
+		return err
+	})
+	fs.StringVar(&cfg.ArtifactsDir, "artifacts-dir", "", "give each execution of a job a new directory in this directory for its artifacts, in $CHIME_ARTIFACTS_DIR")
+}
+
+func (cfg outputConfig) validate() error {
+	if cfg.Dir == "" {
+		if cfg.Limit > 0 || cfg.Quota > 0 {
+			return fmt.Errorf("--output-limit and --output-quota need --output-dir")
+		}
+		return nil
+	}
+	switch cfg.Keep {
+	case outputKeepHead, outputKeepTail:
+		return nil
+	}
+	return fmt.Errorf("invalid --output-keep: '%s' (expected head or tail)", cfg.Keep)
+}
+
+// SetJobOutputPath records the file a job's output is saved to.
+func (db *DB) SetJobOutputPath(jobID int64, path string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`UPDATE jobs SET output_path = ? WHERE id = ?`, path, jobID)
+	return err
+}
+
+// jobOutputPaths returns the output files of the jobs matching where,
+// including those of their earlier attempts.
+func jobOutputPaths(tx *sql.Tx, where string, args ...any) ([]string, error) {
+	paths, err := attemptOutputPaths(tx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT output_path FROM jobs WHERE output_path != '' AND (`+where+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, rows.Err()
+}
+
+// removeOutputFiles removes the output files of deleted jobs. Failures are
+// only logged, since the jobs are already gone.
+func removeOutputFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove job output", "path", path, "err", err)
+		}
+	}
+}
+
+// openOutputs holds the paths of the output files being written, which the
+// quota leaves alone.
+var openOutputs = struct {
+	lock  sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// outputFile is the file a job's output is saved to. Writes never fail, so
+// a full disk doesn't fail the job; saving stops instead.
+type outputFile struct {
+	lock    sync.Mutex
+	path    string
+	f       *os.File
+	limit   int64
+	keep    string
+	size    int64
+	dropped int64
+	err     error
+}
+
+// outputFileName returns the name of a job's output file. Job IDs may be
+// reused once jobs are deleted, so it has the job's UUID too, and attempts
+// after the first have their number, so they don't replace earlier ones.
+func outputFileName(job *Job) string {
+	if job.Attempts > 1 {
+		return fmt.Sprintf("%d-%s.%d.log", job.ID, job.UUID, job.Attempts)
+	}
+	return fmt.Sprintf("%d-%s.log", job.ID, job.UUID)
+}
+
+func createOutputFile(cfg outputConfig, job *Job) (*outputFile, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(filepath.Join(cfg.Dir, outputFileName(job)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	openOutputs.lock.Lock()
+	openOutputs.paths[path] = true
+	openOutputs.lock.Unlock()
+	return &outputFile{path: path, f: f, limit: cfg.Limit, keep: cfg.Keep}, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	n := len(p)
+	if o.err != nil {
+		return n, nil
+	}
+	if o.limit > 0 && o.keep == outputKeepHead {
+		room := max(o.limit-o.size, 0)
+		if int64(len(p)) > room {
+			o.dropped += int64(len(p)) - room
+			p = p[:room]
+		}
+	}
+	written, err := o.f.Write(p)
+	o.size += int64(written)
+	if err == nil && o.limit > 0 && o.keep == outputKeepTail && o.size > 2*o.limit {
+		// Trimming as it goes keeps the file under twice the limit.
+		err = o.trim()
+	}
+	if err != nil {
+		slog.Warn("failed to save job output; no more will be saved", "path", o.path, "err", err)
+		o.err = err
+	}
+	return n, nil
+}
+
+// trim drops all but the last limit bytes of the file.
+func (o *outputFile) trim() error {
+	tail := make([]byte, o.limit)
+	if _, err := o.f.ReadAt(tail, o.size-o.limit); err != nil {
+		return err
+	}
+	if _, err := o.f.WriteAt(tail, 0); err != nil {
+		return err
+	}
+	if err := o.f.Truncate(o.limit); err != nil {
+		return err
+	}
+	if _, err := o.f.Seek(o.limit, io.SeekStart); err != nil {
+		return err
+	}
+	o.dropped += o.size - o.limit
+	o.size = o.limit
+	return nil
+}
+
+// Close notes how much output was dropped, if any, at the end of the file
+// for the head, and at its start for the tail, and closes it.
+func (o *outputFile) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	defer func() {
+		openOutputs.lock.Lock()
+		delete(openOutputs.paths, o.path)
+		openOutputs.lock.Unlock()
+	}()
+	err := o.err
+	if err == nil && o.keep == outputKeepTail && o.size > o.limit && o.limit > 0 {
+		err = o.trim()
+	}
+	if err == nil && o.dropped > 0 {
+		marker := fmt.Sprintf("[chime: %s of output dropped beyond --output-limit]\n", formatBytes(o.dropped))
+		if o.keep == outputKeepHead {
+			_, err = o.f.WriteString("\n" + marker)
+		} else {
+			err = o.prepend(marker)
+		}
+	}
+	return errors.Join(err, o.f.Close())
+}
+
+func (o *outputFile) prepend(s string) error {
+	data := make([]byte, o.size)
+	if _, err := o.f.ReadAt(data, 0); err != nil {
+		return err
+	}
+	_, err := o.f.WriteAt(append([]byte(s), data...), 0)
+	return err
+}
+
+// enforceOutputQuota removes the oldest files in the output directory until
+// they add up to no more than the quota, except those still being written.
+func enforceOutputQuota(cfg outputConfig) error {
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	type logFile struct {
+		path    string
+		size    int64
+		modTime int64
+	}
+	var files []logFile
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path, err := filepath.Abs(filepath.Join(cfg.Dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		files = append(files, logFile{path, info.Size(), info.ModTime().UnixNano()})
+		total += info.Size()
+	}
+	slices.SortFunc(files, func(a, b logFile) int { return cmp.Compare(a.modTime, b.modTime) })
+
+	openOutputs.lock.Lock()
+	defer openOutputs.lock.Unlock()
+	for _, file := range files {
+		if total <= cfg.Quota {
+			break
+		}
+		if openOutputs.paths[file.path] {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			return err
+		}
+		slog.Debug("removed job output over quota", "path", file.path, "size", file.size)
+		total -= file.size
+	}
+	return nil
+}
+
+// execJobSavingOutput runs a job like execJobWithArtifacts, saving its output if the
+// runner is configured to.
+func execJobSavingOutput(db *DB, cfg execConfig, job *Job) (*jobResult, error) {
+	if cfg.Output.Dir == "" {
+		return execJobWithArtifacts(db, cfg, job)
+	}
+	out, err := createOutputFile(cfg.Output, job)
+	if err != nil {
+		slog.Warn("failed to create file for job output; it won't be saved", "id", job.ID, "err", err)
+		return execJobWithArtifacts(db, cfg, job)
+	}
+	if err := db.SetJobOutputPath(int64(job.ID), out.path); err != nil {
+		slog.Warn("failed to record job output file", "id", job.ID, "err", err)
+	}
+	cfg.Stdout = io.MultiWriter(cfg.stdout(), out)
+	cfg.Stderr = io.MultiWriter(cfg.stderr(), out)
+
+	result, err := execJobWithArtifacts(db, cfg, job)
+	if err := out.Close(); err != nil {
+		slog.Warn("failed to save job output", "id", job.ID, "err", err)
+	}
+	if err := db.IndexJobOutput(int64(job.ID), out.path); err != nil {
+		slog.Warn("failed to index job output for search", "id", job.ID, "err", err)
+	}
+	if cfg.Output.Quota > 0 {
+		if err := enforceOutputQuota(cfg.Output); err != nil {
+			slog.Warn("failed to apply output quota", "dir", cfg.Output.Dir, "err", err)
+		}
+	}
+	return result, err
+}
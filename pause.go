@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Paused queues are stored in the settingPausedQueues setting, as a comma
+// separated list of queue names, or allQueues if every queue is paused.
+// Runners don't take jobs from paused queues, but let the jobs they're
+// running finish.
+const allQueues = "*"
+
+type pause struct {
+	globalArgs
+	// Queue to pause or resume, or empty for all queues.
+	queue string
+}
+
+type resume struct {
+	globalArgs
+	queue string
+}
+
+// PausedQueues returns the paused queues, which is [allQueues] if every
+// queue is paused.
+func (db *DB) PausedQueues() ([]string, error) {
+	value, _, err := db.GetSetting(settingPausedQueues)
+	if err != nil {
+		return nil, err
+	}
+	var queues CommaList
+	if err := queues.Scan(value); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+func (db *DB) setPausedQueues(queues []string) error {
+	if len(queues) == 0 {
+		return db.DeleteSetting(settingPausedQueues)
+	}
+	return db.SetSetting(settingPausedQueues, CommaList(queues).String())
+}
+
+func parsePauseFlags(name string, args []string) (string, error) {
+	var queue string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&queue, "queue", "", "only this queue (default: all queues)")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 0 {
+		return "", fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if queue != "" {
+		if err := validateName("queue", queue); err != nil {
+			return "", err
+		}
+	}
+	return queue, nil
+}
+
+func parsePauseSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	queue, err := parsePauseFlags(pauseCommandName, args)
+	if err != nil {
+		return nil, err
+	}
+	return pause{globalArgs: globals, queue: queue}, nil
+}
+
+// parseResumeSubcommand parses `resume [--queue q]`, which resumes queues,
+// or `resume <job>`, which resumes a suspended job; see suspend.go.
+func parseResumeSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args[1:])
+		}
+		return resumeJob{globalArgs: globals, ref: args[0]}, nil
+	}
+	queue, err := parsePauseFlags(resumeCommandName, args)
+	if err != nil {
+		return nil, err
+	}
+	return resume{globalArgs: globals, queue: queue}, nil
+}
+
+func (cmd pause) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	paused, err := db.PausedQueues()
+	if err != nil {
+		return fmt.Errorf("failed to read paused queues: %w", err)
+	}
+	switch {
+	case cmd.queue == "":
+		paused = []string{allQueues}
+	case slices.Contains(paused, allQueues):
+		return fmt.Errorf("all queues are already paused")
+	case !slices.Contains(paused, cmd.queue):
+		paused = append(paused, cmd.queue)
+	}
+	if err := db.setPausedQueues(paused); err != nil {
+		return fmt.Errorf("failed to pause: %w", err)
+	}
+	if cmd.queue == "" {
+		slog.Info("paused all queues")
+	} else {
+		slog.Info("paused queue", "queue", cmd.queue)
+	}
+	return nil
+}
+
+func (cmd resume) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	paused, err := db.PausedQueues()
+	if err != nil {
+		return fmt.Errorf("failed to read paused queues: %w", err)
+	}
+	switch {
+	case cmd.queue == "":
+		paused = nil
+	case slices.Contains(paused, allQueues):
+		return fmt.Errorf("all queues are paused; resume them all with `chime resume`")
+	case !slices.Contains(paused, cmd.queue):
+		return fmt.Errorf("queue '%s' isn't paused", cmd.queue)
+	default:
+		paused = slices.DeleteFunc(paused, func(q string) bool { return q == cmd.queue })
+	}
+	if err := db.setPausedQueues(paused); err != nil {
+		return fmt.Errorf("failed to resume: %w", err)
+	}
+	if cmd.queue == "" {
+		slog.Info("resumed all queues")
+	} else {
+		slog.Info("resumed queue", "queue", cmd.queue)
+	}
+	return nil
+}
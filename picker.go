@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	bubbleslist "github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+// Commands that act on a job, like `chime show`, open a picker over the
+// current jobs when run on a terminal without one. Typing / filters the jobs
+// by ID, name, command and note, fuzzily; enter picks the highlighted job
+// and esc gives up.
+
+// canPickJob reports whether a job can be picked interactively, which needs
+// a terminal for both input and output.
+func canPickJob() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+type pickerItem struct {
+	job Job
+}
+
+func (i pickerItem) Title() string {
+	if i.job.Name != "" {
+		return fmt.Sprintf("#%d %s", i.job.ID, i.job.Name)
+	}
+	return fmt.Sprintf("#%d", i.job.ID)
+}
+
+func (i pickerItem) Description() string {
+	return fmt.Sprintf("%s · %s · %s", statusNames[i.job.Status], i.job.Queue, i.job.Command)
+}
+
+func (i pickerItem) FilterValue() string {
+	return strings.Join([]string{strconv.Itoa(i.job.ID), i.job.Name, i.job.Command, i.job.Note}, " ")
+}
+
+type pickerModel struct {
+	list   bubbleslist.Model
+	picked *Job
+}
+
+func (m pickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.list.SetSize(msg.Width, msg.Height)
+	case tea.KeyMsg:
+		// While filtering, enter accepts the filter instead.
+		if msg.String() == "enter" && m.list.FilterState() != bubbleslist.Filtering {
+			if item, ok := m.list.SelectedItem().(pickerItem); ok {
+				m.picked = &item.job
+			}
+			return m, tea.Quit
+		}
+	}
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m pickerModel) View() string {
+	return m.list.View()
+}
+
+// pickJob lets the user pick one of the jobs with the given statuses, or any
+// job if there are none, for the action described by verb, like "show".
+// Returns the picked job's ID as a reference to it.
+func pickJob(db *DB, verb string, statuses ...int) (string, error) {
+	where, args := "1", []any{}
+	if len(statuses) > 0 {
+		where = "status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	jobs, err := db.queryJobs("jobs", where, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to list jobs: %w", err)
+	}
+	if len(jobs) == 0 {
+		return "", fmt.Errorf("no jobs to %s", verb)
+	}
+
+	// Newest first.
+	items := make([]bubbleslist.Item, len(jobs))
+	for i, job := range jobs {
+		items[len(jobs)-1-i] = pickerItem{job: job}
+	}
+	l := bubbleslist.New(items, bubbleslist.NewDefaultDelegate(), 0, 0)
+	l.Title = "Pick a job to " + verb
+	done, err := tea.NewProgram(pickerModel{list: l}, tea.WithAltScreen()).Run()
+	if err != nil {
+		return "", fmt.Errorf("failed to run job picker: %w", err)
+	}
+	picked := done.(pickerModel).picked
+	if picked == nil {
+		return "", fmt.Errorf("no job picked")
+	}
+	return strconv.Itoa(picked.ID), nil
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// workerPool runs consumer workers for a `chime run`, and allows the number
+// of workers to be changed while it runs. Each worker is a slot; a job
+// occupies as many slots as it asks for, or all of them if it asks for more.
+//
+// Workers claim their own jobs, one at a time, once they're free: the job
+// the run would claim next is only claimed once the slots and devices it
+// needs are, so no job is ever leased to the run without running, where a
+// crash would hold it up until its lease expired and busy workers would keep
+// it from other runners.
+type workerPool struct {
+	db       *DB
+	cfg      execConfig
+	claims   claimConfig
+	recorder *runRecorder
+	tracer   *runTracer
+	report   *runReport
+	// Cancelled once the run stops taking jobs.
+	ctx context.Context
+	// Makes room for urgent jobs, with --preempt.
+	preempt *preempter
+	// Says when the queue drains, with --notify.
+	drain *drainNotifier
+
+	// Held by the worker claiming a job, so only one claims at a time. The
+	// fields after it are only used while it's held.
+	claimTurn chan struct{}
+	idleSince time.Time
+
+	lock sync.Mutex
+	wg   sync.WaitGroup
+	// The running workers, in the order they were started.
+	workers []*poolWorker
+	nextID  int
+	// Number of workers to return to when a burst ends.
+	base       int
+	burstTimer *time.Timer
+	burstUntil time.Time
+	stopped    bool
+	errs       []error
+	// Jobs claimed, for the run's summary.
+	numJobs int
+
+	// Slots reserved by the jobs handed to workers, by job ID, and their
+	// total. slotsFreed is closed, and replaced, when slots come free.
+	slots      map[int]int
+	usedSlots  int
+	slotsFreed chan struct{}
+	// Devices are reserved along with slots; see resources.go.
+	devices devicePool
+	// Jobs requeued for more urgent ones that haven't exited yet.
+	preempted map[int]bool
+}
+
+// poolWorker is a running worker, which stops claiming jobs once cancelled.
+type poolWorker struct {
+	cancel context.CancelFunc
+}
+
+// claimConfig decides which jobs a run's workers claim, and when.
+type claimConfig struct {
+	filter claimFilter
+	// Keep waiting for jobs once the queue is empty; with a positive
+	// idleTimeout, only until there's been nothing to take and nothing
+	// running for that long.
+	follow      bool
+	idleTimeout time.Duration
+	// Jobs are claimed no faster than limiter allows, not while load says
+	// the machine is busy, and not while control has the run paused.
+	limiter *rateLimiter
+	load    loadGate
+	control *runControl
+}
+
+func newWorkerPool(ctx context.Context, db *DB, cfg execConfig, claims claimConfig, recorder *runRecorder, tracer *runTracer, report *runReport, resources resourceCounts) *workerPool {
+	return &workerPool{
+		db:         db,
+		cfg:        cfg,
+		claims:     claims,
+		recorder:   recorder,
+		tracer:     tracer,
+		report:     report,
+		ctx:        ctx,
+		claimTurn:  make(chan struct{}, 1),
+		slots:      map[int]int{},
+		slotsFreed: make(chan struct{}),
+		devices:    newDevicePool(resources),
+		preempted:  map[int]bool{},
+	}
+}
+
+// Scale sets the number of workers. Any burst in progress is cancelled.
+func (p *workerPool) Scale(n int) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n < 1 {
+		return fmt.Errorf("number of workers must be at least 1")
+	}
+	p.cancelBurst()
+	p.base = n
+	p.resize(n)
+	return nil
+}
+
+// Burst raises the number of workers to n for the duration d, after which
+// the pool returns to its base number of workers.
+func (p *workerPool) Burst(n int, d time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n < 1 {
+		return fmt.Errorf("number of workers must be at least 1")
+	}
+	if d <= 0 {
+		return fmt.Errorf("burst duration must be positive")
+	}
+	p.cancelBurst()
+	p.resize(n)
+	p.burstUntil = time.Now().Add(d)
+	p.burstTimer = time.AfterFunc(d, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		slog.Info("burst ended", "workers", p.base)
+		p.burstTimer = nil
+		p.burstUntil = time.Time{}
+		p.resize(p.base)
+	})
+	return nil
+}
+
+// Size returns the current number of workers, and when the current burst
+// ends (zero if there is none).
+func (p *workerPool) Size() (int, time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.workers), p.burstUntil
+}
+
+func (p *workerPool) cancelBurst() {
+	if p.burstTimer != nil {
+		p.burstTimer.Stop()
+		p.burstTimer = nil
+		p.burstUntil = time.Time{}
+	}
+}
+
+// resize starts or retires workers so that n are running. Retired workers
+// finish the job they're running, if any, before exiting. Must be called
+// with the lock held.
+func (p *workerPool) resize(n int) {
+	if p.stopped {
+		return
+	}
+	if len(p.workers) < n {
+		p.notifySlotsFreed()
+	}
+	for len(p.workers) < n {
+		ctx, cancel := context.WithCancel(p.ctx)
+		w := &poolWorker{cancel: cancel}
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		id := p.nextID
+		p.nextID++
+		go func() {
+			defer p.wg.Done()
+			defer cancel()
+			err := runConsumerWorker(ctx, id, p)
+
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			if err != nil {
+				p.errs = append(p.errs, err)
+			}
+			for i, other := range p.workers {
+				if other == w {
+					p.workers = append(p.workers[:i], p.workers[i+1:]...)
+					break
+				}
+			}
+		}()
+	}
+	for len(p.workers) > n {
+		last := len(p.workers) - 1
+		p.workers[last].cancel()
+		p.workers = p.workers[:last]
+	}
+}
+
+// claim claims the next job for a free worker, once it's the worker's turn
+// and the job can start right away. Returns nil once the worker should
+// exit: when ctx is cancelled, or the run has finished.
+func (p *workerPool) claim(ctx context.Context) (*Job, error) {
+	select {
+	case p.claimTurn <- struct{}{}:
+	case <-ctx.Done():
+		if p.ctx.Err() != nil {
+			p.finish()
+		}
+		return nil, nil
+	}
+	defer func() { <-p.claimTurn }()
+
+	c := p.claims
+	for {
+		if p.isStopped() {
+			return nil, nil
+		}
+		if p.ctx.Err() != nil {
+			p.finish()
+			return nil, nil
+		}
+		if ctx.Err() != nil {
+			// Retired.
+			return nil, nil
+		}
+		if !c.control.wait(ctx) || !c.limiter.wait(ctx.Done()) || !c.load.wait(ctx.Done()) {
+			continue
+		}
+
+		freed := p.slotsChanged()
+		next, err := p.peek()
+		if err != nil {
+			return nil, p.fail(err)
+		}
+		if next == nil {
+			finished, err := p.idle()
+			if err != nil {
+				return nil, p.fail(err)
+			}
+			if finished {
+				p.finish()
+				return nil, nil
+			}
+			// Without --follow, only the run's own jobs finishing can make
+			// more available, or finish the run, so there's nothing to poll.
+			var poll <-chan time.Time
+			if c.follow {
+				poll = time.After(followPollInterval)
+			}
+			select {
+			case <-ctx.Done():
+			case <-freed:
+			case <-poll:
+			}
+			continue
+		}
+		p.idleSince = time.Time{}
+		p.drain.claimed()
+
+		// A job that needs more devices than the run has is claimed to fail
+		// it, rather than waiting for them forever.
+		if p.devices.fits(next) == nil && !p.fits(next) {
+			preempted := false
+			if p.preempt != nil {
+				if preempted, err = p.preempt.makeRoom(next); err != nil {
+					return nil, p.fail(err)
+				}
+			}
+			if !preempted {
+				select {
+				case <-ctx.Done():
+				case <-freed:
+				case <-time.After(followPollInterval):
+				}
+			}
+			continue
+		}
+
+		job, err := p.db.TakeNextJob(c.filter)
+		if err != nil {
+			return nil, p.fail(fmt.Errorf("failed to read next job from DB: %w", err))
+		}
+		if job == nil {
+			// Another runner took it.
+			continue
+		}
+		if err := p.devices.fits(job); err != nil {
+			p.started()
+			if err := p.db.FinishJob(int64(job.ID), int64(statusDoneFailed), -1, unknownUsage, err.Error()); err != nil {
+				return nil, p.fail(fmt.Errorf("failed to set status of job #%d: %w", job.ID, err))
+			}
+			continue
+		}
+		if !p.tryReserveSlots(job) {
+			// Another runner took the job that fit, and this one doesn't.
+			if _, err := p.db.PutBackJob(int64(job.ID), "waiting for free slots"); err != nil {
+				return nil, p.fail(fmt.Errorf("failed to put back job #%d: %w", job.ID, err))
+			}
+			continue
+		}
+		p.started()
+		return job, nil
+	}
+}
+
+// peek returns the job the run would claim next, without claiming it, or nil
+// if there's none.
+func (p *workerPool) peek() (*Job, error) {
+	next, err := p.db.PlanClaims(p.claims.filter, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read next job from DB: %w", err)
+	}
+	if len(next) == 0 {
+		return nil, nil
+	}
+	return &next[0], nil
+}
+
+// idle reports whether the run has finished, now that there's nothing to
+// claim.
+func (p *workerPool) idle() (bool, error) {
+	// Until the run's own jobs have finished, more may become available,
+	// e.g. by releasing a lock or being retried.
+	busy, err := p.db.OwnsRunningJobs(p.db.actor)
+	if err != nil {
+		return false, fmt.Errorf("failed to read running jobs from DB: %w", err)
+	}
+	if !p.claims.follow {
+		return !busy, nil
+	}
+	// The DB may show the last job finished before its worker has recorded
+	// it.
+	p.lock.Lock()
+	busy = busy || p.usedSlots > 0
+	p.lock.Unlock()
+	if busy {
+		p.idleSince = time.Time{}
+	} else if p.idleSince.IsZero() {
+		p.idleSince = time.Now()
+		p.drain.drained(p.recorder.snapshot())
+	} else if timeout := p.claims.idleTimeout; timeout > 0 && time.Since(p.idleSince) >= timeout {
+		slog.Info("stopping after being idle", "for", timeout)
+		return true, nil
+	}
+	return false, nil
+}
+
+// started records that a job was claimed.
+func (p *workerPool) started() {
+	p.claims.limiter.started()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.numJobs++
+}
+
+// NumJobs returns the number of jobs claimed.
+func (p *workerPool) NumJobs() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.numJobs
+}
+
+// finish stops the pool once the run has nothing left to do, so the
+// remaining workers exit as they finish their jobs.
+func (p *workerPool) finish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stopped = true
+	p.cancelBurst()
+	for _, w := range p.workers {
+		w.cancel()
+	}
+}
+
+// fail finishes the pool after an error claiming jobs, and returns it.
+func (p *workerPool) fail(err error) error {
+	p.finish()
+	return err
+}
+
+func (p *workerPool) isStopped() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stopped
+}
+
+// watchPreemption makes room for urgent jobs while every worker is busy, so
+// none is claiming them, until ctx is cancelled.
+func (p *workerPool) watchPreemption(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followPollInterval):
+		}
+		select {
+		case p.claimTurn <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		err := p.checkPreemption(ctx)
+		<-p.claimTurn
+		if err != nil {
+			p.lock.Lock()
+			p.errs = append(p.errs, err)
+			p.lock.Unlock()
+			p.finish()
+			return
+		}
+	}
+}
+
+// checkPreemption preempts a running job if the next job doesn't fit. Must
+// be called on the claiming turn.
+func (p *workerPool) checkPreemption(ctx context.Context) error {
+	c := p.claims
+	if p.isStopped() || c.control.isPaused() || !c.limiter.wait(ctx.Done()) || !c.load.wait(ctx.Done()) {
+		return nil
+	}
+	next, err := p.peek()
+	if err != nil || next == nil || p.devices.fits(next) != nil || p.fits(next) {
+		return err
+	}
+	_, err = p.preempt.makeRoom(next)
+	return err
+}
+
+// slotsNeeded returns the number of slots job occupies. Must be called with
+// the lock held.
+func (p *workerPool) slotsNeeded(job *Job) int {
+	return min(max(job.Slots, 1), max(len(p.workers), 1))
+}
+
+// fits reports whether the slots and devices job needs are free.
+func (p *workerPool) fits(job *Job) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.workers)-p.usedSlots >= p.slotsNeeded(job) && p.devices.available(job)
+}
+
+// tryReserveSlots reserves the slots job needs, and assigns it the devices
+// it needs, if they're free.
+func (p *workerPool) tryReserveSlots(job *Job) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := p.slotsNeeded(job)
+	if len(p.workers)-p.usedSlots < n || !p.devices.take(job) {
+		return false
+	}
+	p.slots[job.ID] += n
+	p.usedSlots += n
+	return true
+}
+
+// slotsChanged returns a channel that's closed when slots next come free.
+func (p *workerPool) slotsChanged() <-chan struct{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.slotsFreed
+}
+
+// releaseSlots frees the slots and devices of a job a worker has finished.
+func (p *workerPool) releaseSlots(job *Job) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.usedSlots -= p.slots[job.ID]
+	delete(p.slots, job.ID)
+	delete(p.preempted, job.ID)
+	p.devices.release(job)
+	p.notifySlotsFreed()
+}
+
+// Must be called with the lock held.
+func (p *workerPool) notifySlotsFreed() {
+	close(p.slotsFreed)
+	p.slotsFreed = make(chan struct{})
+}
+
+// markPreempted records that a job was requeued for a more urgent one, and
+// will free its slots once it exits.
+func (p *workerPool) markPreempted(id int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.preempted[id] = true
+}
+
+// preempting reports whether a job requeued for a more urgent one is still
+// exiting, so nothing more needs preempting yet.
+func (p *workerPool) preempting() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.preempted) > 0
+}
+
+// runExtra runs a job on a worker of its own, outside the pool's size and
+// slots, and calls done once it has finished. Returns false, without running
+// it, if the pool has stopped.
+func (p *workerPool) runExtra(job *Job, done func()) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return false
+	}
+	id := p.nextID
+	p.nextID++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := runWorkerJob(p.db.WithActor(fmt.Sprintf("%s worker %d", p.db.actor, id)), p.cfg, job, p.recorder, p.tracer, p.report)
+		done()
+
+		if err != nil {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			p.errs = append(p.errs, err)
+		}
+	}()
+	return true
+}
+
+// Wait waits for all workers to exit, which they do once the run has
+// finished or stopped taking jobs, and returns their errors.
+func (p *workerPool) Wait() []error {
+	p.wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.errs
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"syscall"
+)
+
+// With `run --preempt`, a job that outranks one of the run's running jobs
+// doesn't wait for a worker to free up when they're all busy: the
+// lowest-priority running job is either suspended until the urgent job has
+// finished on a worker of its own, or killed and put back in the queue to
+// start over later. Only jobs running as local processes are preempted.
+
+// Preemption modes.
+const (
+	preemptSuspend = "suspend"
+	preemptRequeue = "requeue"
+)
+
+// preemptedJobs holds the IDs of jobs killed to make room for more urgent
+// ones, which are put back in the queue rather than marked failed.
+var preemptedJobs = &jobSet{ids: map[int]bool{}}
+
+type jobSet struct {
+	lock sync.Mutex
+	ids  map[int]bool
+}
+
+func (s *jobSet) add(id int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ids[id] = true
+}
+
+// take removes id from the set, returning true if it was there.
+func (s *jobSet) take(id int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ids[id] {
+		return false
+	}
+	delete(s.ids, id)
+	return true
+}
+
+// PreemptionVictim returns the running job leased to owner that would be
+// preempted for a job with the given priority: the lowest-priority one below
+// it running as a local process, and of those the one started last, which
+// loses the least work. Returns nil if there's none.
+func (db *DB) PreemptionVictim(owner string, priority int) (*Job, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	job, err := scanJob(db.QueryRow(`
+	SELECT `+jobColumns+` FROM jobs
+	WHERE lease_owner = ? AND status = ? AND priority < ?
+	AND pid > 0 AND image = '' AND host = ''
+	ORDER BY priority ASC, started_at DESC, id DESC
+	LIMIT 1`, owner, statusInProgress, priority))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// PutBackJob returns a running job to the queue, recording why in its
+// requeued event. Returns false if the job wasn't running.
+func (db *DB) PutBackJob(id int64, reason string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// A job put back before its process started hasn't made an attempt.
+	if err := recordAttempts(tx, reason, "id = ? AND status = ? AND pid != 0", id, statusInProgress); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(`
+	UPDATE jobs SET status = ?, pid = 0, started_at = 0, worker = '', lease_owner = '', lease_expires_at = 0,
+		attempts = CASE WHEN pid = 0 THEN attempts - 1 ELSE attempts END
+	WHERE id = ? AND status = ?`,
+		statusPending, id, statusInProgress)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := recordEvents(tx, db.actor, eventRequeued, reason, "id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// preempter makes room for a run's urgent jobs by preempting its running
+// ones when the slots they need are busy.
+type preempter struct {
+	mode   string
+	db     *DB
+	pool   *workerPool
+	filter claimFilter
+}
+
+// makeRoom preempts the lowest-priority running job below next, the job the
+// run would claim next, which doesn't fit in the free slots. In requeue
+// mode, the job's worker claims next once the job has exited; in suspend
+// mode, a job more urgent than it is claimed and started on a worker of its
+// own. Returns true if a job was preempted.
+func (p *preempter) makeRoom(next *Job) (bool, error) {
+	if p.pool.preempting() {
+		return false, nil
+	}
+	if p.mode == preemptSuspend && len(next.Resources) > 0 {
+		// Suspended jobs keep their devices, so there are none to run it on.
+		return false, nil
+	}
+	victim, err := p.db.PreemptionVictim(p.db.actor, next.Priority)
+	if err != nil {
+		return false, fmt.Errorf("failed to read running jobs from DB: %w", err)
+	}
+	if victim == nil {
+		return false, nil
+	}
+	if p.mode == preemptRequeue {
+		return p.requeue(victim, next)
+	}
+
+	job, err := p.db.TakeJobAbove(p.filter, victim.Priority)
+	if err != nil {
+		return false, fmt.Errorf("failed to read next job from DB: %w", err)
+	}
+	if job == nil {
+		return false, nil
+	}
+	suspended := false
+	if len(job.Resources) == 0 {
+		suspended, err = p.suspend(victim, job)
+	}
+	if !suspended {
+		// Another runner took next, or the victim finished, in the meantime.
+		if _, err := p.db.PutBackJob(int64(job.ID), "no job to preempt"); err != nil {
+			return false, fmt.Errorf("failed to put back job #%d: %w", job.ID, err)
+		}
+		return false, err
+	}
+	p.pool.started()
+	return true, nil
+}
+
+// requeue puts victim back in the queue and kills it, so its worker claims
+// job once it has exited.
+func (p *preempter) requeue(victim, job *Job) (bool, error) {
+	// Put it back first, so it isn't marked failed once killed.
+	ok, err := p.db.PutBackJob(int64(victim.ID), fmt.Sprintf("preempted by #%d", job.ID))
+	if err != nil {
+		return false, fmt.Errorf("failed to requeue job #%d: %w", victim.ID, err)
+	}
+	if !ok {
+		// It finished in the meantime.
+		return false, nil
+	}
+	p.pool.markPreempted(victim.ID)
+	preemptedJobs.add(victim.ID)
+	if err := signalJob(victim.PID, syscall.SIGTERM); err != nil {
+		slog.Warn("failed to stop preempted job", "id", victim.ID, "err", err)
+	}
+	slog.Info("requeued job for a more urgent one", "id", victim.ID, "by", job.ID)
+	return true, nil
+}
+
+// suspend stops victim until job, which has been claimed, has finished on a
+// worker of its own.
+func (p *preempter) suspend(victim, job *Job) (bool, error) {
+	if err := signalJob(victim.PID, sigStop); err != nil {
+		return false, fmt.Errorf("failed to suspend job #%d: %w", victim.ID, err)
+	}
+	resume := func() {
+		if err := signalJob(victim.PID, sigCont); err != nil {
+			slog.Warn("failed to resume preempted job", "id", victim.ID, "err", err)
+		}
+		if _, err := p.db.SetJobSuspended(int64(victim.ID), false); err != nil {
+			slog.Error("failed to update job", "id", victim.ID, "err", err)
+		}
+	}
+	if ok, err := p.db.SetJobSuspended(int64(victim.ID), true); err != nil || !ok {
+		signalJob(victim.PID, sigCont)
+		if err != nil {
+			return false, fmt.Errorf("failed to suspend job #%d: %w", victim.ID, err)
+		}
+		return false, nil
+	}
+	if !p.pool.runExtra(job, func() {
+		resume()
+		slog.Info("resumed preempted job", "id", victim.ID)
+	}) {
+		resume()
+		return false, nil
+	}
+	slog.Info("suspended job for a more urgent one", "id", victim.ID, "by", job.ID)
+	return true, nil
+}
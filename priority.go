@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+type prioritySet struct {
+	globalArgs
+	filter   JobFilter
+	priority int
+}
+
+func parsePrioritySubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 || args[0] != "set" {
+		return nil, fmt.Errorf("priority command required: set")
+	}
+
+	var tags stringList
+	var statuses statusList
+	var queue string
+	fs := flag.NewFlagSet(priorityCommandName+" set", flag.ContinueOnError)
+	fs.Var(&tags, "tag", "only update jobs with this tag; may be repeated")
+	fs.Var(&statuses, "status", "only update jobs with this status; may be repeated")
+	fs.StringVar(&queue, "queue", "", "only update jobs in this queue")
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: priority to set")
+	}
+	priority, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority: '%s'", fs.Arg(0))
+	}
+
+	filter := JobFilter{Statuses: statuses, Tags: tags, Queue: queue}
+	if filter.IsEmpty() {
+		return nil, fmt.Errorf("at least one of --tag, --status or --queue is required")
+	}
+	return prioritySet{globalArgs: globals, filter: filter, priority: priority}, nil
+}
+
+func (cmd prioritySet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	n, err := db.SetPriority(cmd.filter, cmd.priority)
+	if err != nil {
+		return fmt.Errorf("failed to set priority: %w", err)
+	}
+	slog.Info("set priority", "count", n, "priority", cmd.priority)
+	return nil
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Jobs run in their own process groups, so signals reach every process of
+// the job.
+
+// Signals that suspend and resume a job.
+const (
+	sigStop = syscall.SIGSTOP
+	sigCont = syscall.SIGCONT
+)
+
+// shellCommand returns the command running line with the shell.
+func shellCommand(line string) *exec.Cmd {
+	return exec.Command("sh", "-c", line)
+}
+
+// scriptCommand returns the command running script, written to the file at
+// path, with its interpreter line if it has one, or the shell.
+func scriptCommand(path, script string) *exec.Cmd {
+	if strings.HasPrefix(script, "#!") {
+		return exec.Command(path)
+	}
+	return exec.Command("sh", path)
+}
+
+// editorCommand returns the command opening the file at path in editor,
+// which may come with arguments, like "code --wait".
+func editorCommand(editor, path string) *exec.Cmd {
+	return exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+}
+
+// Suffix of the files scripts are written to.
+const scriptFileSuffix = ""
+
+// setJobProcAttr starts the job in its own process group.
+func setJobProcAttr(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// How long processes a job left behind get to exit after SIGTERM before
+// they're killed.
+const leftoverGrace = 5 * time.Second
+
+// trackJobProcess is called once a job's process has started, and returns a
+// function to call once it has exited, which stops the processes it left
+// running in its group, like `server &`, so they don't outlive the job.
+func trackJobProcess(pid int) (func(), error) {
+	return func() {
+		if syscall.Kill(-pid, syscall.SIGTERM) != nil {
+			// The group is empty.
+			return
+		}
+		slog.Debug("stopping processes left behind by job", "pgid", pid)
+		go func() {
+			time.Sleep(leftoverGrace)
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}()
+	}, nil
+}
+
+// signalJob sends sig to the process group of a job's process, or just to
+// the process if it isn't a group leader, e.g. because it was started by an
+// older version of chime.
+func signalJob(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		err = syscall.Kill(pid, sig)
+	}
+	return err
+}
+
+// processExists reports whether a process with the PID is running on this
+// machine.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// setNiceness sets the niceness of a process.
+func setNiceness(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// maxRSS returns the peak RSS in KiB of an exited process, or -1 if it isn't
+// known.
+func maxRSS(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return -1
+	}
+	// macOS reports it in bytes, other platforms in KiB.
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss) / 1024
+	}
+	return int64(rusage.Maxrss)
+}
+
+// freeDiskSpace returns the bytes available to unprivileged users on the
+// filesystem holding dir.
+func freeDiskSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
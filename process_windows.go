@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// On Windows, jobs run in their own process groups, which get CTRL_BREAK
+// rather than Ctrl+C, and in Job Objects, so the whole tree of processes a
+// job starts can be killed at once. Jobs can't be suspended, and their peak
+// RSS isn't recorded.
+
+// Windows has no such signals; signalJob refuses them.
+const (
+	sigStop = syscall.Signal(0x13)
+	sigCont = syscall.Signal(0x12)
+)
+
+// Exit code of a process that's still running.
+const stillActive = 259
+
+// shell returns the path of cmd.exe.
+func shell() string {
+	if comspec := os.Getenv("ComSpec"); comspec != "" {
+		return comspec
+	}
+	return "cmd.exe"
+}
+
+// shellCommand returns the command running line with cmd.exe. The line is
+// passed on as is, since cmd.exe doesn't unquote arguments the way other
+// programs do.
+func shellCommand(line string) *exec.Cmd {
+	cmd := exec.Command(shell())
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: syscall.EscapeArg(cmd.Path) + ` /d /s /c "` + line + `"`}
+	return cmd
+}
+
+// scriptCommand returns the command running script, written to the file at
+// path, with its interpreter line if it has one, like "#!/usr/bin/env
+// python3", or as a batch file.
+func scriptCommand(path, script string) *exec.Cmd {
+	line, _, _ := strings.Cut(script, "\n")
+	if interp, ok := strings.CutPrefix(strings.TrimSpace(line), "#!"); ok {
+		// Unix paths like /usr/bin/python3 don't exist here; look the
+		// interpreter up in PATH instead.
+		fields := strings.Fields(interp)
+		if len(fields) > 1 && filepath.Base(filepath.ToSlash(fields[0])) == "env" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			name := filepath.Base(filepath.ToSlash(fields[0]))
+			return exec.Command(name, append(fields[1:], path)...)
+		}
+	}
+	return shellCommand(syscall.EscapeArg(path))
+}
+
+// editorCommand returns the command opening the file at path in editor,
+// which may come with arguments, like "code --wait".
+func editorCommand(editor, path string) *exec.Cmd {
+	return shellCommand(editor + " " + syscall.EscapeArg(path))
+}
+
+// Suffix of the files scripts are written to, which cmd.exe needs to run
+// them.
+const scriptFileSuffix = ".cmd"
+
+// setJobProcAttr starts the job in its own process group.
+func setJobProcAttr(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// jobObjects holds the Job Objects of the jobs this process is running, by
+// the pids of their processes.
+var jobObjects = struct {
+	lock    sync.Mutex
+	handles map[int]windows.Handle
+}{handles: map[int]windows.Handle{}}
+
+// trackJobProcess puts a job's process in a Job Object, which the processes
+// it starts are in too, and returns a function to call once it has exited,
+// which kills the processes it left running. Processes it starts before it's
+// put in one aren't.
+func trackJobProcess(pid int) (func(), error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return func() {}, err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return func() {}, err
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		windows.CloseHandle(job)
+		return func() {}, err
+	}
+
+	jobObjects.lock.Lock()
+	jobObjects.handles[pid] = job
+	jobObjects.lock.Unlock()
+	return func() {
+		jobObjects.lock.Lock()
+		delete(jobObjects.handles, pid)
+		jobObjects.lock.Unlock()
+		windows.TerminateJobObject(job, 1)
+		windows.CloseHandle(job)
+	}, nil
+}
+
+// signalJob sends a job's process group CTRL_BREAK for SIGINT, letting it
+// shut down, and kills the job's processes for other signals: all of them if
+// this process started it, otherwise just its first.
+func signalJob(pid int, sig syscall.Signal) error {
+	switch sig {
+	case sigStop, sigCont:
+		return errors.New("suspending jobs isn't supported on Windows")
+	case syscall.SIGINT:
+		return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+	}
+
+	jobObjects.lock.Lock()
+	job, ok := jobObjects.handles[pid]
+	jobObjects.lock.Unlock()
+	if ok {
+		return windows.TerminateJobObject(job, 1)
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(process)
+	return windows.TerminateProcess(process, 1)
+}
+
+// processExists reports whether a process with the PID is running on this
+// machine.
+func processExists(pid int) bool {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(process)
+	var code uint32
+	if err := windows.GetExitCodeProcess(process, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// setNiceness sets the priority class of a process closest to a niceness.
+func setNiceness(pid, nice int) error {
+	var class uint32
+	switch {
+	case nice <= -15:
+		class = windows.HIGH_PRIORITY_CLASS
+	case nice < 0:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	case nice == 0:
+		class = windows.NORMAL_PRIORITY_CLASS
+	case nice < 15:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	default:
+		class = windows.IDLE_PRIORITY_CLASS
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(process)
+	return windows.SetPriorityClass(process, class)
+}
+
+// maxRSS returns -1, since the peak RSS of an exited process isn't known.
+func maxRSS(state *os.ProcessState) int64 {
+	return -1
+}
+
+// freeDiskSpace returns the bytes available to the user on the volume
+// holding dir.
+func freeDiskSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, &total, &totalFree); err != nil {
+		return 0, fmt.Errorf("failed to read free disk space: %w", err)
+	}
+	return int64(free), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jobs run locally can report how far along they are by writing lines like
+//
+//	##chime-progress 42% building
+//
+// to the file named by $CHIME_PROGRESS_FILE, e.g. with
+// `echo '##chime-progress 42% building' >> "$CHIME_PROGRESS_FILE"`. The
+// message after the percentage is optional. The runner reads the file while
+// the job runs and records the latest report with the job, which list, show
+// and top show. A file rather than a file descriptor works the same way on
+// every platform and in containers, and keeps reports out of the job's
+// output. Other lines in the file are ignored.
+
+const chimeProgressFileEnvKey = "CHIME_PROGRESS_FILE"
+
+// How often a running job's progress file is read.
+const progressPollInterval = time.Second
+
+// Longest progress message recorded; longer ones are cut.
+const maxProgressMessage = 200
+
+var progressLine = regexp.MustCompile(`^##chime-progress\s+(\d{1,3})%\s*(.*)$`)
+
+// jobProgress is a job's report of how far along it is.
+type jobProgress struct {
+	// Percent done, from 0 to 100.
+	Percent int    `json:"percent"`
+	Message string `json:"message,omitempty"`
+}
+
+// parseProgressLine parses a progress report, returning false if line isn't
+// one.
+func parseProgressLine(line string) (jobProgress, bool) {
+	m := progressLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return jobProgress{}, false
+	}
+	percent, err := strconv.Atoi(m[1])
+	if err != nil || percent > 100 {
+		return jobProgress{}, false
+	}
+	message := strings.TrimSpace(m[2])
+	if len(message) > maxProgressMessage {
+		message = strings.ToValidUTF8(message[:maxProgressMessage], "")
+	}
+	return jobProgress{percent, message}, true
+}
+
+// SetJobProgress records a job's latest progress report. A negative percent
+// clears it.
+func (db *DB) SetJobProgress(jobID int64, percent int, message string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`UPDATE jobs SET progress = ?, progress_message = ? WHERE id = ?`, percent, message, jobID)
+	return err
+}
+
+// progressWatcher reads a job's progress file as the job writes it.
+type progressWatcher struct {
+	db     *DB
+	jobID  int64
+	f      *os.File
+	offset int64
+	// Part of a line the job hasn't finished writing yet.
+	partial []byte
+	last    jobProgress
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+func startProgressWatcher(db *DB, jobID int64, path string) (*progressWatcher, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &progressWatcher{db: db, jobID: jobID, f: f, last: jobProgress{Percent: -1}, stop: make(chan struct{})}
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		ticker := time.NewTicker(progressPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.poll(false)
+			}
+		}
+	}()
+	return w, nil
+}
+
+// poll records the latest report written since the last poll, if there is
+// one and it's changed. Once final, the last line counts even if it's
+// unfinished.
+func (w *progressWatcher) poll(final bool) {
+	data, err := io.ReadAll(io.NewSectionReader(w.f, w.offset, 1<<62))
+	if err != nil {
+		slog.Warn("failed to read job progress", "id", w.jobID, "err", err)
+		return
+	}
+	w.offset += int64(len(data))
+	data = append(w.partial, data...)
+	if final {
+		data = append(data, '\n')
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	w.partial = append([]byte(nil), data[end+1:]...)
+
+	latest, found := w.last, false
+	for _, line := range strings.Split(string(data[:end+1]), "\n") {
+		if p, ok := parseProgressLine(line); ok {
+			latest, found = p, true
+		}
+	}
+	if !found || latest == w.last {
+		return
+	}
+	if err := w.db.SetJobProgress(w.jobID, latest.Percent, latest.Message); err != nil {
+		slog.Warn("failed to record job progress", "id", w.jobID, "err", err)
+		return
+	}
+	w.last = latest
+}
+
+// Close stops watching the file, after recording any last report.
+func (w *progressWatcher) Close() error {
+	close(w.stop)
+	w.done.Wait()
+	w.poll(true)
+	return w.f.Close()
+}
+
+// execJobReportingProgress runs a job like execJob, recording the progress
+// it reports if it runs locally. Its last execution's progress is cleared
+// first.
+func execJobReportingProgress(db *DB, cfg execConfig, job *Job) (*jobResult, error) {
+	if !cfg.runsLocally(job) {
+		return execJob(db, cfg, job)
+	}
+	if err := db.SetJobProgress(int64(job.ID), -1, ""); err != nil {
+		slog.Warn("failed to clear job progress", "id", job.ID, "err", err)
+	}
+	f, err := os.CreateTemp("", fmt.Sprintf("chime-progress-%d-*", job.ID))
+	if err != nil {
+		slog.Warn("failed to create job progress file; its progress won't be recorded", "id", job.ID, "err", err)
+		return execJob(db, cfg, job)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	w, err := startProgressWatcher(db, int64(job.ID), f.Name())
+	if err != nil {
+		slog.Warn("failed to watch job progress file; its progress won't be recorded", "id", job.ID, "err", err)
+		return execJob(db, cfg, job)
+	}
+	cfg.progressFile = f.Name()
+	result, err := execJob(db, cfg, job)
+	w.Close()
+	return result, err
+}
+
+// formatProgress renders a job's latest progress report as a bar, or returns
+// "" if it hasn't reported any.
+func formatProgress(job Job) string {
+	if job.Progress < 0 {
+		return ""
+	}
+	const width = 10
+	filled := job.Progress * width / 100
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	out := fmt.Sprintf("%s %d%%", bar, job.Progress)
+	if job.ProgressMessage != "" {
+		out += " " + job.ProgressMessage
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Each worker of a `chime run`, and each `chime take`, records itself in the
+// workers table while it runs, and the run's heartbeat updates when it was
+// last seen along with its jobs' leases, so `chime ps` can show from another
+// terminal which workers are alive and what they're running. Workers remove
+// themselves when they exit; one not seen for a lease period has died
+// without doing so, and is removed once it's been gone for a day.
+
+// How long after it was last seen a worker is considered gone.
+const workerStaleAfter = defaultLease
+
+// How long gone workers are kept, so ps can show runs that died.
+const workerRetention = 24 * time.Hour
+
+// WorkerStatus is a worker's latest heartbeat, and the job it's running.
+type WorkerStatus struct {
+	Name string
+	// Run the worker belongs to, which keeps it alive; see heartbeat.
+	Run       string
+	Host      string
+	PID       int
+	StartedAt int64
+	LastSeen  int64
+	// The running job, if any.
+	JobID      int
+	JobCommand string
+}
+
+func (w WorkerStatus) alive(now time.Time) bool {
+	return now.Sub(time.UnixMilli(w.LastSeen)) < workerStaleAfter
+}
+
+// RegisterWorker records the named worker of the run as alive, and removes
+// workers that have been gone for longer than workerRetention.
+func (db *DB) RegisterWorker(name, run string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	now := time.Now().UnixMilli()
+	if _, err := db.Exec(`DELETE FROM workers WHERE last_seen < ?`, now-workerRetention.Milliseconds()); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+	INSERT OR REPLACE INTO workers (name, run, host, pid, started_at, last_seen) VALUES (?,?,?,?,?,?)`,
+		name, run, host, os.Getpid(), now, now)
+	return err
+}
+
+// UnregisterWorker removes a worker that's exiting.
+func (db *DB) UnregisterWorker(name string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`DELETE FROM workers WHERE name = ?`, name)
+	return err
+}
+
+// TouchWorkers records the run's workers as seen now.
+func (db *DB) TouchWorkers(run string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`UPDATE workers SET last_seen = ? WHERE run = ?`, time.Now().UnixMilli(), run)
+	return err
+}
+
+// ListWorkers returns every recorded worker, with the job it's running, in
+// the order they started.
+func (db *DB) ListWorkers() ([]WorkerStatus, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT w.name, w.run, w.host, w.pid, w.started_at, w.last_seen, coalesce(j.id, 0), coalesce(j.command, '')
+	FROM workers AS w
+	LEFT JOIN jobs AS j ON j.worker = w.name AND j.status = ?
+	ORDER BY w.started_at, w.name`, statusInProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []WorkerStatus
+	for rows.Next() {
+		var w WorkerStatus
+		if err := rows.Scan(&w.Name, &w.Run, &w.Host, &w.PID, &w.StartedAt, &w.LastSeen, &w.JobID, &w.JobCommand); err != nil {
+			return workers, err
+		}
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
+
+// registerWorker is RegisterWorker for db's actor, returning a func that
+// unregisters it. Failures are only logged, since ps is just for show.
+func registerWorker(db *DB, run string) func() {
+	if err := db.RegisterWorker(db.actor, run); err != nil {
+		slog.Warn("failed to record worker", "worker", db.actor, "err", err)
+		return func() {}
+	}
+	return func() {
+		if err := db.UnregisterWorker(db.actor); err != nil {
+			slog.Warn("failed to remove worker", "worker", db.actor, "err", err)
+		}
+	}
+}
+
+type ps struct {
+	globalArgs
+	all bool
+}
+
+func parsePsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := ps{globalArgs: globals}
+	fs := flag.NewFlagSet(psCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.all, "all", false, "also list workers that stopped sending heartbeats without exiting cleanly")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument: '%s'", fs.Arg(0))
+	}
+	return cmd, nil
+}
+
+func (cmd ps) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	workers, err := db.ListWorkers()
+	if err != nil {
+		return fmt.Errorf("failed to list workers: %w", err)
+	}
+	now := time.Now()
+	var shown []WorkerStatus
+	gone := 0
+	for _, w := range workers {
+		if w.alive(now) || cmd.all {
+			shown = append(shown, w)
+		} else {
+			gone++
+		}
+	}
+	if gone > 0 {
+		slog.Info("some workers stopped sending heartbeats; see ps --all", "count", gone)
+	}
+	if len(shown) == 0 {
+		slog.Info("no workers are running")
+		return nil
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+	goneStyle := cellStyle.Foreground(lipgloss.Color("#ff0000"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(shown) {
+				return headerStyle
+			}
+			if !shown[row].alive(now) {
+				return goneStyle
+			}
+			return cellStyle
+		}).
+		Headers("WORKER", "HOST", "PID", "UP", "LAST SEEN", "JOB", "COMMAND")
+	for _, w := range shown {
+		job, command := "idle", "-"
+		if w.JobID != 0 {
+			job, command = strconv.Itoa(w.JobID), w.JobCommand
+		}
+		lastSeen := now.Sub(time.UnixMilli(w.LastSeen)).Round(time.Second).String() + " ago"
+		if !w.alive(now) {
+			lastSeen += " (gone)"
+		}
+		t.Row(
+			w.Name,
+			w.Host,
+			strconv.Itoa(w.PID),
+			now.Sub(time.UnixMilli(w.StartedAt)).Round(time.Second).String(),
+			lastSeen,
+			job,
+			command,
+		)
+	}
+	fmt.Println(t)
+	return nil
+}
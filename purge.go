@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type purge struct {
+	globalArgs
+	olderThan time.Duration
+	statuses  []int
+}
+
+type purgeAuto struct {
+	globalArgs
+	// Zero disables auto-purging.
+	olderThan time.Duration
+}
+
+func parsePurgeSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	var olderThan, auto string
+	var statuses statusList
+	fs := flag.NewFlagSet(purgeCommandName, flag.ContinueOnError)
+	fs.StringVar(&olderThan, "older-than", "", "only purge jobs that finished longer ago than this, e.g. 30d")
+	fs.Var(&statuses, "status", "only purge jobs with this status (default: done); may be repeated")
+	fs.StringVar(&auto, "auto", "", "instead of purging now, purge jobs older than this when `chime run` starts; 'off' disables")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if auto != "" {
+		if olderThan != "" || len(statuses) > 0 {
+			return nil, fmt.Errorf("--auto can't be combined with --older-than or --status")
+		}
+		cmd := purgeAuto{globalArgs: globals}
+		if auto != "off" {
+			age, err := parseAge(auto)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --auto: %w", err)
+			}
+			cmd.olderThan = age
+		}
+		return cmd, nil
+	}
+
+	cmd := purge{globalArgs: globals, statuses: statuses}
+	if len(cmd.statuses) == 0 {
+		cmd.statuses = terminalStatuses
+	}
+	for _, s := range cmd.statuses {
+		if !isTerminal(s) {
+			return nil, fmt.Errorf("purge only removes finished jobs, not %s ones", statusNames[s])
+		}
+	}
+	if olderThan != "" {
+		age, err := parseAge(olderThan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --older-than: %w", err)
+		}
+		cmd.olderThan = age
+	}
+	return cmd, nil
+}
+
+// parseAge parses a duration, additionally accepting whole days and weeks
+// like "30d" or "2w".
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration '%s'", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+// purgeJobs deletes finished jobs in the given statuses that finished longer
+// ago than olderThan, and logs how many were deleted.
+func purgeJobs(db *DB, statuses []int, olderThan time.Duration) error {
+	filter := JobFilter{Statuses: statuses}
+	if olderThan > 0 {
+		filter.FinishedBefore = time.Now().Add(-olderThan).UnixMilli()
+	}
+	counts, err := db.PurgeJobs(filter)
+	if err != nil {
+		return fmt.Errorf("failed to purge jobs: %w", err)
+	}
+
+	var total int
+	var parts []string
+	for _, s := range allStatuses {
+		if counts[s] > 0 {
+			total += counts[s]
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], statusNames[s]))
+		}
+	}
+	if total == 0 {
+		slog.Info("purged jobs", "count", 0)
+		return nil
+	}
+	slog.Info("purged jobs", "count", total, "statuses", strings.Join(parts, ", "))
+	return nil
+}
+
+// autoPurge applies the auto-purge setting, if there is one.
+func autoPurge(db *DB) error {
+	value, ok, err := db.GetSetting(settingAutoPurge)
+	if err != nil || !ok {
+		return err
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid auto-purge setting '%s': %w", value, err)
+	}
+	return purgeJobs(db, terminalStatuses, age)
+}
+
+func (cmd purge) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	return purgeJobs(db, cmd.statuses, cmd.olderThan)
+}
+
+func (cmd purgeAuto) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.olderThan == 0 {
+		if err := db.DeleteSetting(settingAutoPurge); err != nil {
+			return err
+		}
+		slog.Info("disabled auto-purge")
+		return nil
+	}
+	if err := db.SetSetting(settingAutoPurge, cmd.olderThan.String()); err != nil {
+		return err
+	}
+	slog.Info("jobs will be purged when `chime run` starts", "older_than", cmd.olderThan.String())
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimit is how many jobs a runner may start per window, e.g. 10/1m, as
+// given to `run --rate`. The zero value is unlimited.
+type rateLimit struct {
+	jobs   int
+	window time.Duration
+}
+
+func (r *rateLimit) String() string {
+	if r.jobs == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", r.jobs, r.window)
+}
+
+func (r *rateLimit) Set(s string) error {
+	jobs, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return fmt.Errorf("must be jobs/window, e.g. 10/1m")
+	}
+	n, err := strconv.Atoi(jobs)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid number of jobs: '%s'", jobs)
+	}
+	// Allow e.g. 10/m for 10/1m.
+	d, err := time.ParseDuration(window)
+	if err != nil && window != "" && (window[0] < '0' || window[0] > '9') {
+		d, err = time.ParseDuration("1" + window)
+	}
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid window: '%s'", window)
+	}
+	r.jobs, r.window = n, d
+	return nil
+}
+
+// rateLimiter spaces out job starts to stay within a rateLimit over any
+// sliding window.
+type rateLimiter struct {
+	limit rateLimit
+	// Times of the most recent starts, oldest first; at most limit.jobs.
+	starts []time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit}
+}
+
+// wait blocks until another job may start. Returns false if stop was closed
+// first.
+func (l *rateLimiter) wait(stop <-chan struct{}) bool {
+	if l.limit.jobs == 0 || len(l.starts) < l.limit.jobs {
+		return true
+	}
+	if d := time.Until(l.starts[0].Add(l.limit.window)); d > 0 {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(d):
+		}
+	}
+	return true
+}
+
+// started records that a job has started.
+func (l *rateLimiter) started() {
+	if l.limit.jobs == 0 {
+		return
+	}
+	if len(l.starts) == l.limit.jobs {
+		l.starts = l.starts[1:]
+	}
+	l.starts = append(l.starts, time.Now())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// In read-only mode, set with --read-only or $CHIME_READ_ONLY, only commands
+// that query jobs are allowed, and every connection to the DB is made
+// query-only, so nothing that slips through can change it either. The DB
+// isn't migrated, so an older DB must be migrated by someone else first.
+//
+// This keeps teammates from changing a queue by accident; they can still
+// run chime without it. To enforce it, give them read access to the DB
+// file only.
+
+const chimeReadOnlyEnvKey = "CHIME_READ_ONLY"
+
+// Whether chime is in read-only mode; set by main.
+var readOnly bool
+
+// readOnlyFromEnv returns whether $CHIME_READ_ONLY turns on read-only mode.
+func readOnlyFromEnv() (bool, error) {
+	value, ok := os.LookupEnv(chimeReadOnlyEnvKey)
+	if !ok || value == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid $%s: '%s' (expected true or false)", chimeReadOnlyEnvKey, value)
+	}
+	return on, nil
+}
+
+// isQueryCommand reports whether cmd only reads the DB, so can run in
+// read-only mode.
+func isQueryCommand(cmd subcommand) bool {
+	switch cmd.(type) {
+	case list, show, search, history, events, stats, timeline, report, top, metrics,
+		runs, runsShow, batchStatus, batchWait, dlqList, hostsList, limitList,
+		templateList, weightList, agingList, artifacts, export, aliasList, ps, wait:
+		return true
+	}
+	return false
+}
+
+// checkReadOnlySchema returns an error if the DB needs migrating, which
+// read-only mode doesn't allow.
+func checkReadOnlySchema(db *DB) error {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the DB needs migrating to this version of chime, which can't be done in read-only mode; run chime migrate without --read-only")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// A runner that crashes leaves its jobs running in the DB. Their leases stop
+// being renewed, and running jobs are put back in the queue once theirs
+// expire, but suspended jobs, and jobs claimed before leases were added,
+// stay running forever. `chime run --recover` sweeps these up as the run
+// starts: jobs running or suspended without a live lease, or leased to a
+// runner on this machine whose process has exited, are put back in the
+// queue with requeue, or finished as failed with fail, which retries or
+// dead-letters them like any other failed job. With ignore, the default,
+// they're left as they are.
+
+const (
+	recoverRequeue = "requeue"
+	recoverFail    = "fail"
+	recoverIgnore  = "ignore"
+)
+
+// Failure of jobs finished by --recover fail.
+const recoveredFailure = "its runner stopped without finishing it"
+
+// RecoverJobs requeues or fails, as mode says, the jobs left running by
+// runners that stopped: running or suspended jobs whose leases have expired,
+// and those leased to one of deadRunners. Returns the number of jobs.
+func (db *DB) RecoverJobs(mode string, deadRunners []string) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	where := "status IN (?, ?) AND (lease_expires_at < ?"
+	args := []any{statusInProgress, statusSuspended, now}
+	if len(deadRunners) > 0 {
+		where += " OR lease_owner IN (" + placeholders(len(deadRunners)) + ")"
+		for _, runner := range deadRunners {
+			args = append(args, runner)
+		}
+	}
+	where += ")"
+
+	switch mode {
+	case recoverRequeue:
+		if err := recordAttempts(tx, attemptRunnerStopped, where, args...); err != nil {
+			return 0, err
+		}
+		if err := recordEvents(tx, db.actor, eventRequeued, "recovered: "+recoveredFailure, where, args...); err != nil {
+			return 0, err
+		}
+		result, err := tx.Exec(`UPDATE jobs SET status = ?, pid = 0, started_at = 0, lease_owner = '', lease_expires_at = 0, worker = ''
+		WHERE `+where, append([]any{statusPending}, args...)...)
+		if err != nil {
+			return 0, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		return rows, tx.Commit()
+	case recoverFail:
+		// Failed jobs are finished as FinishJob finishes them, so those with
+		// retries left are retried, and those without are dead-lettered.
+		ids, err := jobIDs(tx, where, args...)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			if err := finishJob(tx, db.actor, id, int64(statusDoneFailed), -1, unknownUsage, recoveredFailure, attemptRunnerStopped); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(ids)), tx.Commit()
+	}
+	return 0, fmt.Errorf("unknown recovery mode '%s'", mode)
+}
+
+// deadLocalRunners returns the runners recorded in the workers table as
+// running on this machine whose processes have exited.
+func deadLocalRunners(db *DB) ([]string, error) {
+	workers, err := db.ListWorkers()
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, nil
+	}
+	var dead []string
+	seen := map[string]bool{}
+	for _, w := range workers {
+		if w.Host != host || w.PID == os.Getpid() || seen[w.Run] {
+			continue
+		}
+		seen[w.Run] = true
+		if !processExists(w.PID) {
+			dead = append(dead, w.Run)
+		}
+	}
+	return dead, nil
+}
+
+// jobIDs returns the IDs of the jobs matching where.
+func jobIDs(tx *sql.Tx, where string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(`SELECT id FROM jobs WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
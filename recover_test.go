@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+// expireTestLease makes the lease of a claimed job look like its runner
+// stopped renewing it.
+func expireTestLease(t *testing.T, db *DB, id int64) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE jobs SET lease_expires_at = 1 WHERE id = ?`, id); err != nil {
+		t.Fatalf("failed to expire lease of job #%d: %v", id, err)
+	}
+}
+
+func TestRecoverFailRetriesThenDeadLetters(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestJob(t, db, JobSpec{Command: "flaky", MaxRetries: 1})
+	plain := insertTestJob(t, db, JobSpec{Command: "false"})
+
+	claimTestJob(t, db, claimFilter{}, id)
+	claimTestJob(t, db, claimFilter{}, plain)
+	expireTestLease(t, db, id)
+	expireTestLease(t, db, plain)
+	if n, err := db.RecoverJobs(recoverFail, nil); err != nil || n != 2 {
+		t.Fatalf("recovered %d jobs (err %v); want 2", n, err)
+	}
+	if job := getTestJob(t, db, id); job.Status != statusPending || job.Retries != 1 {
+		t.Fatalf("recovered job is %s with %d retries; want pending with 1", statusNames[job.Status], job.Retries)
+	}
+	if job := getTestJob(t, db, plain); job.Status != statusDoneFailed || job.Failure != recoveredFailure {
+		t.Errorf("recovered job without retries is %s with failure %q; want failed with %q", statusNames[job.Status], job.Failure, recoveredFailure)
+	}
+
+	claimTestJob(t, db, claimFilter{}, id)
+	expireTestLease(t, db, id)
+	if _, err := db.RecoverJobs(recoverFail, nil); err != nil {
+		t.Fatal(err)
+	}
+	if job := getTestJob(t, db, id); job.Status != statusDeadLetter {
+		t.Errorf("recovered job out of retries is %s; want dead", statusNames[job.Status])
+	}
+	attempts, err := db.JobAttempts(getTestJob(t, db, id).UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Outcome != attemptRunnerStopped || attempts[1].Outcome != attemptRunnerStopped {
+		t.Errorf("attempts of recovered job: %+v; want two ended by %q", attempts, attemptRunnerStopped)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often a remote worker posts a running job's output to the server.
+const outputFlushInterval = time.Second
+
+// How long a remote worker waits before trying the server again after a
+// request fails.
+const serverRetryInterval = 5 * time.Second
+
+// remoteWorker runs jobs claimed from a `chime serve` server; see server.go.
+type remoteWorker struct {
+	globalArgs
+	execConfig
+	server     string
+	queue      string
+	numWorkers int
+}
+
+func parseWorkerSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := remoteWorker{globalArgs: globals, numWorkers: 1}
+	fs := flag.NewFlagSet(workerCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.server, "server", "", "URL of the `chime serve` server to take jobs from, e.g. https://host:8080")
+	fs.StringVar(&cmd.queue, "queue", "", "only take jobs from this queue (default: any queue)")
+	addExecFlags(fs, &cmd.execConfig)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := cmd.execConfig.validate(); err != nil {
+		return nil, err
+	}
+	if cmd.server == "" {
+		return nil, fmt.Errorf("--server is required")
+	}
+	if u, err := url.Parse(cmd.server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid --server '%s': must be an http or https URL", cmd.server)
+	}
+	if cmd.queue != "" {
+		if err := validateName("queue", cmd.queue); err != nil {
+			return nil, err
+		}
+	}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of workers: '%s'", fs.Arg(0))
+		}
+		cmd.numWorkers = n
+	default:
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args()[1:])
+	}
+	return cmd, nil
+}
+
+func (cmd remoteWorker) Run() error {
+	client := &serverClient{
+		url:   strings.TrimSuffix(cmd.server, "/"),
+		token: os.Getenv(chimeServerTokenEnvKey),
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+
+	// Stop taking new jobs once interrupted, and let the running ones finish.
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		slog.Info("stopping once running jobs finish")
+		close(stop)
+	}()
+
+	slog.Info("taking jobs", "server", client.url, "queue", cmd.queue, "workers", cmd.numWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.work(client, fmt.Sprintf("%s worker %d", cmd.runnerName(), i), stop)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// work claims and runs jobs as the named worker until stop is closed.
+func (cmd remoteWorker) work(client *serverClient, name string, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		job, lease, err := client.claim(claimRequest{Worker: name, Queue: cmd.queue, Labels: cmd.Labels})
+		wait := followPollInterval
+		if err != nil {
+			slog.Warn("failed to claim job", "server", client.url, "err", err)
+			wait = serverRetryInterval
+		}
+		if job == nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		slog.Debug("claimed job", "id", job.ID, "worker", name)
+		if err := cmd.runJob(client, name, job, lease); err != nil {
+			slog.Error("job failed", "id", job.ID, "err", err)
+			continue
+		}
+		if !job.hasFollowUps() {
+			continue
+		}
+		child, lease, err := client.followUp(job.ID, name)
+		if err != nil {
+			slog.Error("failed to add follow-up job", "id", job.ID, "err", err)
+			continue
+		}
+		if child != nil {
+			if err := cmd.runJob(client, name, child, lease); err != nil {
+				slog.Error("follow-up job failed", "id", child.ID, "err", err)
+			}
+		}
+	}
+}
+
+// runJob runs a job leased to the named worker.
+func (cmd remoteWorker) runJob(client *serverClient, name string, job *Job, lease time.Duration) error {
+	lj := newLeasedJob(client, name, job.ID, lease)
+	cfg := cmd.execConfig
+	cfg.Stdout, cfg.Stderr = lj.stdout, lj.stderr
+	_, err := execJob(lj, cfg, job)
+	lj.stop()
+	return err
+}
+
+// serverClient makes requests to a `chime serve` server.
+type serverClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// do sends a request with body, and decodes the JSON response into resp if
+// it's not nil. Returns the response's status code.
+func (c *serverClient) do(method, path string, body io.Reader, resp any) (int, error) {
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return res.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	if resp != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return res.StatusCode, fmt.Errorf("invalid response to %s %s: %w", method, path, err)
+		}
+	}
+	return res.StatusCode, nil
+}
+
+func (c *serverClient) post(path string, req any) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	return c.do(http.MethodPost, path, bytes.NewReader(body), nil)
+}
+
+// claim takes the next job the worker can run. Returns nil if there are no
+// such pending jobs.
+func (c *serverClient) claim(claim claimRequest) (*Job, time.Duration, error) {
+	body, err := json.Marshal(claim)
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp claimResponse
+	if _, err := c.do(http.MethodPost, "/v1/claim", bytes.NewReader(body), &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Job, time.Duration(resp.LeaseMS) * time.Millisecond, nil
+}
+
+// followUp adds the follow-up of a job the worker has finished, and leases
+// it to the worker. Returns nil if the job has no follow-up for its outcome.
+func (c *serverClient) followUp(jobID int, worker string) (*Job, time.Duration, error) {
+	body, err := json.Marshal(jobReport{Worker: worker})
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp claimResponse
+	if _, err := c.do(http.MethodPost, fmt.Sprintf("/v1/jobs/%d/follow-up", jobID), bytes.NewReader(body), &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Job, time.Duration(resp.LeaseMS) * time.Millisecond, nil
+}
+
+// leasedJob is a job claimed from the server. It renews the job's lease and
+// posts its output while the job runs, and records its progress on the
+// server as the jobStore execJob uses.
+type leasedJob struct {
+	client *serverClient
+	worker string
+	id     int
+	stdout *outputBuffer
+	stderr *outputBuffer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	// Serializes posting output, so chunks arrive in order.
+	flushLock sync.Mutex
+}
+
+func newLeasedJob(client *serverClient, worker string, id int, lease time.Duration) *leasedJob {
+	lj := &leasedJob{
+		client: client,
+		worker: worker,
+		id:     id,
+		stdout: &outputBuffer{stream: "stdout"},
+		stderr: &outputBuffer{stream: "stderr"},
+		done:   make(chan struct{}),
+	}
+	lj.wg.Add(1)
+	go lj.keepAlive(lease)
+	return lj
+}
+
+// keepAlive posts the job's output every outputFlushInterval, and renews
+// its lease a few times per lease period, until stop is called. If the
+// lease is lost the job keeps running, but its result won't be recorded.
+func (lj *leasedJob) keepAlive(lease time.Duration) {
+	defer lj.wg.Done()
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-lj.done:
+			return
+		case <-ticker.C:
+		}
+		lj.flush()
+		if time.Since(renewed) < lease/3 {
+			continue
+		}
+		status, err := lj.client.post(lj.path("heartbeat"), jobReport{Worker: lj.worker})
+		if status == http.StatusConflict {
+			slog.Error("lost lease on job; its result won't be recorded", "id", lj.id, "err", err)
+			return
+		}
+		if err != nil {
+			slog.Warn("failed to renew lease on job", "id", lj.id, "err", err)
+			continue
+		}
+		renewed = time.Now()
+	}
+}
+
+func (lj *leasedJob) stop() {
+	close(lj.done)
+	lj.wg.Wait()
+	lj.flush()
+}
+
+func (lj *leasedJob) path(action string) string {
+	return fmt.Sprintf("/v1/jobs/%d/%s", lj.id, action)
+}
+
+// flush posts the output written since the last flush. Output that fails to
+// post is kept, and sent with the next flush.
+func (lj *leasedJob) flush() {
+	lj.flushLock.Lock()
+	defer lj.flushLock.Unlock()
+	for _, out := range []*outputBuffer{lj.stdout, lj.stderr} {
+		chunk := out.pending()
+		if len(chunk) == 0 {
+			continue
+		}
+		path := lj.path("output") + "?" + url.Values{"worker": {lj.worker}, "stream": {out.stream}}.Encode()
+		if _, err := lj.client.do(http.MethodPost, path, bytes.NewReader(chunk), nil); err != nil {
+			slog.Warn("failed to send job output", "id", lj.id, "err", err)
+			continue
+		}
+		out.sent(len(chunk))
+	}
+}
+
+func (lj *leasedJob) report(action string, report jobReport) error {
+	report.Worker = lj.worker
+	_, err := lj.client.post(lj.path(action), report)
+	return err
+}
+
+func (lj *leasedJob) SetJobStatus(jobID int64, status int64) error {
+	lj.flush()
+	return lj.report("finish", jobReport{Status: status, ExitCode: -1, Usage: unknownUsage})
+}
+
+func (lj *leasedJob) SetJobPID(jobID int64, pid int64) error {
+	return lj.report("started", jobReport{Where: fmt.Sprintf("pid %d", pid)})
+}
+
+func (lj *leasedJob) SetRemoteJobPID(jobID int64, pid int64, host string) error {
+	return lj.report("started", jobReport{Where: fmt.Sprintf("ssh %s, pid %d", host, pid)})
+}
+
+func (lj *leasedJob) RecordJobStarted(jobID int64, where string) error {
+	return lj.report("started", jobReport{Where: where})
+}
+
+func (lj *leasedJob) FinishJob(jobID int64, status int64, exitCode int, usage jobUsage, failure string) error {
+	// Send all of the job's output before its result.
+	lj.flush()
+	report := jobReport{Worker: lj.worker, Status: status, ExitCode: exitCode, Usage: usage, Failure: failure}
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(serverRetryInterval)
+		}
+		var code int
+		if code, err = lj.client.post(lj.path("finish"), report); err == nil || code == http.StatusConflict {
+			break
+		}
+	}
+	return err
+}
+
+func (lj *leasedJob) GetHostPool(name string) (*HostPool, error) {
+	var pool HostPool
+	status, err := lj.client.do(http.MethodGet, "/v1/host-pools/"+url.PathEscape(name), nil, &pool)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
+
+// outputBuffer holds a job's output until it's posted to the server.
+type outputBuffer struct {
+	stream string
+	lock   sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// pending returns up to maxOutputChunk bytes of output that haven't been
+// sent yet.
+func (b *outputBuffer) pending() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := min(b.buf.Len(), maxOutputChunk)
+	return bytes.Clone(b.buf.Bytes()[:n])
+}
+
+// sent discards the first n bytes of pending output.
+func (b *outputBuffer) sent(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf.Next(n)
+}
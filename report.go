@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reportTemplate renders a self-contained HTML page, with no external
+// stylesheets or scripts, so it can be attached to CI artifacts as is.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chime run #{{.Run.ID}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f8; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+code { font-family: ui-monospace, Menlo, monospace; white-space: pre-wrap; word-break: break-all; }
+.summary td { border: none; padding: 0.1em 1em 0.1em 0; }
+.succeeded { color: #1a7f37; }
+.failed, .dead { color: #cf222e; font-weight: bold; }
+.running { color: #9a6700; }
+.error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>chime run #{{.Run.ID}}</h1>
+<table class="summary">
+<tr><td>Started</td><td>{{.Started}}</td></tr>
+<tr><td>Wall time</td><td>{{.Wall}}</td></tr>
+<tr><td>Workers</td><td>{{.Run.NumWorkers}}</td></tr>
+<tr><td>Jobs</td><td>{{.Run.NumJobs}} ({{.Run.NumSucceeded}} succeeded, {{.Run.NumFailed}} failed)</td></tr>
+<tr><td>Generated</td><td>{{.Generated}}</td></tr>
+</table>
+<h2>Jobs</h2>
+<table>
+<tr><th>ID</th><th>Status</th><th>Duration</th><th>Exit code</th><th>Command</th></tr>
+{{range .Jobs}}<tr>
+<td class="num">{{.ID}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td class="num">{{.Duration}}</td>
+<td class="num">{{.ExitCode}}</td>
+<td><code>{{.Command}}</code>{{if .Error}}<br><span class="error">{{.Error}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type reportJob struct {
+	ID       int
+	Status   string
+	Duration string
+	ExitCode string
+	Command  string
+	Error    string
+}
+
+type reportData struct {
+	Run       RunSummary
+	Started   string
+	Wall      string
+	Generated string
+	Jobs      []reportJob
+}
+
+// RunJobs returns the jobs, including archived ones, that started during
+// the run.
+func (db *DB) RunJobs(run RunSummary) ([]Job, error) {
+	var jobs []Job
+	for _, table := range []string{"jobs", "archived_jobs"} {
+		found, err := db.queryJobs(table, "started_at >= ? AND started_at <= ?", run.StartedAt, run.FinishedAt)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, found...)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].StartedAt < jobs[b].StartedAt })
+	return jobs, nil
+}
+
+func writeReport(run RunSummary, jobs []Job) ([]byte, error) {
+	errs := map[int]string{}
+	for _, f := range run.Failures {
+		errs[f.JobID] = f.Error
+	}
+
+	data := reportData{
+		Run:       run,
+		Started:   run.StartedAtTime().Format(time.DateTime),
+		Wall:      run.WallTime().Round(time.Millisecond).String(),
+		Generated: time.Now().Format(time.DateTime),
+	}
+	for _, job := range jobs {
+		j := reportJob{
+			ID:      job.ID,
+			Status:  statusNames[job.Status],
+			Command: job.Command,
+			Error:   errs[job.ID],
+		}
+		if job.FinishedAt != 0 {
+			j.Duration = job.FinishedAtTime().Sub(job.StartedAtTime()).Round(time.Millisecond).String()
+		}
+		if job.ExitCode >= 0 {
+			j.ExitCode = strconv.Itoa(job.ExitCode)
+		}
+		data.Jobs = append(data.Jobs, j)
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type report struct {
+	globalArgs
+	runID int64
+	out   string
+}
+
+func parseReportSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := report{globalArgs: globals}
+	fs := flag.NewFlagSet(reportCommandName, flag.ContinueOnError)
+	fs.Int64Var(&cmd.runID, "run", 0, "ID of the run to report on (default: the most recent)")
+	fs.StringVar(&cmd.out, "out", "", "file to write the HTML report to")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if cmd.out == "" {
+		return nil, fmt.Errorf("--out is required")
+	}
+	return cmd, nil
+}
+
+func (cmd report) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	var run *RunSummary
+	if cmd.runID != 0 {
+		if run, err = db.GetRun(cmd.runID); err != nil {
+			return fmt.Errorf("failed to read run: %w", err)
+		}
+		if run == nil {
+			return fmt.Errorf("no run with ID %d", cmd.runID)
+		}
+	} else {
+		runs, err := db.ListRuns(1)
+		if err != nil {
+			return fmt.Errorf("failed to read last run: %w", err)
+		}
+		if len(runs) == 0 {
+			return fmt.Errorf("no runs recorded yet")
+		}
+		run = &runs[0]
+	}
+
+	jobs, err := db.RunJobs(*run)
+	if err != nil {
+		return fmt.Errorf("failed to read jobs: %w", err)
+	}
+	html, err := writeReport(*run, jobs)
+	if err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	if err := writeFileAtomic(cmd.out, html); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	slog.Info("wrote report", "run", run.ID, "jobs", len(jobs), "path", cmd.out)
+	return nil
+}
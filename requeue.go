@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+type requeue struct {
+	globalArgs
+	ref string
+}
+
+func parseRequeueSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 && canPickJob() {
+		return requeue{globalArgs: globals}, nil
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to requeue")
+	}
+	return requeue{globalArgs: globals, ref: args[0]}, nil
+}
+
+func (cmd requeue) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.ref == "" {
+		// Only finished jobs can be requeued.
+		if cmd.ref, err = pickJob(db, "requeue", terminalStatuses...); err != nil {
+			return err
+		}
+	}
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	ok, err := db.RequeueJob(int64(job.ID))
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("job #%d hasn't finished", job.ID)
+	}
+	slog.Info("requeued job", "id", job.ID)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A run can be given named resources made of numbered devices, like
+// `--resource gpus=2` for GPUs 0 and 1. A job that needs some of them, like
+// `add --gpus 1`, is only taken by runs that have them, and is handed to a
+// worker once enough devices are free; the indices of the devices it gets
+// are in its environment, as CHIME_<NAME>, and for GPUs as
+// CUDA_VISIBLE_DEVICES, until it finishes and they're released.
+
+const resourceGPUs = "gpus"
+
+// resourceCounts maps resource names to numbers of devices. As a flag, it's
+// given as name=count, and may be repeated.
+type resourceCounts map[string]int
+
+func (r *resourceCounts) String() string {
+	if r == nil {
+		return ""
+	}
+	return CommaList(r.list()).String()
+}
+
+func (r *resourceCounts) Set(value string) error {
+	name, count, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected name=count, like gpus=2")
+	}
+	if err := validateName("resource", name); err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid number of %s: '%s'", name, count)
+	}
+	if *r == nil {
+		*r = resourceCounts{}
+	}
+	(*r)[name] = n
+	return nil
+}
+
+// list returns the counts as sorted name=count pairs, the way jobs store them.
+func (r resourceCounts) list() []string {
+	var pairs []string
+	for name, n := range r {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, n))
+	}
+	slices.Sort(pairs)
+	return pairs
+}
+
+// names returns the sorted names of the resources.
+func (r resourceCounts) names() []string {
+	var names []string
+	for name := range r {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// resourceNeeds returns the devices the job needs, by resource name.
+func (job Job) resourceNeeds() resourceCounts {
+	needs := resourceCounts{}
+	for _, pair := range job.Resources {
+		name, count, _ := strings.Cut(pair, "=")
+		n, _ := strconv.Atoi(count)
+		needs[name] = n
+	}
+	return needs
+}
+
+// deviceVars returns the variables telling a job which devices it got.
+func deviceVars(job *Job) []string {
+	var vars []string
+	for _, name := range slices.Sorted(maps.Keys(job.Devices)) {
+		var ids []string
+		for _, id := range job.Devices[name] {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		list := strings.Join(ids, ",")
+		envName := "CHIME_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+		vars = append(vars, envName+"="+list)
+		if name == resourceGPUs {
+			vars = append(vars, "CUDA_VISIBLE_DEVICES="+list)
+		}
+	}
+	return vars
+}
+
+// devicePool tracks which of a run's devices are in use.
+type devicePool map[string][]bool
+
+func newDevicePool(resources resourceCounts) devicePool {
+	p := devicePool{}
+	for name, n := range resources {
+		p[name] = make([]bool, n)
+	}
+	return p
+}
+
+// fits returns an error if the run doesn't have the devices the job needs,
+// however many are free.
+func (p devicePool) fits(job *Job) error {
+	for name, n := range job.resourceNeeds() {
+		if have := len(p[name]); have < n {
+			return fmt.Errorf("job needs %d %s but the runner has %d", n, name, have)
+		}
+	}
+	return nil
+}
+
+// available reports whether enough devices are free for the job.
+func (p devicePool) available(job *Job) bool {
+	for name, n := range job.resourceNeeds() {
+		free := 0
+		for _, used := range p[name] {
+			if !used {
+				free++
+			}
+		}
+		if free < n {
+			return false
+		}
+	}
+	return true
+}
+
+// take assigns free devices to the job, if there are enough of them.
+func (p devicePool) take(job *Job) bool {
+	if !p.available(job) {
+		return false
+	}
+	needs := job.resourceNeeds()
+	if len(needs) == 0 {
+		return true
+	}
+	job.Devices = map[string][]int{}
+	for name, n := range needs {
+		for id, used := range p[name] {
+			if len(job.Devices[name]) == n {
+				break
+			}
+			if !used {
+				p[name][id] = true
+				job.Devices[name] = append(job.Devices[name], id)
+			}
+		}
+	}
+	return true
+}
+
+// release frees the devices assigned to the job.
+func (p devicePool) release(job *Job) {
+	for name, ids := range job.Devices {
+		for _, id := range ids {
+			p[name][id] = false
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// `chime run --report run.ndjson` appends a JSON object per line to the file
+// as each job starts and finishes, so CI wrappers can post-process a run's
+// results without querying the DB. Each object has "event" ("started" or
+// "finished"), the job's "id", "uuid" and "command", the "worker" running it
+// and when the event happened, "at". Finished events add the job's
+// "status", "exit_code", "started_at", "finished_at" and "duration_ms", and
+// "error" if the job or its runner failed. A job put back in the queue for a
+// more urgent one has "preempted" set instead of a status.
+
+// runReport appends a run's job events to its report file. A nil runReport
+// writes nothing.
+type runReport struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+type runReportEvent struct {
+	Event      string     `json:"event"`
+	ID         int        `json:"id"`
+	UUID       string     `json:"uuid"`
+	Command    string     `json:"command"`
+	Worker     string     `json:"worker"`
+	At         time.Time  `json:"at"`
+	Status     string     `json:"status,omitempty"`
+	Preempted  bool       `json:"preempted,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs *int64     `json:"duration_ms,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// openRunReport opens the report file for appending, creating it if needed.
+// Returns nil if path is empty.
+func openRunReport(path string) (*runReport, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run report: %w", err)
+	}
+	return &runReport{f: f}, nil
+}
+
+func (r *runReport) jobStarted(job *Job, worker string) {
+	r.write(runReportEvent{Event: "started", ID: job.ID, UUID: job.UUID, Command: job.Command, Worker: worker, At: time.Now()})
+}
+
+// jobEnded records the result of a job, or the error that kept its runner
+// from getting one.
+func (r *runReport) jobEnded(job *Job, worker string, result *jobResult, err error) {
+	e := runReportEvent{Event: "finished", ID: job.ID, UUID: job.UUID, Command: job.Command, Worker: worker, At: time.Now()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if result != nil {
+		if result.Preempted {
+			e.Preempted = true
+		} else {
+			e.Status = statusNames[result.status()]
+		}
+		if result.Err != nil && e.Error == "" {
+			e.Error = result.Err.Error()
+		}
+		code := result.ExitCode
+		duration := result.Duration().Milliseconds()
+		e.ExitCode, e.StartedAt, e.FinishedAt, e.DurationMs = &code, &result.StartedAt, &result.FinishedAt, &duration
+	}
+	r.write(e)
+}
+
+func (r *runReport) write(e runReportEvent) {
+	if r == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// One write per line, so readers never see half of one.
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		slog.Warn("failed to write run report", "err", err)
+	}
+}
+
+func (r *runReport) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Number of run summaries kept in the DB; older ones are pruned as new
+// runs are recorded.
+const runRetention = 30
+
+// RunSummary is the recorded outcome of a single invocation of `chime run`.
+type RunSummary struct {
+	ID             int64
+	StartedAt      int64
+	FinishedAt     int64
+	NumWorkers     int
+	NumJobs        int
+	NumSucceeded   int
+	NumFailed      int
+	TotalJobTime   int64 // sum of job durations, in milliseconds
+	LongestJobID   int
+	LongestJobTime int64 // in milliseconds
+	Failures       []FailureSignature
+}
+
+// FailureSignature identifies a job that failed during a run and why.
+type FailureSignature struct {
+	JobID   int    `json:"job_id"`
+	Command string `json:"command"`
+	Error   string `json:"error"`
+}
+
+func (s RunSummary) StartedAtTime() time.Time {
+	return time.UnixMilli(s.StartedAt)
+}
+
+func (s RunSummary) WallTime() time.Duration {
+	return time.Duration(s.FinishedAt-s.StartedAt) * time.Millisecond
+}
+
+func (s RunSummary) MeanJobTime() time.Duration {
+	if s.NumJobs == 0 {
+		return 0
+	}
+	return time.Duration(s.TotalJobTime/int64(s.NumJobs)) * time.Millisecond
+}
+
+// runRecorder accumulates job results from concurrent workers into a RunSummary.
+type runRecorder struct {
+	lock    sync.Mutex
+	summary RunSummary
+
+	// Live counters, for the metrics endpoint.
+	started int
+	busy    int
+	// Number of finished jobs by duration, see jobDurationBuckets.
+	durationCounts []int
+	durationSum    time.Duration
+}
+
+func newRunRecorder(numWorkers int) *runRecorder {
+	return &runRecorder{
+		summary: RunSummary{
+			StartedAt:  time.Now().UnixMilli(),
+			NumWorkers: numWorkers,
+		},
+		durationCounts: make([]int, len(jobDurationBuckets)+1),
+	}
+}
+
+// jobStarted and jobEnded bracket a worker executing a job.
+func (r *runRecorder) jobStarted() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.started++
+	r.busy++
+}
+
+func (r *runRecorder) jobEnded() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.busy--
+}
+
+func (r *runRecorder) add(result *jobResult) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.durationCounts[sort.SearchFloat64s(jobDurationBuckets, result.Duration().Seconds())]++
+	r.durationSum += result.Duration()
+
+	elapsed := result.Duration().Milliseconds()
+	r.summary.NumJobs++
+	r.summary.TotalJobTime += elapsed
+	if elapsed >= r.summary.LongestJobTime {
+		r.summary.LongestJobID = result.Job.ID
+		r.summary.LongestJobTime = elapsed
+	}
+	if result.Err != nil {
+		r.summary.NumFailed++
+		r.summary.Failures = append(r.summary.Failures, FailureSignature{
+			JobID:   result.Job.ID,
+			Command: result.Job.Command,
+			Error:   result.Err.Error(),
+		})
+	} else {
+		r.summary.NumSucceeded++
+	}
+}
+
+// snapshot returns the run's summary so far.
+func (r *runRecorder) snapshot() RunSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.summary
+}
+
+func (r *runRecorder) finish() RunSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.summary.FinishedAt = time.Now().UnixMilli()
+	return r.summary
+}
+
+// RecordRun stores a run summary, keeping only the most recent `keep` runs.
+func (db *DB) RecordRun(summary RunSummary, keep int) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	failures, err := json.Marshal(summary.Failures)
+	if err != nil {
+		return 0, err
+	}
+	if summary.Failures == nil {
+		failures = []byte("[]")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT INTO runs (started_at, finished_at, num_workers, num_jobs, num_succeeded, num_failed,
+		total_job_time, longest_job_id, longest_job_time, failures)
+	VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		summary.StartedAt,
+		summary.FinishedAt,
+		summary.NumWorkers,
+		summary.NumJobs,
+		summary.NumSucceeded,
+		summary.NumFailed,
+		summary.TotalJobTime,
+		summary.LongestJobID,
+		summary.LongestJobTime,
+		string(failures),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+	DELETE FROM runs WHERE id NOT IN (SELECT id FROM runs ORDER BY id DESC LIMIT ?)`, keep); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+const runColumns = `id, started_at, finished_at, num_workers, num_jobs, num_succeeded, num_failed,
+	total_job_time, longest_job_id, longest_job_time, failures`
+
+func scanRun(row scanner) (RunSummary, error) {
+	var summary RunSummary
+	var failures string
+	if err := row.Scan(
+		&summary.ID,
+		&summary.StartedAt,
+		&summary.FinishedAt,
+		&summary.NumWorkers,
+		&summary.NumJobs,
+		&summary.NumSucceeded,
+		&summary.NumFailed,
+		&summary.TotalJobTime,
+		&summary.LongestJobID,
+		&summary.LongestJobTime,
+		&failures,
+	); err != nil {
+		return summary, err
+	}
+	if err := json.Unmarshal([]byte(failures), &summary.Failures); err != nil {
+		return summary, fmt.Errorf("invalid failures for run #%d: %w", summary.ID, err)
+	}
+	return summary, nil
+}
+
+// ListRuns returns the most recent runs, newest first.
+func (db *DB) ListRuns(limit int) ([]RunSummary, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT `+runColumns+` FROM runs ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		summary, err := scanRun(rows)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, summary)
+	}
+	return runs, rows.Err()
+}
+
+// GetRun returns the run with the given ID, or nil if it doesn't exist.
+func (db *DB) GetRun(id int64) (*RunSummary, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	summary, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM runs WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// PreviousRun returns the run recorded just before the given run ID, or nil.
+func (db *DB) PreviousRun(id int64) (*RunSummary, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	summary, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM runs WHERE id < ? ORDER BY id DESC LIMIT 1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &summary, nil
+}
+
+type runs struct {
+	globalArgs
+}
+
+type runsShow struct {
+	globalArgs
+	id int64
+}
+
+func parseRunsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return runs{globalArgs: globals}, nil
+	}
+	switch args[0] {
+	case "show":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("param required: run ID to show")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid run ID: '%s'", args[1])
+		}
+		return runsShow{globalArgs: globals, id: id}, nil
+	}
+	return nil, fmt.Errorf("unknown runs command: '%s'", args[0])
+}
+
+func (cmd runs) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	summaries, err := db.ListRuns(runRetention)
+	if err != nil {
+		return fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+	failedStyle := cellStyle.Foreground(lipgloss.Color("196"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(summaries) {
+				return headerStyle
+			}
+			if col == 5 && summaries[row].NumFailed > 0 {
+				return failedStyle
+			}
+			return cellStyle
+		}).
+		Headers("ID", "STARTED", "WALL", "WORKERS", "JOBS", "FAILED", "MEAN JOB")
+
+	for _, s := range summaries {
+		t.Row(
+			fmt.Sprintf("%d", s.ID),
+			s.StartedAtTime().Format(time.DateTime),
+			s.WallTime().Round(time.Millisecond).String(),
+			fmt.Sprintf("%d", s.NumWorkers),
+			fmt.Sprintf("%d", s.NumJobs),
+			fmt.Sprintf("%d", s.NumFailed),
+			s.MeanJobTime().Round(time.Millisecond).String(),
+		)
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd runsShow) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	summary, err := db.GetRun(cmd.id)
+	if err != nil {
+		return fmt.Errorf("failed to read run: %w", err)
+	}
+	if summary == nil {
+		return fmt.Errorf("no run with ID %d", cmd.id)
+	}
+	prev, err := db.PreviousRun(cmd.id)
+	if err != nil {
+		return fmt.Errorf("failed to read previous run: %w", err)
+	}
+
+	fmt.Printf("Run #%d\n", summary.ID)
+	fmt.Printf("  started:   %s\n", summary.StartedAtTime().Format(time.DateTime))
+	fmt.Printf("  wall time: %s\n", summary.WallTime().Round(time.Millisecond))
+	fmt.Printf("  workers:   %d\n", summary.NumWorkers)
+	fmt.Printf("  jobs:      %d (%d succeeded, %d failed)\n", summary.NumJobs, summary.NumSucceeded, summary.NumFailed)
+	fmt.Printf("  mean job:  %s\n", summary.MeanJobTime().Round(time.Millisecond))
+	if summary.NumJobs > 0 {
+		fmt.Printf("  longest:   #%d (%s)\n", summary.LongestJobID, time.Duration(summary.LongestJobTime)*time.Millisecond)
+	}
+
+	if prev != nil {
+		fmt.Printf("\nCompared to run #%d:\n", prev.ID)
+		fmt.Printf("  wall time: %s\n", signedDuration(summary.WallTime()-prev.WallTime()))
+		fmt.Printf("  jobs:      %+d\n", summary.NumJobs-prev.NumJobs)
+		fmt.Printf("  failed:    %+d\n", summary.NumFailed-prev.NumFailed)
+		fmt.Printf("  mean job:  %s\n", signedDuration(summary.MeanJobTime()-prev.MeanJobTime()))
+	}
+
+	if len(summary.Failures) > 0 {
+		// Group failures by error so repeated causes are easy to spot.
+		byError := map[string][]FailureSignature{}
+		for _, f := range summary.Failures {
+			byError[f.Error] = append(byError[f.Error], f)
+		}
+		errs := make([]string, 0, len(byError))
+		for e := range byError {
+			errs = append(errs, e)
+		}
+		sort.Slice(errs, func(i, j int) bool {
+			return len(byError[errs[i]]) > len(byError[errs[j]])
+		})
+
+		fmt.Printf("\nFailures:\n")
+		for _, e := range errs {
+			fmt.Printf("  %dx %s\n", len(byError[e]), e)
+			for _, f := range byError[e] {
+				fmt.Printf("      #%d %s\n", f.JobID, f.Command)
+			}
+		}
+	}
+	return nil
+}
+
+func signedDuration(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	if d > 0 {
+		return "+" + d.String()
+	}
+	return d.String()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Jobs' commands, notes and saved output are indexed for full-text search
+// in job_search, an FTS5 table whose rows have the IDs of their jobs.
+// Triggers keep commands and notes indexed; output is indexed when a job
+// finishes.
+//
+// go-sqlite3 only has FTS5 when built with the sqlite_fts5 tag, as the
+// Makefile builds chime. A chime built without it does everything but
+// search: it doesn't create the index, and drops the triggers of a DB that
+// has one, since every change to its jobs would fail on them. A chime with
+// FTS5 rebuilds the index of a DB it opens without the triggers.
+
+// The triggers keeping job_search up to date.
+var searchTriggers = []struct{ name, sql string }{
+	{"job_search_insert", `
+	CREATE TRIGGER job_search_insert AFTER INSERT ON jobs BEGIN
+		INSERT INTO job_search (rowid, command, note, output) VALUES (new.id, new.command, new.note, '');
+	END`},
+	{"job_search_update", `
+	CREATE TRIGGER job_search_update AFTER UPDATE OF command, note ON jobs BEGIN
+		UPDATE job_search SET command = new.command, note = new.note WHERE rowid = new.id;
+	END`},
+	{"job_search_delete", `
+	CREATE TRIGGER job_search_delete AFTER DELETE ON jobs BEGIN
+		DELETE FROM job_search WHERE rowid = old.id;
+	END`},
+}
+
+var errNoFTS5 = errors.New("chime was built without FTS5, which search needs (build it with make, or go build -tags sqlite_fts5)")
+
+// Most bytes of a job's output that are indexed: the end of it, where
+// errors usually are.
+const maxIndexedOutput = 1 << 20
+
+// Markers around matched terms in snippets.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// readOutputTail returns the last maxIndexedOutput bytes of an output file.
+func readOutputTail(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxIndexedOutput {
+		if _, err := f.Seek(-maxIndexedOutput, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+// searchIndexState reports whether SQLite was built with FTS5, and how many
+// of the search triggers the DB has.
+func searchIndexState(q querier) (bool, int, error) {
+	rows, err := q.Query(`
+	SELECT sqlite_compileoption_used('ENABLE_FTS5'),
+		(SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?,?,?))`,
+		searchTriggers[0].name, searchTriggers[1].name, searchTriggers[2].name)
+	if err != nil {
+		return false, 0, err
+	}
+	defer rows.Close()
+	var fts5 bool
+	var triggers int
+	if rows.Next() {
+		if err := rows.Scan(&fts5, &triggers); err != nil {
+			return false, 0, err
+		}
+	}
+	return fts5, triggers, rows.Err()
+}
+
+// syncSearchIndex creates the search index if SQLite has FTS5 and the DB
+// doesn't have all of its triggers, and drops the triggers if SQLite
+// doesn't have FTS5.
+func syncSearchIndex(q querier) error {
+	fts5, triggers, err := searchIndexState(q)
+	if err != nil {
+		return err
+	}
+	if !fts5 {
+		if triggers == 0 {
+			return nil
+		}
+		return dropSearchTriggers(q)
+	}
+	if triggers == len(searchTriggers) {
+		return nil
+	}
+
+	// The index is missing, or is out of date after a chime without FTS5
+	// changed the DB's jobs.
+	if err := dropSearchTriggers(q); err != nil {
+		return err
+	}
+	if _, err := q.Exec(`DROP TABLE IF EXISTS job_search`); err != nil {
+		return err
+	}
+	if _, err := q.Exec(`CREATE VIRTUAL TABLE job_search USING fts5(command, note, output)`); err != nil {
+		return err
+	}
+	if _, err := q.Exec(`
+	INSERT INTO job_search (rowid, command, note, output)
+	SELECT id, command, note, '' FROM jobs`); err != nil {
+		return err
+	}
+	for _, trigger := range searchTriggers {
+		if _, err := q.Exec(trigger.sql); err != nil {
+			return err
+		}
+	}
+	return indexSavedOutput(q)
+}
+
+func dropSearchTriggers(q querier) error {
+	for _, trigger := range searchTriggers {
+		if _, err := q.Exec(`DROP TRIGGER IF EXISTS ` + trigger.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncSearchIndex brings the DB's search index in line with whether SQLite
+// has FTS5; see syncSearchIndex.
+func (db *DB) SyncSearchIndex() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := syncSearchIndex(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IndexJobOutput indexes the output saved to a job's file at path, if
+// there's an index.
+func (db *DB) IndexJobOutput(jobID int64, path string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if fts5, _, err := searchIndexState(db.DB); err != nil || !fts5 {
+		return err
+	}
+	output, err := readOutputTail(path)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE job_search SET output = ? WHERE rowid = ?`, output, jobID)
+	return err
+}
+
+// indexSavedOutput indexes the output files of jobs that had them before
+// output was indexed.
+func indexSavedOutput(q querier) error {
+	rows, err := q.Query(`SELECT id, output_path FROM jobs WHERE output_path != ''`)
+	if err != nil {
+		return err
+	}
+	paths := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return err
+		}
+		paths[id] = path
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, path := range paths {
+		output, err := readOutputTail(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := q.Exec(`UPDATE job_search SET output = ? WHERE rowid = ?`, output, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchResult is a job matching a search, with a snippet of the matching
+// text.
+type searchResult struct {
+	ID      int
+	Status  int
+	Command string
+	Snippet string
+}
+
+// SearchJobs returns the jobs matching an FTS5 query, newest first.
+func (db *DB) SearchJobs(query string, limit int) ([]searchResult, error) {
+	if fts5, _, err := searchIndexState(db.DB); err != nil {
+		return nil, err
+	} else if !fts5 {
+		return nil, errNoFTS5
+	}
+	rows, err := db.Query(`
+	SELECT jobs.id, jobs.status, jobs.command,
+		snippet(job_search, -1, ?, ?, '…', 16)
+	FROM job_search JOIN jobs ON jobs.id = job_search.rowid
+	WHERE job_search MATCH ?
+	ORDER BY jobs.id DESC
+	LIMIT ?`, matchStart, matchEnd, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []searchResult
+	for rows.Next() {
+		var r searchResult
+		if err := rows.Scan(&r.ID, &r.Status, &r.Command, &r.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchQuery returns the FTS5 query matching jobs with every one of
+// phrases in them.
+func searchQuery(phrases []string) string {
+	quoted := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		quoted[i] = `"` + strings.ReplaceAll(phrase, `"`, " ") + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+type search struct {
+	globalArgs
+	query string
+	limit int
+}
+
+func parseSearchSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	var raw bool
+	cmd := search{globalArgs: globals}
+	fs := flag.NewFlagSet(searchCommandName, flag.ContinueOnError)
+	fs.IntVar(&cmd.limit, "limit", 50, "most jobs to list")
+	fs.BoolVar(&raw, "raw", false, "take the arguments as an FTS5 query, with operators like OR, NOT, NEAR(...) and prefix*")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("param required: text to search for")
+	}
+	if cmd.limit < 1 {
+		return nil, fmt.Errorf("--limit must be positive")
+	}
+	if raw {
+		cmd.query = strings.Join(fs.Args(), " ")
+	} else {
+		cmd.query = searchQuery(fs.Args())
+	}
+	return cmd, nil
+}
+
+var snippetMatch = regexp.MustCompile(matchStart + `(.*?)` + matchEnd)
+
+func (cmd search) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	results, err := db.SearchJobs(cmd.query, cmd.limit)
+	if isSearchSyntaxError(err) {
+		return fmt.Errorf("invalid search query: %w", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to search jobs: %w", err)
+	}
+	if len(results) == 0 {
+		slog.Info("no jobs match")
+		return nil
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+	matchStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffff00"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(results) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("ID", "STATUS", "COMMAND", "MATCH")
+	for _, r := range results {
+		snippet := strings.Join(strings.Fields(r.Snippet), " ")
+		snippet = snippetMatch.ReplaceAllStringFunc(snippet, func(s string) string {
+			return matchStyle.Render(strings.Trim(s, matchStart+matchEnd))
+		})
+		t.Row(strconv.Itoa(r.ID), statusNames[r.Status], r.Command, snippet)
+	}
+	fmt.Println(t)
+	return nil
+}
+
+// isSearchSyntaxError reports whether err is SQLite rejecting a malformed
+// FTS query.
+func isSearchSyntaxError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "fts5: syntax error") || strings.Contains(err.Error(), "unterminated string"))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// searchTestJobs fails the test unless searching for phrases finds the jobs
+// with IDs want, newest first.
+func searchTestJobs(t *testing.T, db *DB, phrases []string, want ...int64) {
+	t.Helper()
+	results, err := db.SearchJobs(searchQuery(phrases), 10)
+	if err != nil {
+		t.Fatalf("failed to search for %q: %v", phrases, err)
+	}
+	var got []int64
+	for _, r := range results {
+		got = append(got, int64(r.ID))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("search for %q found jobs %v; want %v", phrases, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("search for %q found jobs %v; want %v", phrases, got, want)
+		}
+	}
+}
+
+func TestSearchJobs(t *testing.T) {
+	db := openTestDB(t)
+	refused := insertTestJob(t, db, JobSpec{Command: "curl http://db/ || echo connection refused"})
+	insertTestJob(t, db, JobSpec{Command: "echo refused connection"})
+
+	fts5, _, err := searchIndexState(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fts5 {
+		// Everything else works without the index.
+		if _, err := db.SearchJobs(searchQuery([]string{"refused"}), 10); !errors.Is(err, errNoFTS5) {
+			t.Fatalf("search without FTS5 returned %v; want %v", err, errNoFTS5)
+		}
+		return
+	}
+
+	searchTestJobs(t, db, []string{"connection refused"}, refused)
+	searchTestJobs(t, db, []string{"refused", "connection"}, refused+1, refused)
+	searchTestJobs(t, db, []string{"timeout"})
+}
+
+func TestSyncSearchIndexRebuildsStaleIndex(t *testing.T) {
+	db := openTestDB(t)
+	if fts5, _, err := searchIndexState(db.DB); err != nil || !fts5 {
+		t.Skip("needs FTS5; run the tests with -tags sqlite_fts5, as make test does")
+	}
+	old := insertTestJob(t, db, JobSpec{Command: "echo old"})
+
+	// A chime without FTS5 drops the triggers, so jobs it adds aren't indexed.
+	if err := dropSearchTriggers(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	added := insertTestJob(t, db, JobSpec{Command: "echo added"})
+	searchTestJobs(t, db, []string{"added"})
+
+	if err := db.SyncSearchIndex(); err != nil {
+		t.Fatalf("failed to sync search index: %v", err)
+	}
+	searchTestJobs(t, db, []string{"added"}, added)
+	searchTestJobs(t, db, []string{"echo"}, added, old)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// `chime serve` lets workers on other machines, started with `chime worker`,
+// take jobs from the DB over HTTP. A worker claims a job with a lease, which
+// it renews with heartbeats while the job runs; a job whose lease expires,
+// e.g. because its worker died, goes back in the queue. Workers post the
+// job's output as it runs, which the server copies to its own, and its
+// result once it's finished.
+
+// Environment variable holding the token workers must present to the
+// server. If it's unset, the server accepts any worker.
+const chimeServerTokenEnvKey = "CHIME_SERVER_TOKEN"
+
+// Largest chunk of output a worker may post at once.
+const maxOutputChunk = 4 << 20
+
+type serve struct {
+	globalArgs
+	addr    string
+	tlsCert string
+	tlsKey  string
+	lease   time.Duration
+}
+
+type claimRequest struct {
+	Worker string `json:"worker"`
+	// Queue to take a job from, or empty for any queue.
+	Queue string `json:"queue,omitempty"`
+	// Labels of the worker; see execConfig.
+	Labels []string `json:"labels,omitempty"`
+}
+
+type claimResponse struct {
+	Job     *Job  `json:"job"`
+	LeaseMS int64 `json:"lease_ms"`
+}
+
+// jobReport is what a worker sends about a job it has claimed.
+type jobReport struct {
+	Worker string `json:"worker"`
+	// Where the job started, e.g. its pid on the worker.
+	Where    string   `json:"where,omitempty"`
+	Status   int64    `json:"status,omitempty"`
+	ExitCode int      `json:"exit_code,omitempty"`
+	Usage    jobUsage `json:"usage"`
+	Failure  string   `json:"failure,omitempty"`
+}
+
+func parseServeSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := serve{globalArgs: globals}
+	fs := flag.NewFlagSet(serveCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.addr, "addr", ":8080", "address to listen on for workers")
+	fs.StringVar(&cmd.tlsCert, "tls-cert", "", "certificate file to serve HTTPS with")
+	fs.StringVar(&cmd.tlsKey, "tls-key", "", "key file of the --tls-cert certificate")
+	fs.DurationVar(&cmd.lease, "lease", defaultLease, "how long a worker's claim on a job lasts without a heartbeat")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if (cmd.tlsCert == "") != (cmd.tlsKey == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if cmd.lease < 3*time.Second {
+		return nil, fmt.Errorf("--lease must be at least 3s")
+	}
+	return cmd, nil
+}
+
+func (cmd serve) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	listener, err := net.Listen("tcp", cmd.addr)
+	if err != nil {
+		return err
+	}
+	s := &jobServer{db: db, lease: cmd.lease, token: os.Getenv(chimeServerTokenEnvKey)}
+	if s.token == "" {
+		slog.Warn("accepting workers without a token", "env", chimeServerTokenEnvKey)
+	}
+	srv := &http.Server{Handler: s.handler()}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.expireLeases(stop)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		slog.Info("shutting down")
+		srv.Close()
+	}()
+
+	slog.Info("serving workers", "addr", listener.Addr().String(), "lease", cmd.lease)
+	if cmd.tlsCert != "" {
+		err = srv.ServeTLS(listener, cmd.tlsCert, cmd.tlsKey)
+	} else {
+		err = srv.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// jobServer serves the API remote workers use.
+type jobServer struct {
+	db    *DB
+	lease time.Duration
+	token string
+	// Held while checking a worker's lease and acting on it, so the lease
+	// can't expire in between.
+	lock sync.Mutex
+}
+
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/claim", s.handleClaim)
+	mux.HandleFunc("POST /v1/jobs/{id}/heartbeat", s.handleHeartbeat)
+	mux.HandleFunc("POST /v1/jobs/{id}/started", s.handleStarted)
+	mux.HandleFunc("POST /v1/jobs/{id}/output", s.handleOutput)
+	mux.HandleFunc("POST /v1/jobs/{id}/finish", s.handleFinish)
+	mux.HandleFunc("POST /v1/jobs/{id}/follow-up", s.handleFollowUp)
+	mux.HandleFunc("GET /v1/host-pools/{name}", s.handleHostPool)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.token != "" {
+			want := "Bearer " + s.token
+			if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(want)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// expireLeases periodically requeues jobs whose workers stopped renewing
+// their leases, until stop is closed.
+func (s *jobServer) expireLeases(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.lease / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		n, err := s.db.ExpireLeases()
+		s.lock.Unlock()
+		if err != nil {
+			slog.Error("failed to expire leases", "err", err)
+		} else if n > 0 {
+			slog.Warn("requeued jobs with expired leases", "count", n)
+		}
+	}
+}
+
+func (s *jobServer) handleClaim(w http.ResponseWriter, req *http.Request) {
+	var claim claimRequest
+	if err := json.NewDecoder(req.Body).Decode(&claim); err != nil || claim.Worker == "" {
+		http.Error(w, "invalid claim", http.StatusBadRequest)
+		return
+	}
+	job, err := s.db.WithActor(claim.Worker).ClaimJob(claimFilter{Labels: claim.Labels, Queue: claim.Queue}, claim.Worker, s.lease)
+	if err != nil {
+		slog.Error("failed to claim job", "worker", claim.Worker, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	slog.Debug("job claimed", "id", job.ID, "worker", claim.Worker)
+	writeJSON(w, claimResponse{Job: job, LeaseMS: s.lease.Milliseconds()})
+}
+
+// leased decodes a worker's report about a job, and calls f with the lock
+// held if the job is still leased to the worker.
+func (s *jobServer) leased(w http.ResponseWriter, req *http.Request, f func(db *DB, id int64, report jobReport) error) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		return
+	}
+	var report jobReport
+	if err := json.NewDecoder(req.Body).Decode(&report); err != nil || report.Worker == "" {
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+	s.leasedJob(w, id, report, f)
+}
+
+func (s *jobServer) leasedJob(w http.ResponseWriter, id int64, report jobReport, f func(db *DB, id int64, report jobReport) error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	job, err := s.db.GetJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.Status != statusInProgress || job.LeaseOwner != report.Worker {
+		http.Error(w, fmt.Sprintf("job #%d isn't leased to %s", id, report.Worker), http.StatusConflict)
+		return
+	}
+	if err := f(s.db.WithActor(report.Worker), id, report); err != nil {
+		slog.Error("failed to update job", "id", id, "worker", report.Worker, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *jobServer) handleHeartbeat(w http.ResponseWriter, req *http.Request) {
+	s.leased(w, req, func(db *DB, id int64, report jobReport) error {
+		_, err := db.RenewLease(id, report.Worker, s.lease)
+		return err
+	})
+}
+
+func (s *jobServer) handleStarted(w http.ResponseWriter, req *http.Request) {
+	s.leased(w, req, func(db *DB, id int64, report jobReport) error {
+		return db.RecordJobStarted(id, report.Where)
+	})
+}
+
+func (s *jobServer) handleFinish(w http.ResponseWriter, req *http.Request) {
+	s.leased(w, req, func(db *DB, id int64, report jobReport) error {
+		slog.Debug("job finished", "id", id, "worker", report.Worker, "status", statusNames[int(report.Status)])
+		return db.FinishJob(id, report.Status, report.ExitCode, report.Usage, report.Failure)
+	})
+}
+
+// handleFollowUp adds the follow-up of a job the worker has finished, and
+// leases it to the worker; see followup.go.
+func (s *jobServer) handleFollowUp(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		return
+	}
+	var report jobReport
+	if err := json.NewDecoder(req.Body).Decode(&report); err != nil || report.Worker == "" {
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	job, err := s.db.GetJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil || !isTerminal(job.Status) || job.LeaseOwner != report.Worker {
+		http.Error(w, fmt.Sprintf("job #%d wasn't finished by %s", id, report.Worker), http.StatusConflict)
+		return
+	}
+	child, err := s.db.WithActor(report.Worker).claimFollowUp(id, s.lease)
+	if err != nil {
+		slog.Error("failed to add follow-up job", "id", id, "worker", report.Worker, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if child == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, claimResponse{Job: child, LeaseMS: s.lease.Milliseconds()})
+}
+
+// handleOutput copies a chunk of a job's output, sent as the request body,
+// to the server's stdout or stderr.
+func (s *jobServer) handleOutput(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		return
+	}
+	out := os.Stdout
+	if req.URL.Query().Get("stream") == "stderr" {
+		out = os.Stderr
+	}
+	chunk, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxOutputChunk))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report := jobReport{Worker: req.URL.Query().Get("worker")}
+	s.leasedJob(w, id, report, func(db *DB, id int64, report jobReport) error {
+		_, err := out.Write(chunk)
+		return err
+	})
+}
+
+func (s *jobServer) handleHostPool(w http.ResponseWriter, req *http.Request) {
+	pool, err := s.db.GetHostPool(req.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pool == nil {
+		http.NotFound(w, req)
+		return
+	}
+	writeJSON(w, pool)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to send response", "err", err)
+	}
+}
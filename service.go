@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// `chime install-service` installs and starts a service running
+// `chime run --follow` against the DB, so a runner comes back after reboots
+// and crashes: a systemd unit, or a launchd job on macOS. It's the user's
+// unless --system is given, which needs root and runs the runner as the user
+// installing it. `chime uninstall-service` stops and removes it.
+
+const defaultServiceName = "chime"
+
+type installService struct {
+	globalArgs
+	name    string
+	system  bool
+	workers string
+	// Extra flags for `chime run`, given after --.
+	runArgs []string
+	// Print the service definition instead of installing it.
+	print bool
+}
+
+type uninstallService struct {
+	globalArgs
+	name   string
+	system bool
+}
+
+func addServiceFlags(fs *flag.FlagSet, name *string, system *bool) {
+	fs.StringVar(name, "name", defaultServiceName, "name of the service")
+	fs.BoolVar(system, "system", false, "install a system service rather than a user one; needs root")
+}
+
+func parseInstallServiceSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := installService{globalArgs: globals}
+	fs := flag.NewFlagSet(installServiceCommandName, flag.ContinueOnError)
+	addServiceFlags(fs, &cmd.name, &cmd.system)
+	fs.StringVar(&cmd.workers, "workers", "1", "number of workers, or auto for one per CPU")
+	fs.BoolVar(&cmd.print, "print", false, "print the service definition rather than installing it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := validateName("service", cmd.name); err != nil {
+		return nil, err
+	}
+	if _, err := parseWorkerCount(cmd.workers); err != nil {
+		return nil, err
+	}
+	// Check the run flags now rather than once the service fails to start.
+	cmd.runArgs = fs.Args()
+	if _, err := parseSubcommand(globals, append([]string{runCommandName, "--follow", "--workers", cmd.workers}, cmd.runArgs...)); err != nil {
+		return nil, fmt.Errorf("invalid run flags: %w", err)
+	}
+	return cmd, nil
+}
+
+func parseUninstallServiceSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := uninstallService{globalArgs: globals}
+	fs := flag.NewFlagSet(uninstallServiceCommandName, flag.ContinueOnError)
+	addServiceFlags(fs, &cmd.name, &cmd.system)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if err := validateName("service", cmd.name); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// argv returns the command line of the service's runner.
+func (cmd installService) argv() ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	dbPath, err := filepath.Abs(cmd.globalArgs.dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{exe, "--dbpath", dbPath, runCommandName, "--follow", "--workers", cmd.workers}, cmd.runArgs...), nil
+}
+
+// serviceEnv returns the variables the service's runner needs: the DB's key
+// file, if it's encrypted with one.
+func serviceEnv() (map[string]string, error) {
+	env := map[string]string{}
+	if path, ok := os.LookupEnv(chimeDBKeyFileEnvKey); ok {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		env[chimeDBKeyFileEnvKey] = path
+	}
+	return env, nil
+}
+
+func (cmd installService) Run() error {
+	def, err := serviceDefinition(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.print {
+		fmt.Print(def)
+		return nil
+	}
+	if _, ok := os.LookupEnv(chimeDBKeyEnvKey); ok {
+		// The key isn't written into the service, where anyone could read it.
+		return fmt.Errorf("the DB key can't be passed to the service in $%s; use $%s instead", chimeDBKeyEnvKey, chimeDBKeyFileEnvKey)
+	}
+
+	path, err := servicePath(cmd.name, cmd.system)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(def), 0o644); err != nil {
+		return fmt.Errorf("failed to write service: %w", err)
+	}
+	slog.Info("wrote service", "path", path)
+	if err := startService(cmd.name, cmd.system, path); err != nil {
+		return err
+	}
+	slog.Info("installed service", "name", cmd.name)
+	return nil
+}
+
+func (cmd uninstallService) Run() error {
+	path, err := servicePath(cmd.name, cmd.system)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no service named '%s' is installed at %s", cmd.name, path)
+	}
+	if err := stopService(cmd.name, cmd.system, path); err != nil {
+		return err
+	}
+	slog.Info("uninstalled service", "name", cmd.name)
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Services are launchd jobs: agents of the user, or daemons with --system.
+// launchd restarts the runner if it exits with an error, and its output goes
+// to a log file, since there's no journal to send it to.
+
+// serviceLabel returns the launchd label of the named service.
+func serviceLabel(name string) string {
+	return "com.github.mpobrien." + name
+}
+
+// servicePath returns where the named service's plist is installed.
+func servicePath(name string, system bool) (string, error) {
+	if system {
+		return filepath.Join("/Library/LaunchDaemons", serviceLabel(name)+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", serviceLabel(name)+".plist"), nil
+}
+
+// serviceLogPath returns where the named service's runner logs to.
+func serviceLogPath(name string, system bool) (string, error) {
+	if system {
+		return filepath.Join("/Library/Logs/chime", name+".log"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "Logs", "chime", name+".log"), nil
+}
+
+func plistString(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return "<string>" + sb.String() + "</string>"
+}
+
+// serviceDefinition returns the contents of the plist.
+func serviceDefinition(cmd installService) (string, error) {
+	argv, err := cmd.argv()
+	if err != nil {
+		return "", err
+	}
+	env, err := serviceEnv()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	logPath, err := serviceLogPath(cmd.name, cmd.system)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&sb, "\t<key>Label</key>\n\t%s\n", plistString(serviceLabel(cmd.name)))
+	sb.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range argv {
+		fmt.Fprintf(&sb, "\t\t%s\n", plistString(arg))
+	}
+	sb.WriteString("\t</array>\n")
+	fmt.Fprintf(&sb, "\t<key>WorkingDirectory</key>\n\t%s\n", plistString(dir))
+	if cmd.system {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "\t<key>UserName</key>\n\t%s\n", plistString(u.Username))
+	}
+	if len(env) > 0 {
+		sb.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, key := range slices.Sorted(maps.Keys(env)) {
+			fmt.Fprintf(&sb, "\t\t<key>%s</key>\n\t\t%s\n", key, plistString(env[key]))
+		}
+		sb.WriteString("\t</dict>\n")
+	}
+	fmt.Fprintf(&sb, "\t<key>StandardOutPath</key>\n\t%s\n", plistString(logPath))
+	fmt.Fprintf(&sb, "\t<key>StandardErrorPath</key>\n\t%s\n", plistString(logPath))
+	sb.WriteString(`	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+`)
+	// The first SIGTERM lets running jobs finish; give them a day to.
+	sb.WriteString("\t<key>ExitTimeOut</key>\n\t<integer>86400</integer>\n</dict>\n</plist>\n")
+	return sb.String(), nil
+}
+
+// launchdDomain returns the launchd domain services are loaded into.
+func launchdDomain(system bool) string {
+	if system {
+		return "system"
+	}
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+func launchctl(args ...string) error {
+	c := exec.Command("launchctl", args...)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("launchctl %s failed: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// startService loads the plist installed at path, which starts the runner.
+func startService(name string, system bool, path string) error {
+	logPath, err := serviceLogPath(name, system)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		return err
+	}
+	// Reinstalling replaces the loaded service.
+	exec.Command("launchctl", "bootout", launchdDomain(system)+"/"+serviceLabel(name)).Run()
+	if err := launchctl("bootstrap", launchdDomain(system), path); err != nil {
+		return err
+	}
+	slog.Info("the runner logs to", "path", logPath)
+	return nil
+}
+
+// stopService unloads and removes the plist installed at path.
+func stopService(name string, system bool, path string) error {
+	if err := launchctl("bootout", launchdDomain(system)+"/"+serviceLabel(name)); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
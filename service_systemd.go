@@ -0,0 +1,119 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Services are systemd units.
+
+// servicePath returns where the named unit is installed.
+func servicePath(name string, system bool) (string, error) {
+	if system {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+// systemdQuote quotes a word of an ExecStart line, if it needs it.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`).Replace(s) + `"`
+}
+
+// serviceDefinition returns the contents of the unit file.
+func serviceDefinition(cmd installService) (string, error) {
+	argv, err := cmd.argv()
+	if err != nil {
+		return "", err
+	}
+	env, err := serviceEnv()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	// The runner's DB, for the description.
+	dbPath := argv[2]
+	for i, arg := range argv {
+		argv[i] = systemdQuote(arg)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Unit]\nDescription=chime runner for %s\nAfter=network-online.target\nWants=network-online.target\n\n", strings.ReplaceAll(dbPath, "%", "%%"))
+	fmt.Fprintf(&sb, "[Service]\nExecStart=%s\nWorkingDirectory=%s\n", strings.Join(argv, " "), strings.ReplaceAll(dir, "%", "%%"))
+	if cmd.system {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "User=%s\n", u.Username)
+	}
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		fmt.Fprintf(&sb, "Environment=%s\n", systemdQuote(key+"="+env[key]))
+	}
+	// The first SIGTERM lets running jobs finish; don't cut them short.
+	sb.WriteString("KillMode=mixed\nTimeoutStopSec=infinity\nRestart=on-failure\nRestartSec=5\n\n")
+	target := "default.target"
+	if cmd.system {
+		target = "multi-user.target"
+	}
+	fmt.Fprintf(&sb, "[Install]\nWantedBy=%s\n", target)
+	return sb.String(), nil
+}
+
+// systemctl runs systemctl for a user or system unit.
+func systemctl(system bool, args ...string) error {
+	if !system {
+		args = append([]string{"--user"}, args...)
+	}
+	c := exec.Command("systemctl", args...)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("systemctl %s failed: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// startService enables and starts the unit installed at path.
+func startService(name string, system bool, path string) error {
+	if err := systemctl(system, "daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl(system, "enable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if !system {
+		slog.Info("to keep the service running while logged out, run: loginctl enable-linger")
+	}
+	return nil
+}
+
+// stopService stops, disables and removes the unit installed at path.
+func stopService(name string, system bool, path string) error {
+	if err := systemctl(system, "disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl(system, "daemon-reload")
+}
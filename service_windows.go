@@ -0,0 +1,23 @@
+package main
+
+import "errors"
+
+// There's no installing the runner as a Windows service yet.
+
+var errServiceUnsupported = errors.New("installing services isn't supported on Windows")
+
+func servicePath(name string, system bool) (string, error) {
+	return "", errServiceUnsupported
+}
+
+func serviceDefinition(cmd installService) (string, error) {
+	return "", errServiceUnsupported
+}
+
+func startService(name string, system bool, path string) error {
+	return errServiceUnsupported
+}
+
+func stopService(name string, system bool, path string) error {
+	return errServiceUnsupported
+}
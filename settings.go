@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Keys of settings stored in the DB.
+const (
+	// Age after which finished jobs are purged when `chime run` starts.
+	settingAutoPurge = "auto_purge_older_than"
+	// Queues runners don't take jobs from; see pause.go.
+	settingPausedQueues = "paused_queues"
+)
+
+// GetSetting returns the value of a setting, and whether it is set.
+func (db *DB) GetSetting(key string) (string, bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var value string
+	if err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (db *DB) SetSetting(key, value string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO settings (key, value) VALUES (?,?)
+	ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func (db *DB) DeleteSetting(key string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`DELETE FROM settings WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shortest UUID prefix accepted in place of a job ID.
+const minUUIDPrefix = 4
+
+// resolveJob finds the job referred to by ref, which is a job ID, a job name,
+// or a prefix of a job's UUID.
+func resolveJob(db *DB, ref string) (*Job, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		job, err := db.GetJob(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		if job == nil {
+			return nil, fmt.Errorf("no job '%s'", ref)
+		}
+		return job, nil
+	}
+
+	job, err := db.FindJobByName(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job: %w", err)
+	}
+	if job != nil {
+		return job, nil
+	}
+
+	if isUUIDPrefix(ref) {
+		jobs, err := db.FindJobsByUUIDPrefix(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		switch len(jobs) {
+		case 1:
+			return &jobs[0], nil
+		case 2:
+			return nil, fmt.Errorf("UUID prefix '%s' matches more than one job", ref)
+		}
+	}
+	return nil, fmt.Errorf("no job '%s'", ref)
+}
+
+func isUUIDPrefix(s string) bool {
+	if len(s) < minUUIDPrefix || len(s) > 36 {
+		return false
+	}
+	for _, r := range strings.ToLower(s) {
+		if !strings.ContainsRune("0123456789abcdef-", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// JobJSON is the representation of a job in JSON output.
+type JobJSON struct {
+	ID         int        `json:"id"`
+	UUID       string     `json:"uuid"`
+	Name       string     `json:"name,omitempty"`
+	Command    string     `json:"command"`
+	Script     string     `json:"script,omitempty"`
+	Status     string     `json:"status"`
+	Queue      string     `json:"queue"`
+	Priority   int        `json:"priority"`
+	Tags       []string   `json:"tags"`
+	Template   string     `json:"template,omitempty"`
+	ArrayID    int        `json:"array_id,omitempty"`
+	ArrayIndex int        `json:"array_index,omitempty"`
+	BatchID    int        `json:"batch_id,omitempty"`
+	EnvMode    string     `json:"env_mode,omitempty"`
+	EnvAllow   []string   `json:"env_allow,omitempty"`
+	Env        []string   `json:"env,omitempty"`
+	PID        int        `json:"pid,omitempty"`
+	Nice       int        `json:"nice,omitempty"`
+	IONice     string     `json:"ionice,omitempty"`
+	MemLimit   int64      `json:"mem_limit,omitempty"`
+	CPULimit   float64    `json:"cpu_limit,omitempty"`
+	Failure    string     `json:"failure,omitempty"`
+	Image      string     `json:"image,omitempty"`
+	Host       string     `json:"host,omitempty"`
+	LeaseOwner string     `json:"lease_owner,omitempty"`
+	Requires   []string   `json:"requires,omitempty"`
+	Resources  []string   `json:"resources,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	OutputPath string     `json:"output_path,omitempty"`
+	Artifacts  string     `json:"artifacts_dir,omitempty"`
+	User       string     `json:"user,omitempty"`
+	TimeWindow string     `json:"window,omitempty"`
+	OnSuccess  string     `json:"on_success,omitempty"`
+	OnFailure  string     `json:"on_failure,omitempty"`
+	ParentID   int64      `json:"parent_id,omitempty"`
+	FollowUp   bool       `json:"follow_up,omitempty"`
+	MaxRetries int        `json:"max_retries,omitempty"`
+	Retries    int        `json:"retries,omitempty"`
+	Timeout    string     `json:"timeout,omitempty"`
+	Lock       string     `json:"lock,omitempty"`
+	Slots      int        `json:"slots"`
+	Worker     string     `json:"worker,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	UserCPU    *int64     `json:"user_cpu_ms,omitempty"`
+	SysCPU     *int64     `json:"sys_cpu_ms,omitempty"`
+	MaxRSS     *int64     `json:"max_rss_kb,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// Latest progress the job reported; see progress.go.
+	Progress *jobProgress `json:"progress,omitempty"`
+}
+
+func (job Job) JSON() JobJSON {
+	out := JobJSON{
+		ID:         job.ID,
+		UUID:       job.UUID,
+		Name:       job.Name,
+		Command:    job.Command,
+		Script:     job.Script,
+		Status:     statusNames[job.Status],
+		Queue:      job.Queue,
+		Priority:   job.Priority,
+		Tags:       job.Tags,
+		Template:   job.Template,
+		ArrayID:    job.ArrayID,
+		ArrayIndex: job.ArrayIndex,
+		BatchID:    job.BatchID,
+		EnvMode:    job.EnvMode,
+		EnvAllow:   job.EnvAllow,
+		Env:        job.Env,
+		PID:        job.PID,
+		Nice:       job.Nice,
+		IONice:     job.IONice,
+		MemLimit:   job.MemLimit,
+		CPULimit:   job.CPULimit,
+		Failure:    job.Failure,
+		Image:      job.Image,
+		Host:       job.Host,
+		LeaseOwner: job.LeaseOwner,
+		Requires:   job.Requires,
+		Resources:  job.Resources,
+		Note:       job.Note,
+		OutputPath: job.OutputPath,
+		Artifacts:  job.ArtifactsDir,
+		User:       job.SubmittedBy,
+		TimeWindow: job.TimeWindow,
+		OnSuccess:  job.OnSuccess,
+		OnFailure:  job.OnFailure,
+		ParentID:   job.ParentID,
+		FollowUp:   job.FollowUp,
+		MaxRetries: job.MaxRetries,
+		Retries:    job.Retries,
+		Lock:       job.Lock,
+		Slots:      job.Slots,
+		Worker:     job.Worker,
+		CreatedAt:  job.CreatedAtTime(),
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	if job.StartedAt != 0 {
+		t := job.StartedAtTime()
+		out.StartedAt = &t
+	}
+	if job.FinishedAt != 0 {
+		t := job.FinishedAtTime()
+		out.FinishedAt = &t
+	}
+	if job.Timeout > 0 {
+		out.Timeout = job.timeout().String()
+	}
+	if job.Progress >= 0 {
+		out.Progress = &jobProgress{job.Progress, job.ProgressMessage}
+	}
+	if job.ExitCode >= 0 {
+		code := job.ExitCode
+		out.ExitCode = &code
+	}
+	if _, ok := job.CPUTime(); ok {
+		user, sys := job.UserCPU, job.SysCPU
+		out.UserCPU, out.SysCPU = &user, &sys
+	}
+	if job.MaxRSS >= 0 {
+		rss := job.MaxRSS
+		out.MaxRSS = &rss
+	}
+	return out
+}
+
+// validateJobName checks a name given to a job. Names can't look like IDs,
+// so that anywhere a job ID is accepted a name can be given instead.
+func validateJobName(name string) error {
+	if err := validateName("job name", name); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return fmt.Errorf("invalid job name '%s': names can't be numbers", name)
+	}
+	return nil
+}
+
+type show struct {
+	globalArgs
+	ref    string
+	asJSON bool
+}
+
+func parseShowSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := show{globalArgs: globals}
+	fs := flag.NewFlagSet(showCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.asJSON, "json", false, "print the job as JSON")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 && canPickJob() {
+		return cmd, nil
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: job ID, name or UUID to show")
+	}
+	cmd.ref = fs.Arg(0)
+	return cmd, nil
+}
+
+func (cmd show) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if cmd.ref == "" {
+		if cmd.ref, err = pickJob(db, "show"); err != nil {
+			return err
+		}
+	}
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+
+	if cmd.asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(job.JSON())
+	}
+
+	fmt.Printf("Job #%d\n", job.ID)
+	field := func(name string, format string, args ...any) {
+		fmt.Printf("  %-10s %s\n", name+":", fmt.Sprintf(format, args...))
+	}
+	if job.Name != "" {
+		field("name", "%s", job.Name)
+	}
+	field("uuid", "%s", job.UUID)
+	field("command", "%s", job.Command)
+	if job.Note != "" {
+		field("note", "%s", job.Note)
+	}
+	if job.OutputPath != "" {
+		if _, err := os.Stat(job.OutputPath); err != nil {
+			field("output", "%s (removed)", job.OutputPath)
+		} else {
+			field("output", "%s", job.OutputPath)
+		}
+	}
+	if job.ArtifactsDir != "" {
+		field("artifacts", "%s", job.ArtifactsDir)
+	}
+	if progress := formatProgress(*job); progress != "" {
+		field("progress", "%s", progress)
+	}
+	field("status", "%s", statusNames[job.Status])
+	if job.SubmittedBy != "" {
+		field("user", "%s", job.SubmittedBy)
+	}
+	field("queue", "%s", job.Queue)
+	interval, err := db.AgingInterval(job.Queue)
+	if err != nil {
+		return fmt.Errorf("failed to read priority aging: %w", err)
+	}
+	if aged := agedPriority(job, interval, time.Now()); aged != job.Priority {
+		field("priority", "%d (%d with aging)", job.Priority, aged)
+	} else if job.Priority != 0 {
+		field("priority", "%d", job.Priority)
+	}
+	if len(job.Tags) > 0 {
+		field("tags", "%s", strings.Join(job.Tags, ", "))
+	}
+	if len(job.Requires) > 0 {
+		field("requires", "%s", strings.Join(job.Requires, ", "))
+	}
+	if len(job.Resources) > 0 {
+		field("resources", "%s", strings.Join(job.Resources, ", "))
+	}
+	if job.TimeWindow != "" {
+		if job.Status == statusPending && !inTimeWindow(job.TimeWindow, clockTime(time.Now())) {
+			field("window", "%s (waiting)", job.TimeWindow)
+		} else {
+			field("window", "%s", job.TimeWindow)
+		}
+	}
+	if job.Template != "" {
+		field("template", "%s", job.Template)
+	}
+	if job.ArrayID != 0 {
+		field("array", "#%d, index %d", job.ArrayID, job.ArrayIndex)
+	}
+	if job.BatchID != 0 {
+		field("batch", "#%d", job.BatchID)
+	}
+	if job.Lock != "" {
+		field("lock", "%s", job.Lock)
+	}
+	if job.Slots > 1 {
+		field("slots", "%d", job.Slots)
+	}
+	if job.MaxRetries > 0 {
+		field("retries", "%d of %d", job.Retries, job.MaxRetries)
+	}
+	if job.Timeout > 0 {
+		field("timeout", "%s", job.timeout())
+	}
+	if job.ParentID != 0 && job.FollowUp {
+		field("parent", "#%d, as its follow-up", job.ParentID)
+	} else if job.ParentID != 0 {
+		field("parent", "#%d", job.ParentID)
+	}
+	if job.OnSuccess != "" {
+		field("on ok", "%s", job.OnSuccess)
+	}
+	if job.OnFailure != "" {
+		field("on fail", "%s", job.OnFailure)
+	}
+	field("created", "%s", job.CreatedAtTime().Format(time.DateTime))
+	if job.StartedAt != 0 {
+		field("started", "%s", job.StartedAtTime().Format(time.DateTime))
+	}
+	if job.FinishedAt != 0 {
+		field("finished", "%s", job.FinishedAtTime().Format(time.DateTime))
+		field("duration", "%s", job.FinishedAtTime().Sub(job.StartedAtTime()))
+	}
+	if job.ExitCode >= 0 {
+		field("exit code", "%d", job.ExitCode)
+	}
+	if job.Failure != "" {
+		field("failure", "%s", job.Failure)
+	}
+	if _, ok := job.CPUTime(); ok {
+		field("cpu time", "%s", formatCPU(*job))
+	}
+	if job.MaxRSS >= 0 {
+		field("max rss", "%s", formatRSS(job.MaxRSS))
+	}
+	if job.Worker != "" {
+		field("worker", "%s", job.Worker)
+	}
+	if job.PID > 0 {
+		field("pid", "%d", job.PID)
+	}
+	if (job.Status == statusInProgress || job.Status == statusSuspended) && job.LeaseOwner != "" {
+		field("leased to", "%s until %s", job.LeaseOwner, time.UnixMilli(job.LeaseExpiresAt).Format(time.DateTime))
+	}
+	if job.Image != "" {
+		field("image", "%s", job.Image)
+	}
+	if job.Host != "" {
+		field("host", "%s", job.Host)
+	}
+	if job.Nice != 0 {
+		field("nice", "%d", job.Nice)
+	}
+	if job.IONice != "" {
+		field("ionice", "%s", job.IONice)
+	}
+	if job.MemLimit > 0 {
+		field("mem limit", "%s", formatBytes(job.MemLimit))
+	}
+	if job.CPULimit > 0 {
+		field("cpu limit", "%g cores", job.CPULimit)
+	}
+	if job.EnvMode != "" {
+		field("env mode", "%s", job.EnvMode)
+	}
+	if len(job.EnvAllow) > 0 {
+		field("env allow", "%s", strings.Join(job.EnvAllow, ", "))
+	}
+	for _, kv := range job.Env {
+		field("env", "%s", kv)
+	}
+	if job.Script != "" {
+		fmt.Printf("\nScript:\n%s", job.Script)
+		if !strings.HasSuffix(job.Script, "\n") {
+			fmt.Println()
+		}
+	}
+	descendants, err := db.JobDescendants(int64(job.ID))
+	if err != nil {
+		return fmt.Errorf("failed to read child jobs: %w", err)
+	}
+	if len(descendants) > 0 {
+		fmt.Printf("\nChildren:\n%s\n", jobTree(*job, descendants))
+	}
+	return nil
+}
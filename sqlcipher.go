@@ -0,0 +1,51 @@
+//go:build sqlcipher
+
+package main
+
+// Encrypted DBs are supported by linking against SQLCipher instead of the
+// SQLite bundled with go-sqlite3:
+//
+//	CGO_CFLAGS="-DSQLITE_HAS_CODEC -I/usr/include/sqlcipher" CGO_LDFLAGS="-lsqlcipher" \
+//		go build -tags "sqlite_fts5 sqlcipher libsqlite3" -o chime .
+//
+// Job search works if SQLCipher was built with FTS5.
+//
+// Every connection is keyed as it's opened, so the rest of chime doesn't
+// need to know whether the DB is encrypted.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(filename, key string) (*sql.DB, error) {
+	if key == "" {
+		return sql.OpenDB(newSQLiteConnector(filename, nil)), nil
+	}
+
+	db := sql.OpenDB(newSQLiteConnector(filename, func(conn *sqlite3.SQLiteConn) error {
+		_, err := conn.Exec(fmt.Sprintf("PRAGMA key = '%s'", strings.ReplaceAll(key, "'", "''")), nil)
+		return err
+	}))
+
+	// Without SQLCipher, PRAGMA key is silently ignored and the DB would be
+	// written unencrypted.
+	var version string
+	if err := db.QueryRow(`PRAGMA cipher_version`).Scan(&version); err != nil {
+		db.Close()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("chime isn't linked against SQLCipher, so can't open encrypted DBs")
+		}
+		return nil, err
+	}
+	// Reading the schema fails if the key is wrong.
+	if _, err := db.Exec(`SELECT count(*) FROM sqlite_master`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to decrypt DB (wrong key?): %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Every connection to the DB has chime's own SQL functions registered as
+// it's opened: regexp, which SQLite calls for `X REGEXP Y` but doesn't
+// provide itself. In read-only mode, connections are also made query-only.
+//
+// Transactions take the DB's write lock as they begin, since runners, the
+// commands their jobs run and users all write to it at once: a transaction
+// that only asked for the lock once it wrote, having read first, would fail
+// straight away if another held it, rather than waiting its turn.
+
+// sqliteConnector opens connections to a DB, running setup, if it isn't
+// nil, on each after registering the functions.
+type sqliteConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func newSQLiteConnector(dsn string, setup func(conn *sqlite3.SQLiteConn) error) sqliteConnector {
+	d := &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("regexp", sqlRegexp, true); err != nil {
+				return err
+			}
+			if setup != nil {
+				if err := setup(conn); err != nil {
+					return err
+				}
+			}
+			if readOnly {
+				_, err := conn.Exec("PRAGMA query_only = ON", nil)
+				return err
+			}
+			return nil
+		},
+	}
+	// The DSN may already have parameters, like a backup opened with mode=ro.
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return sqliteConnector{driver: d, dsn: dsn + sep + "_txlock=immediate"}
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Compiled patterns of regexp, since a query calls it once per row.
+var sqlRegexps sync.Map
+
+// sqlRegexp reports whether s matches the regular expression pattern.
+func sqlRegexp(pattern, s string) (bool, error) {
+	re, ok := sqlRegexps.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		re, _ = sqlRegexps.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(s), nil
+}
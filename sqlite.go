@@ -0,0 +1,15 @@
+//go:build !sqlcipher
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+func openSQLite(filename, key string) (*sql.DB, error) {
+	if key != "" {
+		return nil, fmt.Errorf("a DB key is set, but chime was built without encryption support (build with -tags sqlcipher)")
+	}
+	return sql.OpenDB(newSQLiteConnector(filename, nil)), nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Environment variable naming the ssh binary used to run jobs on remote
+// hosts. Hosts, users and keys come from the usual ssh config.
+const chimeSSHEnvKey = "CHIME_SSH"
+
+// Exit code ssh uses for its own errors, e.g. when it can't connect.
+const sshErrorExitCode = 255
+
+// HostPool is a named set of hosts. A job added with a pool's name as its
+// host runs on whichever of the pool's hosts is least busy.
+type HostPool struct {
+	Name  string
+	Hosts CommaList
+}
+
+// SetHostPool creates the named pool, replacing any existing one.
+func (db *DB) SetHostPool(name string, hosts []string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO host_pools (name, hosts) VALUES (?,?)
+	ON CONFLICT (name) DO UPDATE SET hosts = excluded.hosts`,
+		name, CommaList(hosts).String())
+	return err
+}
+
+// GetHostPool returns the named pool, or nil if it doesn't exist.
+func (db *DB) GetHostPool(name string) (*HostPool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var p HostPool
+	if err := db.QueryRow(`SELECT name, hosts FROM host_pools WHERE name = ?`, name).Scan(&p.Name, &p.Hosts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (db *DB) ListHostPools() ([]HostPool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT name, hosts FROM host_pools ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pools []HostPool
+	for rows.Next() {
+		var p HostPool
+		if err := rows.Scan(&p.Name, &p.Hosts); err != nil {
+			return pools, err
+		}
+		pools = append(pools, p)
+	}
+	return pools, rows.Err()
+}
+
+// Deletes the named pool. Returns true if the pool existed.
+func (db *DB) DeleteHostPool(name string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM host_pools WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// hostPicker spreads a runner's jobs over the hosts of their pools.
+type hostPicker struct {
+	lock    sync.Mutex
+	running map[string]int
+}
+
+func newHostPicker() *hostPicker {
+	return &hostPicker{running: map[string]int{}}
+}
+
+// pick returns the host to run a job with the given host or pool name on,
+// and a func to call once the job has finished there.
+func (p *hostPicker) pick(db jobStore, target string) (string, func(), error) {
+	pool, err := db.GetHostPool(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read host pool: %w", err)
+	}
+	hosts := []string{target}
+	if pool != nil {
+		if len(pool.Hosts) == 0 {
+			return "", nil, fmt.Errorf("host pool '%s' has no hosts", target)
+		}
+		hosts = pool.Hosts
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	host := hosts[0]
+	for _, h := range hosts[1:] {
+		if p.running[h] < p.running[host] {
+			host = h
+		}
+	}
+	p.running[host]++
+	return host, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.running[host]--
+	}, nil
+}
+
+// validateHost checks a host or host pool name given to --host or hosts set.
+// Names can't start with a dash, which ssh would take as an option.
+func validateHost(host string) error {
+	if err := validateName("host", host); err != nil {
+		return err
+	}
+	if strings.HasPrefix(host, "-") {
+		return fmt.Errorf("invalid host '%s': must not start with '-'", host)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshCommand returns the command that runs a job on a remote host. The job
+// gets its own environment and CHIME_* variables there, not the runner's,
+// and runs in the remote user's home directory. Scripts are sent on stdin.
+func sshCommand(job *Job, host string) *exec.Cmd {
+	ssh := os.Getenv(chimeSSHEnvKey)
+	if ssh == "" {
+		ssh = "ssh"
+	}
+
+	var env []string
+	for _, kv := range append(append([]string(nil), job.Env...), chimeJobVars(job)...) {
+		env = append(env, shellQuote(kv))
+	}
+	envPrefix := ""
+	if len(env) > 0 {
+		envPrefix = "env " + strings.Join(env, " ") + " "
+	}
+
+	var remote string
+	if job.Script == "" {
+		remote = envPrefix + "sh -c " + shellQuote(job.Command)
+	} else {
+		run := `sh "$f"`
+		if strings.HasPrefix(job.Script, "#!") {
+			run = `chmod +x "$f" && "$f"`
+		}
+		remote = `f=$(mktemp) && cat > "$f" && ` + envPrefix + run + `; rc=$?; rm -f "$f"; exit $rc`
+	}
+
+	// BatchMode makes ssh fail rather than prompt for a password, and "--"
+	// stops it reading the host as an option.
+	cmd := exec.Command(ssh, "-T", "-o", "BatchMode=yes", "--", host, remote)
+	if job.Script != "" {
+		cmd.Stdin = strings.NewReader(job.Script)
+	}
+	return cmd
+}
+
+// execRemoteJob runs a job on its host, or the least busy host of its pool,
+// over ssh. The remote command's output is copied to the runner's, and its
+// exit code recorded; resource usage isn't known for remote jobs.
+func execRemoteJob(db jobStore, cfg execConfig, job *Job) (*jobResult, error) {
+	result := &jobResult{Job: job, ExitCode: -1, StartedAt: time.Now()}
+	finish := func(status int, failure string) (*jobResult, error) {
+		result.FinishedAt = time.Now()
+		if err := db.FinishJob(int64(job.ID), int64(status), result.ExitCode, unknownUsage, failure); err != nil {
+			return result, fmt.Errorf("failed to set status of job #%d: %w", job.ID, err)
+		}
+		return result, nil
+	}
+
+	host, release, err := cfg.hosts.pick(db, job.Host)
+	if err != nil {
+		result.Err = err
+		return finish(statusDoneFailed, err.Error())
+	}
+	defer release()
+	// Jobs imported or pools set by older versions weren't validated.
+	if err := validateHost(host); err != nil {
+		result.Err = err
+		return finish(statusDoneFailed, err.Error())
+	}
+
+	cmd := sshCommand(job, host)
+	cmd.Stdout = cfg.stdout()
+	cmd.Stderr = cfg.stderr()
+	if err := cmd.Start(); err != nil {
+		result.Err = fmt.Errorf("failed to run ssh: %w", err)
+		return finish(statusDoneFailed, result.Err.Error())
+	}
+	if err := db.SetRemoteJobPID(int64(job.ID), int64(cmd.Process.Pid), host); err != nil {
+		slog.Error("failed to set job pid", "id", job.ID, "err", err)
+	}
+	err = cmd.Wait()
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	if err == nil {
+		return finish(statusDoneSuccess, "")
+	}
+
+	failure := err.Error()
+	if result.ExitCode == sshErrorExitCode {
+		// Most likely ssh itself failed, though the job could exit with 255.
+		failure = fmt.Sprintf("ssh to %s failed (exit status %d)", host, sshErrorExitCode)
+	}
+	result.Err = errors.New(failure)
+	return finish(statusDoneFailed, failure)
+}
+
+type hostsSet struct {
+	globalArgs
+	name  string
+	hosts []string
+}
+
+type hostsList struct {
+	globalArgs
+}
+
+type hostsRemove struct {
+	globalArgs
+	name string
+}
+
+func parseHostsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("hosts command required: set, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "set":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("params required: pool name and hosts")
+		}
+		if err := validateName("host pool", args[0]); err != nil {
+			return nil, err
+		}
+		for _, host := range args[1:] {
+			if err := validateHost(host); err != nil {
+				return nil, err
+			}
+		}
+		return hostsSet{globalArgs: globals, name: args[0], hosts: args[1:]}, nil
+	case "list":
+		return hostsList{globalArgs: globals}, nil
+	case "remove":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: pool name to remove")
+		}
+		return hostsRemove{globalArgs: globals, name: args[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown hosts command: '%s'", cmd)
+}
+
+func (cmd hostsSet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	return db.SetHostPool(cmd.name, cmd.hosts)
+}
+
+func (cmd hostsList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	pools, err := db.ListHostPools()
+	if err != nil {
+		return fmt.Errorf("failed to list host pools: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(pools) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("POOL", "HOSTS")
+	for _, p := range pools {
+		t.Row(p.Name, strings.Join(p.Hosts, ", "))
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd hostsRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeleteHostPool(cmd.name)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("no host pool named '%s'", cmd.name)
+	}
+	return nil
+}
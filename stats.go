@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// finishedJob is the timing and resource usage of a job that has finished.
+type finishedJob struct {
+	ID         int
+	Command    string
+	Status     int
+	StartedAt  int64
+	FinishedAt int64
+	UserCPU    int64
+	SysCPU     int64
+	MaxRSS     int64
+}
+
+func (j finishedJob) Duration() time.Duration {
+	if j.StartedAt == 0 {
+		return 0
+	}
+	return time.Duration(j.FinishedAt-j.StartedAt) * time.Millisecond
+}
+
+// FinishedJobs returns the jobs, including archived ones, that finished at or
+// after since (in Unix milliseconds).
+func (db *DB) FinishedJobs(since int64) ([]finishedJob, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT id, command, status, started_at, finished_at, user_cpu_ms, sys_cpu_ms, max_rss_kb FROM jobs
+	WHERE status IN (?, ?, ?, ?) AND finished_at >= ?
+	UNION ALL
+	SELECT id, command, status, started_at, finished_at, user_cpu_ms, sys_cpu_ms, max_rss_kb FROM archived_jobs
+	WHERE status IN (?, ?, ?, ?) AND finished_at >= ?`,
+		statusDoneSuccess, statusDoneFailed, statusTimedOut, statusDeadLetter, since,
+		statusDoneSuccess, statusDoneFailed, statusTimedOut, statusDeadLetter, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []finishedJob
+	for rows.Next() {
+		var j finishedJob
+		if err := rows.Scan(&j.ID, &j.Command, &j.Status, &j.StartedAt, &j.FinishedAt, &j.UserCPU, &j.SysCPU, &j.MaxRSS); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// jobStats summarizes the jobs that finished in a window of time.
+type jobStats struct {
+	Since       time.Time `json:"since"`
+	Finished    int       `json:"finished"`
+	Succeeded   int       `json:"succeeded"`
+	Failed      int       `json:"failed"`
+	SuccessRate float64   `json:"success_rate"`
+	// Jobs finished per hour over the window.
+	Throughput float64 `json:"throughput_per_hour"`
+	P50        float64 `json:"p50_seconds"`
+	P95        float64 `json:"p95_seconds"`
+	// Hours of the day (local time) in which the most jobs finished, busiest first.
+	BusiestHours []hourCount `json:"busiest_hours"`
+
+	// Estimated time for the pending jobs to finish, based on how long
+	// previous runs of their commands took.
+	Pending      int     `json:"pending"`
+	DrainWorkers int     `json:"drain_workers"`
+	DrainTime    float64 `json:"drain_time_seconds"`
+	// Number of pending jobs with no history, assumed to take the median.
+	DrainUnknown int `json:"drain_unknown"`
+
+	// Resource usage of the jobs for which it was recorded. P95MaxRSS is -1
+	// if none were.
+	CPUTime     float64    `json:"cpu_seconds"`
+	P95MaxRSS   int64      `json:"p95_max_rss_kb"`
+	HeaviestCPU []heavyJob `json:"heaviest_cpu"`
+	HeaviestRSS []heavyJob `json:"heaviest_rss"`
+}
+
+type hourCount struct {
+	Hour int `json:"hour"`
+	Jobs int `json:"jobs"`
+}
+
+type heavyJob struct {
+	ID      int     `json:"id"`
+	Command string  `json:"command"`
+	CPUTime float64 `json:"cpu_seconds"`
+	MaxRSS  int64   `json:"max_rss_kb"`
+}
+
+// Number of hours listed as the busiest, and of jobs listed as the heaviest.
+const (
+	numBusiestHours = 3
+	numHeaviestJobs = 3
+)
+
+func computeStats(jobs []finishedJob, since, now time.Time) jobStats {
+	stats := jobStats{Since: since, Finished: len(jobs)}
+
+	var durations []time.Duration
+	var hours [24]int
+	for _, j := range jobs {
+		if j.Status == statusDoneSuccess {
+			stats.Succeeded++
+		} else {
+			stats.Failed++
+		}
+		if j.StartedAt > 0 {
+			durations = append(durations, j.Duration())
+		}
+		hours[time.UnixMilli(j.FinishedAt).Hour()]++
+	}
+	if stats.Finished > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Finished)
+	}
+	if window := now.Sub(since).Hours(); window > 0 {
+		stats.Throughput = float64(stats.Finished) / window
+	}
+
+	sort.Slice(durations, func(a, b int) bool { return durations[a] < durations[b] })
+	stats.P50 = percentile(durations, 0.50).Seconds()
+	stats.P95 = percentile(durations, 0.95).Seconds()
+
+	for h, n := range hours {
+		if n > 0 {
+			stats.BusiestHours = append(stats.BusiestHours, hourCount{Hour: h, Jobs: n})
+		}
+	}
+	sort.SliceStable(stats.BusiestHours, func(a, b int) bool {
+		return stats.BusiestHours[a].Jobs > stats.BusiestHours[b].Jobs
+	})
+	if len(stats.BusiestHours) > numBusiestHours {
+		stats.BusiestHours = stats.BusiestHours[:numBusiestHours]
+	}
+
+	var cpu, rss []heavyJob
+	var rssSizes []int64
+	for _, j := range jobs {
+		h := heavyJob{ID: j.ID, Command: j.Command, CPUTime: -1, MaxRSS: j.MaxRSS}
+		if j.UserCPU >= 0 && j.SysCPU >= 0 {
+			h.CPUTime = float64(j.UserCPU+j.SysCPU) / 1000
+			stats.CPUTime += h.CPUTime
+			cpu = append(cpu, h)
+		}
+		if j.MaxRSS >= 0 {
+			rss = append(rss, h)
+			rssSizes = append(rssSizes, j.MaxRSS)
+		}
+	}
+	sort.SliceStable(cpu, func(a, b int) bool { return cpu[a].CPUTime > cpu[b].CPUTime })
+	sort.SliceStable(rss, func(a, b int) bool { return rss[a].MaxRSS > rss[b].MaxRSS })
+	stats.HeaviestCPU = cpu[:min(numHeaviestJobs, len(cpu))]
+	stats.HeaviestRSS = rss[:min(numHeaviestJobs, len(rss))]
+	stats.P95MaxRSS = -1
+	if len(rssSizes) > 0 {
+		sort.Slice(rssSizes, func(a, b int) bool { return rssSizes[a] < rssSizes[b] })
+		stats.P95MaxRSS = percentile(rssSizes, 0.95)
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of the sorted values, using the
+// nearest-rank method.
+func percentile[T int64 | time.Duration](sorted []T, p float64) T {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+type stats struct {
+	globalArgs
+	window  time.Duration
+	asJSON  bool
+	workers int
+}
+
+func parseStatsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := stats{globalArgs: globals}
+	var since string
+	fs := flag.NewFlagSet(statsCommandName, flag.ContinueOnError)
+	fs.StringVar(&since, "since", "24h", "only count jobs that finished within this long, e.g. 24h or 7d")
+	fs.BoolVar(&cmd.asJSON, "json", false, "output as JSON")
+	fs.IntVar(&cmd.workers, "workers", 0, "number of workers to estimate the drain time with (default: as many as the last run)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	window, err := parseAge(since)
+	if err != nil || window == 0 {
+		return nil, fmt.Errorf("invalid --since: '%s'", since)
+	}
+	cmd.window = window
+	return cmd, nil
+}
+
+func (cmd stats) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	since := now.Add(-cmd.window)
+	jobs, err := db.FinishedJobs(since.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to read jobs: %w", err)
+	}
+	s := computeStats(jobs, since, now)
+
+	est, err := loadDurationEstimator(db)
+	if err != nil {
+		return fmt.Errorf("failed to read job durations: %w", err)
+	}
+	pending, err := db.queryJobs("jobs", "status = ?", statusPending)
+	if err != nil {
+		return fmt.Errorf("failed to read pending jobs: %w", err)
+	}
+	s.DrainWorkers = cmd.workers
+	if s.DrainWorkers == 0 {
+		s.DrainWorkers = 1
+		if lastRuns, err := db.ListRuns(1); err != nil {
+			return fmt.Errorf("failed to read last run: %w", err)
+		} else if len(lastRuns) > 0 && lastRuns[0].NumWorkers > 0 {
+			s.DrainWorkers = lastRuns[0].NumWorkers
+		}
+	}
+	drain, unknown := est.drainTime(pending, s.DrainWorkers)
+	s.Pending, s.DrainTime, s.DrainUnknown = len(pending), drain.Seconds(), unknown
+
+	if cmd.asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || col == 0 {
+				return headerStyle
+			}
+			return cellStyle
+		})
+
+	busiest := "-"
+	for i, h := range s.BusiestHours {
+		if i == 0 {
+			busiest = ""
+		} else {
+			busiest += ", "
+		}
+		busiest += fmt.Sprintf("%02d:00 (%d)", h.Hour, h.Jobs)
+	}
+	t.Row("WINDOW", fmt.Sprintf("since %s", s.Since.Format(time.DateTime)))
+	t.Row("FINISHED", fmt.Sprintf("%d (%d succeeded, %d failed)", s.Finished, s.Succeeded, s.Failed))
+	t.Row("SUCCESS RATE", fmt.Sprintf("%.1f%%", s.SuccessRate*100))
+	t.Row("THROUGHPUT", fmt.Sprintf("%.1f jobs/hour", s.Throughput))
+	t.Row("P50 DURATION", secondsDuration(s.P50).String())
+	t.Row("P95 DURATION", secondsDuration(s.P95).String())
+	t.Row("BUSIEST HOURS", busiest)
+	drainETA := fmt.Sprintf("~%s with %d workers", secondsDuration(s.DrainTime).Round(time.Second), s.DrainWorkers)
+	if s.DrainUnknown > 0 {
+		drainETA += fmt.Sprintf(" (%d jobs without history)", s.DrainUnknown)
+	}
+	t.Row("PENDING", fmt.Sprintf("%d", s.Pending))
+	t.Row("DRAIN ETA", drainETA)
+	t.Row("CPU TIME", secondsDuration(s.CPUTime).String())
+	t.Row("P95 MAX RSS", formatRSS(s.P95MaxRSS))
+	t.Row("TOP CPU", heavyList(s.HeaviestCPU, func(j heavyJob) string { return secondsDuration(j.CPUTime).String() }))
+	t.Row("TOP MAX RSS", heavyList(s.HeaviestRSS, func(j heavyJob) string { return formatRSS(j.MaxRSS) }))
+
+	fmt.Println(t)
+	return nil
+}
+
+// heavyList formats the heaviest jobs, e.g. "#12 3.1s, #4 2s".
+func heavyList(jobs []heavyJob, amount func(heavyJob) string) string {
+	if len(jobs) == 0 {
+		return "-"
+	}
+	var parts []string
+	for _, j := range jobs {
+		parts = append(parts, fmt.Sprintf("#%d %s", j.ID, amount(j)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// secondsDuration converts seconds to a duration rounded for display.
+func secondsDuration(s float64) time.Duration {
+	return (time.Duration(s * float64(time.Second))).Round(time.Millisecond)
+}
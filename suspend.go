@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"syscall"
+)
+
+// Running jobs can be suspended with SIGSTOP and resumed with SIGCONT, to
+// free up the machine for a while without losing their progress. Jobs run in
+// their own process groups, so the signals reach every process of the job.
+// There's no suspending jobs on Windows.
+
+type suspend struct {
+	globalArgs
+	ref string
+}
+
+type resumeJob struct {
+	globalArgs
+	ref string
+}
+
+func parseSuspendSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to suspend")
+	}
+	return suspend{globalArgs: globals, ref: args[0]}, nil
+}
+
+// runningJobs holds the pids of the jobs this process is running, so the
+// signals it gets can be passed on to them.
+var runningJobs = &processGroups{pids: map[int]bool{}}
+
+type processGroups struct {
+	lock sync.Mutex
+	pids map[int]bool
+}
+
+func (g *processGroups) add(pid int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.pids[pid] = true
+}
+
+func (g *processGroups) remove(pid int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.pids, pid)
+}
+
+// signal sends sig to every running job, and continues any suspended ones
+// so they get it.
+func (g *processGroups) signal(sig syscall.Signal) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for pid := range g.pids {
+		if err := signalJob(pid, sig); err != nil {
+			slog.Warn("failed to signal job", "pid", pid, "err", err)
+			continue
+		}
+		signalJob(pid, sigCont)
+	}
+}
+
+// localJob resolves ref to a job running as a process on this machine.
+func localJob(db *DB, ref string) (*Job, error) {
+	job, err := resolveJob(db, ref)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != statusInProgress && job.Status != statusSuspended {
+		return nil, fmt.Errorf("job #%d isn't running", job.ID)
+	}
+	if job.PID <= 0 || job.Image != "" || job.Host != "" {
+		return nil, fmt.Errorf("job #%d isn't running as a local process", job.ID)
+	}
+	return job, nil
+}
+
+func (cmd suspend) Run() error {
+	return setSuspended(cmd.globalArgs, cmd.ref, true)
+}
+
+func (cmd resumeJob) Run() error {
+	return setSuspended(cmd.globalArgs, cmd.ref, false)
+}
+
+func setSuspended(globals globalArgs, ref string, suspended bool) error {
+	db, err := Open(globals.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := localJob(db, ref)
+	if err != nil {
+		return err
+	}
+	if suspended && job.Status == statusSuspended {
+		return fmt.Errorf("job #%d is already suspended", job.ID)
+	}
+	if !suspended && job.Status != statusSuspended {
+		return fmt.Errorf("job #%d isn't suspended", job.ID)
+	}
+
+	sig := sigStop
+	if !suspended {
+		sig = sigCont
+	}
+	if err := signalJob(job.PID, sig); err != nil {
+		return fmt.Errorf("failed to signal job #%d: %w", job.ID, err)
+	}
+	ok, err := db.SetJobSuspended(int64(job.ID), suspended)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	if !ok {
+		// It finished in the meantime.
+		return fmt.Errorf("job #%d is no longer running", job.ID)
+	}
+	if suspended {
+		slog.Info("suspended job", "id", job.ID)
+	} else {
+		slog.Info("resumed job", "id", job.ID)
+	}
+	return nil
+}
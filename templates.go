@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// JobTemplate is a named, parameterized command. Commands use text/template
+// syntax, e.g. 'kubectl apply -f {{.manifest}}'.
+type JobTemplate struct {
+	Name      string
+	Command   string
+	CreatedAt int64
+}
+
+func parseJobTemplate(name, command string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(command)
+}
+
+// Render substitutes params into the template's command.
+func (t JobTemplate) Render(params map[string]string) (string, error) {
+	tmpl, err := parseJobTemplate(t.Name, t.Command)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, params); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// SetTemplate creates the named template, replacing any existing one.
+func (db *DB) SetTemplate(name, command string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO templates (name, command, created_at) VALUES (?,?,?)
+	ON CONFLICT (name) DO UPDATE SET command = excluded.command`,
+		name, command, time.Now().UnixMilli())
+	return err
+}
+
+// GetTemplate returns the named template, or nil if it doesn't exist.
+func (db *DB) GetTemplate(name string) (*JobTemplate, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var t JobTemplate
+	if err := db.QueryRow(`SELECT name, command, created_at FROM templates WHERE name = ?`, name).
+		Scan(&t.Name, &t.Command, &t.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (db *DB) ListTemplates() ([]JobTemplate, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT name, command, created_at FROM templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []JobTemplate
+	for rows.Next() {
+		var t JobTemplate
+		if err := rows.Scan(&t.Name, &t.Command, &t.CreatedAt); err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// Deletes the named template. Returns true if the template existed.
+func (db *DB) DeleteTemplate(name string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM templates WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+type templateAdd struct {
+	globalArgs
+	name    string
+	command string
+}
+
+type templateList struct {
+	globalArgs
+}
+
+type templateRemove struct {
+	globalArgs
+	name string
+}
+
+func parseTemplateSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("template command required: add, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "add":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("params required: template name and command")
+		}
+		if _, err := parseJobTemplate(args[0], args[1]); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return templateAdd{globalArgs: globals, name: args[0], command: args[1]}, nil
+	case "list":
+		return templateList{globalArgs: globals}, nil
+	case "remove":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: template name to remove")
+		}
+		return templateRemove{globalArgs: globals, name: args[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown template command: '%s'", cmd)
+}
+
+// parseTemplateParams parses key=value arguments into template parameters.
+func parseTemplateParams(args []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid template parameter '%s': expected key=value", arg)
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
+func (cmd templateAdd) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	return db.SetTemplate(cmd.name, cmd.command)
+}
+
+func (cmd templateList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	templates, err := db.ListTemplates()
+	if err != nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(templates) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("NAME", "COMMAND")
+	for _, tmpl := range templates {
+		t.Row(tmpl.Name, tmpl.Command)
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd templateRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeleteTemplate(cmd.name)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("no template named '%s'", cmd.name)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The colors `chime list` and `chime timeline` draw jobs in, and the glyphs
+// jobs' statuses are marked with in plain listings, come from a theme: dark,
+// the default, light, for terminals with a light background, or solarized.
+// The config file picks one, or $CHIME_THEME does, and can override its
+// colors and glyphs:
+//
+//	{"theme": {"name": "light", "colors": {"failed": "#d70000"}, "glyphs": {"succeeded": "✓"}}}
+//
+// Colors are named by status, or text, header and border, and are hex codes
+// like #ff0000 or ANSI colors from 0 to 255.
+
+const chimeThemeEnvKey = "CHIME_THEME"
+
+// themeConfig is the theme section of the config file.
+type themeConfig struct {
+	Name   string            `json:"name"`
+	Colors map[string]string `json:"colors"`
+	Glyphs map[string]string `json:"glyphs"`
+}
+
+// theme holds colors by status name, or text, header or border, and glyphs
+// by status name.
+type theme struct {
+	colors map[string]string
+	glyphs map[string]string
+}
+
+// defaultGlyphs are the glyphs of every built-in theme.
+var defaultGlyphs = map[string]string{
+	"pending":   "[ ]",
+	"running":   "[-]",
+	"succeeded": "[x]",
+	"failed":    "[!]",
+	"suspended": "[z]",
+	"held":      "[h]",
+	"dead":      "[d]",
+	"cancelled": "[c]",
+	"timed-out": "[t]",
+}
+
+var themes = map[string]map[string]string{
+	"dark": {
+		"text":      "#ffffff",
+		"header":    "#ffffff",
+		"border":    "99",
+		"pending":   "#888888",
+		"running":   "#ffff00",
+		"succeeded": "#00ff00",
+		"failed":    "196",
+		"suspended": "#888888",
+		"held":      "#888888",
+		"dead":      "196",
+		"cancelled": "#888888",
+		"timed-out": "196",
+	},
+	"light": {
+		"text":      "#000000",
+		"header":    "#000000",
+		"border":    "61",
+		"pending":   "#6c6c6c",
+		"running":   "#af5f00",
+		"succeeded": "#008700",
+		"failed":    "160",
+		"suspended": "#6c6c6c",
+		"held":      "#6c6c6c",
+		"dead":      "160",
+		"cancelled": "#6c6c6c",
+		"timed-out": "160",
+	},
+	"solarized": {
+		"text":      "#839496",
+		"header":    "#93a1a1",
+		"border":    "#6c71c4",
+		"pending":   "#586e75",
+		"running":   "#b58900",
+		"succeeded": "#859900",
+		"failed":    "#dc322f",
+		"suspended": "#586e75",
+		"held":      "#586e75",
+		"dead":      "#dc322f",
+		"cancelled": "#586e75",
+		"timed-out": "#cb4b16",
+	},
+}
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validateColor(color string) error {
+	if colorPattern.MatchString(color) {
+		return nil
+	}
+	if n, err := strconv.Atoi(color); err == nil && n >= 0 && n <= 255 {
+		return nil
+	}
+	return fmt.Errorf("invalid color '%s' (expected a hex code like #ff0000 or a number from 0 to 255)", color)
+}
+
+// newTheme returns the built-in theme cfg names, or dark, with cfg's
+// overrides.
+func newTheme(cfg themeConfig) (*theme, error) {
+	name := cfg.Name
+	if name == "" {
+		name = "dark"
+	}
+	colors, ok := themes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown theme '%s' (expected dark, light or solarized)", name)
+	}
+	t := &theme{colors: maps.Clone(colors), glyphs: maps.Clone(defaultGlyphs)}
+	for key, color := range cfg.Colors {
+		if _, ok := t.colors[key]; !ok {
+			return nil, fmt.Errorf("unknown theme color '%s' (expected a status, text, header or border)", key)
+		}
+		if err := validateColor(color); err != nil {
+			return nil, fmt.Errorf("theme color '%s': %w", key, err)
+		}
+		t.colors[key] = color
+	}
+	for key, glyph := range cfg.Glyphs {
+		if _, ok := t.glyphs[key]; !ok {
+			return nil, fmt.Errorf("unknown theme glyph '%s' (expected a status)", key)
+		}
+		t.glyphs[key] = glyph
+	}
+	return t, nil
+}
+
+// currentTheme returns the theme set by the config file and $CHIME_THEME.
+// A config that can't be used is warned about once, and the dark theme is
+// used instead.
+var currentTheme = sync.OnceValue(func() *theme {
+	cfg, err := loadUserConfig()
+	if err != nil {
+		slog.Warn("failed to read config file", "err", err)
+	}
+	if name := os.Getenv(chimeThemeEnvKey); name != "" {
+		cfg.Theme.Name = name
+	}
+	t, err := newTheme(cfg.Theme)
+	if err != nil {
+		slog.Warn("failed to load theme, using dark", "err", err)
+		t, _ = newTheme(themeConfig{})
+	}
+	return t
+})
+
+// color returns the theme's color for key.
+func (t *theme) color(key string) lipgloss.Color {
+	return lipgloss.Color(t.colors[key])
+}
+
+// statusColor returns the theme's color for jobs with the given status.
+func (t *theme) statusColor(status int) lipgloss.Color {
+	if color, ok := t.colors[statusNames[status]]; ok {
+		return lipgloss.Color(color)
+	}
+	return t.color("text")
+}
+
+// glyph returns the glyph jobs with the given status are marked with.
+func (t *theme) glyph(status int) string {
+	return t.glyphs[statusNames[status]]
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/term"
+)
+
+// StartedBy returns the actor of the most recent started event of each job
+// started at or after since (in Unix milliseconds), by job UUID. For jobs run
+// by `chime run` this identifies the worker.
+func (db *DB) StartedBy(since int64) (map[string]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`
+	SELECT job_uuid, actor FROM job_events
+	WHERE event = ? AND at >= ?
+	ORDER BY id`, eventStarted, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actors := map[string]string{}
+	for rows.Next() {
+		var uuid, actor string
+		if err := rows.Scan(&uuid, &actor); err != nil {
+			return actors, err
+		}
+		actors[uuid] = actor
+	}
+	return actors, rows.Err()
+}
+
+// timelineLane is a row of the timeline: the jobs run one after another by
+// a single worker.
+type timelineLane struct {
+	label string
+	jobs  []Job
+}
+
+// jobEnd returns when the job finished, or now if it's still running.
+func jobEnd(job Job, now time.Time) time.Time {
+	if job.FinishedAt == 0 {
+		return now
+	}
+	return job.FinishedAtTime()
+}
+
+// timelineLanes groups the jobs into lanes by the worker that ran them. Jobs
+// whose worker isn't known are packed into as few extra lanes as possible.
+func timelineLanes(jobs []Job, workers map[string]string, now time.Time) []timelineLane {
+	byWorker := map[string]*timelineLane{}
+	var lanes []*timelineLane
+	var unknown []*timelineLane
+	for _, job := range jobs {
+		if w, ok := workers[job.UUID]; ok {
+			lane, ok := byWorker[w]
+			if !ok {
+				lane = &timelineLane{label: w}
+				byWorker[w] = lane
+				lanes = append(lanes, lane)
+			}
+			lane.jobs = append(lane.jobs, job)
+			continue
+		}
+
+		var lane *timelineLane
+		for _, l := range unknown {
+			if !jobEnd(l.jobs[len(l.jobs)-1], now).After(job.StartedAtTime()) {
+				lane = l
+				break
+			}
+		}
+		if lane == nil {
+			lane = &timelineLane{label: fmt.Sprintf("lane %d", len(unknown)+1)}
+			unknown = append(unknown, lane)
+		}
+		lane.jobs = append(lane.jobs, job)
+	}
+
+	sort.Slice(lanes, func(a, b int) bool { return lanes[a].label < lanes[b].label })
+	var out []timelineLane
+	for _, l := range append(lanes, unknown...) {
+		out = append(out, *l)
+	}
+	return out
+}
+
+type timeline struct {
+	globalArgs
+	window time.Duration
+}
+
+func parseTimelineSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := timeline{globalArgs: globals}
+	var since string
+	fs := flag.NewFlagSet(timelineCommandName, flag.ContinueOnError)
+	fs.StringVar(&since, "since", "1h", "show jobs that ran within this long, e.g. 1h or 1d")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	window, err := parseAge(since)
+	if err != nil || window == 0 {
+		return nil, fmt.Errorf("invalid --since: '%s'", since)
+	}
+	cmd.window = window
+	return cmd, nil
+}
+
+// Number of the longest jobs listed below the timeline.
+const numLongPoles = 5
+
+func (cmd timeline) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	since := now.Add(-cmd.window).UnixMilli()
+	var jobs []Job
+	for _, table := range []string{"jobs", "archived_jobs"} {
+		found, err := db.queryJobs(table, "started_at > 0 AND (finished_at = 0 OR finished_at >= ?)", since)
+		if err != nil {
+			return fmt.Errorf("failed to read jobs: %w", err)
+		}
+		jobs = append(jobs, found...)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("no jobs ran in that time")
+		return nil
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].StartedAt < jobs[b].StartedAt })
+
+	workers, err := db.StartedBy(since)
+	if err != nil {
+		return fmt.Errorf("failed to read job events: %w", err)
+	}
+	lanes := timelineLanes(jobs, workers, now)
+
+	// The axis runs from the first start to the last finish.
+	start := jobs[0].StartedAtTime()
+	end := start
+	for _, job := range jobs {
+		if e := jobEnd(job, now); e.After(end) {
+			end = e
+		}
+	}
+	span := end.Sub(start)
+	if span <= 0 {
+		span = time.Millisecond
+	}
+
+	labelW := 0
+	for _, l := range lanes {
+		labelW = max(labelW, lipgloss.Width(l.label))
+	}
+	width, _, _ := term.GetSize(int(os.Stdout.Fd()))
+	if width <= 0 {
+		width = 100
+	}
+	barW := max(width-labelW-3, 10)
+
+	theme := currentTheme()
+	styles := map[int]lipgloss.Style{}
+	for _, status := range []int{statusInProgress, statusSuspended, statusDoneSuccess, statusDoneFailed, statusDeadLetter, statusTimedOut, statusCancelled} {
+		styles[status] = lipgloss.NewStyle().Foreground(theme.statusColor(status))
+	}
+	labelStyle := lipgloss.NewStyle().Width(labelW).Foreground(theme.color("text"))
+	axisStyle := lipgloss.NewStyle().Foreground(theme.color("border"))
+
+	col := func(t time.Time) int {
+		return min(int(float64(t.Sub(start))/float64(span)*float64(barW)), barW-1)
+	}
+	for _, lane := range lanes {
+		cells := make([]string, barW)
+		for i := range cells {
+			cells[i] = axisStyle.Render("·")
+		}
+		for i, job := range lane.jobs {
+			from, to := col(job.StartedAtTime()), col(jobEnd(job, now))
+			// Alternate shades so back-to-back jobs can be told apart.
+			glyph := "█"
+			if i%2 == 1 {
+				glyph = "▓"
+			}
+			for c := from; c <= to; c++ {
+				cells[c] = styles[job.Status].Render(glyph)
+			}
+		}
+		fmt.Printf("%s │%s\n", labelStyle.Render(lane.label), strings.Join(cells, ""))
+	}
+
+	startLabel, endLabel := start.Format(time.TimeOnly), end.Format(time.TimeOnly)
+	gap := max(barW-len(startLabel)-len(endLabel), 1)
+	fmt.Printf("%s └%s\n", strings.Repeat(" ", labelW), strings.Repeat("─", barW))
+	fmt.Printf("%s  %s%s%s (%s)\n\n", strings.Repeat(" ", labelW), startLabel, strings.Repeat(" ", gap), endLabel, span.Round(time.Millisecond))
+
+	longest := append([]Job(nil), jobs...)
+	sort.SliceStable(longest, func(a, b int) bool {
+		return jobEnd(longest[a], now).Sub(longest[a].StartedAtTime()) > jobEnd(longest[b], now).Sub(longest[b].StartedAtTime())
+	})
+	fmt.Println("longest jobs:")
+	for _, job := range longest[:min(numLongPoles, len(longest))] {
+		d := jobEnd(job, now).Sub(job.StartedAtTime())
+		fmt.Printf("  #%d  %s  %.0f%% of the timeline  %s\n", job.ID, d.Round(time.Millisecond), 100*float64(d)/float64(span), job.Command)
+	}
+	return nil
+}
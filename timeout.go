@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A job added with --timeout is stopped once it has run for that long, and
+// finishes as timed-out, which is retried like a failure. It gets SIGTERM
+// first, to let it clean up, and SIGKILL if it's still running timeoutGrace
+// later. Timeouts apply to jobs run as local processes, including those in
+// containers.
+
+// How long a job that ran past its timeout gets to exit after SIGTERM
+// before it's killed.
+const timeoutGrace = 10 * time.Second
+
+// jobTimer stops a job's processes once the job's timeout has passed.
+type jobTimer struct {
+	timer  *time.Timer
+	exited chan struct{}
+	fired  atomic.Bool
+}
+
+// startJobTimer starts timing the job, whose process has just started.
+func startJobTimer(job *Job, pid int) *jobTimer {
+	t := &jobTimer{exited: make(chan struct{})}
+	timeout := job.timeout()
+	if timeout <= 0 {
+		return t
+	}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.fired.Store(true)
+		slog.Warn("stopping job that ran past its timeout", "id", job.ID, "timeout", timeout)
+		if err := signalJob(pid, syscall.SIGTERM); err != nil {
+			slog.Warn("failed to stop timed-out job", "id", job.ID, "err", err)
+		}
+		select {
+		case <-t.exited:
+		case <-time.After(timeoutGrace):
+			signalJob(pid, syscall.SIGKILL)
+		}
+	})
+	return t
+}
+
+// stop is called once the job's process has exited, and reports whether the
+// job timed out.
+func (t *jobTimer) stop() bool {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	close(t.exited)
+	return t.fired.Load()
+}
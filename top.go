@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"golang.org/x/term"
+)
+
+// Clock ticks per second used by /proc/<pid>/stat. Linux fixes this at 100
+// for userspace regardless of the kernel's HZ.
+const procClockTicks = 100
+
+// procStat is the part of /proc/<pid>/stat that `chime top` uses.
+type procStat struct {
+	ppid     int
+	cpuTicks uint64 // user + system time
+	rssPages int64
+}
+
+// readProcTable reads the stat of every process on the system, by PID.
+func readProcTable() (map[int]procStat, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no processes found in /proc; top is only supported on Linux")
+	}
+
+	procs := map[int]procStat{}
+	for _, p := range paths {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(p)))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			// The process exited since the glob.
+			continue
+		}
+		if stat, ok := parseProcStat(string(data)); ok {
+			procs[pid] = stat
+		}
+	}
+	return procs, nil
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat. The command name in
+// field 2 may contain spaces and parentheses, so fields are counted from the
+// last ')'.
+func parseProcStat(data string) (procStat, bool) {
+	end := strings.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, false
+	}
+	// Fields from 3 (state) onwards.
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, false
+	}
+	ppid, err1 := strconv.Atoi(fields[1])
+	utime, err2 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err3 := strconv.ParseUint(fields[12], 10, 64)
+	rss, err4 := strconv.ParseInt(fields[21], 10, 64)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return procStat{}, false
+	}
+	return procStat{ppid: ppid, cpuTicks: utime + stime, rssPages: rss}, true
+}
+
+// treeSample is the combined usage of a process and all its descendants.
+type treeSample struct {
+	cpuTicks uint64
+	rssKB    int64
+	procs    int
+}
+
+// sampleTree sums the usage of the process pid and its descendants, and
+// returns false if the process doesn't exist.
+func sampleTree(procs map[int]procStat, children map[int][]int, pid int) (treeSample, bool) {
+	if _, ok := procs[pid]; !ok {
+		return treeSample{}, false
+	}
+	pageKB := int64(os.Getpagesize() / 1024)
+	var s treeSample
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		stat := procs[p]
+		s.cpuTicks += stat.cpuTicks
+		s.rssKB += stat.rssPages * pageKB
+		s.procs++
+		queue = append(queue, children[p]...)
+	}
+	return s, true
+}
+
+type top struct {
+	globalArgs
+	interval time.Duration
+}
+
+func parseTopSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := top{globalArgs: globals}
+	fs := flag.NewFlagSet(topCommandName, flag.ContinueOnError)
+	fs.DurationVar(&cmd.interval, "interval", 2*time.Second, "how often to refresh")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if cmd.interval <= 0 {
+		return nil, fmt.Errorf("--interval must be positive")
+	}
+	return cmd, nil
+}
+
+func (cmd top) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// Refresh in place on a terminal; otherwise print a single sample. CPU%
+	// is measured between samples, so that takes two.
+	live := term.IsTerminal(int(os.Stdout.Fd()))
+	prev := map[int]uint64{}
+	prevAt := time.Now()
+	for first := true; ; first = false {
+		jobs, err := db.queryJobs("jobs", "status IN (?, ?) AND pid > 0", statusInProgress, statusSuspended)
+		if err != nil {
+			return fmt.Errorf("failed to read running jobs: %w", err)
+		}
+		procs, err := readProcTable()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		if live {
+			fmt.Print("\033[H\033[2J")
+		}
+		if live || !first {
+			fmt.Println(topTable(jobs, procs, prev, now.Sub(prevAt), now))
+			if !live {
+				return nil
+			}
+		}
+
+		prev = map[int]uint64{}
+		children := procChildren(procs)
+		for _, job := range jobs {
+			if s, ok := sampleTree(procs, children, job.PID); ok {
+				prev[job.ID] = s.cpuTicks
+			}
+		}
+		prevAt = now
+
+		select {
+		case <-signals:
+			return nil
+		case <-time.After(cmd.interval):
+		}
+	}
+}
+
+// procChildren maps each process to its children.
+func procChildren(procs map[int]procStat) map[int][]int {
+	children := map[int][]int{}
+	for pid, stat := range procs {
+		children[stat.ppid] = append(children[stat.ppid], pid)
+	}
+	return children
+}
+
+type topRow struct {
+	job   Job
+	cpu   float64 // percent of one core, -1 if unknown
+	rssKB int64
+	procs int
+	alive bool
+}
+
+// topTable renders the running jobs, busiest first. prev holds each job's
+// CPU ticks as of elapsed ago; jobs not in it are shown with an unknown CPU%.
+func topTable(jobs []Job, procs map[int]procStat, prev map[int]uint64, elapsed time.Duration, now time.Time) string {
+	children := procChildren(procs)
+	var rows []topRow
+	for _, job := range jobs {
+		r := topRow{job: job, cpu: -1}
+		s, ok := sampleTree(procs, children, job.PID)
+		if ok {
+			r.alive, r.rssKB, r.procs = true, s.rssKB, s.procs
+			if before, ok := prev[job.ID]; ok && elapsed > 0 && s.cpuTicks >= before {
+				r.cpu = float64(s.cpuTicks-before) / procClockTicks / elapsed.Seconds() * 100
+			}
+		}
+		rows = append(rows, r)
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		if rows[a].cpu != rows[b].cpu {
+			return rows[a].cpu > rows[b].cpu
+		}
+		return rows[a].rssKB > rows[b].rssKB
+	})
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("ID", "PID", "CPU%", "MEM", "PROCS", "ELAPSED", "PROGRESS", "COMMAND")
+
+	for _, r := range rows {
+		cpu, mem, nprocs := "-", "-", "-"
+		if r.alive {
+			mem, nprocs = formatRSS(r.rssKB), strconv.Itoa(r.procs)
+			if r.cpu >= 0 {
+				cpu = fmt.Sprintf("%.1f", r.cpu)
+			}
+		} else {
+			nprocs = "gone"
+		}
+		t.Row(
+			strconv.Itoa(r.job.ID),
+			strconv.Itoa(r.job.PID),
+			cpu,
+			mem,
+			nprocs,
+			now.Sub(r.job.StartedAtTime()).Round(time.Second).String(),
+			cmp.Or(formatProgress(r.job), "-"),
+			r.job.Command,
+		)
+	}
+
+	header := fmt.Sprintf("chime top - %s - %d running jobs", now.Format(time.TimeOnly), len(jobs))
+	return header + "\n" + t.String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// How long to wait for spans to be exported when a run finishes.
+const traceShutdownTimeout = 5 * time.Second
+
+// tracingEnabled reports whether the standard OTEL_* environment variables
+// ask for traces to be exported. Tracing is off unless an OTLP endpoint is
+// configured.
+func tracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" && exporter != "otlp" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// runTracer emits a trace for a `chime run`, with a span for the run and a
+// child span for each job it executes.
+type runTracer struct {
+	provider *sdktrace.TracerProvider // nil if tracing is disabled
+	tracer   trace.Tracer
+	ctx      context.Context
+	span     trace.Span
+}
+
+// newRunTracer starts the run's span. The exporter is configured by the
+// OTEL_EXPORTER_OTLP_* variables, and the service by OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES.
+func newRunTracer(numWorkers int) (*runTracer, error) {
+	t := &runTracer{tracer: noop.NewTracerProvider().Tracer("chime")}
+	if tracingEnabled() {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		res, err := resource.Merge(
+			resource.NewSchemaless(semconv.ServiceName("chime")),
+			resource.Environment(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(res),
+		)
+		t.tracer = t.provider.Tracer("github.com/mpobrien/chime")
+	}
+	t.ctx, t.span = t.tracer.Start(context.Background(), "chime run",
+		trace.WithAttributes(attribute.Int("chime.workers", numWorkers)))
+	return t, nil
+}
+
+// jobStarted starts the span of a job run by the given worker.
+func (t *runTracer) jobStarted(job *Job, worker string) trace.Span {
+	_, span := t.tracer.Start(t.ctx, "chime job",
+		trace.WithAttributes(
+			attribute.Int("chime.job.id", job.ID),
+			attribute.String("chime.job.uuid", job.UUID),
+			attribute.String("chime.job.command_hash", commandHash(job)),
+			attribute.String("chime.job.queue", job.Queue),
+			attribute.String("chime.worker", worker),
+		))
+	return span
+}
+
+// jobEnded ends the span of a job with its outcome.
+func (t *runTracer) jobEnded(span trace.Span, result *jobResult, err error) {
+	if err == nil && result != nil {
+		err = result.Err
+		span.SetAttributes(attribute.Int("chime.job.exit_code", result.ExitCode))
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// finish ends the run's span and flushes any spans not yet exported.
+func (t *runTracer) finish(numJobs, numErrs int) {
+	t.span.SetAttributes(attribute.Int("chime.jobs", numJobs), attribute.Int("chime.errors", numErrs))
+	t.span.End()
+	if t.provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		slog.Error("failed to export traces", "err", err)
+	}
+}
+
+// commandHash identifies what a job runs without putting the command, which
+// may contain secrets, in the trace.
+func commandHash(job *Job) string {
+	sum := sha256.Sum256([]byte(job.Command + "\x00" + job.Script))
+	return hex.EncodeToString(sum[:8])
+}
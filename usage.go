@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// jobUsage is the resources used by a job's process, in the units they're
+// stored in. Fields are -1 if unknown.
+type jobUsage struct {
+	UserCPU int64 // milliseconds
+	SysCPU  int64 // milliseconds
+	MaxRSS  int64 // KiB
+}
+
+var unknownUsage = jobUsage{UserCPU: -1, SysCPU: -1, MaxRSS: -1}
+
+// processUsage returns the resources used by an exited process and the
+// children it waited for. state may be nil if the process never started.
+// On Linux the peak RSS is at least the runner's own RSS when it started the
+// process, since the child counts the memory it shared before exec.
+func processUsage(state *os.ProcessState) jobUsage {
+	if state == nil {
+		return unknownUsage
+	}
+	return jobUsage{
+		UserCPU: state.UserTime().Milliseconds(),
+		SysCPU:  state.SystemTime().Milliseconds(),
+		MaxRSS:  maxRSS(state),
+	}
+}
+
+// CPUTime returns the user plus system CPU time used by the job, and false
+// if it isn't known.
+func (job Job) CPUTime() (time.Duration, bool) {
+	if job.UserCPU < 0 || job.SysCPU < 0 {
+		return 0, false
+	}
+	return time.Duration(job.UserCPU+job.SysCPU) * time.Millisecond, true
+}
+
+// formatCPU formats the job's CPU time, e.g. "1.2s (user 1s, sys 200ms)".
+func formatCPU(job Job) string {
+	total, ok := job.CPUTime()
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%s (user %s, sys %s)", total,
+		time.Duration(job.UserCPU)*time.Millisecond, time.Duration(job.SysCPU)*time.Millisecond)
+}
+
+// formatRSS formats a peak resident set size in KiB.
+func formatRSS(kb int64) string {
+	if kb < 0 {
+		return "-"
+	}
+	return formatBytes(kb * 1024)
+}
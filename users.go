@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os/user"
+	"slices"
+	"strings"
+)
+
+// Jobs record the OS user who added them, so `chime list --mine` can show a
+// user their own jobs on a shared machine, and limits can keep one user from
+// monopolizing the queue: `chime limit set --user` caps how many of a user's
+// jobs run at once, enforced when claiming like other concurrency limits,
+// and with --pending how many may wait in the queue, enforced by a trigger
+// on every insert. Follow-up jobs don't count against the pending limit,
+// since they're added by runners rather than their users.
+
+// Message of the error the pending limit trigger raises.
+const pendingLimitMessage = "pending job limit reached"
+
+// ErrPendingLimit is returned when adding jobs would take their user over
+// the most pending jobs they may have.
+var ErrPendingLimit = errors.New("you have as many pending jobs as your limit allows; see chime limit list")
+
+// pendingLimitTrigger aborts inserting a pending job whose user already has
+// as many pending or held jobs as their limit allows.
+const pendingLimitTrigger = `
+	CREATE TRIGGER IF NOT EXISTS jobs_pending_limit BEFORE INSERT ON jobs
+	WHEN new.status = 0 AND new.follow_up = 0 AND EXISTS (
+		SELECT 1 FROM concurrency_limits AS l
+		WHERE l.kind = 'user' AND l.name = new.submitted_by AND l.max_pending > 0
+		AND (
+			SELECT count(*) FROM jobs
+			WHERE submitted_by = new.submitted_by AND status IN (0, 5)
+		) >= l.max_pending
+	) BEGIN
+		SELECT RAISE(ABORT, '` + pendingLimitMessage + `');
+	END`
+
+// osUserName returns the name of the user chime runs as.
+func osUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// isPendingLimitErr reports whether err is the pending limit trigger
+// aborting an insert.
+func isPendingLimitErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), pendingLimitMessage)
+}
+
+// jobsOf returns the jobs added by the named user.
+func jobsOf(jobs []Job, name string) []Job {
+	return slices.DeleteFunc(jobs, func(job Job) bool { return job.SubmittedBy != name })
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Vacuum rebuilds the DB file to reclaim the space left by deleted rows, and
+// refreshes the query planner's statistics.
+func (db *DB) Vacuum() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum: %w", err)
+	}
+	if _, err := db.Exec(`ANALYZE`); err != nil {
+		return fmt.Errorf("failed to analyze: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint copies the contents of the write-ahead log into the DB and
+// truncates it. It has no effect unless the DB is in WAL mode.
+func (db *DB) Checkpoint() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return fmt.Errorf("checkpoint was blocked by another connection")
+	}
+	return nil
+}
+
+// dbFileSize returns the size of the DB file plus its write-ahead log, if any.
+func dbFileSize(path string) (int64, error) {
+	var total int64
+	for _, p := range []string{path, path + "-wal"} {
+		info, err := os.Stat(p)
+		if errors.Is(err, os.ErrNotExist) && p != path {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// formatBytes formats a size in bytes, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type vacuum struct {
+	globalArgs
+	checkpoint bool
+}
+
+func parseVacuumSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := vacuum{globalArgs: globals}
+	fs := flag.NewFlagSet(vacuumCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.checkpoint, "checkpoint", false, "also checkpoint and truncate the write-ahead log")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return cmd, nil
+}
+
+func (cmd vacuum) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	before, err := dbFileSize(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to read db size: %w", err)
+	}
+	if err := db.Vacuum(); err != nil {
+		return err
+	}
+	if cmd.checkpoint {
+		if err := db.Checkpoint(); err != nil {
+			return fmt.Errorf("failed to checkpoint: %w", err)
+		}
+	}
+	after, err := dbFileSize(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to read db size: %w", err)
+	}
+
+	slog.Info("vacuumed db", "path", cmd.globalArgs.dbPath,
+		"before", formatBytes(before), "after", formatBytes(after), "reclaimed", formatBytes(max(before-after, 0)))
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// `chime wait 42` blocks until a job has finished, e.g. in a script that
+// added it, and fails if the job did. With --recursive it also waits for
+// the jobs descended from it, including any they add while it waits, and
+// fails if any of them did.
+
+type wait struct {
+	globalArgs
+	ref       string
+	recursive bool
+	interval  time.Duration
+}
+
+func parseWaitSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := wait{globalArgs: globals}
+	fs := flag.NewFlagSet(waitCommandName, flag.ContinueOnError)
+	fs.BoolVar(&cmd.recursive, "recursive", false, "also wait for the job's children, and theirs")
+	fs.DurationVar(&cmd.interval, "interval", 2*time.Second, "how often to check the jobs")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: job ID or name to wait for")
+	}
+	if cmd.interval <= 0 {
+		return nil, fmt.Errorf("--interval must be positive")
+	}
+	cmd.ref = fs.Arg(0)
+	return cmd, nil
+}
+
+func (cmd wait) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	job, err := resolveJob(db, cmd.ref)
+	if err != nil {
+		return err
+	}
+	id := int64(job.ID)
+	for {
+		if job, err = db.GetJob(id); err != nil {
+			return fmt.Errorf("failed to read job: %w", err)
+		}
+		if job == nil {
+			return fmt.Errorf("job #%d was removed while waiting for it", id)
+		}
+		jobs := []Job{*job}
+		if cmd.recursive {
+			descendants, err := db.JobDescendants(id)
+			if err != nil {
+				return fmt.Errorf("failed to read child jobs: %w", err)
+			}
+			jobs = append(jobs, descendants...)
+		}
+		if done, failed := waitState(jobs); done {
+			if cmd.recursive {
+				fmt.Println(jobTree(jobs[0], jobs[1:]))
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+			}
+			return nil
+		}
+		time.Sleep(cmd.interval)
+	}
+}
+
+// waitState reports whether all of the jobs have finished, and how many of
+// them failed.
+func waitState(jobs []Job) (done bool, failed int) {
+	for _, job := range jobs {
+		if !isTerminal(job.Status) {
+			return false, 0
+		}
+		if isFailure(job.Status) {
+			failed++
+		}
+	}
+	return true, failed
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// `chime watch <dir>` adds a job for every file that appears in a
+// directory, turning a drop folder into a pipeline. A file is only taken
+// once it's stopped changing for the stability delay, so a file still being
+// copied in isn't processed half-written. Dotfiles, which are usually
+// temporary, and subdirectories are ignored.
+
+const defaultWatchDelay = 2 * time.Second
+
+type watch struct {
+	globalArgs
+	dir string
+	// Command in template syntax, or the name of a saved template.
+	template string
+	pattern  string
+	delay    time.Duration
+	queue    string
+	priority int
+	existing bool
+}
+
+func parseWatchSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := watch{globalArgs: globals}
+	// The directory may come before the flags, as in `chime watch drop --template ...`.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd.dir, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet(watchCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.template, "template", "", "command to add for each file, with {{.file}}, {{.name}} and {{.dir}} shell-quoted, or the name of a saved template given those params")
+	fs.StringVar(&cmd.pattern, "pattern", "", "only files whose name matches this glob, e.g. '*.csv'")
+	fs.DurationVar(&cmd.delay, "delay", defaultWatchDelay, "how long a file must go unchanged before its job is added")
+	fs.StringVar(&cmd.queue, "queue", "", "queue to add the jobs to")
+	fs.IntVar(&cmd.priority, "priority", 0, "priority of the jobs")
+	fs.BoolVar(&cmd.existing, "existing", false, "also add jobs for the files already in the directory")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	rest := fs.Args()
+	if cmd.dir == "" && len(rest) > 0 {
+		cmd.dir, rest = rest[0], rest[1:]
+	}
+	if cmd.dir == "" {
+		return nil, fmt.Errorf("param required: directory to watch")
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	if cmd.template == "" {
+		return nil, fmt.Errorf("--template is required")
+	}
+	if _, err := filepath.Match(cmd.pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid --pattern: %w", err)
+	}
+	if cmd.delay < 0 {
+		return nil, fmt.Errorf("--delay can't be negative")
+	}
+	if cmd.queue != "" {
+		if err := validateName("queue", cmd.queue); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+func (cmd watch) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	// A saved template's jobs record its name, like those added with it.
+	tmpl, err := db.GetTemplate(cmd.template)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	if tmpl == nil {
+		if _, err := parseJobTemplate(watchCommandName, cmd.template); err != nil {
+			return fmt.Errorf("invalid --template: %w", err)
+		}
+	}
+
+	dir, err := filepath.Abs(cmd.dir)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch directory: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch directory: %w", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// Files waiting to settle, and when they last changed.
+	changed := map[string]time.Time{}
+	if cmd.existing {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			changed[filepath.Join(dir, entry.Name())] = time.Time{}
+		}
+	}
+	tick := time.NewTicker(min(max(cmd.delay/4, 10*time.Millisecond), time.Second))
+	defer tick.Stop()
+
+	slog.Info("watching for files", "dir", dir)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case err := <-watcher.Errors:
+			return fmt.Errorf("failed to watch directory: %w", err)
+		case event := <-watcher.Events:
+			switch {
+			case event.Has(fsnotify.Create) || event.Has(fsnotify.Write):
+				changed[event.Name] = time.Now()
+			case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+				delete(changed, event.Name)
+			}
+		case now := <-tick.C:
+			for path, at := range changed {
+				if now.Sub(at) < cmd.delay {
+					continue
+				}
+				delete(changed, path)
+				if !cmd.wants(path) {
+					continue
+				}
+				if err := cmd.addJob(db, tmpl, path); err != nil {
+					slog.Error("failed to add job", "file", path, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// wants reports whether a job should be added for the file at path.
+func (cmd watch) wants(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	if cmd.pattern != "" {
+		if ok, _ := filepath.Match(cmd.pattern, name); !ok {
+			return false
+		}
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// addJob adds the job for the file at path, from the saved template tmpl or,
+// if it's nil, from the --template command.
+func (cmd watch) addJob(db *DB, tmpl *JobTemplate, path string) error {
+	spec := JobSpec{Queue: cmd.queue, Priority: cmd.priority}
+	if tmpl != nil {
+		spec.Template = tmpl.Name
+	} else {
+		tmpl = &JobTemplate{Name: watchCommandName, Command: cmd.template}
+	}
+	command, err := tmpl.Render(map[string]string{
+		"file": shellQuote(path),
+		"name": shellQuote(filepath.Base(path)),
+		"dir":  shellQuote(filepath.Dir(path)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	spec.Command = command
+	id, err := db.InsertJob(spec)
+	if err != nil {
+		return err
+	}
+	slog.Info("added job", "id", id, "file", path)
+	return nil
+}
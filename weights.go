@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// Queues share workers in proportion to their weights, which default to 1:
+// among pending jobs of the same priority, runners take the next job from
+// the queue that's had the fewest jobs started in the last fairShareWindow
+// relative to its weight, so a flooded queue can't starve the others.
+const fairShareWindow = 10 * time.Minute
+
+type QueueWeight struct {
+	Queue  string
+	Weight float64
+}
+
+// fairShareCTE defines the share of recent starts each queue has had
+// relative to its weight, given the start of the window as its argument.
+// Jobs are ordered by their queue's share, from the fair_shares table
+// joined on share_queue.
+const fairShareCTE = `fair_shares AS (
+	SELECT q.queue AS share_queue, count(r.id) / coalesce(w.weight, 1.0) AS share
+	FROM (SELECT DISTINCT queue FROM jobs WHERE status = 0) AS q
+	LEFT JOIN jobs AS r ON r.queue = q.queue AND r.started_at >= ?
+	LEFT JOIN queue_weights AS w ON w.queue_name = q.queue
+	GROUP BY q.queue
+)`
+
+// SetQueueWeight sets the weight of the named queue.
+func (db *DB) SetQueueWeight(queue string, weight float64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	_, err := db.Exec(`
+	INSERT INTO queue_weights (queue_name, weight) VALUES (?,?)
+	ON CONFLICT (queue_name) DO UPDATE SET weight = excluded.weight`,
+		queue, weight)
+	return err
+}
+
+func (db *DB) ListQueueWeights() ([]QueueWeight, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	rows, err := db.Query(`SELECT queue_name, weight FROM queue_weights ORDER BY queue_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var weights []QueueWeight
+	for rows.Next() {
+		var w QueueWeight
+		if err := rows.Scan(&w.Queue, &w.Weight); err != nil {
+			return weights, err
+		}
+		weights = append(weights, w)
+	}
+	return weights, rows.Err()
+}
+
+// Deletes the weight of the named queue, so it gets the default one.
+// Returns true if the queue had a weight.
+func (db *DB) DeleteQueueWeight(queue string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result, err := db.Exec(`DELETE FROM queue_weights WHERE queue_name = ?`, queue)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+type weightSet struct {
+	globalArgs
+	queue  string
+	weight float64
+}
+
+type weightList struct {
+	globalArgs
+}
+
+type weightRemove struct {
+	globalArgs
+	queue string
+}
+
+func parseWeightSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("weight command required: set, list or remove")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "set":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("params required: queue and weight")
+		}
+		if err := validateName("queue", args[0]); err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight: '%s'", args[1])
+		}
+		return weightSet{globalArgs: globals, queue: args[0], weight: weight}, nil
+	case "list":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unexpected arguments: %v", args)
+		}
+		return weightList{globalArgs: globals}, nil
+	case "remove":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("param required: queue to remove the weight of")
+		}
+		return weightRemove{globalArgs: globals, queue: args[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown weight command: '%s'", cmd)
+}
+
+func (cmd weightSet) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.SetQueueWeight(cmd.queue, cmd.weight); err != nil {
+		return err
+	}
+	slog.Info("set queue weight", "queue", cmd.queue, "weight", cmd.weight)
+	return nil
+}
+
+func (cmd weightList) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	weights, err := db.ListQueueWeights()
+	if err != nil {
+		return fmt.Errorf("failed to list queue weights: %w", err)
+	}
+
+	cellStyle := lipgloss.NewStyle().
+		PaddingLeft(2).
+		PaddingRight(2).Foreground(lipgloss.Color("#ffffff"))
+	headerStyle := cellStyle.Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row < 0 || row >= len(weights) {
+				return headerStyle
+			}
+			return cellStyle
+		}).
+		Headers("QUEUE", "WEIGHT")
+	for _, w := range weights {
+		t.Row(w.Queue, strconv.FormatFloat(w.Weight, 'g', -1, 64))
+	}
+
+	fmt.Println(t)
+	return nil
+}
+
+func (cmd weightRemove) Run() error {
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	existed, err := db.DeleteQueueWeight(cmd.queue)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("queue '%s' has no weight", cmd.queue)
+	}
+	return nil
+}
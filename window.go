@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A job's time window, e.g. 22:00-06:00, limits when it may start, in the
+// local time of the runner or server taking it. Windows are stored as
+// HH:MM-HH:MM, so times can be compared as strings; the start is inclusive
+// and the end exclusive, and a window whose end is before its start spans
+// midnight. Jobs outside their window stay pending.
+
+// parseTimeWindow checks a window given as START-END, returning it in its
+// stored form.
+func parseTimeWindow(s string) (string, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid window '%s': must be START-END, e.g. 22:00-06:00", s)
+	}
+	var times [2]string
+	for i, v := range []string{start, end} {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return "", fmt.Errorf("invalid window '%s': invalid time '%s'", s, v)
+		}
+		times[i] = t.Format("15:04")
+	}
+	if times[0] == times[1] {
+		return "", fmt.Errorf("invalid window '%s': start and end must differ", s)
+	}
+	return times[0] + "-" + times[1], nil
+}
+
+// clockTime returns t as it's compared to time windows.
+func clockTime(t time.Time) string {
+	return t.Format("15:04")
+}
+
+// inTimeWindow reports whether clock, a time formatted by clockTime, is in
+// window, or true if there's no window.
+func inTimeWindow(window, clock string) bool {
+	if window == "" {
+		return true
+	}
+	start, end, _ := strings.Cut(window, "-")
+	if start < end {
+		return clock >= start && clock < end
+	}
+	return clock >= start || clock < end
+}
+
+// timeWindowCond is a SQL condition matching jobs whose window includes
+// the clock time given as each of its 4 arguments; it mirrors inTimeWindow.
+const timeWindowCond = `(time_window = '' OR CASE
+	WHEN substr(time_window, 1, 5) < substr(time_window, 7, 5)
+	THEN ? >= substr(time_window, 1, 5) AND ? < substr(time_window, 7, 5)
+	ELSE ? >= substr(time_window, 1, 5) OR ? < substr(time_window, 7, 5) END)`
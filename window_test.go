@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "22:00-06:00", want: "22:00-06:00"},
+		{in: "9:05-17:30", want: "09:05-17:30"},
+		{in: "00:00-23:59", want: "00:00-23:59"},
+		{in: "22:00", wantErr: true},
+		{in: "22:00-25:00", wantErr: true},
+		{in: "noon-06:00", wantErr: true},
+		{in: "08:00-08:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeWindow(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTimeWindow(%q) = %q; want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTimeWindow(%q) failed: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("parseTimeWindow(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInTimeWindow(t *testing.T) {
+	tests := []struct {
+		window, clock string
+		want          bool
+	}{
+		{"", "12:00", true},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"09:00-17:00", "08:59", false},
+		{"22:00-06:00", "23:30", true},
+		{"22:00-06:00", "05:59", true},
+		{"22:00-06:00", "06:00", false},
+		{"22:00-06:00", "12:00", false},
+	}
+	for _, tt := range tests {
+		if got := inTimeWindow(tt.window, tt.clock); got != tt.want {
+			t.Errorf("inTimeWindow(%q, %q) = %v; want %v", tt.window, tt.clock, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// `cat urls.txt | chime xargs 'fetch.sh {}'` adds a job per line of its
+// input, with the line in place of {}, shell-quoted, or after the command if
+// it has no {}. Unlike xargs, the jobs are queued, so they survive restarts
+// and can be retried. All of them are added, or none if one can't be.
+
+const defaultXargsPlaceholder = "{}"
+
+type xargs struct {
+	globalArgs
+	command     string
+	placeholder string
+	// Split the input on NUL bytes rather than lines, like `xargs -0`.
+	null       bool
+	queue      string
+	priority   int
+	maxRetries int
+	tags       stringList
+}
+
+func parseXargsSubcommand(globals globalArgs, args []string) (subcommand, error) {
+	cmd := xargs{globalArgs: globals}
+	fs := flag.NewFlagSet(xargsCommandName, flag.ContinueOnError)
+	fs.StringVar(&cmd.placeholder, "I", defaultXargsPlaceholder, "placeholder replaced by each input line in the command")
+	fs.BoolVar(&cmd.null, "0", false, "input items are separated by NUL bytes rather than newlines, as from find -print0")
+	fs.StringVar(&cmd.queue, "queue", defaultQueue, "queue to add the jobs to")
+	fs.IntVar(&cmd.priority, "priority", 0, "jobs with higher priority are taken first")
+	fs.IntVar(&cmd.maxRetries, "retries", 0, "times to requeue a job if it fails, before moving it to the dead-letter queue")
+	fs.Var(&cmd.tags, "tag", "tag to label the jobs with; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("param required: command to run for each input line, quoted")
+	}
+	cmd.command = fs.Arg(0)
+	if strings.TrimSpace(cmd.command) == "" {
+		return nil, fmt.Errorf("the command can't be empty")
+	}
+	if cmd.placeholder == "" {
+		return nil, fmt.Errorf("-I needs a placeholder")
+	}
+	if err := validateName("queue", cmd.queue); err != nil {
+		return nil, err
+	}
+	for _, tag := range cmd.tags {
+		if err := validateName("tag", tag); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.maxRetries < 0 {
+		return nil, fmt.Errorf("--retries can't be negative")
+	}
+	return cmd, nil
+}
+
+// expand returns the command to run for an input item.
+func (cmd xargs) expand(item string) string {
+	if strings.Contains(cmd.command, cmd.placeholder) {
+		return strings.ReplaceAll(cmd.command, cmd.placeholder, shellQuote(item))
+	}
+	return cmd.command + " " + shellQuote(item)
+}
+
+// readXargsItems returns the non-empty items in r.
+func readXargsItems(r io.Reader, null bool) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if null {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	}
+	var items []string
+	for scanner.Scan() {
+		item := scanner.Text()
+		if !null {
+			item = strings.TrimSuffix(item, "\r")
+		}
+		if strings.TrimSpace(item) != "" {
+			items = append(items, item)
+		}
+	}
+	return items, scanner.Err()
+}
+
+func (cmd xargs) Run() error {
+	items, err := readXargsItems(os.Stdin, cmd.null)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	if len(items) == 0 {
+		slog.Info("no input; no jobs added")
+		return nil
+	}
+
+	db, err := Open(cmd.globalArgs.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	commands := make([]string, len(items))
+	for i, item := range items {
+		commands[i] = cmd.expand(item)
+	}
+	ids, err := db.AddJobs(commands, withSettings(JobSpec{
+		Queue:      cmd.queue,
+		Priority:   cmd.priority,
+		MaxRetries: cmd.maxRetries,
+		Tags:       CommaList(cmd.tags),
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to add jobs: %w", err)
+	}
+	slog.Info("added jobs", "count", len(ids), "first_id", ids[0], "last_id", ids[len(ids)-1])
+	return nil
+}